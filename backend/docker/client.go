@@ -0,0 +1,222 @@
+package docker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// defaultHost is the daemon used when neither the options nor DOCKER_HOST
+// name one.
+const defaultHost = "unix:///var/run/docker.sock"
+
+// client talks to the Docker Engine API. Only the few endpoints needed to
+// exec into a container are implemented, so that no SDK is needed.
+type client struct {
+	dial func(ctx context.Context) (net.Conn, error)
+	http *http.Client
+}
+
+// newClient returns a client of the daemon at host, a unix:// or tcp:// URL.
+func newClient(host string) (*client, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+	if host == "" {
+		host = defaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid docker host `%s`", host)
+	}
+	var network, address string
+	switch u.Scheme {
+	case "unix":
+		network, address = "unix", u.Path
+	case "tcp":
+		network, address = "tcp", u.Host
+	default:
+		return nil, errors.Errorf("invalid docker host `%s`, expected unix:// or tcp://", host)
+	}
+
+	dialer := &net.Dialer{}
+	dial := func(ctx context.Context) (net.Conn, error) {
+		return dialer.DialContext(ctx, network, address)
+	}
+	return &client{
+		dial: dial,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					return dial(ctx)
+				},
+			},
+		},
+	}, nil
+}
+
+// newRequest returns a request of path with body encoded as JSON.
+func newRequest(ctx context.Context, method string, path string, body interface{}) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+	// The host is ignored by the dialer
+	req, err := http.NewRequestWithContext(ctx, method, "http://docker"+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req, nil
+}
+
+// do sends a request to path and decodes the response into out, if not nil.
+func (c *client) do(ctx context.Context, method string, path string, body interface{}, out interface{}) error {
+	req, err := newRequest(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to connect to the docker daemon")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return responseError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// responseError returns the error message of a failed API call.
+func responseError(resp *http.Response) error {
+	var apiErr struct {
+		Message string `json:"message"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+		apiErr.Message = strings.TrimSpace(string(data))
+	}
+	return errors.Errorf("docker daemon returned %s: %s", resp.Status, apiErr.Message)
+}
+
+// containerInfo is the part of a container inspection used here.
+type containerInfo struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+	} `json:"State"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+}
+
+func (c *client) inspectContainer(ctx context.Context, container string) (*containerInfo, error) {
+	var info containerInfo
+	if err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(container)+"/json", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// execConfig is the body creating an exec.
+type execConfig struct {
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	Tty          bool
+	Cmd          []string
+	Env          []string `json:",omitempty"`
+	User         string   `json:",omitempty"`
+}
+
+func (c *client) createExec(ctx context.Context, containerID string, config execConfig) (string, error) {
+	var created struct {
+		ID string `json:"Id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(containerID)+"/exec", config, &created); err != nil {
+		return "", err
+	}
+	return created.ID, nil
+}
+
+// startExec starts an exec and returns the connection carrying its
+// terminal, hijacked from the HTTP request.
+func (c *client) startExec(ctx context.Context, execID string) (net.Conn, *bufio.Reader, error) {
+	req, err := newRequest(ctx, http.MethodPost, "/exec/"+url.PathEscape(execID)+"/start", map[string]bool{"Detach": false, "Tty": true})
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to connect to the docker daemon")
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrapf(err, "failed to start exec")
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, errors.Wrapf(err, "failed to start exec")
+	}
+	// Older daemons answer 200 instead of switching protocols
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		defer conn.Close()
+		return nil, nil, responseError(resp)
+	}
+	return conn, reader, nil
+}
+
+// execInfo is the part of an exec inspection used here.
+type execInfo struct {
+	Running bool `json:"Running"`
+	Pid     int  `json:"Pid"`
+}
+
+func (c *client) inspectExec(ctx context.Context, execID string) (*execInfo, error) {
+	var info execInfo
+	if err := c.do(ctx, http.MethodGet, "/exec/"+url.PathEscape(execID)+"/json", nil, &info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// runExec starts cmd in a container without waiting for it to exit.
+func (c *client) runExec(ctx context.Context, containerID string, cmd []string, user string) error {
+	execID, err := c.createExec(ctx, containerID, execConfig{Cmd: cmd, User: user})
+	if err != nil {
+		return err
+	}
+	return c.do(ctx, http.MethodPost, "/exec/"+url.PathEscape(execID)+"/start", map[string]bool{"Detach": true}, nil)
+}
+
+func (c *client) resizeExec(ctx context.Context, execID string, columns int, rows int) error {
+	path := fmt.Sprintf("/exec/%s/resize?h=%d&w=%d", url.PathEscape(execID), rows, columns)
+	return c.do(ctx, http.MethodPost, path, nil, nil)
+}
@@ -0,0 +1,3 @@
+// Package docker provides an implementation of webtty.Slave
+// that runs a command in a running container with docker exec.
+package docker
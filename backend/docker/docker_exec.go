@@ -0,0 +1,182 @@
+package docker
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// apiTimeout bounds the API calls made outside of the exec stream.
+const apiTimeout = 10 * time.Second
+
+// killTimeout is how long a closed exec is given to exit after each signal.
+var killTimeout = 3 * time.Second
+
+// DockerExec is a command running in a container with a TTY.
+type DockerExec struct {
+	client      *client
+	containerID string
+	container   string
+	user        string
+	image       string
+	command     string
+	argv        []string
+	execID      string
+
+	conn      net.Conn
+	reader    *bufio.Reader
+	closeOnce sync.Once
+}
+
+// newDockerExec starts command in container, which must be running.
+func newDockerExec(client *client, container string, command string, argv []string, user string) (*DockerExec, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+
+	info, err := client.inspectContainer(ctx, container)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect container `%s`", container)
+	}
+	if !info.State.Running {
+		return nil, errors.Errorf("container `%s` is not running", container)
+	}
+
+	execID, err := client.createExec(ctx, info.ID, execConfig{
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+		Tty:          true,
+		Cmd:          append([]string{command}, argv...),
+		Env:          []string{"TERM=xterm-256color"},
+		User:         user,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create exec of `%s` in container `%s`", command, container)
+	}
+
+	conn, reader, err := client.startExec(ctx, execID)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to start `%s` in container `%s`", command, container)
+	}
+
+	return &DockerExec{
+		client:      client,
+		containerID: info.ID,
+		container:   strings.TrimPrefix(info.Name, "/"),
+		user:        user,
+		image:       info.Config.Image,
+		command:     command,
+		argv:        argv,
+		execID:      execID,
+		conn:        conn,
+		reader:      reader,
+	}, nil
+}
+
+func (dexec *DockerExec) Read(p []byte) (n int, err error) {
+	return dexec.reader.Read(p)
+}
+
+func (dexec *DockerExec) Write(p []byte) (n int, err error) {
+	return dexec.conn.Write(p)
+}
+
+// Close ends the input of the exec, on which a shell exits, kills it when
+// it keeps running and releases its connection.
+func (dexec *DockerExec) Close() error {
+	var err error
+	dexec.closeOnce.Do(func() {
+		if closer, ok := dexec.conn.(interface{ CloseWrite() error }); ok {
+			closer.CloseWrite()
+		}
+		if err := dexec.kill(); err != nil {
+			log.Printf("Failed to stop `%s` in container `%s`: %v", dexec.command, dexec.container, err)
+		}
+		err = dexec.conn.Close()
+	})
+	return err
+}
+
+// kill sends SIGTERM, then SIGKILL, to the exec until it exits. The Docker
+// API cannot signal an exec, so kill is run in the container.
+func (dexec *DockerExec) kill() error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout+2*killTimeout)
+	defer cancel()
+
+	info, err := dexec.client.inspectExec(ctx, dexec.execID)
+	if err != nil || !info.Running {
+		return err
+	}
+	pid := strconv.Itoa(containerPid(info.Pid))
+	for _, signal := range []string{"-TERM", "-KILL"} {
+		if err := dexec.client.runExec(ctx, dexec.containerID, []string{"kill", signal, pid}, dexec.user); err != nil {
+			return errors.Wrapf(err, "failed to run kill %s %s", signal, pid)
+		}
+		exited, err := dexec.waitExit(ctx, killTimeout)
+		if err != nil || exited {
+			return err
+		}
+	}
+	return errors.Errorf("process %s is still running", pid)
+}
+
+// waitExit polls the exec for up to timeout and reports whether it exited.
+func (dexec *DockerExec) waitExit(ctx context.Context, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		info, err := dexec.client.inspectExec(ctx, dexec.execID)
+		if err != nil {
+			return false, err
+		}
+		if !info.Running {
+			return true, nil
+		}
+		if time.Now().After(deadline) {
+			return false, nil
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// containerPid translates pid, as reported by the daemon, into the PID
+// namespace of the container. This needs the daemon's host procfs; pid is
+// returned as is otherwise, which holds for containers sharing its PID
+// namespace.
+func containerPid(pid int) int {
+	status, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/status")
+	if err != nil {
+		return pid
+	}
+	for _, line := range strings.Split(string(status), "\n") {
+		if fields := strings.Fields(line); len(fields) > 1 && fields[0] == "NSpid:" {
+			if nspid, err := strconv.Atoi(fields[len(fields)-1]); err == nil {
+				return nspid
+			}
+		}
+	}
+	return pid
+}
+
+func (dexec *DockerExec) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{
+		"command":   dexec.command,
+		"argv":      dexec.argv,
+		"args":      dexec.argv,
+		"container": dexec.container,
+		"image":     dexec.image,
+	}
+}
+
+func (dexec *DockerExec) ResizeTerminal(width int, height int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+	defer cancel()
+	return dexec.client.resizeExec(ctx, dexec.execID, width, height)
+}
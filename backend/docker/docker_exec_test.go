@@ -0,0 +1,254 @@
+package docker
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDaemon serves the Docker API endpoints used by the backend, with one
+// running container `web` and one stopped container `stopped`.
+type fakeDaemon struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	exec     execConfig
+	resizes  []string
+	released chan struct{}
+
+	// The exec runs until killed, or a signal it does not ignore
+	exited     bool
+	ignoreTerm bool
+	kills      [][]string
+}
+
+func newFakeDaemon(t *testing.T) *fakeDaemon {
+	t.Helper()
+	daemon := &fakeDaemon{released: make(chan struct{})}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /containers/{name}/json", func(w http.ResponseWriter, r *http.Request) {
+		switch r.PathValue("name") {
+		case "web", "abc123":
+			io.WriteString(w, `{"Id":"abc123","Name":"/web","State":{"Running":true},"Config":{"Image":"nginx:latest"}}`)
+		case "stopped":
+			io.WriteString(w, `{"Id":"def456","Name":"/stopped","State":{"Running":false},"Config":{"Image":"nginx:latest"}}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message":"No such container: `+r.PathValue("name")+`"}`)
+		}
+	})
+	mux.HandleFunc("POST /containers/abc123/exec", func(w http.ResponseWriter, r *http.Request) {
+		var config execConfig
+		json.NewDecoder(r.Body).Decode(&config)
+		daemon.mu.Lock()
+		defer daemon.mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+		if len(config.Cmd) > 0 && config.Cmd[0] == "kill" {
+			daemon.kills = append(daemon.kills, config.Cmd)
+			if config.Cmd[1] == "-KILL" || !daemon.ignoreTerm {
+				daemon.exited = true
+			}
+			io.WriteString(w, `{"Id":"kill`+strconv.Itoa(len(daemon.kills))+`"}`)
+			return
+		}
+		daemon.exec = config
+		io.WriteString(w, `{"Id":"exec1"}`)
+	})
+	mux.HandleFunc("GET /exec/exec1/json", func(w http.ResponseWriter, r *http.Request) {
+		daemon.mu.Lock()
+		defer daemon.mu.Unlock()
+		json.NewEncoder(w).Encode(execInfo{Running: !daemon.exited, Pid: testPid})
+	})
+	mux.HandleFunc("POST /exec/{id}/start", func(w http.ResponseWriter, r *http.Request) {
+		var start map[string]bool
+		json.NewDecoder(r.Body).Decode(&start)
+		if !strings.HasPrefix(r.PathValue("id"), "kill") || !start["Detach"] {
+			http.Error(w, "no such exec", http.StatusNotFound)
+		}
+	})
+	mux.HandleFunc("POST /exec/exec1/start", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Upgrade") != "tcp" {
+			http.Error(w, "upgrade required", http.StatusBadRequest)
+			return
+		}
+		io.Copy(io.Discard, r.Body)
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Hijack() error: %v", err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 UPGRADED\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		rw.Flush()
+
+		// Echo the input like a terminal until the client goes away
+		io.Copy(conn, rw)
+		close(daemon.released)
+	})
+	mux.HandleFunc("POST /exec/exec1/resize", func(w http.ResponseWriter, r *http.Request) {
+		daemon.mu.Lock()
+		daemon.resizes = append(daemon.resizes, r.URL.Query().Get("w")+"x"+r.URL.Query().Get("h"))
+		daemon.mu.Unlock()
+	})
+
+	daemon.Server = httptest.NewServer(mux)
+	t.Cleanup(daemon.Close)
+	return daemon
+}
+
+// testPid is the PID of the exec, which exists in no PID namespace.
+const testPid = 1 << 30
+
+func (daemon *fakeDaemon) host() string {
+	return "tcp://" + strings.TrimPrefix(daemon.URL, "http://")
+}
+
+func TestDockerExec(t *testing.T) {
+	daemon := newFakeDaemon(t)
+	factory, err := NewFactory("/bin/bash", []string{"-l"}, &Options{Container: "web", Host: daemon.host(), User: "app"})
+	if err != nil {
+		t.Fatalf("NewFactory() error: %v", err)
+	}
+
+	slave, err := factory.New(map[string][]string{"arg": {"extra"}}, nil)
+	if err != nil {
+		t.Fatalf("factory.New() error: %v", err)
+	}
+	defer slave.Close()
+
+	daemon.mu.Lock()
+	exec := daemon.exec
+	daemon.mu.Unlock()
+	if !reflect.DeepEqual(exec.Cmd, []string{"/bin/bash", "-l", "extra"}) {
+		t.Errorf("exec Cmd = %v, want the command with its arguments", exec.Cmd)
+	}
+	if !exec.Tty || !exec.AttachStdin || exec.User != "app" {
+		t.Errorf("exec = %+v, want an interactive TTY as app", exec)
+	}
+
+	if _, err := slave.Write([]byte("echo hi\r")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	buf := make([]byte, 64)
+	n, err := io.ReadAtLeast(slave, buf, len("echo hi\r"))
+	if err != nil || string(buf[:n]) != "echo hi\r" {
+		t.Errorf("Read() = %q, %v, want the echoed input", buf[:n], err)
+	}
+
+	if err := slave.ResizeTerminal(120, 40); err != nil {
+		t.Fatalf("ResizeTerminal() error: %v", err)
+	}
+	daemon.mu.Lock()
+	resizes := daemon.resizes
+	daemon.mu.Unlock()
+	if !reflect.DeepEqual(resizes, []string{"120x40"}) {
+		t.Errorf("resizes = %v, want [120x40]", resizes)
+	}
+
+	vars := slave.WindowTitleVariables()
+	if vars["container"] != "web" || vars["image"] != "nginx:latest" || vars["command"] != "/bin/bash" {
+		t.Errorf("WindowTitleVariables() = %v, want the container, image and command", vars)
+	}
+
+	if err := slave.Close(); err != nil {
+		t.Errorf("Close() error: %v", err)
+	}
+	select {
+	case <-daemon.released:
+	case <-time.After(time.Second):
+		t.Error("Close() did not release the exec connection")
+	}
+}
+
+func TestDockerExecCloseKills(t *testing.T) {
+	oldTimeout := killTimeout
+	killTimeout = 50 * time.Millisecond
+	defer func() { killTimeout = oldTimeout }()
+
+	pid := strconv.Itoa(testPid)
+	tests := []struct {
+		name       string
+		ignoreTerm bool
+		exited     bool
+		want       [][]string
+	}{
+		{"exited on EOF", false, true, nil},
+		{"exits on SIGTERM", false, false, [][]string{{"kill", "-TERM", pid}}},
+		{"ignores SIGTERM", true, false, [][]string{{"kill", "-TERM", pid}, {"kill", "-KILL", pid}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			daemon := newFakeDaemon(t)
+			daemon.ignoreTerm = tt.ignoreTerm
+			daemon.exited = tt.exited
+			factory, err := NewFactory("top", nil, &Options{Container: "web", Host: daemon.host(), User: "app"})
+			if err != nil {
+				t.Fatalf("NewFactory() error: %v", err)
+			}
+			slave, err := factory.New(nil, nil)
+			if err != nil {
+				t.Fatalf("factory.New() error: %v", err)
+			}
+
+			if err := slave.Close(); err != nil {
+				t.Errorf("Close() error: %v", err)
+			}
+			daemon.mu.Lock()
+			defer daemon.mu.Unlock()
+			if !reflect.DeepEqual(daemon.kills, tt.want) {
+				t.Errorf("kills = %v, want %v", daemon.kills, tt.want)
+			}
+			if !daemon.exited {
+				t.Error("Close() should stop the exec")
+			}
+		})
+	}
+}
+
+func TestDockerExecContainerNotRunning(t *testing.T) {
+	daemon := newFakeDaemon(t)
+
+	for _, container := range []string{"stopped", "missing"} {
+		factory, err := NewFactory("/bin/sh", nil, &Options{Container: container, Host: daemon.host()})
+		if err != nil {
+			t.Fatalf("NewFactory() error: %v", err)
+		}
+		_, err = factory.New(nil, nil)
+		if err == nil {
+			t.Errorf("factory.New() in %s container should fail", container)
+			continue
+		}
+		if !strings.Contains(err.Error(), container) {
+			t.Errorf("factory.New() error = %q, want it to name the container", err)
+		}
+	}
+}
+
+func TestNewFactoryInvalidOptions(t *testing.T) {
+	if _, err := NewFactory("/bin/sh", nil, &Options{}); err == nil {
+		t.Error("NewFactory() without a container should fail")
+	}
+	if _, err := NewFactory("/bin/sh", nil, &Options{Container: "web", Host: "ssh://example.com"}); err == nil {
+		t.Error("NewFactory() with an ssh:// host should fail")
+	}
+}
+
+func TestNewClientHost(t *testing.T) {
+	t.Setenv("DOCKER_HOST", "")
+	if _, err := newClient(""); err != nil {
+		t.Errorf("newClient() with the default host error: %v", err)
+	}
+	t.Setenv("DOCKER_HOST", "ftp://example.com")
+	if _, err := newClient(""); err == nil {
+		t.Error("newClient() should use DOCKER_HOST and reject ftp://")
+	}
+}
@@ -0,0 +1,52 @@
+package docker
+
+import (
+	"github.com/pkg/errors"
+
+	"webtmux/server"
+)
+
+type Factory struct {
+	container string
+	command   string
+	argv      []string
+	options   *Options
+	client    *client
+}
+
+// NewFactory returns a factory running command in options.Container.
+func NewFactory(command string, argv []string, options *Options) (*Factory, error) {
+	if options.Container == "" {
+		return nil, errors.New("no docker container is given")
+	}
+	client, err := newClient(options.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Factory{
+		container: options.Container,
+		command:   command,
+		argv:      argv,
+		options:   options,
+		client:    client,
+	}, nil
+}
+
+func (factory *Factory) Name() string {
+	return "docker exec"
+}
+
+func (factory *Factory) Command() (string, []string) {
+	return factory.command, factory.argv
+}
+
+func (factory *Factory) New(params map[string][]string, headers map[string][]string) (server.Slave, error) {
+	argv := make([]string, len(factory.argv))
+	copy(argv, factory.argv)
+	if params["arg"] != nil && len(params["arg"]) > 0 {
+		argv = append(argv, params["arg"]...)
+	}
+
+	return newDockerExec(factory.client, factory.container, factory.command, argv, factory.options.User)
+}
@@ -0,0 +1,7 @@
+package docker
+
+type Options struct {
+	Container string `hcl:"docker_container" flagName:"docker-container" flagSName:"" flagDescribe:"Run the command in this running container with docker exec instead of locally" default:""`
+	Host      string `hcl:"docker_host" flagName:"docker-host" flagSName:"" flagDescribe:"Docker daemon to connect to, e.g. unix:///var/run/docker.sock or tcp://127.0.0.1:2375 (empty for $DOCKER_HOST or the local socket)" default:""`
+	User      string `hcl:"docker_user" flagName:"docker-user" flagSName:"" flagDescribe:"User to run the command as in the container (empty for the container's user)" default:""`
+}
@@ -1,22 +1,38 @@
 package localcommand
 
 import (
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/pkg/errors"
+
+	"webtmux/pkg/homedir"
 	"webtmux/server"
 )
 
 type Options struct {
-	CloseSignal  int `hcl:"close_signal" flagName:"close-signal" flagSName:"" flagDescribe:"Signal sent to the command process when gotty close it (default: SIGHUP)" default:"1"`
-	CloseTimeout int `hcl:"close_timeout" flagName:"close-timeout" flagSName:"" flagDescribe:"Time in seconds to force kill process after client is disconnected (default: -1)" default:"-1"`
+	CloseSignal  int    `hcl:"close_signal" flagName:"close-signal" flagSName:"" flagDescribe:"Signal sent to the command process when gotty close it (default: SIGHUP)" default:"1"`
+	CloseTimeout int    `hcl:"close_timeout" flagName:"close-timeout" flagSName:"" flagDescribe:"Time in seconds to force kill process after client is disconnected (default: -1)" default:"-1"`
+	SuspendAfter int    `hcl:"suspend_after" flagName:"suspend-after" flagSName:"" flagDescribe:"Time in seconds without client input after which the command is paused with SIGSTOP until the next input (0 to disable)" default:"0"`
+	Umask        string `hcl:"umask" flagName:"umask" flagSName:"" flagDescribe:"Octal umask of the command, e.g. 077 (empty to inherit the server's)" default:""`
+	WorkingDir   string `hcl:"working_dir" flagName:"working-dir" flagSName:"" flagDescribe:"Working directory of the command (empty for the server's)" default:""`
+	EnvParams    string `hcl:"env_params" flagName:"env-params" flagSName:"" flagDescribe:"Comma separated URL parameters passed to the command as WEBTMUX_ARG_<NAME> variables when permit-arguments is set (ex: cols,lang)" default:""`
+
+	// Env are more `KEY=VALUE` variables of the command. They are only read
+	// from the config file, as values may contain commas.
+	Env []string `hcl:"env"`
 }
 
 type Factory struct {
-	command string
-	argv    []string
-	options *Options
-	opts    []Option
+	command   string
+	argv      []string
+	options   *Options
+	opts      []Option
+	envParams []string
 }
 
 func NewFactory(command string, argv []string, options *Options) (*Factory, error) {
@@ -24,12 +40,45 @@ func NewFactory(command string, argv []string, options *Options) (*Factory, erro
 	if options.CloseTimeout >= 0 {
 		opts = append(opts, WithCloseTimeout(time.Duration(options.CloseTimeout)*time.Second))
 	}
+	if options.SuspendAfter > 0 {
+		opts = append(opts, WithSuspendAfter(time.Duration(options.SuspendAfter)*time.Second))
+	}
+	if options.Umask != "" {
+		umask, err := parseUmask(options.Umask)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, WithUmask(umask))
+	}
+
+	for _, env := range options.Env {
+		if key, _, ok := strings.Cut(env, "="); !ok || key == "" {
+			return nil, errors.Errorf("invalid env `%s`, expected KEY=VALUE", env)
+		}
+	}
+	if len(options.Env) > 0 {
+		opts = append(opts, WithEnv(options.Env))
+	}
+	if options.WorkingDir != "" {
+		dir := homedir.Expand(options.WorkingDir)
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return nil, errors.Errorf("working directory `%s` is not a directory", options.WorkingDir)
+		}
+		opts = append(opts, WithWorkingDir(dir))
+	}
+	var envParams []string
+	for _, param := range strings.Split(options.EnvParams, ",") {
+		if param = strings.TrimSpace(param); param != "" {
+			envParams = append(envParams, param)
+		}
+	}
 
 	return &Factory{
-		command: command,
-		argv:    argv,
-		options: options,
-		opts:    opts,
+		command:   command,
+		argv:      argv,
+		options:   options,
+		opts:      opts,
+		envParams: envParams,
 	}, nil
 }
 
@@ -48,5 +97,39 @@ func (factory *Factory) New(params map[string][]string, headers map[string][]str
 		argv = append(argv, params["arg"]...)
 	}
 
-	return New(factory.command, argv, headers, factory.opts...)
+	opts := factory.opts
+	if env := paramsEnv(params, factory.envParams); len(env) > 0 {
+		opts = append(slices.Clone(opts), WithEnv(env))
+	}
+
+	return New(factory.command, argv, headers, opts...)
+}
+
+// paramsEnv returns the allowed params as WEBTMUX_ARG_<NAME> variables.
+// Values with a NUL byte, which cannot be passed in the environment, are
+// skipped.
+func paramsEnv(params map[string][]string, allowed []string) []string {
+	var env []string
+	for _, name := range allowed {
+		values, ok := params[name]
+		if !ok {
+			continue
+		}
+		value := strings.Join(values, ",")
+		if strings.ContainsRune(value, 0) {
+			continue
+		}
+		key := "WEBTMUX_ARG_" + strings.ToUpper(strings.NewReplacer("-", "_", ".", "_").Replace(name))
+		env = append(env, key+"="+value)
+	}
+	return env
+}
+
+// parseUmask parses an octal umask such as "077" or "0022".
+func parseUmask(value string) (os.FileMode, error) {
+	umask, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || umask > 0777 {
+		return 0, errors.Errorf("invalid umask `%s`, expected an octal value up to 777", value)
+	}
+	return os.FileMode(umask), nil
 }
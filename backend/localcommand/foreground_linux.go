@@ -0,0 +1,50 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/pkg/errors"
+)
+
+// ForegroundProcess returns the name of the process group leader currently
+// in the foreground of the pty, as reported by tcgetpgrp and /proc.
+func (lcmd *LocalCommand) ForegroundProcess() (string, error) {
+	pgrp, err := foregroundProcessGroup(lcmd.pty)
+	if err != nil {
+		return "", err
+	}
+
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pgrp))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read name of process %d", pgrp)
+	}
+	return strings.TrimSpace(string(comm)), nil
+}
+
+// foregroundProcessGroup calls tcgetpgrp on f. It goes through SyscallConn
+// because f.Fd() would switch the pty to blocking mode.
+func foregroundProcessGroup(f *os.File) (int, error) {
+	conn, err := f.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+
+	var pgrp int32
+	var errno syscall.Errno
+	err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp)))
+	})
+	if err != nil {
+		return 0, err
+	}
+	if errno != 0 {
+		return 0, errors.Wrapf(errno, "failed to get foreground process group")
+	}
+	return int(pgrp), nil
+}
@@ -0,0 +1,46 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func waitForeground(t *testing.T, lcmd *LocalCommand, want string) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	var name string
+	for time.Now().Before(deadline) {
+		name, _ = lcmd.ForegroundProcess()
+		if name == want {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("ForegroundProcess() = %q, expected %q", name, want)
+}
+
+func TestForegroundProcess(t *testing.T) {
+	// The shell leads the foreground process group until it execs sleep.
+	lcmd, err := New("/bin/sh", []string{"-c", "read line; exec sleep 5"}, nil)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer lcmd.Close()
+	go io.Copy(io.Discard, lcmd)
+
+	waitForeground(t, lcmd, "sh")
+
+	if _, err := lcmd.Write([]byte("\n")); err != nil {
+		t.Fatalf("Write() returned error: %v", err)
+	}
+	waitForeground(t, lcmd, "sleep")
+
+	vars := lcmd.WindowTitleVariables()
+	if vars["foreground"] != "sleep" {
+		t.Errorf("vars[foreground] = %v, expected %v", vars["foreground"], "sleep")
+	}
+}
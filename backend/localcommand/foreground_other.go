@@ -0,0 +1,12 @@
+//go:build !linux
+
+package localcommand
+
+import (
+	"github.com/pkg/errors"
+)
+
+// ForegroundProcess is only supported on Linux.
+func (lcmd *LocalCommand) ForegroundProcess() (string, error) {
+	return "", errors.New("foreground process is not supported on this platform")
+}
@@ -1,19 +1,26 @@
 package localcommand
 
 import (
+	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
 	"github.com/creack/pty"
 	"github.com/pkg/errors"
+
+	"webtmux/server"
 )
 
 const (
 	DefaultCloseSignal  = syscall.SIGINT
 	DefaultCloseTimeout = 10 * time.Second
+
+	exitCodeWait = 100 * time.Millisecond
 )
 
 type LocalCommand struct {
@@ -22,6 +29,15 @@ type LocalCommand struct {
 
 	closeSignal  syscall.Signal
 	closeTimeout time.Duration
+	suspendAfter time.Duration
+	umask        os.FileMode
+	setUmask     bool
+	env          []string
+	workingDir   string
+
+	activity  chan struct{}
+	suspendMu sync.Mutex
+	suspended bool
 
 	cmd       *exec.Cmd
 	pty       *os.File
@@ -29,9 +45,31 @@ type LocalCommand struct {
 }
 
 func New(command string, argv []string, headers map[string][]string, options ...Option) (*LocalCommand, error) {
+	lcmd := &LocalCommand{
+		command: command,
+		argv:    argv,
+
+		closeSignal:  DefaultCloseSignal,
+		closeTimeout: DefaultCloseTimeout,
+
+		activity: make(chan struct{}, 1),
+	}
+
+	for _, option := range options {
+		option(lcmd)
+	}
+
 	cmd := exec.Command(command, argv...)
+	if lcmd.setUmask {
+		// A command that cannot be run fails to start without the wrapper
+		if path, err := exec.LookPath(command); err == nil {
+			cmd = umaskCommand(lcmd.umask, path, argv)
+		}
+	}
 
 	cmd.Env = append(os.Environ(), "TERM=xterm-256color")
+	cmd.Env = append(cmd.Env, lcmd.env...)
+	cmd.Dir = lcmd.workingDir
 
 	denyHeaders := map[string]struct{}{
 		"AUTHORIZATION":       {},
@@ -54,28 +92,14 @@ func New(command string, argv []string, headers map[string][]string, options ...
 		cmd.Env = append(cmd.Env, h)
 	}
 
-	pty, err := pty.Start(cmd)
+	pty, err := startPTY(cmd)
 	if err != nil {
 		// todo close cmd?
 		return nil, errors.Wrapf(err, "failed to start command `%s`", command)
 	}
-	ptyClosed := make(chan struct{})
-
-	lcmd := &LocalCommand{
-		command: command,
-		argv:    argv,
-
-		closeSignal:  DefaultCloseSignal,
-		closeTimeout: DefaultCloseTimeout,
-
-		cmd:       cmd,
-		pty:       pty,
-		ptyClosed: ptyClosed,
-	}
-
-	for _, option := range options {
-		option(lcmd)
-	}
+	lcmd.cmd = cmd
+	lcmd.pty = pty
+	lcmd.ptyClosed = make(chan struct{})
 
 	// When the process is closed by the user,
 	// close pty so that Read() on the pty breaks with an EOF.
@@ -88,19 +112,77 @@ func New(command string, argv []string, headers map[string][]string, options ...
 		lcmd.cmd.Wait()
 	}()
 
+	if lcmd.suspendAfter > 0 {
+		go lcmd.suspendOnInactivity()
+	}
+
 	return lcmd, nil
 }
 
+// umaskCommand runs path with argv under umask. There is no way to set
+// the umask of a child alone from Go, so a shell sets it and replaces
+// itself with the command, which keeps its pid.
+func umaskCommand(umask os.FileMode, path string, argv []string) *exec.Cmd {
+	script := fmt.Sprintf(`umask %03o && exec "$0" "$@"`, umask)
+	return exec.Command("/bin/sh", append([]string{"-c", script, path}, argv...)...)
+}
+
+// openPTY allocates a pty, replaceable in tests to simulate running out of
+// ptys.
+var openPTY = pty.Open
+
+// startPTY starts cmd on a new pty like pty.Start, but reports a failure to
+// allocate the pty as a server.NoPTYError.
+func startPTY(cmd *exec.Cmd) (*os.File, error) {
+	ptmx, tty, err := openPTY()
+	if err != nil {
+		return nil, &server.NoPTYError{Err: err}
+	}
+	defer tty.Close()
+
+	// Keep the streams and process attributes already set on cmd
+	if cmd.Stdin == nil {
+		cmd.Stdin = tty
+	}
+	if cmd.Stdout == nil {
+		cmd.Stdout = tty
+	}
+	if cmd.Stderr == nil {
+		cmd.Stderr = tty
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is a descriptor of the child, the tty is passed as an extra
+	// file when it is not stdin
+	if cmd.Stdin == tty {
+		cmd.SysProcAttr.Ctty = 0
+	} else {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, tty)
+		cmd.SysProcAttr.Ctty = 2 + len(cmd.ExtraFiles)
+	}
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
+
 func (lcmd *LocalCommand) Read(p []byte) (n int, err error) {
 	return lcmd.pty.Read(p)
 }
 
 func (lcmd *LocalCommand) Write(p []byte) (n int, err error) {
+	lcmd.touch()
 	return lcmd.pty.Write(p)
 }
 
 func (lcmd *LocalCommand) Close() error {
 	if lcmd.cmd != nil && lcmd.cmd.Process != nil {
+		// a stopped command would not handle the close signal
+		lcmd.touch()
 		lcmd.cmd.Process.Signal(lcmd.closeSignal)
 	}
 	for {
@@ -114,14 +196,24 @@ func (lcmd *LocalCommand) Close() error {
 }
 
 func (lcmd *LocalCommand) WindowTitleVariables() map[string]interface{} {
+	// Fall back to the command when the foreground process is unknown,
+	// e.g. on platforms other than Linux.
+	foreground, err := lcmd.ForegroundProcess()
+	if err != nil {
+		foreground = filepath.Base(lcmd.command)
+	}
+
 	return map[string]interface{}{
-		"command": lcmd.command,
-		"argv":    lcmd.argv,
-		"pid":     lcmd.cmd.Process.Pid,
+		"command":    lcmd.command,
+		"argv":       lcmd.argv,
+		"args":       lcmd.argv,
+		"pid":        lcmd.cmd.Process.Pid,
+		"foreground": foreground,
 	}
 }
 
 func (lcmd *LocalCommand) ResizeTerminal(width int, height int) error {
+	lcmd.touch()
 	window := pty.Winsize{
 		Rows: uint16(height),
 		Cols: uint16(width),
@@ -136,6 +228,18 @@ func (lcmd *LocalCommand) ResizeTerminal(width int, height int) error {
 	}
 }
 
+// ExitCode returns the exit code of the command. As the pty may report EOF
+// slightly before the process is reaped, it waits up to exitCodeWait for
+// the command to exit.
+func (lcmd *LocalCommand) ExitCode() (int, bool) {
+	select {
+	case <-lcmd.ptyClosed:
+		return lcmd.cmd.ProcessState.ExitCode(), true
+	case <-time.After(exitCodeWait):
+		return 0, false
+	}
+}
+
 func (lcmd *LocalCommand) closeTimeoutC() <-chan time.Time {
 	if lcmd.closeTimeout >= 0 {
 		return time.After(lcmd.closeTimeout)
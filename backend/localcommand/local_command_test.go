@@ -2,9 +2,19 @@ package localcommand
 
 import (
 	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
 	"reflect"
+	"strings"
+	"syscall"
 	"testing"
 	"time"
+
+	"github.com/creack/pty"
+
+	"webtmux/server"
 )
 
 func TestNewFactory(t *testing.T) {
@@ -92,3 +102,192 @@ func TestFactoryNew(t *testing.T) {
 	}
 
 }
+
+func TestWindowTitleVariables(t *testing.T) {
+	factory, err := NewFactory("/bin/cat", []string{"-u"}, &Options{})
+	if err != nil {
+		t.Errorf("NewFactory() returned error")
+		return
+	}
+
+	slave, err := factory.New(map[string][]string{"arg": {"-"}}, nil)
+	if err != nil {
+		t.Errorf("factory.New() returned error: %v", err)
+		return
+	}
+	defer slave.Close()
+
+	vars := slave.WindowTitleVariables()
+	if vars["command"] != "/bin/cat" {
+		t.Errorf("vars[command] = %v, expected %v", vars["command"], "/bin/cat")
+	}
+	if !reflect.DeepEqual(vars["args"], []string{"-u", "-"}) {
+		t.Errorf("vars[args] = %v, expected %v", vars["args"], []string{"-u", "-"})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	lcmd, err := New("/bin/sh", []string{"-c", "exit 3"}, nil)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer lcmd.Close()
+
+	// Drain the pty until the command exits
+	buf := make([]byte, 1024)
+	for {
+		if _, err := lcmd.Read(buf); err != nil {
+			break
+		}
+	}
+
+	code, ok := lcmd.ExitCode()
+	if !ok {
+		t.Fatal("ExitCode() reported the command as running")
+	}
+	if code != 3 {
+		t.Errorf("ExitCode() = %d, expected %d", code, 3)
+	}
+}
+
+func TestNewFactorySuspendAfter(t *testing.T) {
+	factory, err := NewFactory("/bin/cat", []string{}, &Options{CloseTimeout: 1, SuspendAfter: 30})
+	if err != nil {
+		t.Fatalf("NewFactory() returned error: %v", err)
+	}
+
+	slave, err := factory.New(nil, nil)
+	if err != nil {
+		t.Fatalf("factory.New() returned error: %v", err)
+	}
+	defer slave.Close()
+
+	if lcmd := slave.(*LocalCommand); lcmd.suspendAfter != 30*time.Second {
+		t.Errorf("lcmd.suspendAfter = %v, expected %v", lcmd.suspendAfter, 30*time.Second)
+	}
+}
+
+func TestFactoryNewNoPTY(t *testing.T) {
+	openPTY = func() (*os.File, *os.File, error) {
+		return nil, nil, &os.PathError{Op: "open", Path: "/dev/ptmx", Err: syscall.ENOSPC}
+	}
+	defer func() { openPTY = pty.Open }()
+
+	factory, err := NewFactory("/bin/sh", []string{}, &Options{})
+	if err != nil {
+		t.Fatalf("NewFactory() returned error: %v", err)
+	}
+
+	_, err = factory.New(nil, nil)
+	var noPTY *server.NoPTYError
+	if !errors.As(err, &noPTY) {
+		t.Fatalf("factory.New() error = %v, want a NoPTYError", err)
+	}
+	if !errors.Is(err, syscall.ENOSPC) {
+		t.Errorf("factory.New() error = %v, should keep the cause", err)
+	}
+}
+
+func TestStartPTYKeepsCommandSettings(t *testing.T) {
+	var stdout bytes.Buffer
+	attrs := &syscall.SysProcAttr{}
+	// The tty is the controlling terminal though stdin is not
+	cmd := exec.Command("/bin/sh", "-c", "cat; test -t 2 && echo tty")
+	cmd.Stdin = strings.NewReader("input\n")
+	cmd.Stdout = &stdout
+	cmd.SysProcAttr = attrs
+
+	ptmx, err := startPTY(cmd)
+	if err != nil {
+		t.Fatalf("startPTY() error: %v", err)
+	}
+	defer ptmx.Close()
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("command error: %v", err)
+	}
+
+	if cmd.SysProcAttr != attrs || !attrs.Setsid || !attrs.Setctty {
+		t.Errorf("SysProcAttr = %+v, want the given attributes with Setsid and Setctty", cmd.SysProcAttr)
+	}
+	if got := stdout.String(); got != "input\ntty\n" {
+		t.Errorf("stdout = %q, want the given streams and the tty for stderr", got)
+	}
+}
+
+func TestFactoryNewCommandNotFound(t *testing.T) {
+	factory, err := NewFactory("/nonexistent/command", []string{}, &Options{})
+	if err != nil {
+		t.Fatalf("NewFactory() returned error: %v", err)
+	}
+
+	_, err = factory.New(nil, nil)
+	var noPTY *server.NoPTYError
+	if err == nil || errors.As(err, &noPTY) {
+		t.Errorf("factory.New() error = %v, want a start failure that is not a NoPTYError", err)
+	}
+}
+
+func TestNewFactoryInvalidUmask(t *testing.T) {
+	for _, umask := range []string{"8", "0999", "1000", "-1", "u=rwx"} {
+		if _, err := NewFactory("/bin/sh", []string{}, &Options{Umask: umask}); err == nil {
+			t.Errorf("NewFactory() with umask %q should fail", umask)
+		}
+	}
+}
+
+func TestFactoryEnvAndWorkingDir(t *testing.T) {
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	factory, err := NewFactory("/bin/sh", []string{"-c", `echo "[$GREETING|$WEBTMUX_ARG_COLS|$WEBTMUX_ARG_SECRET|$(pwd)]"`}, &Options{
+		CloseTimeout: 1,
+		Env:          []string{"GREETING=hello, world"},
+		WorkingDir:   dir,
+		EnvParams:    "cols",
+	})
+	if err != nil {
+		t.Fatalf("NewFactory() returned error: %v", err)
+	}
+	slave, err := factory.New(map[string][]string{"cols": {"120"}, "secret": {"leaked"}}, nil)
+	if err != nil {
+		t.Fatalf("factory.New() returned error: %v", err)
+	}
+	defer slave.Close()
+
+	var output bytes.Buffer
+	buf := make([]byte, 1024)
+	for {
+		n, err := slave.Read(buf)
+		output.Write(buf[:n])
+		if err != nil {
+			break
+		}
+	}
+	if want := "[hello, world|120||" + dir + "]"; !strings.Contains(output.String(), want) {
+		t.Errorf("output = %q, want %q", output.String(), want)
+	}
+}
+
+func TestNewFactoryInvalidEnvAndWorkingDir(t *testing.T) {
+	if _, err := NewFactory("/bin/sh", []string{}, &Options{Env: []string{"NOVALUE"}}); err == nil {
+		t.Error("NewFactory() with env NOVALUE should fail")
+	}
+	if _, err := NewFactory("/bin/sh", []string{}, &Options{WorkingDir: "/nonexistent/dir"}); err == nil {
+		t.Error("NewFactory() with a missing working directory should fail")
+	}
+}
+
+func TestParamsEnv(t *testing.T) {
+	params := map[string][]string{
+		"cols":      {"120"},
+		"font-size": {"12", "14"},
+		"nul":       {"a\x00b"},
+		"other":     {"x"},
+	}
+	env := paramsEnv(params, []string{"cols", "font-size", "nul", "missing"})
+	want := []string{"WEBTMUX_ARG_COLS=120", "WEBTMUX_ARG_FONT_SIZE=12,14"}
+	if !reflect.DeepEqual(env, want) {
+		t.Errorf("paramsEnv() = %q, want %q", env, want)
+	}
+}
@@ -1,6 +1,7 @@
 package localcommand
 
 import (
+	"os"
 	"syscall"
 	"time"
 )
@@ -18,3 +19,34 @@ func WithCloseTimeout(timeout time.Duration) Option {
 		lcmd.closeTimeout = timeout
 	}
 }
+
+// WithSuspendAfter stops the command with SIGSTOP after the given period
+// without client input and continues it on the next input or resize.
+func WithSuspendAfter(period time.Duration) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.suspendAfter = period
+	}
+}
+
+// WithUmask runs the command with the given umask instead of the server's.
+func WithUmask(umask os.FileMode) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.umask = umask
+		lcmd.setUmask = true
+	}
+}
+
+// WithEnv adds KEY=VALUE variables to the environment of the command.
+func WithEnv(env []string) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.env = append(lcmd.env, env...)
+	}
+}
+
+// WithWorkingDir runs the command in dir instead of the server's working
+// directory.
+func WithWorkingDir(dir string) Option {
+	return func(lcmd *LocalCommand) {
+		lcmd.workingDir = dir
+	}
+}
@@ -0,0 +1,65 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"webtmux/server"
+)
+
+// clockTicks is USER_HZ, the unit of CPU times in /proc, which is 100 on
+// all mainstream architectures.
+const clockTicks = 100
+
+// ResourceUsage sums the CPU time and resident memory of all processes in
+// the session led by the command, including background jobs of a shell.
+func (lcmd *LocalCommand) ResourceUsage() (server.ResourceUsage, error) {
+	var usage server.ResourceUsage
+
+	dirs, err := os.ReadDir("/proc")
+	if err != nil {
+		return usage, errors.Wrap(err, "failed to list processes")
+	}
+
+	sid := lcmd.cmd.Process.Pid
+	pageSize := int64(os.Getpagesize())
+	found := false
+	for _, dir := range dirs {
+		if _, err := strconv.Atoi(dir.Name()); err != nil {
+			continue
+		}
+		stat, err := os.ReadFile("/proc/" + dir.Name() + "/stat")
+		if err != nil {
+			// the process exited meanwhile
+			continue
+		}
+
+		// The command name may contain spaces, so split after its closing
+		// parenthesis; fields[n-3] is then field n of proc(5).
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) < 22 {
+			continue
+		}
+		if session, _ := strconv.Atoi(fields[3]); session != sid {
+			continue
+		}
+
+		utime, _ := strconv.ParseInt(fields[11], 10, 64)
+		stime, _ := strconv.ParseInt(fields[12], 10, 64)
+		rss, _ := strconv.ParseInt(fields[21], 10, 64)
+		usage.CPUTime += time.Duration(utime+stime) * time.Second / clockTicks
+		usage.RSS += rss * pageSize
+		found = true
+	}
+
+	if !found {
+		return usage, errors.New("command is not running")
+	}
+	return usage, nil
+}
@@ -0,0 +1,49 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"testing"
+	"time"
+)
+
+func TestResourceUsage(t *testing.T) {
+	lcmd, err := New("/bin/sh", []string{"-c", "while :; do :; done"}, nil, WithCloseSignal(9), WithCloseTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer lcmd.Close()
+
+	first, err := lcmd.ResourceUsage()
+	if err != nil {
+		t.Fatalf("ResourceUsage() error: %v", err)
+	}
+
+	// The busy loop accumulates CPU time in ticks of 10ms
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		usage, err := lcmd.ResourceUsage()
+		if err != nil {
+			t.Fatalf("ResourceUsage() error: %v", err)
+		}
+		if usage.CPUTime > first.CPUTime && usage.RSS > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ResourceUsage() = %+v, want CPU time increased from %s and a positive RSS", usage, first.CPUTime)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestResourceUsageAfterExit(t *testing.T) {
+	lcmd, err := New("/bin/true", nil, nil)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	<-lcmd.ptyClosed
+
+	if _, err := lcmd.ResourceUsage(); err == nil {
+		t.Error("ResourceUsage() should fail once the command has exited")
+	}
+}
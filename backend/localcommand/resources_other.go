@@ -0,0 +1,14 @@
+//go:build !linux
+
+package localcommand
+
+import (
+	"github.com/pkg/errors"
+
+	"webtmux/server"
+)
+
+// ResourceUsage is only supported on Linux.
+func (lcmd *LocalCommand) ResourceUsage() (server.ResourceUsage, error) {
+	return server.ResourceUsage{}, errors.New("resource usage is not supported on this platform")
+}
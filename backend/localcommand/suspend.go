@@ -0,0 +1,53 @@
+package localcommand
+
+import (
+	"log"
+	"time"
+)
+
+// touch records client activity, resuming the command if it was suspended.
+func (lcmd *LocalCommand) touch() {
+	if lcmd.suspendAfter <= 0 {
+		return
+	}
+
+	lcmd.suspendMu.Lock()
+	if lcmd.suspended {
+		if err := resumeProcess(lcmd.cmd.Process.Pid); err != nil {
+			log.Printf("Failed to resume command: %v", err)
+		}
+		lcmd.suspended = false
+	}
+	lcmd.suspendMu.Unlock()
+
+	select {
+	case lcmd.activity <- struct{}{}:
+	default:
+	}
+}
+
+// suspendOnInactivity stops the command after suspendAfter without client
+// activity until the command exits.
+func (lcmd *LocalCommand) suspendOnInactivity() {
+	timer := time.NewTimer(lcmd.suspendAfter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-lcmd.ptyClosed:
+			return
+		case <-lcmd.activity:
+			timer.Reset(lcmd.suspendAfter)
+		case <-timer.C:
+			lcmd.suspendMu.Lock()
+			if !lcmd.suspended {
+				if err := suspendProcess(lcmd.cmd.Process.Pid); err != nil {
+					log.Printf("Failed to suspend command: %v", err)
+				} else {
+					lcmd.suspended = true
+				}
+			}
+			lcmd.suspendMu.Unlock()
+		}
+	}
+}
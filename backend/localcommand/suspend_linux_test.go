@@ -0,0 +1,97 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// processState returns the state letter from /proc/<pid>/stat, e.g. "T" for
+// a stopped process.
+func processState(t *testing.T, pid int) string {
+	t.Helper()
+
+	stat, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		t.Fatalf("failed to read process stat: %v", err)
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return fields[0]
+}
+
+func waitState(t *testing.T, pid int, stopped bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(3 * time.Second)
+	var state string
+	for time.Now().Before(deadline) {
+		state = processState(t, pid)
+		if (state == "T") == stopped {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("process state = %s, want stopped = %v", state, stopped)
+}
+
+func TestSuspendAfterInactivity(t *testing.T) {
+	lcmd, err := New("/bin/cat", nil, nil, WithSuspendAfter(100*time.Millisecond), WithCloseTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer lcmd.Close()
+	pid := lcmd.cmd.Process.Pid
+
+	waitState(t, pid, true)
+
+	if _, err := lcmd.Write([]byte("foo\n")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+	waitState(t, pid, false)
+
+	// cat must echo the input once resumed
+	buf := make([]byte, 64)
+	got := ""
+	for !strings.Contains(got, "foo\r\nfoo") {
+		n, err := lcmd.Read(buf)
+		if err != nil && err != io.EOF {
+			t.Fatalf("Read() error: %v", err)
+		}
+		got += string(buf[:n])
+	}
+
+	// and be stopped again once idle
+	waitState(t, pid, true)
+}
+
+func TestSuspendDisabledByDefault(t *testing.T) {
+	lcmd, err := New("/bin/cat", nil, nil, WithCloseTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer lcmd.Close()
+
+	time.Sleep(200 * time.Millisecond)
+	if state := processState(t, lcmd.cmd.Process.Pid); state == "T" {
+		t.Errorf("process state = %s, should not be stopped", state)
+	}
+}
+
+func TestCloseResumesSuspendedCommand(t *testing.T) {
+	lcmd, err := New("/bin/cat", nil, nil, WithSuspendAfter(50*time.Millisecond), WithCloseSignal(15), WithCloseTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	waitState(t, lcmd.cmd.Process.Pid, true)
+
+	start := time.Now()
+	lcmd.Close()
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Close() took %s, the close signal should be handled after resuming", elapsed)
+	}
+}
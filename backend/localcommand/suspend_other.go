@@ -0,0 +1,17 @@
+//go:build !unix
+
+package localcommand
+
+import (
+	"github.com/pkg/errors"
+)
+
+// Suspending the command is only supported on Unix.
+
+func suspendProcess(pid int) error {
+	return errors.New("suspending the command is not supported on this platform")
+}
+
+func resumeProcess(pid int) error {
+	return errors.New("resuming the command is not supported on this platform")
+}
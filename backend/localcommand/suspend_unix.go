@@ -0,0 +1,18 @@
+//go:build unix
+
+package localcommand
+
+import (
+	"syscall"
+)
+
+// The command leads its own session, so signalling the process group also
+// stops and continues its children.
+
+func suspendProcess(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGSTOP)
+}
+
+func resumeProcess(pid int) error {
+	return syscall.Kill(-pid, syscall.SIGCONT)
+}
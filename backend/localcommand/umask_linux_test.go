@@ -0,0 +1,78 @@
+//go:build linux
+
+package localcommand
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// createFile runs a session creating path and waits for it to exit.
+func createFile(t *testing.T, path string, options ...Option) {
+	t.Helper()
+	lcmd, err := New("/bin/sh", []string{"-c", `echo data > "$0"`, path}, nil, options...)
+	if err != nil {
+		t.Fatalf("New() returned error: %v", err)
+	}
+	defer lcmd.Close()
+
+	// Drain the pty until the command exits
+	buf := make([]byte, 1024)
+	for {
+		if _, err := lcmd.Read(buf); err != nil {
+			break
+		}
+	}
+	if code, ok := lcmd.ExitCode(); !ok || code != 0 {
+		t.Fatalf("ExitCode() = %d, %v, want 0, true", code, ok)
+	}
+}
+
+func TestUmask(t *testing.T) {
+	for _, umask := range []os.FileMode{0077, 0027, 0} {
+		path := filepath.Join(t.TempDir(), "file")
+		createFile(t, path, WithUmask(umask))
+
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("file was not created: %v", err)
+		}
+		if want := 0666 &^ umask; info.Mode().Perm() != want {
+			t.Errorf("umask %03o: file mode = %v, want %v", umask, info.Mode().Perm(), want)
+		}
+	}
+}
+
+func TestFactoryUmask(t *testing.T) {
+	factory, err := NewFactory("/bin/sh", []string{"-c", `echo data > "$0"`}, &Options{CloseTimeout: 1, Umask: "077"})
+	if err != nil {
+		t.Fatalf("NewFactory() returned error: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "file")
+	slave, err := factory.New(map[string][]string{"arg": {path}}, nil)
+	if err != nil {
+		t.Fatalf("factory.New() returned error: %v", err)
+	}
+	defer slave.Close()
+
+	buf := make([]byte, 1024)
+	for {
+		if _, err := slave.Read(buf); err != nil {
+			break
+		}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("file was not created: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("file mode = %v, want %v", info.Mode().Perm(), os.FileMode(0600))
+	}
+}
+
+func TestUmaskCommandNotFound(t *testing.T) {
+	if _, err := New("/nonexistent/command", nil, nil, WithUmask(0077)); err == nil {
+		t.Error("New() should fail to start a command that does not exist")
+	}
+}
@@ -8,6 +8,9 @@ import { OurXterm } from "./xterm";
 declare var gotty_auth_token: string;
 declare var gotty_ws_query_args: string;
 declare var gotty_webtransport_enabled: boolean;
+declare var gotty_wt_checksum: boolean;
+declare var gotty_wt_wide_frames: boolean;
+declare var gotty_wt_datagrams: boolean;
 // WebTransport uses same port as HTTP (UDP instead of TCP)
 
 /**
@@ -34,7 +37,12 @@ class FallbackTransportFactory implements TransportFactory {
 
         this.activeProtocol = 'webtransport';
         return new FallbackTransport(
-            () => new WebTransportConnection(this.wtUrl),
+            () => new WebTransportConnection(
+                this.wtUrl,
+                typeof gotty_wt_checksum !== 'undefined' && gotty_wt_checksum,
+                typeof gotty_wt_wide_frames !== 'undefined' && gotty_wt_wide_frames,
+                typeof gotty_wt_datagrams !== 'undefined' && gotty_wt_datagrams,
+            ),
             () => new WebSocketConnection(this.wsUrl, this.protocols),
             () => { this.wtFailed = true; }
         );
@@ -3,9 +3,24 @@ import type { Transport, TransportFactory } from './transport';
 /**
  * WebTransport connection wrapper that implements the Transport interface.
  * Uses length-prefixed framing to match WebSocket message semantics.
+ * A zero-length frame means the server moved to a new stream, which is
+ * accepted from the incoming bidirectional streams.
+ * With checksums enabled, bit 15 of the length prefix marks a frame whose
+ * payload is followed by its 4-byte big-endian CRC32.
+ * With wide frames, asked for with frames=wide in the URL, the length prefix
+ * has 4 bytes instead of 2, and bit 31 is the checksum flag.
+ * With datagrams, short messages are sent as datagrams prefixed with a
+ * 4-byte big-endian sequence number. They skip the head-of-line blocking of
+ * the stream but may be lost, which is the tradeoff made for typing over
+ * lossy networks.
  */
 export class WebTransportConnection implements Transport {
     private url: string;
+    private checksum: boolean;
+    private wideFrames: boolean;
+    private datagrams: boolean;
+    private datagramWriter: WritableStreamDefaultWriter<Uint8Array> | null = null;
+    private datagramSeq: number = 0;
     private transport: WebTransport | null = null;
     private stream: WritableStreamDefaultWriter<Uint8Array> | null = null;
     private reader: ReadableStreamDefaultReader<Uint8Array> | null = null;
@@ -17,8 +32,11 @@ export class WebTransportConnection implements Transport {
     private isConnected: boolean = false;
     private readBuffer: Uint8Array = new Uint8Array(0);
 
-    constructor(url: string) {
+    constructor(url: string, checksum: boolean = false, wideFrames: boolean = false, datagrams: boolean = false) {
         this.url = url;
+        this.checksum = checksum;
+        this.wideFrames = wideFrames;
+        this.datagrams = datagrams;
     }
 
     open(): void {
@@ -27,7 +45,11 @@ export class WebTransportConnection implements Transport {
 
     private async connect(): Promise<void> {
         try {
-            this.transport = new WebTransport(this.url);
+            let url = this.url;
+            if (this.wideFrames) {
+                url += (url.includes('?') ? '&' : '?') + 'frames=wide';
+            }
+            this.transport = new WebTransport(url);
 
             // Wait for connection to be ready
             await this.transport.ready;
@@ -38,6 +60,9 @@ export class WebTransportConnection implements Transport {
             const stream = await this.transport.createBidirectionalStream();
             this.stream = stream.writable.getWriter();
             this.reader = stream.readable.getReader();
+            if (this.datagrams && this.transport.datagrams) {
+                this.datagramWriter = this.transport.datagrams.writable.getWriter();
+            }
 
             // Start reading loop
             this.startReading();
@@ -94,17 +119,42 @@ export class WebTransportConnection implements Transport {
         const encoder = new TextEncoder();
         const payload = encoder.encode(data);
 
-        // Create length-prefixed frame (2 bytes big-endian length + payload)
-        const frame = new Uint8Array(2 + payload.length);
-        frame[0] = (payload.length >> 8) & 0xff;
-        frame[1] = payload.length & 0xff;
-        frame.set(payload, 2);
+        if (this.datagramWriter && payload.length > 0 && payload.length <= DATAGRAM_MAX_PAYLOAD) {
+            this.sendDatagram(payload);
+            return;
+        }
+
+        // Create length-prefixed frame (2 or 4 bytes big-endian length + payload)
+        const headerLength = this.wideFrames ? 4 : 2;
+        const trailer = this.checksum ? 4 : 0;
+        const frame = new Uint8Array(headerLength + payload.length + trailer);
+        const view = new DataView(frame.buffer);
+        if (this.wideFrames) {
+            view.setUint32(0, this.checksum ? (payload.length | WIDE_CHECKSUM_FLAG) >>> 0 : payload.length);
+        } else {
+            view.setUint16(0, this.checksum ? payload.length | CHECKSUM_FLAG : payload.length);
+        }
+        frame.set(payload, headerLength);
+        if (this.checksum) {
+            view.setUint32(headerLength + payload.length, crc32(payload));
+        }
 
         this.stream.write(frame).catch((error) => {
             console.error('WebTransport send error:', error);
         });
     }
 
+    private sendDatagram(payload: Uint8Array): void {
+        const datagram = new Uint8Array(4 + payload.length);
+        this.datagramSeq++;
+        new DataView(datagram.buffer).setUint32(0, this.datagramSeq);
+        datagram.set(payload, 4);
+        this.datagramWriter!.write(datagram).catch((error) => {
+            console.error('WebTransport datagram send error, using the stream:', error);
+            this.datagramWriter = null;
+        });
+    }
+
     isOpen(): boolean {
         return this.isConnected;
     }
@@ -121,13 +171,37 @@ export class WebTransportConnection implements Transport {
         this.closeCallback = callback;
     }
 
+    private async migrateStream(): Promise<void> {
+        if (!this.transport) return;
+
+        const incoming = this.transport.incomingBidirectionalStreams.getReader();
+        const { value: stream, done } = await incoming.read();
+        incoming.releaseLock();
+        if (done || !stream) {
+            throw new Error('WebTransport stream migration failed');
+        }
+
+        const oldWriter = this.stream;
+        const oldReader = this.reader;
+        this.stream = stream.writable.getWriter();
+        this.reader = stream.readable.getReader();
+        this.readBuffer = new Uint8Array(0);
+
+        if (oldWriter) {
+            oldWriter.close().catch(() => {});
+        }
+        if (oldReader) {
+            oldReader.releaseLock();
+        }
+    }
+
     private async startReading(): Promise<void> {
         if (!this.reader) return;
 
         const decoder = new TextDecoder();
 
         try {
-            while (this.isConnected) {
+            while (this.isConnected && this.reader) {
                 const { value, done } = await this.reader.read();
 
                 if (done) {
@@ -144,18 +218,46 @@ export class WebTransportConnection implements Transport {
                 this.readBuffer = newBuffer;
 
                 // Process complete frames
-                while (this.readBuffer.length >= 2) {
-                    // Read length prefix (2 bytes big-endian)
-                    const length = (this.readBuffer[0] << 8) | this.readBuffer[1];
+                let migrate = false;
+                const headerLength = this.wideFrames ? 4 : 2;
+                while (this.readBuffer.length >= headerLength) {
+                    // Read length prefix (2 or 4 bytes big-endian)
+                    const view = new DataView(this.readBuffer.buffer, this.readBuffer.byteOffset);
+                    let length: number;
+                    let checksummed: boolean;
+                    if (this.wideFrames) {
+                        length = view.getUint32(0);
+                        checksummed = this.checksum && length >= WIDE_CHECKSUM_FLAG;
+                        if (checksummed) {
+                            length -= WIDE_CHECKSUM_FLAG;
+                        }
+                    } else {
+                        length = view.getUint16(0);
+                        checksummed = this.checksum && (length & CHECKSUM_FLAG) !== 0;
+                        if (checksummed) {
+                            length &= ~CHECKSUM_FLAG;
+                        }
+                    }
+                    const trailer = checksummed ? 4 : 0;
 
                     // Check if we have complete frame
-                    if (this.readBuffer.length < 2 + length) {
+                    if (this.readBuffer.length < headerLength + length + trailer) {
                         break; // Wait for more data
                     }
 
                     // Extract payload
-                    const payload = this.readBuffer.slice(2, 2 + length);
-                    this.readBuffer = this.readBuffer.slice(2 + length);
+                    const payload = this.readBuffer.slice(headerLength, headerLength + length);
+                    if (checksummed) {
+                        if (view.getUint32(headerLength + length) !== crc32(payload)) {
+                            throw new Error('WebTransport frame checksum mismatch');
+                        }
+                    }
+                    this.readBuffer = this.readBuffer.slice(headerLength + length + trailer);
+
+                    if (length === 0) {
+                        migrate = true;
+                        break;
+                    }
 
                     // Decode and deliver message
                     const message = decoder.decode(payload);
@@ -163,13 +265,48 @@ export class WebTransportConnection implements Transport {
                         this.receiveCallback(message);
                     }
                 }
+
+                if (migrate) {
+                    await this.migrateStream();
+                }
             }
         } catch (error) {
             if (this.isConnected) {
                 console.error('WebTransport read error:', error);
+                if (this.transport) {
+                    this.transport.close({ closeCode: 1, reason: String(error) });
+                }
+            }
+        }
+    }
+}
+
+const CHECKSUM_FLAG = 0x8000;
+const WIDE_CHECKSUM_FLAG = 0x80000000;
+// Matches wtDatagramMaxPayload of the server
+const DATAGRAM_MAX_PAYLOAD = 1000;
+
+let crcTable: Uint32Array | null = null;
+
+/**
+ * crc32 computes the IEEE CRC32 of data, matching Go's crc32.ChecksumIEEE.
+ */
+function crc32(data: Uint8Array): number {
+    if (!crcTable) {
+        crcTable = new Uint32Array(256);
+        for (let n = 0; n < 256; n++) {
+            let c = n;
+            for (let k = 0; k < 8; k++) {
+                c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
             }
+            crcTable[n] = c >>> 0;
         }
     }
+    let crc = 0xffffffff;
+    for (let i = 0; i < data.length; i++) {
+        crc = crcTable[(crc ^ data[i]) & 0xff] ^ (crc >>> 8);
+    }
+    return (crc ^ 0xffffffff) >>> 0;
 }
 
 /**
@@ -13,6 +13,7 @@ export const msgSetWindowTitle = '3';
 export const msgSetPreferences = '4';
 export const msgSetReconnect = '5';
 export const msgSetBufferSize = '6';
+export const msgConnectionReady = 'C';
 
 
 export interface Terminal {
@@ -235,6 +236,9 @@ export class WebTTY {
                         const bufSize = JSON.parse(payload);
                         this.bufSize = bufSize;
                         break;
+                    case msgConnectionReady:
+                        // The backend is attached; nothing to do yet
+                        break;
                 }
             });
 
@@ -15,6 +15,7 @@ import (
 
 	cli "github.com/urfave/cli/v2"
 
+	"webtmux/backend/docker"
 	"webtmux/backend/localcommand"
 	"webtmux/pkg/homedir"
 	"webtmux/server"
@@ -37,7 +38,12 @@ func main() {
 		exit(err, 1)
 	}
 
-	cliFlags, flagMappings, err := utils.GenerateFlags(appOptions, backendOptions)
+	dockerOptions := &docker.Options{}
+	if err := utils.ApplyDefaultValues(dockerOptions); err != nil {
+		exit(err, 1)
+	}
+
+	cliFlags, flagMappings, err := utils.GenerateFlags(appOptions, backendOptions, dockerOptions)
 	if err != nil {
 		exit(err, 3)
 	}
@@ -61,12 +67,12 @@ func main() {
 		configFile := c.String("config")
 		_, err := os.Stat(homedir.Expand(configFile))
 		if configFile != "~/.gotty" || !os.IsNotExist(err) {
-			if err := utils.ApplyConfigFile(configFile, appOptions, backendOptions); err != nil {
+			if err := utils.ApplyConfigFile(configFile, appOptions, backendOptions, dockerOptions); err != nil {
 				exit(err, 2)
 			}
 		}
 
-		utils.ApplyFlags(cliFlags, flagMappings, c, appOptions, backendOptions)
+		utils.ApplyFlags(cliFlags, flagMappings, c, appOptions, backendOptions, dockerOptions)
 
 		if appOptions.Quiet {
 			log.SetFlags(0)
@@ -77,7 +83,10 @@ func main() {
 		if appOptions.NoAuth {
 			appOptions.EnableBasicAuth = false
 			log.Printf("WARNING: Authentication disabled. Terminal is publicly accessible!")
-		} else if c.IsSet("credential") {
+		} else if c.IsSet("credential") || len(appOptions.Credentials) > 0 || appOptions.CredentialFile != "" {
+			appOptions.EnableBasicAuth = true
+		} else if appOptions.AuthMode == "jwt" {
+			// Clients authenticate with tokens from the identity provider
 			appOptions.EnableBasicAuth = true
 		} else {
 			// Generate random credentials
@@ -105,7 +114,12 @@ func main() {
 		}
 
 		args := c.Args()
-		factory, err := localcommand.NewFactory(args.First(), args.Tail(), backendOptions)
+		var factory server.Factory
+		if dockerOptions.Container != "" {
+			factory, err = docker.NewFactory(args.First(), args.Tail(), dockerOptions)
+		} else {
+			factory, err = localcommand.NewFactory(args.First(), args.Tail(), backendOptions)
+		}
 		if err != nil {
 			exit(err, 3)
 		}
@@ -127,6 +141,10 @@ func main() {
 
 		log.Printf("WebTmux is starting with command: %s", strings.Join(args.Slice(), " "))
 
+		if appOptions.CredentialFile != "" {
+			go reloadCredentialsOnHangup(srv)
+		}
+
 		errs := make(chan error, 1)
 		go func() {
 			errs <- srv.Run(ctx, server.WithGracefullContext(gCtx))
@@ -150,6 +168,17 @@ func exit(err error, code int) {
 	os.Exit(code)
 }
 
+// reloadCredentialsOnHangup reloads the credential file on every SIGHUP.
+func reloadCredentialsOnHangup(srv *server.Server) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+	for range sigChan {
+		if err := srv.ReloadCredentials(); err != nil {
+			log.Printf("Warning: keeping previous credentials: %v", err)
+		}
+	}
+}
+
 func waitSignals(errs chan error, cancel context.CancelFunc, gracefullCancel context.CancelFunc) error {
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(
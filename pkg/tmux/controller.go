@@ -149,7 +149,8 @@ func (c *Controller) RefreshLayout() error {
 			if paneLine == "" {
 				continue
 			}
-			paneParts := strings.Split(paneLine, ",")
+			// pane titles may contain commas, so keep the rest of the line
+			paneParts := strings.SplitN(paneLine, ",", 9)
 			if len(paneParts) < 9 {
 				continue
 			}
@@ -0,0 +1,85 @@
+package tmux
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+// fakeTmux answers the queries issued by RefreshLayout with a fixed
+// two-pane layout.
+const fakeTmux = `#!/bin/sh
+case "$1" in
+display-message) echo '$1,main' ;;
+list-sessions) echo '$1,main,1,1' ;;
+list-windows) echo '@1,editor,0,1' ;;
+list-panes)
+	echo '%1,0,1,80,23,0,0,vim,notes, draft'
+	echo '%2,1,0,39,23,0,81,bash,host'
+	;;
+*) exit 1 ;;
+esac
+`
+
+func installFakeTmux(t *testing.T) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("fake tmux requires a POSIX shell")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tmux"), []byte(fakeTmux), 0755); err != nil {
+		t.Fatalf("failed to write fake tmux: %v", err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRefreshLayout(t *testing.T) {
+	installFakeTmux(t)
+
+	c, err := NewController("main")
+	if err != nil {
+		t.Fatalf("NewController() error: %v", err)
+	}
+	if err := c.RefreshLayout(); err != nil {
+		t.Fatalf("RefreshLayout() error: %v", err)
+	}
+
+	want := &Layout{
+		SessionID:   "$1",
+		SessionName: "main",
+		Sessions: []Session{
+			{ID: "$1", Name: "main", Windows: 1, Attached: true, Active: true},
+		},
+		Windows: []Window{{
+			ID:     "@1",
+			Name:   "editor",
+			Index:  0,
+			Active: true,
+			Panes: []Pane{
+				{ID: "%1", Index: 0, Active: true, Width: 80, Height: 23, Top: 0, Left: 0, Command: "vim", Title: "notes, draft"},
+				{ID: "%2", Index: 1, Active: false, Width: 39, Height: 23, Top: 0, Left: 81, Command: "bash", Title: "host"},
+			},
+		}},
+		ActiveWinID:  "@1",
+		ActivePaneID: "%1",
+	}
+	if got := c.GetLayout(); !reflect.DeepEqual(got, want) {
+		t.Errorf("GetLayout() = %+v, want %+v", got, want)
+	}
+}
+
+func TestRunTmuxError(t *testing.T) {
+	installFakeTmux(t)
+
+	c, _ := NewController("main")
+	// an unknown command makes the fake tmux fail
+	if _, err := c.runTmux("kill-server"); err == nil {
+		t.Error("runTmux() should fail when tmux exits with an error")
+	}
+	if c.GetLayout() != nil {
+		t.Error("GetLayout() should be nil before a successful refresh")
+	}
+}
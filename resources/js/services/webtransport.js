@@ -0,0 +1,235 @@
+// WebTransport Service - A WebSocket-like connection over WebTransport
+//
+// Messages are sent on a bidirectional stream as frames with a 2-byte
+// big-endian length prefix. A zero-length frame means the server moved to
+// a new stream, which is taken from the incoming bidirectional streams.
+// With checksums, bit 15 of the length prefix marks a frame whose payload
+// is followed by its 4-byte big-endian CRC32.
+// With wide frames, asked for with frames=wide in the URL, the length prefix
+// has 4 bytes instead of 2, and bit 31 is the checksum flag.
+// With datagrams, short messages are sent as datagrams prefixed with a
+// 4-byte big-endian sequence number. They skip the head-of-line blocking of
+// the stream but may be lost.
+
+const CHECKSUM_FLAG = 0x8000;
+const WIDE_CHECKSUM_FLAG = 0x80000000;
+// Matches wtDatagramMaxPayload of the server
+const DATAGRAM_MAX_PAYLOAD = 1000;
+
+export function isWebTransportSupported() {
+  return typeof WebTransport !== 'undefined';
+}
+
+export class WebTransportConnection {
+  constructor(url, { checksum = false, wideFrames = false, datagrams = false } = {}) {
+    this.checksum = checksum;
+    this.datagrams = datagrams;
+    this.datagramWriter = null;
+    this.datagramSeq = 0;
+    this.wideFrames = wideFrames;
+    this.headerLength = wideFrames ? 4 : 2;
+    // Same values as WebSocket.CONNECTING, OPEN and CLOSED
+    this.readyState = 0;
+    this.onopen = null;
+    this.onmessage = null;
+    this.onclose = null;
+    this.onerror = null;
+
+    this.transport = null;
+    this.writer = null;
+    this.reader = null;
+    this.readBuffer = new Uint8Array(0);
+    this.encoder = new TextEncoder();
+    this.decoder = new TextDecoder();
+
+    if (wideFrames) {
+      url += (url.includes('?') ? '&' : '?') + 'frames=wide';
+    }
+    this.connect(url);
+  }
+
+  async connect(url) {
+    try {
+      this.transport = new WebTransport(url);
+      await this.transport.ready;
+
+      const stream = await this.transport.createBidirectionalStream();
+      this.writer = stream.writable.getWriter();
+      this.reader = stream.readable.getReader();
+      if (this.datagrams && this.transport.datagrams) {
+        this.datagramWriter = this.transport.datagrams.writable.getWriter();
+      }
+      this.readyState = 1;
+
+      this.transport.closed
+        .catch((error) => console.error('WebTransport closed with error:', error))
+        .then(() => this.handleClose());
+
+      this.readLoop();
+      if (this.onopen) this.onopen();
+    } catch (error) {
+      console.error('WebTransport connection failed:', error);
+      if (this.onerror) this.onerror(error);
+      this.handleClose();
+    }
+  }
+
+  send(data) {
+    if (this.readyState !== 1) {
+      console.warn('WebTransport not ready, state:', this.readyState);
+      return;
+    }
+    const payload = this.encoder.encode(data);
+    if (this.datagramWriter && payload.length > 0 && payload.length <= DATAGRAM_MAX_PAYLOAD) {
+      this.sendDatagram(payload);
+      return;
+    }
+    this.writer.write(this.encodeFrame(payload)).catch((error) => {
+      console.error('WebTransport send error:', error);
+    });
+  }
+
+  // Send a payload as a datagram, using the stream from now on when
+  // datagrams fail
+  sendDatagram(payload) {
+    const datagram = new Uint8Array(4 + payload.length);
+    this.datagramSeq++;
+    new DataView(datagram.buffer).setUint32(0, this.datagramSeq);
+    datagram.set(payload, 4);
+    this.datagramWriter.write(datagram).catch((error) => {
+      console.error('WebTransport datagram send error, using the stream:', error);
+      this.datagramWriter = null;
+    });
+  }
+
+  close() {
+    if (this.transport) {
+      this.transport.close();
+    }
+    this.handleClose();
+  }
+
+  handleClose() {
+    if (this.readyState === 3) return;
+    this.readyState = 3;
+    if (this.onclose) this.onclose();
+  }
+
+  // Frame a payload with its length prefix and checksum
+  encodeFrame(payload) {
+    const header = this.headerLength;
+    const trailer = this.checksum ? 4 : 0;
+    const frame = new Uint8Array(header + payload.length + trailer);
+    const view = new DataView(frame.buffer);
+    if (this.wideFrames) {
+      view.setUint32(0, this.checksum ? (payload.length | WIDE_CHECKSUM_FLAG) >>> 0 : payload.length);
+    } else {
+      view.setUint16(0, this.checksum ? payload.length | CHECKSUM_FLAG : payload.length);
+    }
+    frame.set(payload, header);
+    if (this.checksum) {
+      view.setUint32(header + payload.length, crc32(payload));
+    }
+    return frame;
+  }
+
+  // Take the next complete frame off the read buffer, or null until more
+  // data arrives
+  decodeFrame() {
+    const header = this.headerLength;
+    if (this.readBuffer.length < header) return null;
+    const view = new DataView(this.readBuffer.buffer, this.readBuffer.byteOffset);
+    let length;
+    let checksummed;
+    if (this.wideFrames) {
+      length = view.getUint32(0);
+      checksummed = this.checksum && length >= WIDE_CHECKSUM_FLAG;
+      if (checksummed) {
+        length -= WIDE_CHECKSUM_FLAG;
+      }
+    } else {
+      length = view.getUint16(0);
+      checksummed = this.checksum && (length & CHECKSUM_FLAG) !== 0;
+      if (checksummed) {
+        length &= ~CHECKSUM_FLAG;
+      }
+    }
+    const trailer = checksummed ? 4 : 0;
+    if (this.readBuffer.length < header + length + trailer) return null;
+
+    const payload = this.readBuffer.slice(header, header + length);
+    if (checksummed && view.getUint32(header + length) !== crc32(payload)) {
+      throw new Error('WebTransport frame checksum mismatch');
+    }
+    this.readBuffer = this.readBuffer.slice(header + length + trailer);
+    return payload;
+  }
+
+  async readLoop() {
+    try {
+      while (this.readyState === 1) {
+        const { value, done } = await this.reader.read();
+        if (done) break;
+        if (!value) continue;
+
+        const buffer = new Uint8Array(this.readBuffer.length + value.length);
+        buffer.set(this.readBuffer);
+        buffer.set(value, this.readBuffer.length);
+        this.readBuffer = buffer;
+
+        let payload;
+        while ((payload = this.decodeFrame()) !== null) {
+          if (payload.length === 0) {
+            await this.migrateStream();
+            break;
+          }
+          if (this.onmessage) this.onmessage({ data: this.decoder.decode(payload) });
+        }
+      }
+    } catch (error) {
+      if (this.readyState === 1) {
+        console.error('WebTransport read error:', error);
+        this.transport.close({ closeCode: 1, reason: String(error) });
+      }
+    }
+  }
+
+  // Switch reading and writing to the stream the server opened
+  async migrateStream() {
+    const incoming = this.transport.incomingBidirectionalStreams.getReader();
+    const { value: stream, done } = await incoming.read();
+    incoming.releaseLock();
+    if (done || !stream) {
+      throw new Error('WebTransport stream migration failed');
+    }
+
+    const oldWriter = this.writer;
+    const oldReader = this.reader;
+    this.writer = stream.writable.getWriter();
+    this.reader = stream.readable.getReader();
+    this.readBuffer = new Uint8Array(0);
+    oldWriter.close().catch(() => {});
+    oldReader.releaseLock();
+  }
+}
+
+let crcTable = null;
+
+// IEEE CRC32 of data, matching Go's crc32.ChecksumIEEE
+function crc32(data) {
+  if (!crcTable) {
+    crcTable = new Uint32Array(256);
+    for (let n = 0; n < 256; n++) {
+      let c = n;
+      for (let k = 0; k < 8; k++) {
+        c = c & 1 ? 0xedb88320 ^ (c >>> 1) : c >>> 1;
+      }
+      crcTable[n] = c >>> 0;
+    }
+  }
+  let crc = 0xffffffff;
+  for (let i = 0; i < data.length; i++) {
+    crc = crcTable[(crc ^ data[i]) & 0xff] ^ (crc >>> 8);
+  }
+  return (crc ^ 0xffffffff) >>> 0;
+}
@@ -8,6 +8,7 @@ import { Unicode11Addon } from '@xterm/addon-unicode11';
 import './components/sidebar.js';
 import './components/mobile-controls.js';
 import './components/shortcuts.js';
+import { WebTransportConnection, isWebTransportSupported } from './services/webtransport.js';
 
 // Protocol message types (must match Go constants)
 const MSG = {
@@ -35,19 +36,33 @@ const MSG = {
   SetBufferSize: '6',
   TmuxLayoutUpdate: '7',
   TmuxModeUpdate: '9',
+  ConnectionReady: 'C',
+  CompressedOutput: 'D',
 };
 
+// Preset dictionary of CompressedOutput messages (empty when disabled)
+const OUTPUT_DICTIONARY = Uint8Array.from(
+  atob(window.gotty_output_dictionary || ''), (c) => c.charCodeAt(0));
+
 class WebTmux {
   constructor() {
     this.terminal = null;
     this.fitAddon = null;
-    this.ws = null;
+    this.ws = null; // WebSocket, or WebTransportConnection when enabled
+    this.webTransportFailed = false;
     this.reconnectInterval = null;
     this.bufferSize = 1024 * 1024;
+    this.ready = false;
     this.inCopyMode = false;
     this.layout = null;
     this.pendingSessionSwitch = null;
     this.oscBuffer = ''; // Buffer for OSC sequence detection
+    this.outputQueue = Promise.resolve(); // Keeps decompressed output in order
+    // Identifies this page's session to resume it after a reconnect
+    this.resumeToken = Array.from(crypto.getRandomValues(new Uint8Array(16)),
+      (b) => b.toString(16).padStart(2, '0')).join('');
+    // Viewer links only watch the session, even with permit-write
+    this.readOnly = new URLSearchParams(window.location.search).has('readonly');
 
     this.init();
   }
@@ -271,18 +286,62 @@ class WebTmux {
     });
   }
 
-  connect() {
-    const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
-    const wsUrl = `${protocol}//${window.location.host}${window.location.pathname}ws`;
+  // Fetch the auth token with the header the server requires when
+  // cross-site token requests are blocked, or a fresh one when each token
+  // is accepted only once
+  async fetchAuthToken() {
+    try {
+      const response = await fetch(this.readOnly ? './auth_token.js?readonly' : './auth_token.js', {
+        headers: { 'X-Requested-With': 'XMLHttpRequest' },
+        credentials: 'same-origin',
+        cache: 'no-store',
+      });
+      const match = (await response.text()).match(/gotty_auth_token = "(.*)";/);
+      return match ? match[1] : '';
+    } catch (e) {
+      console.error('Failed to fetch auth token:', e);
+      return '';
+    }
+  }
 
-    this.ws = new WebSocket(wsUrl, ['webtty']);
+  // WebTransport is tried first when the server enables it, falling back
+  // to WebSocket for good once it fails to connect
+  useWebTransport() {
+    return window.gotty_webtransport_enabled && !this.webTransportFailed &&
+      window.location.protocol === 'https:' && isWebTransportSupported();
+  }
 
-    this.ws.onopen = () => {
-      console.log('WebSocket connected');
+  connect() {
+    const webTransport = this.useWebTransport();
+    const name = webTransport ? 'WebTransport' : 'WebSocket';
+    let opened = false;
+
+    if (webTransport) {
+      this.ws = new WebTransportConnection(
+        `https://${window.location.host}${window.location.pathname}wt`,
+        {
+          checksum: !!window.gotty_wt_checksum,
+          wideFrames: !!window.gotty_wt_wide_frames,
+          datagrams: !!window.gotty_wt_datagrams,
+        });
+    } else {
+      const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+      const wsUrl = `${protocol}//${window.location.host}${window.location.pathname}ws`;
+      this.ws = new WebSocket(wsUrl, ['webtty']);
+    }
 
-      // Send auth token
-      const authToken = window.gotty_auth_token || '';
-      this.ws.send(JSON.stringify({ AuthToken: authToken, Arguments: '' }));
+    this.ws.onopen = async () => {
+      opened = true;
+      console.log(`${name} connected`);
+
+      // Send auth token; fetch a fresh one when tokens are single-use
+      const authToken = (window.gotty_auth_token_csrf || window.gotty_reauth_on_reconnect)
+        ? await this.fetchAuthToken()
+        : (window.gotty_auth_token || '');
+      this.ws.send(JSON.stringify({
+        AuthToken: authToken, Arguments: '', ResumeToken: this.resumeToken,
+        ReadOnly: this.readOnly,
+      }));
 
       // Tell server to expect base64 encoded input
       this.sendMessage(MSG.SetEncoding, 'base64');
@@ -308,7 +367,15 @@ class WebTmux {
     };
 
     this.ws.onclose = () => {
-      console.log('WebSocket closed');
+      console.log(`${name} closed`);
+      this.ready = false;
+
+      if (webTransport && !opened) {
+        console.log('WebTransport connection failed, falling back to WebSocket');
+        this.webTransportFailed = true;
+        this.connect();
+        return;
+      }
 
       // Check if there are other sessions to switch to
       const otherSessions = this.layout?.sessions?.filter(s => !s.active) || [];
@@ -324,7 +391,7 @@ class WebTmux {
     };
 
     this.ws.onerror = (error) => {
-      console.error('WebSocket error:', error);
+      console.error(`${name} error:`, error);
     };
   }
 
@@ -334,17 +401,16 @@ class WebTmux {
 
     switch (type) {
       case MSG.Output:
-        // Decode base64 to Uint8Array for proper UTF-8 handling
         const binaryString = atob(payload);
+        this.outputQueue = this.outputQueue.then(() => this.writeOutput(binaryString));
+        break;
 
-        // Check for OSC 52 clipboard sequences and handle them
-        const processed = this.handleOSC52(binaryString);
-
-        const bytes = new Uint8Array(processed.length);
-        for (let i = 0; i < processed.length; i++) {
-          bytes[i] = processed.charCodeAt(i);
-        }
-        this.terminal.write(bytes);
+      case MSG.CompressedOutput:
+        const compressed = atob(payload);
+        this.outputQueue = this.outputQueue
+          .then(() => this.inflateOutput(compressed))
+          .then((output) => this.writeOutput(output))
+          .catch((error) => console.error('Failed to decompress output:', error));
         break;
 
       case MSG.Pong:
@@ -371,6 +437,14 @@ class WebTmux {
         this.bufferSize = parseInt(payload, 10);
         break;
 
+      case MSG.ConnectionReady:
+        // Backend is attached; output follows
+        this.ready = true;
+        window.dispatchEvent(new CustomEvent('webtmux-ready', {
+          detail: JSON.parse(payload)
+        }));
+        break;
+
       case MSG.TmuxLayoutUpdate:
         this.layout = JSON.parse(payload);
         this.dispatchLayoutUpdate();
@@ -390,7 +464,7 @@ class WebTmux {
     if (this.ws && this.ws.readyState === WebSocket.OPEN) {
       this.ws.send(type + payload);
     } else {
-      console.warn('WebSocket not ready, state:', this.ws?.readyState);
+      console.warn('Connection not ready, state:', this.ws?.readyState);
     }
   }
 
@@ -448,6 +522,43 @@ class WebTmux {
     this.sendMessage(MSG.Input, btoa(binary));
   }
 
+  // Write decoded output to the terminal
+  writeOutput(binaryString) {
+    // Check for OSC 52 clipboard sequences and handle them
+    const processed = this.handleOSC52(binaryString);
+
+    // Convert to Uint8Array for proper UTF-8 handling
+    const bytes = new Uint8Array(processed.length);
+    for (let i = 0; i < processed.length; i++) {
+      bytes[i] = processed.charCodeAt(i);
+    }
+    this.terminal.write(bytes);
+  }
+
+  // Inflate raw DEFLATE data compressed with OUTPUT_DICTIONARY.
+  // DecompressionStream takes no dictionary, so the dictionary is fed
+  // first as a stored block and cut from the result.
+  async inflateOutput(binaryString) {
+    const dictLength = OUTPUT_DICTIONARY.length;
+    const input = new Uint8Array(5 + dictLength + binaryString.length);
+    input.set([0x00, dictLength & 0xff, dictLength >> 8,
+      ~dictLength & 0xff, (~dictLength >> 8) & 0xff]);
+    input.set(OUTPUT_DICTIONARY, 5);
+    for (let i = 0; i < binaryString.length; i++) {
+      input[5 + dictLength + i] = binaryString.charCodeAt(i);
+    }
+
+    const stream = new Blob([input]).stream()
+      .pipeThrough(new DecompressionStream('deflate-raw'));
+    const output = new Uint8Array(await new Response(stream).arrayBuffer());
+
+    let result = '';
+    for (let i = dictLength; i < output.length; i++) {
+      result += String.fromCharCode(output[i]);
+    }
+    return result;
+  }
+
   // Handle OSC 52 clipboard sequences from tmux
   // Format: ESC ] 52 ; Pc ; Pd BEL  or  ESC ] 52 ; Pc ; Pd ESC \
   handleOSC52(data) {
@@ -0,0 +1,16 @@
+package server
+
+// listenNetwork returns the network to listen on for base ("tcp" or "udp")
+// restricted to the given address family. "dual" and the empty family
+// leave the choice to the platform, which is dual-stack for wildcard
+// addresses where supported.
+func listenNetwork(base string, family string) string {
+	switch family {
+	case "ipv4":
+		return base + "4"
+	case "ipv6":
+		return base + "6"
+	default:
+		return base
+	}
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestListenNetwork(t *testing.T) {
+	tests := []struct {
+		base   string
+		family string
+		want   string
+	}{
+		{"tcp", "", "tcp"},
+		{"tcp", "dual", "tcp"},
+		{"tcp", "ipv4", "tcp4"},
+		{"tcp", "ipv6", "tcp6"},
+		{"udp", "ipv4", "udp4"},
+		{"udp", "ipv6", "udp6"},
+	}
+
+	for _, tt := range tests {
+		if got := listenNetwork(tt.base, tt.family); got != tt.want {
+			t.Errorf("listenNetwork(%q, %q) = %q, want %q", tt.base, tt.family, got, tt.want)
+		}
+	}
+}
+
+func requireIPv6(t *testing.T) {
+	t.Helper()
+	ln, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skipf("IPv6 is not available: %v", err)
+	}
+	ln.Close()
+}
+
+func canDial(network, addr string) bool {
+	conn, err := net.DialTimeout(network, addr, time.Second)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
+func TestListenIPv4Only(t *testing.T) {
+	requireIPv6(t)
+
+	ln, err := net.Listen(listenNetwork("tcp", "ipv4"), ":0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	if !canDial("tcp4", net.JoinHostPort("127.0.0.1", port)) {
+		t.Error("IPv4 connection should be accepted")
+	}
+	if canDial("tcp6", net.JoinHostPort("::1", port)) {
+		t.Error("IPv6 connection should be rejected")
+	}
+}
+
+func TestListenDualStack(t *testing.T) {
+	requireIPv6(t)
+
+	ln, err := net.Listen(listenNetwork("tcp", "dual"), ":0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	defer ln.Close()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	if !canDial("tcp4", net.JoinHostPort("127.0.0.1", port)) {
+		t.Error("IPv4 connection should be accepted")
+	}
+	if !canDial("tcp6", net.JoinHostPort("::1", port)) {
+		t.Error("IPv6 connection should be accepted")
+	}
+}
+
+func TestListenUDPIPv4Only(t *testing.T) {
+	requireIPv6(t)
+
+	conn, err := net.ListenPacket(listenNetwork("udp", "ipv4"), ":0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error: %v", err)
+	}
+	defer conn.Close()
+
+	if addr := conn.LocalAddr().(*net.UDPAddr); addr.IP.To4() == nil {
+		t.Errorf("LocalAddr() = %v, want an IPv4 address", addr)
+	}
+}
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"encoding/base64"
+	"log"
+	"net/url"
+
+	"github.com/pkg/errors"
+
+	"webtmux/webtty"
+)
+
+// checkArguments rejects client arguments matching the argument denylist
+// before they reach the factory. Only the arg values, which factories pass
+// to the command, are checked.
+func (server *Server) checkArguments(params url.Values) error {
+	if server.argumentDenylist == nil {
+		return nil
+	}
+	for _, value := range params["arg"] {
+		if server.argumentDenylist.MatchString(value) {
+			return errors.Errorf("argument %q is not permitted", value)
+		}
+	}
+	return nil
+}
+
+// reportDeniedArgument tells the client why its connection is closed.
+func (server *Server) reportDeniedArgument(transport Transport, err error) {
+	log.Printf("Rejected arguments: %v", err)
+	message := base64.StdEncoding.EncodeToString([]byte("\r\nRejected: " + err.Error() + "\r\n"))
+	transport.Write(append([]byte{webtty.Output}, message...))
+}
@@ -0,0 +1,100 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// argsFactory records the parameters its slaves are created with.
+type argsFactory struct {
+	*connTestFactory
+
+	mu     sync.Mutex
+	params map[string][]string
+}
+
+func (f *argsFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	f.mu.Lock()
+	f.params = params
+	f.mu.Unlock()
+	return newExitingSlave("", 0), nil
+}
+
+func (f *argsFactory) created() map[string][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.params
+}
+
+func TestArgumentDenylist(t *testing.T) {
+	tests := []struct {
+		name      string
+		arguments string
+		wantErr   bool
+	}{
+		{"clean argument", "?arg=main&arg=-v", false},
+		{"other parameter", "?arg=main&arg=-v&readonly=a%3Bb", false},
+		{"shell metacharacter", "?arg=main%3Brm+-rf", true},
+		{"command substitution", "?arg=%24%28id%29", true},
+		{"path traversal", "?arg=..%2F..%2Fetc%2Fpasswd", true},
+		{"backtick", "?arg=%60id%60", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := &argsFactory{connTestFactory: newConnTestFactory()}
+			server, err := New(factory, &Options{
+				TitleFormat:      "Test",
+				PermitArguments:  true,
+				EnableBasicAuth:  true,
+				ArgumentDenylist: "[;&|$\\x60<>\\n]|\\.\\.",
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			token, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
+			data, _ := json.Marshal(InitMessage{AuthToken: token, Arguments: tt.arguments})
+			transport := newBlockingTransport(data)
+			defer close(transport.closed)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			err = server.processTransportConn(ctx, transport, nil, "")
+
+			if !tt.wantErr {
+				if got := factory.created()["arg"]; len(got) != 2 || got[0] != "main" {
+					t.Errorf("factory got arguments %v, want [main -v]", got)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), "is not permitted") {
+				t.Errorf("processTransportConn() error = %v, want a denied argument", err)
+			}
+			if factory.created() != nil {
+				t.Error("Denied arguments should not reach the factory")
+			}
+			if got := transport.outputText(t); !strings.Contains(got, "is not permitted") {
+				t.Errorf("client output = %q, want the reason of the rejection", got)
+			}
+		})
+	}
+}
+
+func TestArgumentDenylistDisabled(t *testing.T) {
+	server := &Server{options: &Options{}}
+	if err := server.checkArguments(map[string][]string{"arg": {"a;b"}}); err != nil {
+		t.Errorf("checkArguments() without denylist error = %v", err)
+	}
+}
+
+func TestArgumentDenylistInvalid(t *testing.T) {
+	_, err := New(newMockFactory(), &Options{TitleFormat: "Test", PermitArguments: true, EnableBasicAuth: true, ArgumentDenylist: "("})
+	if err == nil {
+		t.Error("New() should fail with an invalid argument denylist")
+	}
+}
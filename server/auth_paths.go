@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// authRequiredKey marks a connection context whose request path
+// requires an auth token although authentication is disabled globally.
+type authRequiredKey struct{}
+
+// parseAuthPaths turns the comma separated AuthPaths option into absolute
+// path prefixes under pathPrefix. auth_token.js is always included because
+// it issues the tokens that protected connections need.
+func parseAuthPaths(pathPrefix string, paths string) []string {
+	prefixes := []string{pathPrefix + "auth_token.js"}
+	for _, path := range strings.Split(paths, ",") {
+		path = strings.TrimPrefix(strings.TrimSpace(path), "/")
+		if path == "" {
+			continue
+		}
+		prefixes = append(prefixes, pathPrefix+path)
+	}
+	return prefixes
+}
+
+// pathRequiresAuth reports whether path is under one of the auth paths.
+func (server *Server) pathRequiresAuth(path string) bool {
+	for _, prefix := range server.authPaths {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrapPathAuth applies authentication only to requests under the
+// auth paths, leaving other routes open.
+func (server *Server) wrapPathAuth(handler http.Handler) http.Handler {
+	protected := server.wrapAuth(handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if server.pathRequiresAuth(r.URL.Path) {
+			protected.ServeHTTP(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// authRequired reports whether the connection with ctx must present
+// a valid auth token.
+func (server *Server) authRequired(ctx context.Context) bool {
+	if server.options.EnableBasicAuth {
+		return true
+	}
+	required, _ := ctx.Value(authRequiredKey{}).(bool)
+	return required
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+func TestParseAuthPaths(t *testing.T) {
+	got := parseAuthPaths("/term/", " ws, /private/ ,")
+	want := []string{"/term/auth_token.js", "/term/ws", "/term/private/"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("parseAuthPaths() = %v, want %v", got, want)
+	}
+}
+
+func newPathAuthServer(t *testing.T, factory Factory) (*Server, http.Handler) {
+	t.Helper()
+	server, err := New(factory, &Options{
+		TitleFormat: "Test",
+		AuthPaths:   "private/",
+		Credential:  "user:pass",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	return server, server.setupHandlers(ctx, cancel, "/", newCounter(0))
+}
+
+func TestPathAuthHTTPRoutes(t *testing.T) {
+	_, handler := newPathAuthServer(t, newMockFactory())
+
+	tests := []struct {
+		name     string
+		path     string
+		withAuth bool
+		want     int
+	}{
+		{"open index", "/", false, http.StatusOK},
+		{"protected path", "/private/page", false, http.StatusUnauthorized},
+		{"token endpoint without auth", "/auth_token.js", false, http.StatusUnauthorized},
+		{"token endpoint with auth", "/auth_token.js", true, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.path, nil)
+			if tt.withAuth {
+				req.SetBasicAuth("user", "pass")
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("GET %s status = %d, want %d", tt.path, rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestPathAuthConnections(t *testing.T) {
+	factory := newConnTestFactory()
+	server, handler := newPathAuthServer(t, factory)
+
+	// Get a token the way the client does
+	req := httptest.NewRequest("GET", "/auth_token.js", nil)
+	req.SetBasicAuth("user", "pass")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	match := regexp.MustCompile(`gotty_auth_token = "([^"]+)"`).FindStringSubmatch(rr.Body.String())
+	if match == nil {
+		t.Fatalf("auth_token.js did not issue a token: %q", rr.Body.String())
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		token   string
+		wantErr string
+	}{
+		{"open path without token", "/ws", "", ""},
+		{"protected path without token", "/private/ws", "", "authentication failed"},
+		{"protected path with token", "/private/ws", match[1], ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", tt.path, nil)
+			ctx, cancel := context.WithTimeout(server.connectionContext(context.Background(), r), 100*time.Millisecond)
+			defer cancel()
+
+			data, _ := json.Marshal(InitMessage{AuthToken: tt.token})
+			transport := newBlockingTransport(data)
+			defer close(transport.closed)
+
+			err := server.processTransportConn(ctx, transport, nil, "192.0.2.1")
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("processTransportConn() = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != context.DeadlineExceeded {
+				t.Errorf("processTransportConn() = %v, want the session to run", err)
+			}
+			transport.mu.Lock()
+			defer transport.mu.Unlock()
+			if len(transport.messages) == 0 || transport.messages[0][0] != webtty.SetWindowTitle {
+				t.Error("session should have been initialized")
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// ipLockoutStatus is the state of failed logins from one IP.
+type ipLockoutStatus struct {
+	IP          string    `json:"ip"`
+	FailCount   int       `json:"fail_count"`
+	LockedUntil time.Time `json:"locked_until"`
+	// LockType is "ip" while the IP is locked out, empty otherwise
+	LockType string `json:"lock_type"`
+}
+
+// globalLockoutStatus is the state of failed logins from all IPs.
+type globalLockoutStatus struct {
+	Locked      bool      `json:"locked"`
+	LockedUntil time.Time `json:"locked_until"`
+	// Failures is the number of failures within the sliding window
+	Failures int `json:"failures"`
+}
+
+// authStatus is the JSON body served by the auth status endpoint.
+type authStatus struct {
+	Global globalLockoutStatus `json:"global"`
+	IPs    []ipLockoutStatus   `json:"ips"`
+}
+
+// snapshot returns a copy of the per-IP and global state, sorted by IP.
+func (rl *rateLimiter) snapshot() ([]ipLockoutStatus, globalLockoutStatus) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	now := time.Now()
+	ips := make([]ipLockoutStatus, 0, len(rl.attempts))
+	for ip, info := range rl.attempts {
+		status := ipLockoutStatus{
+			IP:          ip,
+			FailCount:   info.failCount,
+			LockedUntil: info.lockedUntil,
+		}
+		if now.Before(info.lockedUntil) {
+			status.LockType = "ip"
+		}
+		ips = append(ips, status)
+	}
+	sort.Slice(ips, func(i, j int) bool { return ips[i].IP < ips[j].IP })
+
+	global := globalLockoutStatus{
+		Locked:      now.Before(rl.globalLockedUntil),
+		LockedUntil: rl.globalLockedUntil,
+		Failures:    len(pruneFailures(rl.globalFailures, now)),
+	}
+	return ips, global
+}
+
+// handleAuthStatus serves the state of the auth rate limiter, so that
+// locked out IPs can be monitored.
+func (server *Server) handleAuthStatus(w http.ResponseWriter, r *http.Request) {
+	ips, global := server.rateLimiter.snapshot()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(authStatus{Global: global, IPs: ips})
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRateLimiterSnapshot(t *testing.T) {
+	rl := &rateLimiter{
+		attempts:       make(map[string]*attemptInfo),
+		globalFailures: make([]time.Time, 0),
+	}
+
+	for i := 0; i < 5; i++ {
+		rl.recordFailure("192.168.1.2")
+	}
+	rl.recordFailure("192.168.1.1")
+	// Failures outside the window are not counted
+	rl.globalFailures = append([]time.Time{time.Now().Add(-2 * globalWindowDuration)}, rl.globalFailures...)
+
+	ips, global := rl.snapshot()
+	if len(ips) != 2 {
+		t.Fatalf("snapshot() returned %d IPs, want 2", len(ips))
+	}
+	if ips[0].IP != "192.168.1.1" || ips[0].FailCount != 1 || ips[0].LockType != "" {
+		t.Errorf("ips[0] = %+v, want 192.168.1.1 with 1 failure and no lockout", ips[0])
+	}
+	if ips[1].IP != "192.168.1.2" || ips[1].FailCount != 5 || ips[1].LockType != "ip" {
+		t.Errorf("ips[1] = %+v, want 192.168.1.2 locked out after 5 failures", ips[1])
+	}
+	if global.Locked || global.Failures != 6 {
+		t.Errorf("global = %+v, want 6 failures and no lockout", global)
+	}
+
+	// The snapshot is a copy
+	ips[1].FailCount = 0
+	if rl.attempts["192.168.1.2"].failCount != 5 {
+		t.Error("changing the snapshot changed the rate limiter")
+	}
+}
+
+func TestRateLimiterSnapshotConcurrent(t *testing.T) {
+	rl := newRateLimiter(defaultRateLimits)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rl.recordFailure("192.168.1.1")
+			rl.cleanup()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			rl.snapshot()
+		}
+	}()
+	wg.Wait()
+}
+
+func TestAuthStatusEndpoint(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/", newCounter(0))
+
+	for i := 0; i < 5; i++ {
+		server.rateLimiter.recordFailure("203.0.113.7")
+	}
+
+	req := httptest.NewRequest("GET", "/internal/auth_status.json", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("without credentials: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("GET", "/internal/auth_status.json", nil)
+	req.SetBasicAuth("user", "pass")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+
+	var status authStatus
+	if err := json.NewDecoder(rr.Body).Decode(&status); err != nil {
+		t.Fatalf("failed to decode auth status: %v", err)
+	}
+	var found bool
+	for _, ip := range status.IPs {
+		if ip.IP == "203.0.113.7" && ip.LockType == "ip" && ip.FailCount == 5 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("IPs = %+v, want 203.0.113.7 locked out", status.IPs)
+	}
+	if status.Global.Failures != 5 {
+		t.Errorf("global failures = %d, want 5", status.Global.Failures)
+	}
+}
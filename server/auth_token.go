@@ -1,54 +1,94 @@
 package server
 
 import (
+	"context"
+	"log"
 	"net"
 	"net/http"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"webtmux/pkg/randomstring"
 )
 
 const authTokenLength = 32
 const authTokenTTL = 1 * time.Hour
 
+// authTokenMaxAttempts bounds the number of tokens generated per issue in
+// search of one that is not in use, so a broken generator cannot hang it.
+const authTokenMaxAttempts = 16
+
 type authTokenInfo struct {
-	expiresAt time.Time
-	ip        string
+	// expiresAt is on the store's monotonic clock, so that wall clock
+	// adjustments neither extend nor shorten a token's life
+	expiresAt time.Duration
+	// issuedAt is on the same clock, bounding the life of tokens extended
+	// on use to the TTL
+	issuedAt time.Duration
+	ip       string
+	// user is the Basic Authentication user the token was issued to
+	user string
+	// claims are the JWT claims of the user in auth-mode jwt
+	claims jwtClaims
+	// readOnly tokens only open sessions that cannot write to the TTY
+	readOnly bool
 }
 
 type authTokenStore struct {
 	mu     sync.Mutex
 	tokens map[string]authTokenInfo
 	ttl    time.Duration
+	// When set, tokens also expire after this long without use
+	idle time.Duration
+	// elapsed reads the monotonic clock, as time since the store was created
+	elapsed func() time.Duration
+
+	generate    func() string
+	maxAttempts int
 }
 
 func newAuthTokenStore(ttl time.Duration) *authTokenStore {
+	start := time.Now()
 	return &authTokenStore{
 		tokens: make(map[string]authTokenInfo),
 		ttl:    ttl,
+		elapsed: func() time.Duration {
+			return time.Since(start)
+		},
+		generate: func() string {
+			return randomstring.Generate(authTokenLength)
+		},
+		maxAttempts: authTokenMaxAttempts,
 	}
 }
 
-func (store *authTokenStore) issue(ip string) string {
+func (store *authTokenStore) issue(ip string, user string, claims jwtClaims, readOnly bool) (string, error) {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
-	now := time.Now()
+	now := store.elapsed()
 	store.pruneLocked(now)
 
-	for {
-		token := randomstring.Generate(authTokenLength)
+	for attempt := 0; attempt < store.maxAttempts; attempt++ {
+		token := store.generate()
 		if _, exists := store.tokens[token]; exists {
 			continue
 		}
 		store.tokens[token] = authTokenInfo{
-			expiresAt: now.Add(store.ttl),
+			expiresAt: store.expiry(now, now),
+			issuedAt:  now,
 			ip:        ip,
+			user:      user,
+			claims:    claims,
+			readOnly:  readOnly,
 		}
-		return token
+		return token, nil
 	}
+
+	return "", errors.Errorf("failed to generate a unique auth token in %d attempts", store.maxAttempts)
 }
 
 func (store *authTokenStore) validate(token string, ip string) bool {
@@ -59,14 +99,14 @@ func (store *authTokenStore) validate(token string, ip string) bool {
 	store.mu.Lock()
 	defer store.mu.Unlock()
 
-	now := time.Now()
+	now := store.elapsed()
 	store.pruneLocked(now)
 
 	info, ok := store.tokens[token]
 	if !ok {
 		return false
 	}
-	if now.After(info.expiresAt) {
+	if now > info.expiresAt {
 		delete(store.tokens, token)
 		return false
 	}
@@ -74,12 +114,59 @@ func (store *authTokenStore) validate(token string, ip string) bool {
 		return false
 	}
 
+	if store.idle > 0 {
+		info.expiresAt = store.expiry(info.issuedAt, now)
+		store.tokens[token] = info
+	}
+	return true
+}
+
+// userOf returns the user token was issued to and their JWT claims, if
+// any.
+func (store *authTokenStore) userOf(token string) (string, jwtClaims) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	info := store.tokens[token]
+	return info.user, info.claims
+}
+
+// readOnly reports whether token was issued for read-only sessions.
+func (store *authTokenStore) readOnly(token string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.tokens[token].readOnly
+}
+
+// expiry returns when a token issued at issuedAt and last used at now
+// expires: the TTL after its issue, or earlier when it goes unused.
+func (store *authTokenStore) expiry(issuedAt time.Duration, now time.Duration) time.Duration {
+	if store.idle > 0 {
+		return min(now+store.idle, issuedAt+store.ttl)
+	}
+	return issuedAt + store.ttl
+}
+
+// consume validates token like validate and revokes it, so that it is
+// accepted only once.
+func (store *authTokenStore) consume(token string, ip string) bool {
+	if !store.validate(token, ip) {
+		return false
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	// Another connection may have consumed it in the meantime
+	if _, ok := store.tokens[token]; !ok {
+		return false
+	}
+	delete(store.tokens, token)
 	return true
 }
 
-func (store *authTokenStore) pruneLocked(now time.Time) {
+func (store *authTokenStore) pruneLocked(now time.Duration) {
 	for token, info := range store.tokens {
-		if now.After(info.expiresAt) {
+		if now > info.expiresAt {
 			delete(store.tokens, token)
 		}
 	}
@@ -111,29 +198,56 @@ func ipFromAddr(addr string) string {
 	return strings.TrimSpace(addr)
 }
 
-func (server *Server) issueAuthToken(r *http.Request) string {
-	if !server.options.EnableBasicAuth || server.authTokens == nil {
-		return ""
+func (server *Server) issueAuthToken(r *http.Request) (string, error) {
+	if (!server.options.EnableBasicAuth && len(server.authPaths) == 0) || server.authTokens == nil {
+		return "", nil
 	}
 
+	user := authUserFromContext(r.Context())
+	claims := authClaimsFromContext(r.Context())
+	// Viewer links ask for a token that cannot open writable sessions
+	readOnly := r.URL.Query().Has("readonly")
 	if !server.options.AuthIPBinding {
-		return server.authTokens.issue("")
+		return server.authTokens.issue("", user, claims, readOnly)
 	}
 
-	return server.authTokens.issue(clientIPFromRequest(r))
+	return server.authTokens.issue(server.clientIP(r), user, claims, readOnly)
 }
 
-func (server *Server) validateAuthToken(token string, ip string) bool {
-	if !server.options.EnableBasicAuth {
-		return true
+// validateAuthToken reports whether token lets a client at ip connect, and
+// the user it was issued to. In auth-mode jwt, token may also be a JWT,
+// falling back to the Bearer token of the connection request.
+func (server *Server) validateAuthToken(ctx context.Context, token string, ip string) (string, jwtClaims, bool) {
+	if !server.authRequired(ctx) {
+		return "", nil, true
+	}
+	if bearer, ok := ctx.Value(bearerTokenKey{}).(string); ok && token == "" {
+		token = bearer
+	}
+	if server.jwt != nil && isJWT(token) {
+		claims, err := server.jwt.verify(token)
+		if err != nil {
+			log.Printf("JWT Authentication failed: %s: %v", ip, err)
+			return "", nil, false
+		}
+		return claims.subject(), claims, true
 	}
 	if server.authTokens == nil {
-		return false
+		return "", nil, false
 	}
 
-	if !server.options.AuthIPBinding {
-		return server.authTokens.validate(token, "")
+	check := server.authTokens.validate
+	if server.options.ReauthOnReconnect {
+		check = server.authTokens.consume
 	}
 
-	return server.authTokens.validate(token, ip)
+	// A consumed token is gone after the check
+	user, claims := server.authTokens.userOf(token)
+	if !server.options.AuthIPBinding {
+		ip = ""
+	}
+	if !check(token, ip) {
+		return "", nil, false
+	}
+	return user, claims, true
 }
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthTokenStoreIssueRetriesCollisions(t *testing.T) {
+	store := newAuthTokenStore(time.Minute)
+	tokens := []string{"taken", "taken", "fresh"}
+	store.generate = func() string {
+		token := tokens[0]
+		tokens = tokens[1:]
+		return token
+	}
+	store.tokens["taken"] = authTokenInfo{expiresAt: store.elapsed() + time.Minute}
+
+	token, err := store.issue("", "", nil, false)
+	if err != nil {
+		t.Fatalf("issue() error: %v", err)
+	}
+	if token != "fresh" {
+		t.Errorf("issue() = %q, want %q", token, "fresh")
+	}
+}
+
+func TestAuthTokenStoreIssueGivesUp(t *testing.T) {
+	store := newAuthTokenStore(time.Minute)
+	attempts := 0
+	store.generate = func() string {
+		attempts++
+		return "taken"
+	}
+	store.tokens["taken"] = authTokenInfo{expiresAt: store.elapsed() + time.Minute}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := store.issue("", "", nil, false)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("issue() expected error when every token collides")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("issue() did not give up on a colliding generator")
+	}
+	if attempts != authTokenMaxAttempts {
+		t.Errorf("attempts = %d, want %d", attempts, authTokenMaxAttempts)
+	}
+}
+
+func TestHandleAuthTokenIssueFailure(t *testing.T) {
+	store := newAuthTokenStore(time.Minute)
+	store.generate = func() string { return "taken" }
+	store.tokens["taken"] = authTokenInfo{expiresAt: store.elapsed() + time.Minute}
+
+	server := &Server{
+		options: &Options{
+			Credential:      "admin:secret",
+			EnableBasicAuth: true,
+		},
+		authTokens: store,
+	}
+
+	req := httptest.NewRequest("GET", "/auth_token.js", nil)
+	rr := httptest.NewRecorder()
+	server.handleAuthToken(rr, req)
+
+	if rr.Code != http.StatusInternalServerError {
+		t.Errorf("handleAuthToken() status = %d, want %d", rr.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestAuthTokenStoreConsume(t *testing.T) {
+	store := newAuthTokenStore(time.Minute)
+	token, _ := store.issue("127.0.0.1", "", nil, false)
+
+	if store.consume(token, "10.0.0.1") {
+		t.Error("consume() should reject a token bound to another IP")
+	}
+	if !store.consume(token, "127.0.0.1") {
+		t.Fatal("consume() should accept a valid token")
+	}
+	if store.consume(token, "127.0.0.1") {
+		t.Error("consume() should reject a token that was already used")
+	}
+}
+
+func TestReauthOnReconnect(t *testing.T) {
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves: []Slave{
+			newExitingSlave("first", 0),
+			newExitingSlave("second", 0),
+		},
+	}
+	server, err := New(factory, &Options{
+		TitleFormat:       "Test",
+		EnableBasicAuth:   true,
+		AuthIPBinding:     true,
+		ReauthOnReconnect: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	connect := func(token string) error {
+		data, _ := json.Marshal(InitMessage{AuthToken: token})
+		transport := newBlockingTransport(data)
+		defer close(transport.closed)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		err := server.processTransportConn(ctx, transport, nil, "127.0.0.1")
+		if err != nil && strings.Contains(err.Error(), "authentication failed") {
+			return err
+		}
+		return nil
+	}
+
+	token, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
+	if err := connect(token); err != nil {
+		t.Fatalf("first connection rejected: %v", err)
+	}
+
+	// Reconnecting with the same token must authenticate again
+	if err := connect(token); err == nil {
+		t.Error("reconnect with a used token should be rejected")
+	}
+
+	expired := newAuthTokenStore(-time.Second)
+	stale, _ := expired.issue("127.0.0.1", "", nil, false)
+	server.authTokens.tokens[stale] = expired.tokens[stale]
+	if err := connect(stale); err == nil {
+		t.Error("reconnect with an expired token should be rejected")
+	}
+
+	fresh, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
+	if err := connect(fresh); err != nil {
+		t.Errorf("reconnect with a fresh token rejected: %v", err)
+	}
+}
+
+func TestAuthTokenStoreIdleExpiry(t *testing.T) {
+	var clock time.Duration
+	store := newAuthTokenStore(time.Hour)
+	store.idle = 10 * time.Minute
+	store.elapsed = func() time.Duration { return clock }
+
+	used, _ := store.issue("", "", nil, false)
+	unused, _ := store.issue("", "", nil, false)
+
+	// Using the token every 8 minutes keeps it alive past the idle period
+	for i := 0; i < 7; i++ {
+		clock += 8 * time.Minute
+		if !store.validate(used, "") {
+			t.Fatalf("validate() after %s should accept a token in use", clock)
+		}
+	}
+	if store.validate(unused, "") {
+		t.Error("validate() should reject a token unused for longer than the idle period")
+	}
+
+	clock += 8 * time.Minute
+	if store.validate(used, "") {
+		t.Error("validate() should reject a token in use past the TTL")
+	}
+}
+
+func TestAuthTokenStoreIdleExpiryFromIssue(t *testing.T) {
+	var clock time.Duration
+	store := newAuthTokenStore(time.Hour)
+	store.idle = time.Minute
+	store.elapsed = func() time.Duration { return clock }
+
+	token, _ := store.issue("", "", nil, false)
+	clock += 59 * time.Second
+	if !store.validate(token, "") {
+		t.Fatal("validate() should accept a token within the idle period")
+	}
+	clock += 61 * time.Second
+	if store.validate(token, "") {
+		t.Error("validate() should reject a token idle since its last use")
+	}
+}
+
+func TestAuthTokenIdleOption(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{TitleFormat: "Test", AuthTokenIdle: 300})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if server.authTokens.idle != 5*time.Minute {
+		t.Errorf("idle = %s, want 5m", server.authTokens.idle)
+	}
+}
+
+func TestAuthTokenStoreExpiryIgnoresClockJump(t *testing.T) {
+	// The wall clock jumps back an hour while the monotonic clock keeps going
+	wall := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var mono time.Duration
+	store := newAuthTokenStore(time.Minute)
+	store.elapsed = func() time.Duration { return mono }
+
+	token, _ := store.issue("", "", nil, false)
+	wall = wall.Add(-time.Hour)
+	mono += 30 * time.Second
+	if !store.validate(token, "") {
+		t.Fatalf("validate() at %s should accept a token within its TTL", wall)
+	}
+
+	// Back at the same wall time as the issue, but past the TTL
+	wall = wall.Add(time.Hour)
+	mono += 31 * time.Second
+	if store.validate(token, "") {
+		t.Errorf("validate() at %s should reject a token past its monotonic TTL", wall)
+	}
+}
+
+func TestAuthTokenStoreDefaultClock(t *testing.T) {
+	store := newAuthTokenStore(50 * time.Millisecond)
+	token, _ := store.issue("", "", nil, false)
+	if !store.validate(token, "") {
+		t.Fatal("validate() should accept a fresh token")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if store.validate(token, "") {
+		t.Error("validate() should reject a token past its TTL")
+	}
+}
@@ -0,0 +1,38 @@
+package server
+
+import "sync"
+
+// pooledBufferSize fits a WebTransport frame of up to 64 KiB with its header
+// and checksum, which covers the init message and all input from webtty.
+const pooledBufferSize = 64*1024 + 8
+
+// bufferPool holds the buffers of reads and frames, so that busy
+// connections do not allocate one per message.
+var bufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, pooledBufferSize)
+		return &buf
+	},
+}
+
+// getBuffer borrows a buffer of size bytes. Buffers larger than the pooled
+// ones are allocated and left to the garbage collector by putBuffer.
+func getBuffer(size int) *[]byte {
+	if size > pooledBufferSize {
+		buf := make([]byte, size)
+		return &buf
+	}
+	buf := bufferPool.Get().(*[]byte)
+	*buf = (*buf)[:size]
+	return buf
+}
+
+// putBuffer returns a buffer borrowed with getBuffer. Nothing may refer to
+// it afterwards, so writes must have returned, having copied it.
+func putBuffer(buf *[]byte) {
+	if cap(*buf) != pooledBufferSize {
+		return
+	}
+	*buf = (*buf)[:pooledBufferSize]
+	bufferPool.Put(buf)
+}
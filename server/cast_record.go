@@ -0,0 +1,206 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// castHeader is the first line of an asciinema v2 recording.
+type castHeader struct {
+	Version   int   `json:"version"`
+	Width     int   `json:"width"`
+	Height    int   `json:"height"`
+	Timestamp int64 `json:"timestamp"`
+}
+
+// castRecorder writes a session to an asciinema v2 `.cast` file. The header
+// waits for the first terminal size, so that it holds the one negotiated
+// with the client, unless an event has to be written before.
+type castRecorder struct {
+	mu sync.Mutex
+
+	path    string
+	file    *os.File
+	w       *bufio.Writer
+	start   time.Time
+	columns int
+	rows    int
+	started bool
+	// maxBytes stops the recording once it would grow past it, 0 to disable
+	maxBytes int64
+	written  int64
+	stopped  bool
+	// pending holds incomplete UTF-8 sequences until their next bytes arrive
+	pending map[string][]byte
+}
+
+// castPath returns the path of a recording in dir for conn.
+func castPath(dir string, conn *connectionEntry) string {
+	name := fmt.Sprintf("%s-%s-%d.cast",
+		conn.StartedAt.Format(sessionLogTimeFormat),
+		sanitizePathElement(ipFromAddr(conn.RemoteAddr)),
+		conn.ID,
+	)
+	return filepath.Join(dir, name)
+}
+
+// newCastRecorder creates the recording at path, sized columns x rows until
+// the terminal is resized.
+func newCastRecorder(path string, columns, rows int, maxBytes int64) (*castRecorder, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create recording directory")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create recording `%s`", path)
+	}
+	return &castRecorder{
+		path:     path,
+		file:     file,
+		w:        bufio.NewWriter(file),
+		start:    time.Now(),
+		columns:  columns,
+		rows:     rows,
+		maxBytes: maxBytes,
+		pending:  map[string][]byte{},
+	}, nil
+}
+
+// resize records a new terminal size, which becomes the header size if
+// nothing has been written yet.
+func (cr *castRecorder) resize(columns, rows int) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	if !cr.started {
+		cr.columns, cr.rows = columns, rows
+		return
+	}
+	if columns == cr.columns && rows == cr.rows {
+		return
+	}
+	cr.columns, cr.rows = columns, rows
+	cr.writeEvent("r", fmt.Sprintf("%dx%d", columns, rows))
+}
+
+// record writes data as an event of code, "o" for output and "i" for input.
+func (cr *castRecorder) record(code string, data []byte) {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	data = append(cr.pending[code], data...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	cr.pending[code] = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		cr.writeEvent(code, string(data[:cut]))
+	}
+}
+
+// writeEvent writes the header if needed, then one event line. It must be
+// called with mu held.
+func (cr *castRecorder) writeEvent(code string, data string) {
+	if cr.stopped {
+		return
+	}
+	if !cr.started {
+		cr.started = true
+		header, _ := json.Marshal(castHeader{
+			Version:   2,
+			Width:     cr.columns,
+			Height:    cr.rows,
+			Timestamp: cr.start.Unix(),
+		})
+		cr.writeLine(header)
+	}
+
+	elapsed := time.Since(cr.start).Seconds()
+	event, _ := json.Marshal([]interface{}{json.Number(fmt.Sprintf("%.6f", elapsed)), code, data})
+	cr.writeLine(event)
+}
+
+// writeLine writes line, stopping the recording when it fails or would
+// exceed maxBytes. It must be called with mu held.
+func (cr *castRecorder) writeLine(line []byte) {
+	if cr.stopped {
+		return
+	}
+	size := int64(len(line) + 1)
+	if cr.maxBytes > 0 && cr.written+size > cr.maxBytes {
+		log.Printf("Recording %s reached %d bytes, stopping it", cr.path, cr.maxBytes)
+		cr.stopped = true
+		return
+	}
+	if _, err := cr.w.Write(append(line, '\n')); err != nil {
+		log.Printf("Failed to write recording %s, stopping it: %v", cr.path, err)
+		cr.stopped = true
+		return
+	}
+	cr.written += size
+}
+
+// Close writes any held back bytes, then flushes and closes the file.
+func (cr *castRecorder) Close() error {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+
+	for _, code := range []string{"o", "i"} {
+		if pending := cr.pending[code]; len(pending) > 0 {
+			cr.writeEvent(code, string(pending))
+		}
+	}
+	if !cr.started {
+		// Keep the recording of a session without any event valid
+		cr.writeEvent("o", "")
+	}
+	if err := cr.w.Flush(); err != nil {
+		cr.file.Close()
+		return errors.Wrapf(err, "failed to flush recording `%s`", cr.path)
+	}
+	return cr.file.Close()
+}
+
+// castSlave records the output, terminal size and, if recordInput is
+// set, the input of a slave.
+type castSlave struct {
+	Slave
+	rec         *castRecorder
+	recordInput bool
+}
+
+func (rs *castSlave) Read(p []byte) (int, error) {
+	n, err := rs.Slave.Read(p)
+	if n > 0 {
+		rs.rec.record("o", p[:n])
+	}
+	return n, err
+}
+
+func (rs *castSlave) Write(p []byte) (int, error) {
+	n, err := rs.Slave.Write(p)
+	if n > 0 && rs.recordInput {
+		rs.rec.record("i", p[:n])
+	}
+	return n, err
+}
+
+func (rs *castSlave) ResizeTerminal(columns int, rows int) error {
+	rs.rec.resize(columns, rows)
+	return rs.Slave.ResizeTerminal(columns, rows)
+}
@@ -0,0 +1,186 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// readCast returns the header and events of the recording at path.
+func readCast(t *testing.T, path string) (castHeader, [][]interface{}) {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open recording: %v", err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		t.Fatal("recording is empty")
+	}
+	var header castHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		t.Fatalf("invalid header %q: %v", scanner.Text(), err)
+	}
+	var events [][]interface{}
+	for scanner.Scan() {
+		var event []interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return header, events
+}
+
+func TestCastRecorder(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	rec, err := newCastRecorder(path, 80, 24, 0)
+	if err != nil {
+		t.Fatalf("newCastRecorder() error: %v", err)
+	}
+
+	// The size negotiated before any output goes into the header
+	rec.resize(120, 40)
+	rec.record("o", []byte("caf\xc3"))
+	rec.record("o", []byte("\xa9\r\n"))
+	rec.record("i", []byte("ls\r"))
+	rec.resize(100, 30)
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	header, events := readCast(t, path)
+	if header.Version != 2 || header.Width != 120 || header.Height != 40 {
+		t.Errorf("header = %+v, want version 2 and 120x40", header)
+	}
+	want := [][2]string{{"o", "caf"}, {"o", "é\r\n"}, {"i", "ls\r"}, {"r", "100x30"}}
+	if len(events) != len(want) {
+		t.Fatalf("events = %v, want %d", events, len(want))
+	}
+	last := 0.0
+	for i, event := range events {
+		if event[1] != want[i][0] || event[2] != want[i][1] {
+			t.Errorf("event %d = %v, want %v", i, event, want[i])
+		}
+		if elapsed := event[0].(float64); elapsed < last {
+			t.Errorf("event %d at %v is before the previous one at %v", i, elapsed, last)
+		} else {
+			last = elapsed
+		}
+	}
+}
+
+func TestCastRecorderMaxBytes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "session.cast")
+	rec, err := newCastRecorder(path, 80, 24, 200)
+	if err != nil {
+		t.Fatalf("newCastRecorder() error: %v", err)
+	}
+	for i := 0; i < 100; i++ {
+		rec.record("o", []byte("0123456789"))
+	}
+	if err := rec.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() > 200 {
+		t.Errorf("recording size = %d, want at most 200", info.Size())
+	}
+	if _, events := readCast(t, path); len(events) == 0 {
+		t.Error("recording has no events before the limit")
+	}
+}
+
+func TestCastSlaveInput(t *testing.T) {
+	for _, recordInput := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "session.cast")
+		rec, err := newCastRecorder(path, 80, 24, 0)
+		if err != nil {
+			t.Fatalf("newCastRecorder() error: %v", err)
+		}
+		slave := &castSlave{Slave: &recordingSlave{}, rec: rec, recordInput: recordInput}
+		slave.Write([]byte("secret\r"))
+		rec.Close()
+
+		var input bool
+		_, events := readCast(t, path)
+		for _, event := range events {
+			if event[1] == "i" {
+				input = true
+			}
+		}
+		if input != recordInput {
+			t.Errorf("recordInput = %v: input recorded = %v", recordInput, input)
+		}
+	}
+}
+
+func TestRecordDirRecordsSession(t *testing.T) {
+	dir := t.TempDir()
+	factory := newConnTestFactory()
+	slave := newExitingSlave("hello from the backend\r\n", 0)
+	server, err := New(&exitingFactory{factory, slave}, &Options{
+		TitleFormat: "Test",
+		RecordDir:   filepath.Join(dir, "casts"),
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.processTransportConn(ctx, transport, nil, "")
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "casts", "*-127.0.0.1-1.cast"))
+	if len(matches) != 1 {
+		t.Fatalf("recordings = %v, want one file in %s/casts", matches, dir)
+	}
+	header, events := readCast(t, matches[0])
+	if header.Version != 2 {
+		t.Errorf("header version = %d, want 2", header.Version)
+	}
+	var output string
+	for _, event := range events {
+		if event[1] == "o" {
+			output += event[2].(string)
+		}
+	}
+	if output != "hello from the backend\r\n" {
+		t.Errorf("recorded output = %q, want the backend output", output)
+	}
+}
+
+func TestRecordDirUnwritable(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", RecordDir: file})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.processTransportConn(ctx, transport, nil, ""); err == nil {
+		t.Error("processTransportConn() should fail when the recording cannot be created")
+	}
+}
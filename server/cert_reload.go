@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// certReloadPoll is how often the TLS certificate files are checked for
+// changes.
+var certReloadPoll = 1 * time.Second
+
+// certReloader serves the certificate of a pair of files to TLS handshakes,
+// replacing it when the files change so that rotated certificates are used
+// without a restart.
+type certReloader struct {
+	crtFile string
+	keyFile string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+}
+
+// newCertReloader loads the certificate from crtFile and keyFile.
+func newCertReloader(crtFile string, keyFile string) (*certReloader, error) {
+	cr := &certReloader{crtFile: crtFile, keyFile: keyFile}
+	if err := cr.reload(); err != nil {
+		return nil, err
+	}
+	return cr, nil
+}
+
+// reload loads the certificate from the files, leaving the current one in
+// place if they do not hold a valid key pair, e.g. while being replaced.
+func (cr *certReloader) reload() error {
+	cert, err := tls.LoadX509KeyPair(cr.crtFile, cr.keyFile)
+	if err != nil {
+		return errors.Wrapf(err, "failed to load TLS certificates from `%s` and `%s`", cr.crtFile, cr.keyFile)
+	}
+
+	cr.mu.Lock()
+	cr.cert = &cert
+	cr.mu.Unlock()
+	return nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// watch reloads the certificate whenever either file changes, until ctx
+// is done.
+func (cr *certReloader) watch(ctx context.Context) {
+	last := cr.stamp()
+
+	ticker := time.NewTicker(certReloadPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			stamp := cr.stamp()
+			if stamp == last {
+				continue
+			}
+			last = stamp
+
+			if err := cr.reload(); err != nil {
+				log.Printf("Keeping previous TLS certificate: %v", err)
+				continue
+			}
+			log.Printf("Reloaded TLS certificate from %s", cr.crtFile)
+		}
+	}
+}
+
+// fileStamp tells versions of a file apart.
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// stamp returns the stamps of the certificate and key files, zero for
+// files that cannot be read.
+func (cr *certReloader) stamp() [2]fileStamp {
+	var stamps [2]fileStamp
+	for i, path := range []string{cr.crtFile, cr.keyFile} {
+		if info, err := os.Stat(path); err == nil {
+			stamps[i] = fileStamp{modTime: info.ModTime(), size: info.Size()}
+		}
+	}
+	return stamps
+}
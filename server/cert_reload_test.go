@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// servedCertificate returns the leaf certificate served at addr.
+func servedCertificate(t *testing.T, addr string) []byte {
+	t.Helper()
+
+	conn, err := tls.Dial("tcp", addr, &tls.Config{InsecureSkipVerify: true})
+	if err != nil {
+		t.Fatalf("tls.Dial() error: %v", err)
+	}
+	defer conn.Close()
+	return conn.ConnectionState().PeerCertificates[0].Raw
+}
+
+// copyFile replaces the contents of dst with those of src.
+func copyFile(t *testing.T, src string, dst string) {
+	t.Helper()
+
+	data, err := os.ReadFile(src)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", src, err)
+	}
+	if err := os.WriteFile(dst, data, 0600); err != nil {
+		t.Fatalf("Failed to write %s: %v", dst, err)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	oldPoll := certReloadPoll
+	certReloadPoll = 10 * time.Millisecond
+	defer func() { certReloadPoll = oldPoll }()
+
+	certFile, keyFile := generateServerCert(t)
+	newCertFile, newKeyFile := generateServerCert(t)
+	newCert, err := tls.LoadX509KeyPair(newCertFile, newKeyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair() error: %v", err)
+	}
+
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat: "Test",
+		EnableTLS:   true,
+		TLSCrtFile:  certFile,
+		TLSKeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	srv, err := server.setupHTTPServer(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("setupHTTPServer() error: %v", err)
+	}
+	if server.certs == nil {
+		t.Fatal("certificates should be loaded from the files")
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.ServeTLS(listener, "", "")
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.certs.watch(ctx)
+
+	addr := listener.Addr().String()
+	oldRaw := servedCertificate(t, addr)
+
+	// A partially written pair keeps the previous certificate
+	if err := os.WriteFile(certFile, []byte("-----BEGIN CERTIFICATE-----\n"), 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	time.Sleep(10 * certReloadPoll)
+	if !bytes.Equal(servedCertificate(t, addr), oldRaw) {
+		t.Fatal("an invalid certificate file should keep the previous certificate")
+	}
+
+	copyFile(t, newCertFile, certFile)
+	copyFile(t, newKeyFile, keyFile)
+	waitFor(t, "the new certificate to be served", func() bool {
+		return bytes.Equal(servedCertificate(t, addr), newCert.Certificate[0])
+	})
+}
+
+func TestCertReloaderMissingFiles(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat: "Test",
+		EnableTLS:   true,
+		TLSCrtFile:  "/nonexistent/server.crt",
+		TLSKeyFile:  "/nonexistent/server.key",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if _, err := server.setupHTTPServer(http.NotFoundHandler()); err == nil {
+		t.Error("setupHTTPServer() should fail without the certificate files")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/NYTimes/gziphandler"
+	"github.com/andybalholm/brotli"
+)
+
+// compressHandler compresses responses with Brotli for clients advertising it
+// in Accept-Encoding, and falls back to gzip for everyone else.
+func compressHandler(h http.Handler) http.Handler {
+	gzHandler := gziphandler.GzipHandler(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsEncoding(r, "br") {
+			gzHandler.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+		bw := &brotliResponseWriter{ResponseWriter: w}
+		defer bw.Close()
+		h.ServeHTTP(bw, r)
+	})
+}
+
+// acceptsEncoding reports whether the request accepts the given content coding
+// with a non-zero quality value.
+func acceptsEncoding(r *http.Request, coding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), coding) {
+			continue
+		}
+		params = strings.TrimSpace(params)
+		if q, ok := strings.CutPrefix(params, "q="); ok {
+			if quality, err := strconv.ParseFloat(q, 64); err == nil && quality == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// brotliResponseWriter compresses the response body with Brotli.
+// The encoder is created on the first write so empty responses stay empty.
+type brotliResponseWriter struct {
+	http.ResponseWriter
+	writer      *brotli.Writer
+	wroteHeader bool
+	passThrough bool
+}
+
+func (bw *brotliResponseWriter) WriteHeader(code int) {
+	if bw.wroteHeader {
+		return
+	}
+	bw.wroteHeader = true
+
+	header := bw.Header()
+	if code == http.StatusPartialContent || header.Get("Content-Encoding") != "" {
+		bw.passThrough = true
+	} else {
+		header.Set("Content-Encoding", "br")
+		header.Del("Content-Length")
+	}
+	bw.ResponseWriter.WriteHeader(code)
+}
+
+func (bw *brotliResponseWriter) Write(p []byte) (int, error) {
+	if !bw.wroteHeader {
+		if bw.Header().Get("Content-Type") == "" {
+			bw.Header().Set("Content-Type", http.DetectContentType(p))
+		}
+		bw.WriteHeader(http.StatusOK)
+	}
+	if bw.passThrough {
+		return bw.ResponseWriter.Write(p)
+	}
+	if bw.writer == nil {
+		bw.writer = brotli.NewWriter(bw.ResponseWriter)
+	}
+	return bw.writer.Write(p)
+}
+
+// Close flushes any buffered compressed data.
+func (bw *brotliResponseWriter) Close() error {
+	if bw.writer == nil {
+		return nil
+	}
+	return bw.writer.Close()
+}
@@ -0,0 +1,119 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+var compressionBody = strings.Repeat("<html>webtmux</html>\n", 200)
+
+func serveCompressed(acceptEncoding string) *httptest.ResponseRecorder {
+	handler := compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		io.WriteString(w, compressionBody)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if acceptEncoding != "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCompressHandlerBrotli(t *testing.T) {
+	rr := serveCompressed("gzip, deflate, br")
+
+	if got := rr.Header().Get("Content-Encoding"); got != "br" {
+		t.Fatalf("Content-Encoding = %q, want br", got)
+	}
+	body, err := io.ReadAll(brotli.NewReader(rr.Body))
+	if err != nil {
+		t.Fatalf("failed to decode brotli body: %v", err)
+	}
+	if string(body) != compressionBody {
+		t.Error("decoded body does not match original")
+	}
+}
+
+func TestCompressHandlerGzipOnly(t *testing.T) {
+	rr := serveCompressed("gzip")
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("Content-Encoding = %q, want gzip", got)
+	}
+	reader, err := gzip.NewReader(rr.Body)
+	if err != nil {
+		t.Fatalf("failed to open gzip body: %v", err)
+	}
+	body, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatalf("failed to decode gzip body: %v", err)
+	}
+	if string(body) != compressionBody {
+		t.Error("decoded body does not match original")
+	}
+}
+
+func TestCompressHandlerBrotliDisabledByQuality(t *testing.T) {
+	rr := serveCompressed("br;q=0, gzip")
+
+	if got := rr.Header().Get("Content-Encoding"); got != "gzip" {
+		t.Errorf("Content-Encoding = %q, want gzip", got)
+	}
+}
+
+func TestCompressHandlerNoEncoding(t *testing.T) {
+	rr := serveCompressed("")
+
+	if got := rr.Header().Get("Content-Encoding"); got != "" {
+		t.Errorf("Content-Encoding = %q, want none", got)
+	}
+	if rr.Body.String() != compressionBody {
+		t.Error("body should be sent uncompressed")
+	}
+}
+
+func TestCompressHandlerBrotliEmptyResponse(t *testing.T) {
+	handler := compressHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotModified)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "br")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Body.Len() != 0 {
+		t.Errorf("body length = %d, want 0", rr.Body.Len())
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{"br", true},
+		{"gzip, br", true},
+		{"BR;q=0.5", true},
+		{"br;q=0", false},
+		{"gzip", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", tt.header)
+		if got := acceptsEncoding(req, "br"); got != tt.want {
+			t.Errorf("acceptsEncoding(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
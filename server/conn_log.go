@@ -0,0 +1,24 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// logConnectionCounts logs the number of active connections and the peak
+// since the previous log line every interval until ctx is done.
+func logConnectionCounts(ctx context.Context, counter *counter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			active, peak := counter.takePeak()
+			log.Printf("Active connections: %d (peak %d)", active, peak)
+		}
+	}
+}
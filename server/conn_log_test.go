@@ -0,0 +1,50 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLogConnectionCounts(t *testing.T) {
+	logBuf := &syncBuffer{}
+	log.SetOutput(logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	c := newCounter(0)
+	c.add(3)
+	c.done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		logConnectionCounts(ctx, c, 10*time.Millisecond)
+		close(done)
+	}()
+
+	waitForLog := func(want string) {
+		t.Helper()
+		deadline := time.Now().Add(time.Second)
+		for !strings.Contains(logBuf.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("log %q does not contain %q", logBuf.String(), want)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForLog("Active connections: 2 (peak 3)")
+
+	c.done()
+	waitForLog("Active connections: 1 (peak 2)")
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("logConnectionCounts() did not stop on shutdown")
+	}
+	c.done()
+}
@@ -0,0 +1,237 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// resourceSampleInterval is how often the resource usage of a session's
+// backend is sampled.
+const resourceSampleInterval = 5 * time.Second
+
+// countingTransport counts the bytes passed through a Transport.
+type countingTransport struct {
+	Transport
+	bytesRead    int64
+	bytesWritten int64
+	// Set when the bytes are also counted in the server's metrics
+	metrics *transportMetrics
+}
+
+func (ct *countingTransport) Read(p []byte) (int, error) {
+	n, err := ct.Transport.Read(p)
+	atomic.AddInt64(&ct.bytesRead, int64(n))
+	if ct.metrics != nil {
+		ct.metrics.bytesReceived.Add(float64(n))
+	}
+	return n, err
+}
+
+func (ct *countingTransport) Write(p []byte) (int, error) {
+	n, err := ct.Transport.Write(p)
+	atomic.AddInt64(&ct.bytesWritten, int64(n))
+	if ct.metrics != nil {
+		ct.metrics.bytesSent.Add(float64(n))
+	}
+	return n, err
+}
+
+// connectionEntry describes an active terminal connection.
+type connectionEntry struct {
+	ID         uint64
+	RequestID  string
+	RemoteAddr string
+	StartedAt  time.Time
+
+	transport  *countingTransport
+	foreground atomic.Value // ForegroundReporter
+	resources  atomic.Value // ResourceUsage
+	queue      atomic.Value // *queuedTransport
+	// Set when stream viewers can attach to the output of the session
+	output atomic.Value // *teeWriter
+	// Closed once the connection is removed
+	done chan struct{}
+}
+
+// BytesSent returns the number of bytes sent to the client so far.
+func (entry *connectionEntry) BytesSent() int64 {
+	return atomic.LoadInt64(&entry.transport.bytesWritten)
+}
+
+// BytesReceived returns the number of bytes received from the client so far.
+func (entry *connectionEntry) BytesReceived() int64 {
+	return atomic.LoadInt64(&entry.transport.bytesRead)
+}
+
+// RTT returns the estimated round trip time to the client, if the transport
+// can tell.
+func (entry *connectionEntry) RTT() (time.Duration, bool) {
+	reporter, ok := entry.transport.Transport.(RTTReporter)
+	if !ok {
+		return 0, false
+	}
+	return reporter.RTT()
+}
+
+// ForegroundProcess returns the name of the process in the foreground of the
+// connection's terminal, or an empty string if the backend cannot tell.
+func (entry *connectionEntry) ForegroundProcess() string {
+	reporter, ok := entry.foreground.Load().(ForegroundReporter)
+	if !ok {
+		return ""
+	}
+	name, err := reporter.ForegroundProcess()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// ResourceUsage returns the most recently sampled resource usage of the
+// connection's backend. ok is false if none has been sampled.
+func (entry *connectionEntry) ResourceUsage() (usage ResourceUsage, ok bool) {
+	usage, ok = entry.resources.Load().(ResourceUsage)
+	return usage, ok
+}
+
+// WriteQueue returns the number of messages waiting in the connection's
+// write queue, the most it held and how many it dropped. ok is false
+// without a write queue.
+func (entry *connectionEntry) WriteQueue() (depth int, peak int, dropped int64, ok bool) {
+	queue, ok := entry.queue.Load().(*queuedTransport)
+	if !ok {
+		return 0, 0, 0, false
+	}
+	return queue.Depth(), queue.Peak(), queue.Dropped(), true
+}
+
+// sampleResources records the resource usage reported by reporter every
+// interval until ctx is done.
+func (entry *connectionEntry) sampleResources(ctx context.Context, reporter ResourceReporter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if usage, err := reporter.ResourceUsage(); err == nil {
+			entry.resources.Store(usage)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// disconnectEvent is emitted when a terminal connection ends.
+type disconnectEvent struct {
+	Event         string  `json:"event"`
+	ID            uint64  `json:"id"`
+	RequestID     string  `json:"request_id,omitempty"`
+	RemoteAddr    string  `json:"remote_addr"`
+	Duration      float64 `json:"duration_seconds"`
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	RTT           float64 `json:"rtt_ms,omitempty"`
+	CPUTime       float64 `json:"cpu_seconds,omitempty"`
+	RSS           int64   `json:"rss_bytes,omitempty"`
+	QueuePeak     int     `json:"queue_peak,omitempty"`
+	QueueDropped  int64   `json:"queue_dropped,omitempty"`
+}
+
+// connectionRegistry keeps track of active terminal connections.
+type connectionRegistry struct {
+	mu      sync.Mutex
+	nextID  uint64
+	entries map[uint64]*connectionEntry
+}
+
+func newConnectionRegistry() *connectionRegistry {
+	return &connectionRegistry{
+		entries: make(map[uint64]*connectionEntry),
+	}
+}
+
+// add registers a connection over transport, correlated by requestID. The
+// returned entry's transport must be used for all further I/O so that bytes
+// are counted.
+func (registry *connectionRegistry) add(transport Transport, requestID string) *connectionEntry {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.nextID++
+	entry := &connectionEntry{
+		ID:         registry.nextID,
+		RequestID:  requestID,
+		RemoteAddr: transport.RemoteAddr(),
+		StartedAt:  time.Now(),
+		transport:  &countingTransport{Transport: transport},
+		done:       make(chan struct{}),
+	}
+	registry.entries[entry.ID] = entry
+	return entry
+}
+
+// remove unregisters entry and returns its disconnect event.
+func (registry *connectionRegistry) remove(entry *connectionEntry) disconnectEvent {
+	registry.mu.Lock()
+	delete(registry.entries, entry.ID)
+	registry.mu.Unlock()
+	close(entry.done)
+
+	event := disconnectEvent{
+		Event:         "disconnect",
+		ID:            entry.ID,
+		RequestID:     entry.RequestID,
+		RemoteAddr:    entry.RemoteAddr,
+		Duration:      time.Since(entry.StartedAt).Seconds(),
+		BytesSent:     entry.BytesSent(),
+		BytesReceived: entry.BytesReceived(),
+	}
+	if rtt, ok := entry.RTT(); ok {
+		event.RTT = float64(rtt) / float64(time.Millisecond)
+	}
+	if usage, ok := entry.ResourceUsage(); ok {
+		event.CPUTime = usage.CPUTime.Seconds()
+		event.RSS = usage.RSS
+	}
+	if _, peak, dropped, ok := entry.WriteQueue(); ok {
+		event.QueuePeak = peak
+		event.QueueDropped = dropped
+	}
+	return event
+}
+
+// list returns the currently active connections.
+func (registry *connectionRegistry) list() []*connectionEntry {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	entries := make([]*connectionEntry, 0, len(registry.entries))
+	for _, entry := range registry.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// viewable returns the connection id whose output stream viewers can
+// attach to, or the most recent such connection when id is 0. It returns
+// nil if there is none.
+func (registry *connectionRegistry) viewable(id uint64) *connectionEntry {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	var found *connectionEntry
+	for _, entry := range registry.entries {
+		if entry.output.Load() == nil || (id != 0 && entry.ID != id) {
+			continue
+		}
+		if found == nil || entry.ID > found.ID {
+			found = entry
+		}
+	}
+	return found
+}
@@ -0,0 +1,229 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCountingTransport(t *testing.T) {
+	transport := newConnTestTransport()
+	transport.SetReadData([]byte("hello"))
+	ct := &countingTransport{Transport: transport}
+
+	buf := make([]byte, 16)
+	ct.Read(buf)
+	ct.Write([]byte("output"))
+
+	if ct.bytesRead != 5 {
+		t.Errorf("bytesRead = %d, want 5", ct.bytesRead)
+	}
+	if ct.bytesWritten != 6 {
+		t.Errorf("bytesWritten = %d, want 6", ct.bytesWritten)
+	}
+}
+
+func TestConnectionRegistry(t *testing.T) {
+	registry := newConnectionRegistry()
+
+	first := registry.add(newConnTestTransport(), "")
+	second := registry.add(newConnTestTransport(), "")
+	if first.ID == second.ID {
+		t.Errorf("connection IDs should be unique, got %d twice", first.ID)
+	}
+	if len(registry.list()) != 2 {
+		t.Errorf("list() = %d entries, want 2", len(registry.list()))
+	}
+
+	first.transport.Write([]byte("output"))
+	time.Sleep(10 * time.Millisecond)
+
+	event := registry.remove(first)
+	if event.Event != "disconnect" || event.ID != first.ID {
+		t.Errorf("event = %+v, want disconnect of %d", event, first.ID)
+	}
+	if event.RemoteAddr != "127.0.0.1:12345" {
+		t.Errorf("event.RemoteAddr = %q, want %q", event.RemoteAddr, "127.0.0.1:12345")
+	}
+	if event.Duration <= 0 {
+		t.Errorf("event.Duration = %v, want > 0", event.Duration)
+	}
+	if event.BytesSent != 6 {
+		t.Errorf("event.BytesSent = %d, want 6", event.BytesSent)
+	}
+
+	entries := registry.list()
+	if len(entries) != 1 || entries[0] != second {
+		t.Errorf("list() after remove = %v, want only the second entry", entries)
+	}
+}
+
+func TestServeTerminalDisconnectEvent(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	init, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(init, []byte("2"))
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		server.processTransportConn(ctx, transport, nil, "")
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if entries := server.connections.list(); len(entries) != 1 {
+		t.Errorf("active connections = %d, want 1", len(entries))
+	}
+	<-done
+
+	if entries := server.connections.list(); len(entries) != 0 {
+		t.Errorf("active connections after disconnect = %d, want 0", len(entries))
+	}
+
+	var event disconnectEvent
+	for _, line := range strings.Split(logBuf.String(), "\n") {
+		if idx := strings.Index(line, "Session ended: "); idx >= 0 {
+			if err := json.Unmarshal([]byte(line[idx+len("Session ended: "):]), &event); err != nil {
+				t.Fatalf("failed to parse disconnect event: %v", err)
+			}
+		}
+	}
+
+	if event.Event != "disconnect" {
+		t.Fatalf("no disconnect event logged, got: %s", logBuf.String())
+	}
+	if event.Duration <= 0 {
+		t.Errorf("event.Duration = %v, want > 0", event.Duration)
+	}
+	if event.BytesSent == 0 {
+		t.Error("event.BytesSent should be non-zero")
+	}
+	if event.BytesReceived != 1 {
+		t.Errorf("event.BytesReceived = %d, want 1", event.BytesReceived)
+	}
+}
+
+type fakeForegroundReporter struct {
+	name string
+	err  error
+}
+
+func (reporter *fakeForegroundReporter) ForegroundProcess() (string, error) {
+	return reporter.name, reporter.err
+}
+
+func TestConnectionEntryForegroundProcess(t *testing.T) {
+	registry := newConnectionRegistry()
+	entry := registry.add(newConnTestTransport(), "")
+
+	if name := entry.ForegroundProcess(); name != "" {
+		t.Errorf("ForegroundProcess() without reporter = %q, want empty", name)
+	}
+
+	reporter := &fakeForegroundReporter{name: "vim"}
+	entry.foreground.Store(ForegroundReporter(reporter))
+	if name := entry.ForegroundProcess(); name != "vim" {
+		t.Errorf("ForegroundProcess() = %q, want %q", name, "vim")
+	}
+
+	reporter.err = errors.New("unsupported")
+	if name := entry.ForegroundProcess(); name != "" {
+		t.Errorf("ForegroundProcess() on error = %q, want empty", name)
+	}
+}
+
+// rttTransport is a Transport reporting a fixed RTT
+type rttTransport struct {
+	*connTestTransport
+	rtt time.Duration
+}
+
+func (rt *rttTransport) RTT() (time.Duration, bool) {
+	return rt.rtt, rt.rtt > 0
+}
+
+func TestConnectionEntryRTT(t *testing.T) {
+	registry := newConnectionRegistry()
+
+	plain := registry.add(newConnTestTransport(), "")
+	if _, ok := plain.RTT(); ok {
+		t.Error("RTT() should not be available for a transport that cannot tell")
+	}
+	if event := registry.remove(plain); event.RTT != 0 {
+		t.Errorf("event.RTT = %v, want 0", event.RTT)
+	}
+
+	entry := registry.add(&rttTransport{connTestTransport: newConnTestTransport(), rtt: 42 * time.Millisecond}, "")
+	if rtt, ok := entry.RTT(); !ok || rtt != 42*time.Millisecond {
+		t.Errorf("RTT() = %s, %v, want 42ms", rtt, ok)
+	}
+	if event := registry.remove(entry); event.RTT != 42 {
+		t.Errorf("event.RTT = %v, want 42", event.RTT)
+	}
+}
+
+// fakeResourceReporter reports a CPU time growing by a second per sample
+type fakeResourceReporter struct {
+	mu      sync.Mutex
+	samples int
+}
+
+func (r *fakeResourceReporter) ResourceUsage() (ResourceUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.samples++
+	return ResourceUsage{CPUTime: time.Duration(r.samples) * time.Second, RSS: 4096}, nil
+}
+
+func TestConnectionEntryResourceUsage(t *testing.T) {
+	registry := newConnectionRegistry()
+	entry := registry.add(newConnTestTransport(), "")
+
+	if _, ok := entry.ResourceUsage(); ok {
+		t.Error("ResourceUsage() should not be available before sampling")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		entry.sampleResources(ctx, &fakeResourceReporter{}, 10*time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		usage, ok := entry.ResourceUsage()
+		if ok && usage.CPUTime >= 2*time.Second {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("ResourceUsage() = %+v, %v, want repeated samples", usage, ok)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+
+	event := registry.remove(entry)
+	if event.CPUTime < 2 || event.RSS != 4096 {
+		t.Errorf("disconnect event cpu_seconds = %v, rss_bytes = %d, want >= 2 and 4096", event.CPUTime, event.RSS)
+	}
+}
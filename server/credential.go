@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// argon2idPrefix starts an argon2id hash in the PHC string format, e.g.
+// $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash> with unpadded base64.
+const argon2idPrefix = "$argon2id$"
+
+// isBcryptHash reports whether password is a bcrypt hash rather than a
+// plain password.
+func isBcryptHash(password string) bool {
+	for _, prefix := range []string{"$2a$", "$2b$", "$2y$"} {
+		if strings.HasPrefix(password, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// argon2idHash is a parsed argon2id hash.
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	key     []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, errors.New("expected $argon2id$v=19$m=...,t=...,p=...$salt$hash")
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, errors.Errorf("unsupported argon2 version `%s`", parts[2])
+	}
+	hash := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &hash.memory, &hash.time, &hash.threads); err != nil {
+		return nil, errors.Errorf("invalid argon2 parameters `%s`", parts[3])
+	}
+	var err error
+	if hash.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, errors.Wrapf(err, "invalid argon2 salt")
+	}
+	if hash.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(hash.key) == 0 {
+		return nil, errors.New("invalid argon2 hash")
+	}
+	return hash, nil
+}
+
+// validateCredential checks that a hashed password in credential can be
+// used to verify passwords.
+func validateCredential(credential string) error {
+	_, password, _ := strings.Cut(credential, ":")
+	switch {
+	case isBcryptHash(password):
+		if _, err := bcrypt.Cost([]byte(password)); err != nil {
+			return errors.Wrapf(err, "invalid bcrypt hash in credential")
+		}
+	case strings.HasPrefix(password, argon2idPrefix):
+		if _, err := parseArgon2id(password); err != nil {
+			return errors.Wrapf(err, "invalid argon2id hash in credential")
+		}
+	}
+	return nil
+}
+
+// checkCredential reports whether payload, the `user:pass` of a Basic
+// Authorization header, matches credential. The password of credential
+// is either plain text or a bcrypt or argon2id hash, told apart by prefix.
+func checkCredential(credential string, payload string) bool {
+	wantUser, wantPassword, _ := strings.Cut(credential, ":")
+	user, password, ok := strings.Cut(payload, ":")
+
+	userMatch := subtle.ConstantTimeCompare([]byte(user), []byte(wantUser)) == 1
+	var passwordMatch bool
+	switch {
+	case isBcryptHash(wantPassword):
+		passwordMatch = bcrypt.CompareHashAndPassword([]byte(wantPassword), []byte(password)) == nil
+	case strings.HasPrefix(wantPassword, argon2idPrefix):
+		hash, err := parseArgon2id(wantPassword)
+		if err == nil {
+			key := argon2.IDKey([]byte(password), hash.salt, hash.time, hash.memory, hash.threads, uint32(len(hash.key)))
+			passwordMatch = subtle.ConstantTimeCompare(key, hash.key) == 1
+		}
+	default:
+		passwordMatch = subtle.ConstantTimeCompare([]byte(password), []byte(wantPassword)) == 1
+	}
+	return ok && userMatch && passwordMatch
+}
+
+// credentials returns all credentials accepted for Basic Authentication.
+func (server *Server) credentials() []string {
+	credentials := []string{}
+	if server.options.Credential != "" {
+		credentials = append(credentials, server.options.Credential)
+	}
+	return append(credentials, server.options.Credentials...)
+}
+
+// matchCredential returns the user of the entry of credentials matching
+// payload. All entries are checked, so that the time taken does not tell
+// which one matched.
+func matchCredential(credentials []string, payload string) (string, bool) {
+	user := ""
+	matched := false
+	for _, credential := range credentials {
+		if checkCredential(credential, payload) && !matched {
+			user, _, _ = strings.Cut(credential, ":")
+			matched = true
+		}
+	}
+	return user, matched
+}
+
+// hasCredentialUser reports whether any of credentials is for user.
+func hasCredentialUser(credentials []string, user string) bool {
+	for _, credential := range credentials {
+		if name, _, _ := strings.Cut(credential, ":"); name == user {
+			return true
+		}
+	}
+	return false
+}
+
+// authUserKey holds a *string with the Basic Authentication user of a
+// request or connection. A pointer lets wrapBasicAuth report the user to
+// the wrapLogger around it.
+type authUserKey struct{}
+
+// withAuthUser returns ctx carrying user.
+func withAuthUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, authUserKey{}, &user)
+}
+
+// authUserFromContext returns the user carried by ctx, if any.
+func authUserFromContext(ctx context.Context) string {
+	if user, ok := ctx.Value(authUserKey{}).(*string); ok {
+		return *user
+	}
+	return ""
+}
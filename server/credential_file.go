@@ -0,0 +1,106 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"webtmux/pkg/homedir"
+)
+
+// credentialReloadPoll is how often the credential file is checked for
+// changes.
+var credentialReloadPoll = 1 * time.Second
+
+// loadCredentialFile reads an htpasswd-style file of `user:password`
+// lines, where the password is plain or a bcrypt or argon2id hash. Blank
+// lines and lines starting with `#` are skipped.
+func loadCredentialFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read credential file `%s`", path)
+	}
+
+	credentials := []string{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		user, password, ok := strings.Cut(entry, ":")
+		if !ok || user == "" {
+			return nil, errors.Errorf("credential file `%s` line %d: expected user:password", path, line)
+		}
+		if strings.HasPrefix(password, "$apr1$") || strings.HasPrefix(password, "{SHA}") {
+			return nil, errors.Errorf("credential file `%s` line %d: only bcrypt and argon2id hashes are supported", path, line)
+		}
+		if err := validateCredential(entry); err != nil {
+			return nil, errors.Wrapf(err, "credential file `%s` line %d", path, line)
+		}
+		credentials = append(credentials, entry)
+	}
+	return credentials, nil
+}
+
+// fileCredentials returns the credentials last loaded from CredentialFile.
+func (server *Server) fileCredentials() []string {
+	server.credentialMu.RLock()
+	defer server.credentialMu.RUnlock()
+	return server.loadedCredentials
+}
+
+// ReloadCredentials reloads the credential file, keeping the credentials
+// loaded before if it cannot be read or parsed.
+func (server *Server) ReloadCredentials() error {
+	if server.options.CredentialFile == "" {
+		return errors.New("no credential file is configured")
+	}
+	path := homedir.Expand(server.options.CredentialFile)
+	credentials, err := loadCredentialFile(path)
+	if err != nil {
+		return err
+	}
+
+	server.credentialMu.Lock()
+	server.loadedCredentials = credentials
+	server.credentialMu.Unlock()
+	log.Printf("Loaded %d credentials from %s", len(credentials), path)
+	return nil
+}
+
+// watchCredentialFile reloads the credential file whenever it changes,
+// until ctx is done.
+func (server *Server) watchCredentialFile(ctx context.Context, path string) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(credentialReloadPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+
+			if err := server.ReloadCredentials(); err != nil {
+				log.Printf("Warning: keeping previous credentials: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLoadCredentialFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# terminal users\n\nalice:wonderland\n" + bcryptCredential(t, "bob", "builder") + "\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := loadCredentialFile(path)
+	if err != nil {
+		t.Fatalf("loadCredentialFile() error: %v", err)
+	}
+	if len(credentials) != 2 || credentials[0] != "alice:wonderland" {
+		t.Errorf("loadCredentialFile() = %q, want alice and bob", credentials)
+	}
+
+	for _, invalid := range []string{"nocolon\n", ":nouser\n", "carol:$apr1$salt$hash\n", "dave:$2y$10$short\n"} {
+		if err := os.WriteFile(path, []byte(invalid), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := loadCredentialFile(path); err == nil {
+			t.Errorf("loadCredentialFile() of %q should fail", invalid)
+		}
+	}
+}
+
+func TestCredentialFileReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:wonderland\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server, err := New(newMockFactory(), &Options{TitleFormat: "Test", EnableBasicAuth: true, CredentialFile: path})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	wrapped := server.wrapBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), server.credentials()...)
+	login := func(payload string) int {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(payload)))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := login("alice:wonderland"); code != http.StatusOK {
+		t.Fatalf("alice: status = %d, want %d", code, http.StatusOK)
+	}
+
+	// Rotate the password
+	if err := os.WriteFile(path, []byte("alice:looking-glass\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadCredentials(); err != nil {
+		t.Fatalf("ReloadCredentials() error: %v", err)
+	}
+	if code := login("alice:wonderland"); code != http.StatusUnauthorized {
+		t.Errorf("old password: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if code := login("alice:looking-glass"); code != http.StatusOK {
+		t.Errorf("new password: status = %d, want %d", code, http.StatusOK)
+	}
+
+	// A broken file keeps the previous credentials
+	if err := os.WriteFile(path, []byte("garbage\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := server.ReloadCredentials(); err == nil {
+		t.Error("ReloadCredentials() of a broken file should fail")
+	}
+	if code := login("alice:looking-glass"); code != http.StatusOK {
+		t.Errorf("after a failed reload: status = %d, want %d", code, http.StatusOK)
+	}
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatchCredentialFile(t *testing.T) {
+	oldPoll := credentialReloadPoll
+	credentialReloadPoll = 10 * time.Millisecond
+	defer func() { credentialReloadPoll = oldPoll }()
+
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:wonderland\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	server, err := New(newMockFactory(), &Options{TitleFormat: "Test", CredentialFile: path})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		server.watchCredentialFile(ctx, path)
+		close(done)
+	}()
+
+	// Let the watcher record the current file before changing it
+	time.Sleep(5 * credentialReloadPoll)
+	if err := os.WriteFile(path, []byte("alice:wonderland\nbob:builder\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "the credential file to be reloaded", func() bool {
+		return len(server.fileCredentials()) == 2
+	})
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("watchCredentialFile did not stop when its context was done")
+	}
+}
+
+func TestNewInvalidCredentialFile(t *testing.T) {
+	if _, err := New(newMockFactory(), &Options{TitleFormat: "Test", CredentialFile: filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("New() should fail when the credential file cannot be read")
+	}
+}
@@ -0,0 +1,201 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptCredential(t *testing.T, user, password string) string {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("GenerateFromPassword() error: %v", err)
+	}
+	return user + ":" + string(hash)
+}
+
+func argon2idCredential(user, password string) string {
+	salt := []byte("0123456789abcdef")
+	key := argon2.IDKey([]byte(password), salt, 1, 64, 1, 32)
+	return fmt.Sprintf("%s:$argon2id$v=%d$m=64,t=1,p=1$%s$%s", user, argon2.Version,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))
+}
+
+func TestCheckCredential(t *testing.T) {
+	credentials := map[string]string{
+		"plain":    "admin:secret",
+		"bcrypt":   bcryptCredential(t, "admin", "secret"),
+		"argon2id": argon2idCredential("admin", "secret"),
+	}
+
+	for name, credential := range credentials {
+		t.Run(name, func(t *testing.T) {
+			if err := validateCredential(credential); err != nil {
+				t.Fatalf("validateCredential() error: %v", err)
+			}
+			tests := []struct {
+				payload string
+				want    bool
+			}{
+				{"admin:secret", true},
+				{"admin:wrong", false},
+				{"other:secret", false},
+				{"admin", false},
+				{"", false},
+			}
+			for _, tt := range tests {
+				if got := checkCredential(credential, tt.payload); got != tt.want {
+					t.Errorf("checkCredential(%q) = %v, want %v", tt.payload, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateCredentialInvalidHash(t *testing.T) {
+	for _, credential := range []string{
+		"admin:$2a$10$tooshort",
+		"admin:$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"admin:$argon2id$v=16$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"admin:$argon2id$v=19$x=1$c2FsdA$aGFzaA",
+	} {
+		if err := validateCredential(credential); err == nil {
+			t.Errorf("validateCredential(%q) should fail", credential)
+		}
+	}
+	if err := (&Options{Credential: "admin:$2b$04$broken"}).Validate(); err == nil {
+		t.Error("Validate() should reject an invalid bcrypt hash")
+	}
+}
+
+func TestWrapBasicAuthHashedCredential(t *testing.T) {
+	server := createTestServer()
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	wrapped := server.wrapBasicAuth(handler, bcryptCredential(t, "admin", "secret"))
+
+	request := func(payload string) int {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.0.2.1:12345"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(payload)))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	if code := request("admin:wrong"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong password: status = %d, want %d", code, http.StatusUnauthorized)
+	}
+	if info := server.rateLimiter.attempts["192.0.2.1"]; info == nil || info.failCount != 1 {
+		t.Fatalf("a wrong password should be recorded as a failure, got %+v", info)
+	}
+
+	if code := request("admin:secret"); code != http.StatusOK {
+		t.Fatalf("right password: status = %d, want %d", code, http.StatusOK)
+	}
+	if info := server.rateLimiter.attempts["192.0.2.1"]; info.failCount != 0 {
+		t.Errorf("a success should reset the failure count, got %d", info.failCount)
+	}
+}
+
+func TestWrapBasicAuthMultipleCredentials(t *testing.T) {
+	logBuf := &bytes.Buffer{}
+	log.SetOutput(logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	server := &Server{options: &Options{
+		Credential:  "admin:secret",
+		Credentials: []string{"alice:wonderland", bcryptCredential(t, "bob", "builder")},
+	}, rateLimiter: newRateLimiter(defaultRateLimits)}
+	var seenUser string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenUser = authUserFromContext(r.Context())
+	})
+	wrapped := server.wrapLogger(server.wrapBasicAuth(handler, server.credentials()...))
+
+	tests := []struct {
+		payload  string
+		wantCode int
+		wantUser string
+	}{
+		{"admin:secret", http.StatusOK, "admin"},
+		{"alice:wonderland", http.StatusOK, "alice"},
+		{"bob:builder", http.StatusOK, "bob"},
+		{"alice:secret", http.StatusUnauthorized, ""},
+		{"mallory:builder", http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		seenUser = ""
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.payload)))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+
+		if rr.Code != tt.wantCode {
+			t.Errorf("%s: status = %d, want %d", tt.payload, rr.Code, tt.wantCode)
+		}
+		if seenUser != tt.wantUser {
+			t.Errorf("%s: handler saw user %q, want %q", tt.payload, seenUser, tt.wantUser)
+		}
+	}
+	if got := logBuf.String(); !strings.Contains(got, "(alice) 200 GET /test") {
+		t.Errorf("log = %q, want the matched user in the access log", got)
+	}
+}
+
+func TestValidateCredentials(t *testing.T) {
+	if err := (&Options{Credentials: []string{"alice:wonderland", "bob:builder"}}).Validate(); err != nil {
+		t.Errorf("Validate() error: %v", err)
+	}
+	for _, credential := range []string{"alice", "alice:won:derland"} {
+		err := (&Options{Credentials: []string{credential}}).Validate()
+		if err == nil || !strings.Contains(err.Error(), "exactly one colon") {
+			t.Errorf("Validate() with credential %q = %v, want an error about colons", credential, err)
+		}
+	}
+}
+
+func TestAuthUserInWindowTitle(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat:     "{{ .auth_user }}@{{ .remote_addr }}",
+		EnableBasicAuth: true,
+		AuthIPBinding:   true,
+		Credentials:     []string{"alice:wonderland"},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/auth_token.js", nil)
+	req = req.WithContext(withAuthUser(req.Context(), "alice"))
+	token, err := server.issueAuthToken(req)
+	if err != nil {
+		t.Fatalf("issueAuthToken() error: %v", err)
+	}
+
+	ctx := context.WithValue(context.Background(), authRequiredKey{}, true)
+	user, _, ok := server.validateAuthToken(ctx, token, "192.0.2.1")
+	if !ok || user != "alice" {
+		t.Fatalf("validateAuthToken() = %q, %v, want %q, true", user, ok, "alice")
+	}
+
+	title, err := server.windowTitle("192.0.2.1:1234", user, nil, newMockSlaveForTransport())
+	if err != nil {
+		t.Fatalf("windowTitle() error: %v", err)
+	}
+	if string(title) != "alice@192.0.2.1:1234" {
+		t.Errorf("windowTitle() = %q, want %q", title, "alice@192.0.2.1:1234")
+	}
+}
@@ -0,0 +1,37 @@
+package server
+
+import (
+	"encoding/json"
+	"log"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// errDuplicateInit ends a connection whose client sent a second init message.
+var errDuplicateInit = errors.New("protocol error: duplicate init message")
+
+// initFilterTransport handles init messages arriving after the handshake
+// according to Options.DuplicateInit. No terminal message starts with '{',
+// so any JSON object is taken for an init message.
+type initFilterTransport struct {
+	Transport
+
+	ignore   bool
+	rejected atomic.Bool
+}
+
+func (ft *initFilterTransport) Read(p []byte) (int, error) {
+	for {
+		n, err := ft.Transport.Read(p)
+		if err != nil || n == 0 || p[0] != '{' || json.Unmarshal(p[:n], &InitMessage{}) != nil {
+			return n, err
+		}
+
+		if !ft.ignore {
+			ft.rejected.Store(true)
+			return 0, errDuplicateInit
+		}
+		log.Printf("Ignoring duplicate init message from %s", ft.RemoteAddr())
+	}
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+func TestDuplicateInit(t *testing.T) {
+	tests := []struct {
+		mode       string
+		wantErr    error
+		wantOutput string
+	}{
+		{mode: "", wantErr: errDuplicateInit, wantOutput: ""},
+		{mode: "reject", wantErr: errDuplicateInit, wantOutput: ""},
+		{mode: "ignore", wantErr: context.DeadlineExceeded, wantOutput: "ls\r"},
+	}
+
+	for _, tt := range tests {
+		// The mock slave echoes its input back as output
+		server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", PermitWrite: true, DuplicateInit: tt.mode})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+
+		init, _ := json.Marshal(InitMessage{AuthToken: "token", Arguments: "?arg=1"})
+		input := append([]byte{webtty.Input}, "ls\r"...)
+		transport := newBlockingTransport(init, init, input)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		err = server.processTransportConn(ctx, transport, nil, "")
+		cancel()
+		close(transport.closed)
+
+		if err != tt.wantErr {
+			t.Errorf("DuplicateInit=%q processTransportConn() error = %v, want %v", tt.mode, err, tt.wantErr)
+		}
+		if got := transport.outputText(t); got != tt.wantOutput {
+			t.Errorf("DuplicateInit=%q output = %q, want %q", tt.mode, got, tt.wantOutput)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// errServerFull is reported by checkCapacity when MaxConnection is reached.
+var errServerFull = errors.New("exceeding max number of connections")
+
+var defaultFullPage = template.Must(template.New("full").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Server is full</title></head>
+<body>
+<h1>Server is full</h1>
+<p>All {{.MaxConnection}} terminal connections are in use.{{if .RetryAfter}} Please try again in {{.RetryAfter}} seconds.{{else}} Please try again later.{{end}}</p>
+</body>
+</html>
+`))
+
+// fullPage is the custom page served when MaxConnection is reached.
+type fullPage struct {
+	contentType string
+	body        []byte
+}
+
+// loadFullPage reads the FullPage option's file, typed by its extension.
+func loadFullPage(path string) (*fullPage, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read full page `%s`", path)
+	}
+	contentType := mime.TypeByExtension(filepath.Ext(path))
+	if contentType == "" {
+		contentType = "text/html; charset=utf-8"
+	}
+	return &fullPage{contentType: contentType, body: body}, nil
+}
+
+// writeFull rejects a connection because MaxConnection is reached with 503,
+// the custom full page, or a built-in page in JSON if the client prefers it
+// and in HTML otherwise.
+func (server *Server) writeFull(w http.ResponseWriter, r *http.Request) {
+	retryAfter := server.options.FullRetryAfter
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+
+	if server.fullPage != nil {
+		w.Header().Set("Content-Type", server.fullPage.contentType)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write(server.fullPage.body)
+		return
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":       "server is full",
+			"retry_after": retryAfter,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusServiceUnavailable)
+	err := defaultFullPage.Execute(w, map[string]int{
+		"MaxConnection": server.options.MaxConnection,
+		"RetryAfter":    retryAfter,
+	})
+	if err != nil {
+		log.Printf("Failed to render full page: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// requestWhenFull sends r to the WebSocket handler while all of the
+// server's connections are in use.
+func requestWhenFull(t *testing.T, options *Options, r *http.Request) *httptest.ResponseRecorder {
+	t.Helper()
+	server, err := New(newMockFactory(), options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	counter.add(options.MaxConnection)
+	defer func() {
+		for i := 0; i < options.MaxConnection; i++ {
+			counter.done()
+		}
+	}()
+
+	rr := httptest.NewRecorder()
+	server.generateHandleWS(ctx, cancel, counter)(rr, r)
+	return rr
+}
+
+func TestFullPageDefault(t *testing.T) {
+	options := &Options{TitleFormat: "Test", MaxConnection: 1, FullRetryAfter: 30}
+	rr := requestWhenFull(t, options, httptest.NewRequest("GET", "/ws", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %q, want %q", got, "30")
+	}
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") {
+		t.Errorf("Content-Type = %q, want HTML", rr.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rr.Body.String(), "try again in 30 seconds") {
+		t.Errorf("body = %q, want a retry suggestion", rr.Body.String())
+	}
+}
+
+func TestFullPageJSON(t *testing.T) {
+	options := &Options{TitleFormat: "Test", MaxConnection: 1, FullRetryAfter: 5}
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("Accept", "application/json")
+	rr := requestWhenFull(t, options, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Error      string `json:"error"`
+		RetryAfter int    `json:"retry_after"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &body); err != nil {
+		t.Fatalf("body %q is not JSON: %v", rr.Body.String(), err)
+	}
+	if body.RetryAfter != 5 || body.Error == "" {
+		t.Errorf("body = %+v, want an error and retry_after 5", body)
+	}
+}
+
+func TestFullPageCustom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "full.json")
+	if err := os.WriteFile(path, []byte(`{"message":"come back soon"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	options := &Options{TitleFormat: "Test", MaxConnection: 2, FullPage: path, FullRetryAfter: 60}
+	rr := requestWhenFull(t, options, httptest.NewRequest("GET", "/ws", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if got := rr.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+	if got := rr.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/json")
+	}
+	if got := rr.Body.String(); got != `{"message":"come back soon"}` {
+		t.Errorf("body = %q, want the custom page", got)
+	}
+}
+
+func TestFullPageMissingFile(t *testing.T) {
+	options := &Options{TitleFormat: "Test", FullPage: filepath.Join(t.TempDir(), "missing.html")}
+	if _, err := New(newMockFactory(), options); err == nil {
+		t.Error("New() should fail when the full page cannot be read")
+	}
+}
@@ -8,23 +8,47 @@ import (
 type counter struct {
 	duration    time.Duration
 	zeroTimer   *time.Timer
+	idleSince   time.Time
+	idle        func()
 	wg          sync.WaitGroup
 	connections int
+	peak        int
 	mutex       sync.Mutex
 }
 
+// newCounter returns a counter calling the onZero callback once there were
+// no connections for duration, starting now. A duration of 0 disables it.
 func newCounter(duration time.Duration) *counter {
-	zeroTimer := time.NewTimer(duration)
+	counter := &counter{
+		duration:  duration,
+		idleSince: time.Now(),
+	}
+	if duration > 0 {
+		counter.zeroTimer = time.AfterFunc(duration, counter.fireZero)
+	}
+	return counter
+}
 
-	// when duration is 0, drain the expire event here
-	// so that user will never get the event.
-	if duration == 0 {
-		<-zeroTimer.C
+// onZero sets f to be called once there were no connections for the
+// counter's duration. A connection within that time cancels the call.
+func (counter *counter) onZero(f func()) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	counter.idle = f
+}
+
+// fireZero calls the onZero callback unless a connection came and went
+// while the timer was firing, which is left to the rescheduled timer.
+func (counter *counter) fireZero() {
+	counter.mutex.Lock()
+	idle := counter.idle
+	if counter.connections > 0 || time.Since(counter.idleSince) < counter.duration {
+		idle = nil
 	}
+	counter.mutex.Unlock()
 
-	return &counter{
-		duration:  duration,
-		zeroTimer: zeroTimer,
+	if idle != nil {
+		idle()
 	}
 }
 
@@ -37,6 +61,7 @@ func (counter *counter) add(n int) int {
 	}
 	counter.wg.Add(n)
 	counter.connections += n
+	counter.peak = max(counter.peak, counter.connections)
 
 	return counter.connections
 }
@@ -48,6 +73,7 @@ func (counter *counter) done() int {
 	counter.connections--
 	counter.wg.Done()
 	if counter.connections == 0 && counter.duration > 0 {
+		counter.idleSince = time.Now()
 		counter.zeroTimer.Reset(counter.duration)
 	}
 
@@ -61,10 +87,17 @@ func (counter *counter) count() int {
 	return counter.connections
 }
 
-func (counter *counter) wait() {
-	counter.wg.Wait()
+// takePeak returns the current count and the peak since the previous call,
+// starting a new peak period.
+func (counter *counter) takePeak() (int, int) {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+
+	peak := counter.peak
+	counter.peak = counter.connections
+	return counter.connections, peak
 }
 
-func (counter *counter) timer() *time.Timer {
-	return counter.zeroTimer
+func (counter *counter) wait() {
+	counter.wg.Wait()
 }
@@ -28,8 +28,8 @@ func TestNewCounter(t *testing.T) {
 			if c.connections != 0 {
 				t.Errorf("connections = %d, want 0", c.connections)
 			}
-			if c.zeroTimer == nil {
-				t.Error("zeroTimer is nil")
+			if (c.zeroTimer != nil) != (tt.duration > 0) {
+				t.Errorf("zeroTimer = %v, want one only for a positive duration", c.zeroTimer)
 			}
 		})
 	}
@@ -107,15 +107,20 @@ func TestCounterCount(t *testing.T) {
 	}
 }
 
-func TestCounterTimer(t *testing.T) {
-	c := newCounter(time.Second)
+func TestCounterOnZeroWithoutConnections(t *testing.T) {
+	duration := 100 * time.Millisecond
+	fired := make(chan time.Time, 1)
+	start := time.Now()
+	c := newCounter(duration)
+	c.onZero(func() { fired <- time.Now() })
 
-	timer := c.timer()
-	if timer == nil {
-		t.Error("timer() returned nil")
-	}
-	if timer != c.zeroTimer {
-		t.Error("timer() returned different timer than zeroTimer")
+	select {
+	case at := <-fired:
+		if elapsed := at.Sub(start); elapsed < duration {
+			t.Errorf("onZero fired after %v, before %v", elapsed, duration)
+		}
+	case <-time.After(10 * duration):
+		t.Fatal("onZero did not fire without connections")
 	}
 }
 
@@ -194,23 +199,67 @@ func TestCounterConcurrency(t *testing.T) {
 	}
 }
 
-func TestCounterTimerResetOnZero(t *testing.T) {
+func TestCounterOnZeroAfterLastConnection(t *testing.T) {
 	duration := 100 * time.Millisecond
+	fired := make(chan time.Time, 1)
 	c := newCounter(duration)
+	c.onZero(func() { fired <- time.Now() })
 
-	// Add and immediately done - should reset timer
 	c.add(1)
-	c.done()
+	time.Sleep(2 * duration)
+	select {
+	case <-fired:
+		t.Fatal("onZero fired with an active connection")
+	default:
+	}
 
-	// Timer should fire after duration
+	idleAt := time.Now()
+	c.done()
 	select {
-	case <-c.timer().C:
-		// Expected - timer fired
-	case <-time.After(2 * duration):
-		t.Error("timer did not fire after duration")
+	case at := <-fired:
+		if elapsed := at.Sub(idleAt); elapsed < duration {
+			t.Errorf("onZero fired %v after the last connection, before %v", elapsed, duration)
+		}
+	case <-time.After(10 * duration):
+		t.Fatal("onZero did not fire after the last connection")
+	}
+}
+
+func TestCounterOnZeroCanceledByReconnect(t *testing.T) {
+	duration := 200 * time.Millisecond
+	var mu sync.Mutex
+	calls := 0
+	c := newCounter(duration)
+	c.onZero(func() {
+		mu.Lock()
+		calls++
+		mu.Unlock()
+	})
+
+	// Reconnect within the window a few times, then stay connected
+	for i := 0; i < 3; i++ {
+		c.add(1)
+		c.done()
+		time.Sleep(duration / 2)
+	}
+	c.add(1)
+	time.Sleep(2 * duration)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 0 {
+		t.Errorf("onZero was called %d times, want 0 while clients kept reconnecting", calls)
 	}
 }
 
+func TestCounterOnZeroDisabled(t *testing.T) {
+	c := newCounter(0)
+	c.onZero(func() { t.Error("onZero fired with a zero duration") })
+	c.add(1)
+	c.done()
+	time.Sleep(50 * time.Millisecond)
+}
+
 // Benchmark counter operations
 func BenchmarkCounterAdd(b *testing.B) {
 	c := newCounter(0)
@@ -228,3 +277,19 @@ func BenchmarkCounterAddDone(b *testing.B) {
 		c.done()
 	}
 }
+
+func TestCounterTakePeak(t *testing.T) {
+	c := newCounter(0)
+	c.add(3)
+	c.done()
+
+	if active, peak := c.takePeak(); active != 2 || peak != 3 {
+		t.Errorf("takePeak() = %d, %d, want 2, 3", active, peak)
+	}
+	if active, peak := c.takePeak(); active != 2 || peak != 2 {
+		t.Errorf("takePeak() after reset = %d, %d, want 2, 2", active, peak)
+	}
+
+	c.done()
+	c.done()
+}
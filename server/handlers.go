@@ -3,34 +3,55 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
 
+	"webtmux/pkg/homedir"
 	"webtmux/webtty"
 )
 
 func (server *Server) generateHandleWS(ctx context.Context, cancel context.CancelFunc, counter *counter) http.HandlerFunc {
 	once := new(int64)
 
-	go func() {
-		select {
-		case <-counter.timer().C:
-			cancel()
-		case <-ctx.Done():
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.isDraining() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
 		}
-	}()
+		if retryAfter := server.overloadRemaining(); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Backend is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if server.shedLoad(counter.count()) {
+			w.Header().Set("Retry-After", strconv.Itoa(server.options.ShedRetryAfter))
+			http.Error(w, "Server is under high load", http.StatusServiceUnavailable)
+			return
+		}
+		if retryAfter, ok := server.tmuxSessions.admit(); !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, "Too many new tmux sessions", http.StatusTooManyRequests)
+			} else {
+				http.Error(w, "Too many tmux sessions", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer server.tmuxSessions.release()
 
-	return func(w http.ResponseWriter, r *http.Request) {
 		if server.options.Once {
 			success := atomic.CompareAndSwapInt64(once, 0, 1)
 			if !success {
@@ -38,60 +59,78 @@ func (server *Server) generateHandleWS(ctx context.Context, cancel context.Cance
 				return
 			}
 		}
+		if server.sessionsExhausted() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		admission := &sessionAdmission{}
 
 		num := counter.add(1)
 		closeReason := "unknown reason"
+		reqID := requestID(r)
 
 		defer func() {
 			num := counter.done()
 			log.Printf(
-				"Connection closed by %s: %s, connections: %d/%d",
-				closeReason, r.RemoteAddr, num, server.options.MaxConnection,
+				"Connection closed by %s: %s, connections: %d/%d, request: %s",
+				closeReason, r.RemoteAddr, num, server.options.MaxConnection, reqID,
 			)
 
-			if server.options.Once {
+			lastSession := server.finishSession(admission)
+			if server.options.Once || lastSession {
 				cancel()
 			}
 		}()
 
-		if int64(server.options.MaxConnection) != 0 {
-			if num > server.options.MaxConnection {
-				closeReason = "exceeding max number of connections"
-				return
+		if err := server.checkCapacity(num); err != nil {
+			closeReason = err.Error()
+			if err == errServerFull {
+				server.writeFull(w, r)
 			}
+			return
 		}
 
-		log.Printf("New client connected: %s, connections: %d/%d", r.RemoteAddr, num, server.options.MaxConnection)
+		log.Printf("New client connected: %s, connections: %d/%d, request: %s", r.RemoteAddr, num, server.options.MaxConnection, reqID)
 
 		if r.Method != "GET" {
 			http.Error(w, "Method not allowed", 405)
 			return
 		}
 
+		if server.options.WSRequireProtocol && !server.acceptsSubprotocol(r) {
+			closeReason = "no acceptable subprotocol"
+			http.Error(w, "No acceptable WebSocket subprotocol", http.StatusBadRequest)
+			return
+		}
+
 		conn, err := server.upgrader.Upgrade(w, r, nil)
 		if err != nil {
 			closeReason = err.Error()
 			return
 		}
-		defer conn.Close()
+		transport := newWSTransport(conn, time.Duration(server.options.CloseGracePeriod)*time.Millisecond)
+		transport.pongWait = time.Duration(server.options.WSPongWait) * time.Second
+		transport.pingPayload = server.options.WSPingPayload
+		defer transport.Close()
 
-		clientIP := clientIPFromRequest(r)
+		if server.options.WSPingInterval > 0 {
+			probeCtx, stopProbe := context.WithCancel(ctx)
+			defer stopProbe()
+			go transport.probeRTT(probeCtx, time.Duration(server.options.WSPingInterval)*time.Second)
+		}
+
+		server.metrics.connectionOpened("websocket")
+		start := time.Now()
+		clientIP := server.clientIP(r)
+		connCtx := withSessionAdmission(withRequestID(server.connectionContext(ctx, r), reqID), admission)
 		if server.options.PassHeaders {
-			err = server.processWSConn(ctx, conn, r.Header, clientIP)
+			err = server.processWSConn(connCtx, transport, r.Header, clientIP)
 		} else {
-			err = server.processWSConn(ctx, conn, nil, clientIP)
+			err = server.processWSConn(connCtx, transport, nil, clientIP)
 		}
 
-		switch err {
-		case ctx.Err():
-			closeReason = "cancelation"
-		case webtty.ErrSlaveClosed:
-			closeReason = server.factory.Name()
-		case webtty.ErrMasterClosed:
-			closeReason = "client"
-		default:
-			closeReason = fmt.Sprintf("an error: %s", err)
-		}
+		server.metrics.connectionClosed("websocket", time.Since(start), sessionFailed(ctx, err))
+		closeReason = server.closeReason(ctx, err)
 	}
 }
 
@@ -99,6 +138,31 @@ func (server *Server) generateHandleWT(ctx context.Context, cancel context.Cance
 	once := new(int64)
 
 	return func(w http.ResponseWriter, r *http.Request) {
+		if server.isDraining() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if retryAfter := server.overloadRemaining(); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Backend is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if server.shedLoad(counter.count()) {
+			w.Header().Set("Retry-After", strconv.Itoa(server.options.ShedRetryAfter))
+			http.Error(w, "Server is under high load", http.StatusServiceUnavailable)
+			return
+		}
+		if retryAfter, ok := server.tmuxSessions.admit(); !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, "Too many new tmux sessions", http.StatusTooManyRequests)
+			} else {
+				http.Error(w, "Too many tmux sessions", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer server.tmuxSessions.release()
+
 		if server.options.Once {
 			success := atomic.CompareAndSwapInt64(once, 0, 1)
 			if !success {
@@ -106,30 +170,38 @@ func (server *Server) generateHandleWT(ctx context.Context, cancel context.Cance
 				return
 			}
 		}
+		if server.sessionsExhausted() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		admission := &sessionAdmission{}
 
 		num := counter.add(1)
 		closeReason := "unknown reason"
+		reqID := requestID(r)
 
 		defer func() {
 			num := counter.done()
 			log.Printf(
-				"WebTransport connection closed by %s: %s, connections: %d/%d",
-				closeReason, r.RemoteAddr, num, server.options.MaxConnection,
+				"WebTransport connection closed by %s: %s, connections: %d/%d, request: %s",
+				closeReason, r.RemoteAddr, num, server.options.MaxConnection, reqID,
 			)
 
-			if server.options.Once {
+			lastSession := server.finishSession(admission)
+			if server.options.Once || lastSession {
 				cancel()
 			}
 		}()
 
-		if int64(server.options.MaxConnection) != 0 {
-			if num > server.options.MaxConnection {
-				closeReason = "exceeding max number of connections"
-				return
+		if err := server.checkCapacity(num); err != nil {
+			closeReason = err.Error()
+			if err == errServerFull {
+				server.writeFull(w, r)
 			}
+			return
 		}
 
-		log.Printf("New WebTransport client connected: %s, connections: %d/%d", r.RemoteAddr, num, server.options.MaxConnection)
+		log.Printf("New WebTransport client connected: %s, connections: %d/%d, request: %s", r.RemoteAddr, num, server.options.MaxConnection, reqID)
 
 		// Upgrade to WebTransport session
 		session, err := server.wtServer.Upgrade(w, r)
@@ -146,7 +218,26 @@ func (server *Server) generateHandleWT(ctx context.Context, cancel context.Cance
 			return
 		}
 
+		if server.options.WTMaxStreams > 0 {
+			streamsCtx, stopStreams := context.WithCancel(ctx)
+			defer stopStreams()
+			go limitWTStreams(streamsCtx, func(ctx context.Context) (incomingStream, error) {
+				return session.AcceptStream(ctx)
+			}, server.options.WTMaxStreams, reqID)
+		}
+
 		transport := newWTTransport(session, stream)
+		transport.maxStreamBytes = server.options.WTStreamMaxBytes
+		transport.checksum = server.options.WTChecksum
+		transport.wideFrames = r.URL.Query().Get(wtWideFramesQuery) == "wide"
+		if server.options.WTDatagrams {
+			transport.enableDatagrams(session)
+		}
+		if qconn, ok := quicConnFromRequest(r); ok {
+			transport.smoothedRTT = func() time.Duration {
+				return qconn.ConnectionStats().SmoothedRTT
+			}
+		}
 		defer transport.Close()
 
 		var headers map[string][]string
@@ -154,95 +245,125 @@ func (server *Server) generateHandleWT(ctx context.Context, cancel context.Cance
 			headers = r.Header
 		}
 
-		clientIP := clientIPFromRequest(r)
-		err = server.processTransportConn(ctx, transport, headers, clientIP)
+		server.metrics.connectionOpened("webtransport")
+		start := time.Now()
+		clientIP := server.clientIP(r)
+		connCtx := withSessionAdmission(withRequestID(server.connectionContext(ctx, r), reqID), admission)
+		err = server.processTransportConn(connCtx, transport, headers, clientIP)
 
-		switch err {
-		case ctx.Err():
-			closeReason = "cancelation"
-		case webtty.ErrSlaveClosed:
-			closeReason = server.factory.Name()
-		case webtty.ErrMasterClosed:
-			closeReason = "client"
-		default:
-			closeReason = fmt.Sprintf("an error: %s", err)
-		}
+		server.metrics.connectionClosed("webtransport", time.Since(start), sessionFailed(ctx, err))
+		closeReason = server.closeReason(ctx, err)
 	}
 }
 
-func (server *Server) processWSConn(ctx context.Context, conn *websocket.Conn, headers map[string][]string, clientIP string) error {
-	typ, initLine, err := conn.ReadMessage()
-	if err != nil {
-		return errors.Wrapf(err, "failed to authenticate websocket connection")
-	}
-	if typ != websocket.TextMessage {
-		return errors.New("failed to authenticate websocket connection: invalid message type")
+// closeReason describes why a connection was torn down. Reads that ended on
+// either side are told apart from writes to the client that failed.
+func (server *Server) closeReason(ctx context.Context, err error) string {
+	var writeErr *webtty.MasterWriteError
+	switch {
+	case err == ctx.Err():
+		return "cancelation"
+	case err == webtty.ErrSlaveClosed:
+		return server.factory.Name() + " (read error)"
+	case err == webtty.ErrMasterClosed:
+		return "client (read error)"
+	case err == errIdleTimeout:
+		return "idle timeout"
+	case errors.As(err, &writeErr):
+		return fmt.Sprintf("client (write error, %T: %s)", writeErr.Err, writeErr.Err)
+	default:
+		return fmt.Sprintf("an error: %s", err)
 	}
+}
 
-	var init InitMessage
-	err = json.Unmarshal(initLine, &init)
-	if err != nil {
-		return errors.Wrapf(err, "failed to authenticate websocket connection")
+// connectionContext returns the context of a connection opened by r.
+func (server *Server) connectionContext(ctx context.Context, r *http.Request) context.Context {
+	if server.pathRequiresAuth(r.URL.Path) {
+		ctx = context.WithValue(ctx, authRequiredKey{}, true)
 	}
-	if !server.validateAuthToken(init.AuthToken, clientIP) {
-		return errors.New("failed to authenticate websocket connection")
+	if token := bearerToken(r); server.jwt != nil && token != "" {
+		ctx = context.WithValue(ctx, bearerTokenKey{}, token)
 	}
-
-	queryPath := "?"
-	if server.options.PermitArguments && init.Arguments != "" {
-		queryPath = init.Arguments
+	if server.connContext == nil {
+		return ctx
 	}
+	return server.connContext(ctx, r)
+}
 
-	query, err := url.Parse(queryPath)
-	if err != nil {
-		return errors.Wrapf(err, "failed to parse arguments")
+// newSlave creates a backend, passing ctx to factories that accept one.
+func (server *Server) newSlave(ctx context.Context, params map[string][]string, headers map[string][]string) (Slave, error) {
+	if factory, ok := server.factory.(ContextFactory); ok {
+		return factory.NewWithContext(ctx, params, headers)
 	}
-	params := query.Query()
-	var slave Slave
-	slave, err = server.factory.New(params, headers)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create backend")
+	return server.factory.New(params, headers)
+}
+
+// acceptsSubprotocol reports whether the client offers one of the
+// subprotocols the upgrader accepts.
+func (server *Server) acceptsSubprotocol(r *http.Request) bool {
+	for _, offered := range websocket.Subprotocols(r) {
+		for _, accepted := range server.upgrader.Subprotocols {
+			if offered == accepted {
+				return true
+			}
+		}
 	}
-	defer slave.Close()
+	return false
+}
 
-	titleVars := server.titleVariables(
-		[]string{"server", "master", "slave"},
-		map[string]map[string]interface{}{
-			"server": server.options.TitleVariables,
-			"master": map[string]interface{}{
-				"remote_addr": conn.RemoteAddr(),
-			},
-			"slave": slave.WindowTitleVariables(),
-		},
-	)
+// checkCapacity reports whether a new connection, making num connections
+// in total, may be served. A MaxConnection of zero means unlimited.
+func (server *Server) checkCapacity(num int) error {
+	if server.options.BlockConnections {
+		return errors.New("connections are blocked")
+	}
+	if server.options.MaxConnection > 0 && num > server.options.MaxConnection {
+		return errServerFull
+	}
+	return nil
+}
 
-	titleBuf := new(bytes.Buffer)
-	err = server.titleTemplate.Execute(titleBuf, titleVars)
+func (server *Server) processWSConn(ctx context.Context, transport *wsTransport, headers map[string][]string, clientIP string) error {
+	typ, initLine, err := transport.Conn.ReadMessage()
 	if err != nil {
-		return errors.Wrapf(err, "failed to fill window title template")
+		return errors.Wrapf(err, "failed to authenticate websocket connection")
+	}
+	if typ != websocket.TextMessage {
+		return errors.New("failed to authenticate websocket connection: invalid message type")
 	}
 
-	opts := server.buildTTYOptions(titleBuf.Bytes())
-	tty, err := webtty.New(&wsTransport{conn}, slave, opts...)
+	var init InitMessage
+	err = json.Unmarshal(initLine, &init)
 	if err != nil {
-		return errors.Wrapf(err, "failed to create webtty")
+		return errors.Wrapf(err, "failed to authenticate websocket connection")
 	}
+	// Read-only tokens stay read-only even when their session asks otherwise
+	if server.authTokens != nil && server.authTokens.readOnly(init.AuthToken) {
+		init.ReadOnly = true
+	}
+	user, claims, ok := server.validateAuthToken(ctx, init.AuthToken, clientIP)
+	if !ok {
+		return errors.New("failed to authenticate websocket connection")
+	}
+	ctx = withAuthClaims(withAuthUser(ctx, user), claims)
 
-	return server.runTTYWithTmux(ctx, tty)
+	return server.serveTerminal(ctx, transport, &init, headers, clientIP)
 }
 
 // processTransportConn handles a connection using the Transport interface.
 // This is transport-agnostic and works with both WebSocket and WebTransport.
 func (server *Server) processTransportConn(ctx context.Context, transport Transport, headers map[string][]string, clientIP string) error {
 	// Read init message
-	initBuf := make([]byte, 4096)
-	n, err := transport.Read(initBuf)
+	initBuf := getBuffer(4096)
+	n, err := transport.Read(*initBuf)
 	if err != nil {
+		putBuffer(initBuf)
 		return errors.Wrapf(err, "failed to read init message")
 	}
 
 	var init InitMessage
-	err = json.Unmarshal(initBuf[:n], &init)
+	err = json.Unmarshal((*initBuf)[:n], &init)
+	putBuffer(initBuf)
 	if err != nil {
 		return errors.Wrapf(err, "failed to parse init message")
 	}
@@ -250,9 +371,49 @@ func (server *Server) processTransportConn(ctx context.Context, transport Transp
 	if authIP == "" {
 		authIP = ipFromAddr(transport.RemoteAddr())
 	}
-	if !server.validateAuthToken(init.AuthToken, authIP) {
+	// Read-only tokens stay read-only even when their session asks otherwise
+	if server.authTokens != nil && server.authTokens.readOnly(init.AuthToken) {
+		init.ReadOnly = true
+	}
+	user, claims, ok := server.validateAuthToken(ctx, init.AuthToken, authIP)
+	if !ok {
 		return errors.New("authentication failed")
 	}
+	ctx = withAuthClaims(withAuthUser(ctx, user), claims)
+
+	return server.serveTerminal(ctx, transport, &init, headers, authIP)
+}
+
+// serveTerminal creates a backend for an authenticated connection from
+// clientIP and bridges it with transport until either side closes.
+func (server *Server) serveTerminal(ctx context.Context, transport Transport, init *InitMessage, headers map[string][]string, clientIP string) error {
+	if !server.admitSession(ctx) {
+		return errSessionsExhausted
+	}
+	reqID := requestIDFromContext(ctx)
+	conn := server.connections.add(transport, reqID)
+	conn.transport.metrics = server.metrics.transport(transportName(transport))
+	defer func() {
+		event := server.connections.remove(conn)
+		server.metrics.connectionRemoved(event)
+		data, _ := json.Marshal(event)
+		log.Printf("Session ended: %s", data)
+	}()
+	transport = conn.transport
+	if server.options.WriteQueueDepth > 0 {
+		timeout := time.Duration(server.options.WriteQueueTimeout) * time.Millisecond
+		queued := newQueuedTransport(transport, server.options.WriteQueueDepth, server.options.WriteQueuePolicy, timeout)
+		defer queued.stop()
+		conn.queue.Store(queued)
+		transport = queued
+	}
+	filter := &initFilterTransport{Transport: transport, ignore: server.options.DuplicateInit == "ignore"}
+	transport = filter
+	var idle *idleTransport
+	if server.options.IdleTimeout > 0 {
+		idle = newIdleTransport(transport, server.options.IdleCountsOutput)
+		transport = idle
+	}
 
 	queryPath := "?"
 	if server.options.PermitArguments && init.Arguments != "" {
@@ -264,37 +425,385 @@ func (server *Server) processTransportConn(ctx context.Context, transport Transp
 		return errors.Wrapf(err, "failed to parse arguments")
 	}
 	params := query.Query()
-	var slave Slave
-	slave, err = server.factory.New(params, headers)
+	if server.options.PermitArguments && init.Arguments != "" {
+		log.Printf("Arguments from %s: %q, request: %s", transport.RemoteAddr(), init.Arguments, reqID)
+		if err := server.checkArguments(params); err != nil {
+			server.reportDeniedArgument(transport, err)
+			return err
+		}
+	}
+	// params hold the readonly argument only with PermitArguments
+	readOnly := init.ReadOnly || params.Has("readonly")
+	if readOnly && server.options.PermitWrite {
+		log.Printf("Session %d is read-only, request: %s", conn.ID, reqID)
+	}
+
+	// Only the client that started a session may resume it
+	owner := sessionOwner{user: authUserFromContext(ctx), ip: clientIP}
+	var replay []byte
+	if server.options.ReplayBufferSize > 0 {
+		output, exited := server.replays.takeSession(init.ResumeToken, owner)
+		if exited {
+			log.Printf("Replaying the output of exited session %d, request: %s", conn.ID, reqID)
+			return server.replayExited(ctx, transport, output)
+		}
+		replay = output
+	}
+
+	sessionCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	slave, resumable, err := server.openSlave(sessionCtx, init.ResumeToken, owner, params, withRequestIDHeader(headers, reqID))
 	if err != nil {
+		var overloaded *OverloadedError
+		if errors.As(err, &overloaded) {
+			server.startOverloadCooldown(overloaded.RetryAfter)
+		}
+		var noPTY *NoPTYError
+		if errors.As(err, &noPTY) {
+			server.reportNoPTY(transport, noPTY)
+		}
 		return errors.Wrapf(err, "failed to create backend")
 	}
-	defer slave.Close()
+	backend := slave
+	if resumable != nil {
+		backend = resumable
+	}
+	parked := false
+	defer func() {
+		if !parked {
+			closeSlave(backend, reqID)
+		}
+	}()
+	// A resumable backend is read from its creation already
+	var startup *startupSlave
+	if resumable == nil && server.options.StartupBufferSize > 0 {
+		startup = newStartupSlave(slave, server.options.StartupBufferSize)
+		defer startup.stop()
+	}
+	if reporter, ok := slave.(ForegroundReporter); ok {
+		conn.foreground.Store(reporter)
+	}
+	if reporter, ok := slave.(ResourceReporter); ok {
+		go conn.sampleResources(sessionCtx, reporter, resourceSampleInterval)
+	}
+
+	authUser := authUserFromContext(ctx)
+	authClaims := authClaimsFromContext(ctx)
+	title, err := server.windowTitle(transport.RemoteAddr(), authUser, authClaims, slave)
+	if err != nil {
+		return err
+	}
+
+	ttySlave := slave
+	if startup != nil {
+		ttySlave = startup
+	}
+	if resumable != nil {
+		attachment := resumable.attach()
+		defer attachment.detach()
+		ttySlave = attachment
+	}
+	if server.options.OutputTransform != nil {
+		ttySlave = newTransformSlave(ttySlave, server.options.OutputTransform)
+	}
+	if server.options.InputLineEnding != "" {
+		ttySlave = &lineEndingSlave{Slave: ttySlave, eol: lineEndings[server.options.InputLineEnding]}
+	}
+	sinks := &teeWriter{}
+	if server.logPathTemplate != nil {
+		path, err := renderSessionLogPath(server.logPathTemplate, server.sessionLogVariables(conn))
+		if err != nil {
+			return err
+		}
+		logFile, err := openSessionLog(path)
+		if err != nil {
+			return err
+		}
+		defer logFile.Close()
+		log.Printf("Recording session %d to %s, request: %s", conn.ID, path, reqID)
+		sinks.add("session log "+path, logFile)
+	}
+
+	if server.options.RecordDir != "" {
+		columns, rows := server.options.Width, server.options.Height
+		if columns <= 0 || rows <= 0 {
+			columns, rows = 80, 24
+		}
+		path := castPath(homedir.Expand(server.options.RecordDir), conn)
+		rec, err := newCastRecorder(path, columns, rows, int64(server.options.RecordMaxBytes))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := rec.Close(); err != nil {
+				log.Printf("Failed to close recording: %v", err)
+			}
+		}()
+		log.Printf("Recording session %d to %s, request: %s", conn.ID, path, reqID)
+		ttySlave = &castSlave{Slave: ttySlave, rec: rec, recordInput: server.options.PermitWrite && !readOnly}
+	}
+
+	exited := false
+	if server.options.ReplayBufferSize > 0 {
+		// Output of a backend of its own is only replayed to the client
+		// holding its resume token
+		shared := server.sharedSession()
+		if replay == nil && shared != "" {
+			replay = server.replays.load(shared)
+		}
+		recent := newRingBuffer(server.options.ReplayBufferSize)
+		defer func() {
+			output := recent.Bytes()
+			if shared != "" {
+				server.replays.save(shared, output)
+			}
+			server.replays.saveSession(init.ResumeToken, owner, output, exited)
+		}()
+		sinks.add("replay buffer", recent)
+	}
+
+	if server.options.OutputSinks != nil {
+		for i, sink := range server.options.OutputSinks(reqID) {
+			if closer, ok := sink.(io.Closer); ok {
+				defer closer.Close()
+			}
+			sinks.add(fmt.Sprintf("#%d", i), sink)
+		}
+	}
+	if server.options.EnableStream {
+		// Stream viewers attach to the output as sinks
+		conn.output.Store(sinks)
+	}
+	if !sinks.empty() || server.options.EnableStream {
+		ttySlave = &loggingSlave{Slave: ttySlave, log: sinks}
+	}
+
+	watched := &firstReadSlave{Slave: ttySlave}
+	ttySlave = watched
+
+	opts := server.buildTTYOptions(title, readOnly)
+	if server.options.ExposeClientIP && clientIP != "" {
+		opts = append(opts, webtty.WithClientIP(clientIP))
+	}
+	if len(replay) > 0 {
+		opts = append(opts, webtty.WithReplay(replay))
+	}
+	tty, err := webtty.New(transport, ttySlave, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create webtty")
+	}
+
+	if server.options.TitleInterval > 0 {
+		remoteAddr := transport.RemoteAddr()
+		go server.refreshTitle(sessionCtx, tty, func() ([]byte, error) {
+			return server.windowTitle(remoteAddr, authUser, authClaims, slave)
+		})
+	}
+
+	if idle != nil {
+		go idle.watch(sessionCtx, time.Duration(server.options.IdleTimeout)*time.Second, reqID)
+	}
+
+	start := time.Now()
+	err = server.runTTYWithTmux(ctx, tty)
+	if filter.rejected.Load() {
+		return errDuplicateInit
+	}
+	if idle != nil && idle.timedOut.Load() {
+		// An idle session is abandoned rather than waiting to be resumed
+		return errIdleTimeout
+	}
+	if err == webtty.ErrSlaveClosed {
+		exited = true
+		server.reportImmediateExit(tty, slave, time.Since(start))
+		server.reportFailedStart(tty, slave, watched.firstError())
+	}
+	if resumable != nil && clientWentAway(err) && ctx.Err() == nil && !resumable.exited() {
+		log.Printf("Parking session %d for %ds to be resumed, request: %s", conn.ID, server.options.ResumeTimeout, reqID)
+		server.resumes.park(init.ResumeToken, owner, resumable)
+		parked = true
+	}
+	return err
+}
+
+// replayExited sends the output of a session whose backend exited while
+// its client was away, then closes the connection.
+func (server *Server) replayExited(ctx context.Context, transport Transport, output []byte) error {
+	slave := exitedSlave{}
+	title, err := server.windowTitle(transport.RemoteAddr(), authUserFromContext(ctx), authClaimsFromContext(ctx), slave)
+	if err != nil {
+		return err
+	}
+	opts := append(server.buildTTYOptions(title, true), webtty.WithReplay(output))
+	tty, err := webtty.New(transport, slave, opts...)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create webtty")
+	}
+	return tty.Run(ctx)
+}
+
+// exitedSlave stands for a backend that already exited.
+type exitedSlave struct{}
+
+func (exitedSlave) Read(p []byte) (int, error) {
+	return 0, io.EOF
+}
+
+func (exitedSlave) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (exitedSlave) ResizeTerminal(columns int, rows int) error {
+	return nil
+}
+
+func (exitedSlave) WindowTitleVariables() map[string]interface{} {
+	return map[string]interface{}{}
+}
+
+func (exitedSlave) Close() error {
+	return nil
+}
+
+// clientWentAway reports whether err means the connection to the client
+// was lost, rather than the backend or the server stopping the session.
+func clientWentAway(err error) bool {
+	var writeErr *webtty.MasterWriteError
+	return err == webtty.ErrMasterClosed || errors.As(err, &writeErr)
+}
 
+// firstReadSlave records whether the very first read from a slave failed,
+// which means the backend died during setup without any output.
+type firstReadSlave struct {
+	Slave
+
+	mu       sync.Mutex
+	read     bool
+	firstErr error
+}
+
+func (fs *firstReadSlave) Read(p []byte) (int, error) {
+	n, err := fs.Slave.Read(p)
+	fs.mu.Lock()
+	if !fs.read {
+		fs.read = true
+		if n == 0 && err != nil {
+			fs.firstErr = err
+		}
+	}
+	fs.mu.Unlock()
+	return n, err
+}
+
+func (fs *firstReadSlave) firstError() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	return fs.firstErr
+}
+
+// windowTitle renders the title template for a session of authUser, with
+// the claims of their JWT if any, with slave.
+func (server *Server) windowTitle(remoteAddr string, authUser string, claims jwtClaims, slave Slave) ([]byte, error) {
 	titleVars := server.titleVariables(
 		[]string{"server", "master", "slave"},
 		map[string]map[string]interface{}{
 			"server": server.options.TitleVariables,
 			"master": map[string]interface{}{
-				"remote_addr": transport.RemoteAddr(),
+				"remote_addr": remoteAddr,
+				"auth_user":   authUser,
+				"auth_claims": claims,
 			},
-			"slave": slave.WindowTitleVariables(),
+			"slave": server.slaveTitleVariables(slave),
 		},
 	)
 
 	titleBuf := new(bytes.Buffer)
-	err = server.titleTemplate.Execute(titleBuf, titleVars)
+	err := server.titleTemplate.Execute(titleBuf, titleVars)
 	if err != nil {
-		return errors.Wrapf(err, "failed to fill window title template")
+		return nil, errors.Wrapf(err, "failed to fill window title template")
 	}
+	return titleBuf.Bytes(), nil
+}
 
-	opts := server.buildTTYOptions(titleBuf.Bytes())
-	tty, err := webtty.New(transport, slave, opts...)
-	if err != nil {
-		return errors.Wrapf(err, "failed to create webtty")
+// titleRefreshPoll is how often window titles are re-rendered when
+// title refresh is enabled.
+var titleRefreshPoll = 500 * time.Millisecond
+
+// refreshTitle polls render for title changes until ctx is done.
+// WebTTY drops unchanged titles and limits how often new ones are sent.
+func (server *Server) refreshTitle(ctx context.Context, tty *webtty.WebTTY, render func() ([]byte, error)) {
+	ticker := time.NewTicker(titleRefreshPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			title, err := render()
+			if err != nil {
+				log.Printf("Failed to refresh window title: %v", err)
+				continue
+			}
+			if _, err := tty.UpdateWindowTitle(title); err != nil {
+				log.Printf("Failed to send window title: %v", err)
+				return
+			}
+		}
 	}
+}
 
-	return server.runTTYWithTmux(ctx, tty)
+// reportFailedStart tells the client that its backend failed before
+// producing any output, unless the slave reported a process exit which
+// reportImmediateExit already covers.
+func (server *Server) reportFailedStart(tty *webtty.WebTTY, slave Slave, readErr error) {
+	if readErr == nil {
+		return
+	}
+	if coder, ok := slave.(ExitCoder); ok {
+		if _, exited := coder.ExitCode(); exited {
+			return
+		}
+	}
+
+	message := fmt.Sprintf("Backend `%s` failed before producing any output: %v", server.factory.Name(), readErr)
+	log.Print(message)
+	tty.SendOutput([]byte("\r\n" + message + "\r\n"))
+}
+
+// noPTYMessage is shown to clients whose backend could not get a pty.
+const noPTYMessage = "No pseudo-terminal is available on the server, so the session cannot start. " +
+	"Try again later; if this persists, ask the administrator to raise the pty limit (e.g. kernel.pty.max)."
+
+// reportNoPTY tells the client that its session failed for lack of a pty
+// rather than just closing the connection.
+func (server *Server) reportNoPTY(transport Transport, err *NoPTYError) {
+	log.Printf("Backend `%s` could not start: %v", server.factory.Name(), err)
+	message := base64.StdEncoding.EncodeToString([]byte("\r\n" + noPTYMessage + "\r\n"))
+	transport.Write(append([]byte{webtty.Output}, message...))
+}
+
+// reportImmediateExit tells the client why its terminal is about to close
+// when the backend failed right after it was started, e.g. on a typo'd command.
+func (server *Server) reportImmediateExit(tty *webtty.WebTTY, slave Slave, elapsed time.Duration) {
+	window := time.Duration(server.options.ImmediateExitWindow) * time.Second
+	if window <= 0 || elapsed > window {
+		return
+	}
+
+	command, _ := server.factory.Command()
+	message := fmt.Sprintf("Command `%s` exited immediately", command)
+	if coder, ok := slave.(ExitCoder); ok {
+		if code, exited := coder.ExitCode(); exited {
+			if code == 0 {
+				return
+			}
+			message += fmt.Sprintf(" with exit code %d", code)
+		}
+	}
+
+	log.Print(message)
+	tty.SendOutput([]byte("\r\n" + message + "\r\n"))
 }
 
 // handleTmuxEvents polls for tmux layout changes and sends updates to the client
@@ -341,8 +850,12 @@ func (server *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	server.templateMu.RLock()
+	indexTemplate := server.indexTemplate
+	server.templateMu.RUnlock()
+
 	indexBuf := new(bytes.Buffer)
-	err = server.indexTemplate.Execute(indexBuf, indexVars)
+	err = indexTemplate.Execute(indexBuf, indexVars)
 	if err != nil {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
@@ -374,7 +887,9 @@ func (server *Server) indexVariables(r *http.Request) (map[string]interface{}, e
 		map[string]map[string]interface{}{
 			"server": server.options.TitleVariables,
 			"master": map[string]interface{}{
-				"remote_addr": r.RemoteAddr,
+				"remote_addr": server.remoteAddr(r),
+				"auth_user":   authUserFromContext(r.Context()),
+				"auth_claims": authClaimsFromContext(r.Context()),
 			},
 		},
 	)
@@ -391,31 +906,99 @@ func (server *Server) indexVariables(r *http.Request) (map[string]interface{}, e
 	return indexVars, err
 }
 
+// csrfHeader must be set on auth token requests when AuthTokenCSRF is enabled.
+// Browsers only send custom headers cross-origin after a CORS preflight,
+// which this server never approves.
+const csrfHeader = "X-Requested-With"
+
 func (server *Server) handleAuthToken(w http.ResponseWriter, r *http.Request) {
+	if !server.checkSecure(w, r) {
+		return
+	}
+	if server.options.AuthTokenCSRF && !isSameSiteRequest(r) {
+		log.Printf("Rejected cross-site auth token request from %s", r.RemoteAddr)
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	authToken, err := server.issueAuthToken(r)
+	if err != nil {
+		log.Printf("Failed to issue auth token: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
 	w.Header().Set("Content-Type", "application/javascript")
 	w.Header().Set("Cache-Control", "no-store")
 	w.Header().Set("Pragma", "no-cache")
-	authToken := server.issueAuthToken(r)
 	w.Write([]byte("var gotty_auth_token = " + strconv.Quote(authToken) + ";"))
 }
 
+// isSameSiteRequest reports whether r carries the CSRF header and was not
+// marked as cross-site by the browser.
+func isSameSiteRequest(r *http.Request) bool {
+	if r.Header.Get("Sec-Fetch-Site") == "cross-site" {
+		return false
+	}
+	return r.Header.Get(csrfHeader) != ""
+}
+
+// isSecureRequest reports whether r arrived over TLS, directly or through
+// one of the trusted proxies, which alone may set X-Forwarded-Proto.
+func (server *Server) isSecureRequest(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return containsIP(server.trustedProxies, ipFromAddr(r.RemoteAddr)) &&
+		strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// checkSecure rejects plaintext requests with 400 when secure endpoints are
+// required, and reports whether the request may proceed.
+func (server *Server) checkSecure(w http.ResponseWriter, r *http.Request) bool {
+	if !server.options.SecureEndpoints || server.isSecureRequest(r) {
+		return true
+	}
+	log.Printf("Rejected plaintext request for %s from %s", r.URL.Path, r.RemoteAddr)
+	http.Error(w, "Bad Request: TLS required", http.StatusBadRequest)
+	return false
+}
+
 func (server *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if !server.checkSecure(w, r) {
+		return
+	}
 	w.Header().Set("Content-Type", "application/javascript")
+	outputDictionary := ""
+	if server.options.CompressOutput {
+		outputDictionary = base64.StdEncoding.EncodeToString(webtty.OutputDictionary)
+	}
 	lines := []string{
 		"var gotty_term = 'xterm';",
 		"var gotty_ws_query_args = '" + server.options.WSQueryArgs + "';",
 		fmt.Sprintf("var gotty_webtransport_enabled = %t;", server.options.EnableWebTransport),
+		fmt.Sprintf("var gotty_wt_checksum = %t;", server.options.WTChecksum),
+		"var gotty_wt_wide_frames = true;",
+		fmt.Sprintf("var gotty_wt_datagrams = %t;", server.options.WTDatagrams),
+		fmt.Sprintf("var gotty_output_dictionary = \"%s\";", outputDictionary),
+		fmt.Sprintf("var gotty_auth_token_csrf = %t;", server.options.AuthTokenCSRF),
+		fmt.Sprintf("var gotty_reauth_on_reconnect = %t;", server.options.ReauthOnReconnect),
 		// WebTransport uses the same port as HTTP (UDP instead of TCP)
 	}
+	if server.options.ExposeTmuxSession && server.tmuxSession != "" {
+		lines = append(lines, "var gotty_tmux_session = "+strconv.Quote(server.tmuxSession)+";")
+	}
 
 	w.Write([]byte(strings.Join(lines, "\n")))
 }
 
-func (server *Server) buildTTYOptions(titleBytes []byte) []webtty.Option {
+// buildTTYOptions returns the WebTTY options of a session, which discards
+// the input of its client when readOnly.
+func (server *Server) buildTTYOptions(titleBytes []byte, readOnly bool) []webtty.Option {
 	opts := []webtty.Option{
 		webtty.WithWindowTitle(titleBytes),
 	}
-	if server.options.PermitWrite {
+	if server.options.PermitWrite && !readOnly {
 		opts = append(opts, webtty.WithPermitWrite())
 	}
 	if server.options.EnableReconnect {
@@ -427,6 +1010,28 @@ func (server *Server) buildTTYOptions(titleBytes []byte) []webtty.Option {
 	if server.options.Height > 0 {
 		opts = append(opts, webtty.WithFixedRows(server.options.Height))
 	}
+	if len(server.resizePresets) > 0 {
+		opts = append(opts, webtty.WithResizePresets(server.resizePresets...))
+	}
+	if server.options.MaxResizeArea > 0 {
+		opts = append(opts, webtty.WithMaxResizeArea(server.options.MaxResizeArea))
+	}
+	if server.options.TrimPartialOutput {
+		opts = append(opts, webtty.WithTrimPartialSequences())
+	}
+	if server.options.ClearOnConnect {
+		opts = append(opts, webtty.WithClearScreen())
+	}
+	if server.options.CompressOutput {
+		opts = append(opts, webtty.WithCompressedOutput())
+	}
+	if server.options.OutputCoalesce > 0 {
+		window := time.Duration(server.options.OutputCoalesce) * time.Millisecond
+		opts = append(opts, webtty.WithOutputCoalescing(window, server.flushPatterns...))
+	}
+	if server.options.TitleInterval > 0 {
+		opts = append(opts, webtty.WithTitleInterval(time.Duration(server.options.TitleInterval)*time.Second))
+	}
 	return opts
 }
 
@@ -438,6 +1043,27 @@ func (server *Server) runTTYWithTmux(ctx context.Context, tty *webtty.WebTTY) er
 	return tty.Run(ctx)
 }
 
+// slaveTitleVariables returns the window title variables of slave.
+// The resolved command and its arguments are always available as
+// `command` and `args`; they fall back to the factory's command when
+// the slave does not report them itself.
+func (server *Server) slaveTitleVariables(slave Slave) map[string]interface{} {
+	vars := map[string]interface{}{}
+	for key, val := range slave.WindowTitleVariables() {
+		vars[key] = val
+	}
+
+	command, argv := server.factory.Command()
+	if _, ok := vars["command"]; !ok {
+		vars["command"] = command
+	}
+	if _, ok := vars["args"]; !ok {
+		vars["args"] = argv
+	}
+
+	return vars
+}
+
 // titleVariables merges maps in a specified order.
 // varUnits are name-keyed maps, whose names will be iterated using order.
 func (server *Server) titleVariables(order []string, varUnits map[string]map[string]interface{}) map[string]interface{} {
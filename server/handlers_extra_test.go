@@ -154,7 +154,7 @@ func TestIndexVariablesWithRemoteAddr(t *testing.T) {
 		t.Fatalf("indexVariables() error: %v", err)
 	}
 
-	// The remote_addr should use X-Forwarded-For when present
+	// The remote_addr ignores X-Forwarded-For without trusted proxies
 	title, ok := vars["title"].(string)
 	if !ok {
 		t.Fatal("title should be a string")
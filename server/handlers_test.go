@@ -1,7 +1,10 @@
 package server
 
 import (
+	"context"
+	"encoding/base64"
 	"html/template"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"regexp"
@@ -9,6 +12,10 @@ import (
 	"testing"
 	texttemplate "text/template"
 	"time"
+
+	"github.com/pkg/errors"
+
+	"webtmux/webtty"
 )
 
 func TestHandleConfig(t *testing.T) {
@@ -48,6 +55,81 @@ func TestHandleConfig(t *testing.T) {
 	// WebTransport uses same port as HTTP (no separate port config)
 }
 
+func TestHandleAuthTokenCSRF(t *testing.T) {
+	server := &Server{
+		options: &Options{
+			EnableBasicAuth: true,
+			AuthTokenCSRF:   true,
+		},
+		authTokens: newAuthTokenStore(time.Minute),
+	}
+
+	tests := []struct {
+		name    string
+		headers map[string]string
+		want    int
+	}{
+		{"without header", nil, http.StatusForbidden},
+		{"with header", map[string]string{"X-Requested-With": "XMLHttpRequest"}, http.StatusOK},
+		{"cross-site with header", map[string]string{"X-Requested-With": "XMLHttpRequest", "Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"same-origin with header", map[string]string{"X-Requested-With": "XMLHttpRequest", "Sec-Fetch-Site": "same-origin"}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/auth_token.js", nil)
+			for key, val := range tt.headers {
+				req.Header.Set(key, val)
+			}
+			rr := httptest.NewRecorder()
+
+			server.handleAuthToken(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("handleAuthToken() status = %d, want %d", rr.Code, tt.want)
+			}
+			if tt.want != http.StatusOK && strings.Contains(rr.Body.String(), "gotty_auth_token") {
+				t.Error("Rejected response should not contain a token")
+			}
+		})
+	}
+}
+
+func TestHandleConfigTmuxSession(t *testing.T) {
+	tests := []struct {
+		name        string
+		expose      bool
+		tmuxSession string
+		want        string
+	}{
+		{"attached", true, "dev", `var gotty_tmux_session = "dev";`},
+		{"quoted", true, `it's "dev"`, `var gotty_tmux_session = "it's \"dev\"";`},
+		{"not tmux", true, "", ""},
+		{"not exposed", false, "dev", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &Server{
+				options:     &Options{ExposeTmuxSession: tt.expose},
+				tmuxSession: tt.tmuxSession,
+			}
+			rr := httptest.NewRecorder()
+
+			server.handleConfig(rr, httptest.NewRequest("GET", "/config.js", nil))
+
+			body := rr.Body.String()
+			if tt.want == "" {
+				if strings.Contains(body, "gotty_tmux_session") {
+					t.Errorf("Config should not contain the tmux session: %q", body)
+				}
+			} else if !strings.Contains(body, tt.want) {
+				t.Errorf("Config %q should contain %q", body, tt.want)
+			}
+		})
+	}
+}
+
 func TestHandleConfigWebTransportDisabled(t *testing.T) {
 	server := &Server{
 		options: &Options{
@@ -66,6 +148,38 @@ func TestHandleConfigWebTransportDisabled(t *testing.T) {
 	if !strings.Contains(body, "gotty_webtransport_enabled = false") {
 		t.Error("Config should contain webtransport_enabled = false")
 	}
+	if !strings.Contains(body, "gotty_auth_token_csrf = false") {
+		t.Error("Config should contain auth_token_csrf = false")
+	}
+	if !strings.Contains(body, "gotty_reauth_on_reconnect = false") {
+		t.Error("Config should contain reauth_on_reconnect = false")
+	}
+	if !strings.Contains(body, "gotty_wt_checksum = false") {
+		t.Error("Config should contain wt_checksum = false")
+	}
+	if !strings.Contains(body, "gotty_wt_wide_frames = true") {
+		t.Error("Config should contain wt_wide_frames = true")
+	}
+	if !strings.Contains(body, "gotty_wt_datagrams = false") {
+		t.Error("Config should contain wt_datagrams = false")
+	}
+	if !strings.Contains(body, `gotty_output_dictionary = "";`) {
+		t.Error("Config should contain an empty output_dictionary")
+	}
+}
+
+func TestHandleConfigCompressOutput(t *testing.T) {
+	server := &Server{options: &Options{CompressOutput: true}}
+
+	req := httptest.NewRequest("GET", "/config.js", nil)
+	rr := httptest.NewRecorder()
+
+	server.handleConfig(rr, req)
+
+	want := base64.StdEncoding.EncodeToString(webtty.OutputDictionary)
+	if !strings.Contains(rr.Body.String(), `gotty_output_dictionary = "`+want+`";`) {
+		t.Error("Config should contain the output dictionary")
+	}
 }
 
 func TestHandleAuthToken(t *testing.T) {
@@ -103,6 +217,143 @@ func TestHandleAuthToken(t *testing.T) {
 	}
 }
 
+func TestSecureEndpoints(t *testing.T) {
+	proxies, err := parseNetworks("192.0.2.1")
+	if err != nil {
+		t.Fatalf("parseNetworks() error: %v", err)
+	}
+	server := &Server{
+		options: &Options{
+			EnableBasicAuth: true,
+			SecureEndpoints: true,
+		},
+		authTokens:     newAuthTokenStore(time.Minute),
+		trustedProxies: proxies,
+	}
+
+	const proxy, client = "192.0.2.1:1234", "203.0.113.9:1234"
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		path    string
+		remote  string
+		tls     bool
+		proto   string
+		want    int
+	}{
+		{"plaintext auth token", server.handleAuthToken, "/auth_token.js", client, false, "", http.StatusBadRequest},
+		{"plaintext config", server.handleConfig, "/config.js", client, false, "", http.StatusBadRequest},
+		{"forwarded http", server.handleAuthToken, "/auth_token.js", proxy, false, "http", http.StatusBadRequest},
+		{"tls auth token", server.handleAuthToken, "/auth_token.js", client, true, "", http.StatusOK},
+		{"forwarded https", server.handleConfig, "/config.js", proxy, false, "HTTPS", http.StatusOK},
+		{"spoofed https", server.handleAuthToken, "/auth_token.js", client, false, "https", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := "http://example.com" + tt.path
+			if tt.tls {
+				target = "https://example.com" + tt.path
+			}
+			req := httptest.NewRequest("GET", target, nil)
+			req.RemoteAddr = tt.remote
+			if tt.proto != "" {
+				req.Header.Set("X-Forwarded-Proto", tt.proto)
+			}
+			rr := httptest.NewRecorder()
+
+			tt.handler(rr, req)
+
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+			if tt.want != http.StatusOK && strings.Contains(rr.Body.String(), "gotty_") {
+				t.Error("Rejected response should not contain any configuration")
+			}
+		})
+	}
+}
+
+func TestSecureEndpointsDisabled(t *testing.T) {
+	server := &Server{options: &Options{}}
+
+	req := httptest.NewRequest("GET", "/config.js", nil)
+	rr := httptest.NewRecorder()
+
+	server.handleConfig(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want %d without secure-endpoints", rr.Code, http.StatusOK)
+	}
+}
+
+func TestCheckCapacity(t *testing.T) {
+	tests := []struct {
+		name    string
+		options *Options
+		num     int
+		wantErr bool
+	}{
+		{"zero is unlimited", &Options{MaxConnection: 0}, 1000, false},
+		{"within limit", &Options{MaxConnection: 2}, 2, false},
+		{"exceeding limit", &Options{MaxConnection: 2}, 3, true},
+		{"blocked", &Options{BlockConnections: true}, 1, true},
+		{"blocked with limit", &Options{MaxConnection: 10, BlockConnections: true}, 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &Server{options: tt.options}
+			err := server.checkCapacity(tt.num)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("checkCapacity(%d) error = %v, wantErr %v", tt.num, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckCapacityZeroAdmitsMany(t *testing.T) {
+	server := &Server{options: &Options{MaxConnection: 0}}
+	counter := newCounter(0)
+
+	for i := 0; i < 100; i++ {
+		num := counter.add(1)
+		if err := server.checkCapacity(num); err != nil {
+			t.Fatalf("connection %d rejected with MaxConnection 0: %v", num, err)
+		}
+	}
+}
+
+func TestCloseReason(t *testing.T) {
+	server := &Server{factory: newMockFactory(), options: &Options{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"slave closed", webtty.ErrSlaveClosed, "mock (read error)"},
+		{"master closed", webtty.ErrMasterClosed, "client (read error)"},
+		{"master write", errors.Wrap(&webtty.MasterWriteError{Err: io.ErrClosedPipe}, "failed to send"), "client (write error, *errors.errorString: io: read/write on closed pipe)"},
+		{"other", errors.New("boom"), "an error: boom"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := server.closeReason(ctx, tt.err); got != tt.want {
+				t.Errorf("closeReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	cancel()
+	if got := server.closeReason(ctx, ctx.Err()); got != "cancelation" {
+		t.Errorf("closeReason() = %q, want %q", got, "cancelation")
+	}
+}
+
 func TestTitleVariables(t *testing.T) {
 	server := &Server{
 		options: &Options{
@@ -128,6 +379,54 @@ func TestTitleVariables(t *testing.T) {
 	}
 }
 
+func TestSlaveTitleVariables(t *testing.T) {
+	factory := newConnTestFactory()
+	server := &Server{
+		factory: factory,
+		options: &Options{},
+	}
+
+	vars := server.slaveTitleVariables(factory.slave)
+
+	if vars["command"] != "test" {
+		t.Errorf("command = %v, want 'test'", vars["command"])
+	}
+	args, ok := vars["args"].([]string)
+	if !ok {
+		t.Fatalf("args = %#v, want []string", vars["args"])
+	}
+	if len(args) != 0 {
+		t.Errorf("args = %v, want empty", args)
+	}
+}
+
+func TestSlaveTitleVariablesFallback(t *testing.T) {
+	factory := newMockFactory()
+	server := &Server{
+		factory: factory,
+		options: &Options{},
+	}
+
+	vars := server.slaveTitleVariables(&emptyTitleSlave{newMockSlaveForTransport()})
+
+	if vars["command"] != "/bin/bash" {
+		t.Errorf("command = %v, want '/bin/bash'", vars["command"])
+	}
+	args, ok := vars["args"].([]string)
+	if !ok || len(args) != 2 || args[0] != "-c" {
+		t.Errorf("args = %#v, want [-c echo test]", vars["args"])
+	}
+}
+
+// emptyTitleSlave is a slave that reports no window title variables
+type emptyTitleSlave struct {
+	*mockSlaveForTransport
+}
+
+func (s *emptyTitleSlave) WindowTitleVariables() map[string]interface{} {
+	return nil
+}
+
 func TestTitleVariablesOverride(t *testing.T) {
 	server := &Server{
 		options: &Options{},
@@ -352,3 +651,55 @@ func BenchmarkTitleVariables(b *testing.B) {
 		server.titleVariables(order, varUnits)
 	}
 }
+
+func TestRefreshTitle(t *testing.T) {
+	oldPoll := titleRefreshPoll
+	titleRefreshPoll = 5 * time.Millisecond
+	defer func() { titleRefreshPoll = oldPoll }()
+
+	transport := newBlockingTransport()
+	tty, err := webtty.New(transport, newMockSlaveForTransport(),
+		webtty.WithWindowTitle([]byte("a")),
+		webtty.WithTitleInterval(50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("webtty.New() error: %v", err)
+	}
+
+	// The title flaps on every poll
+	renders := 0
+	render := func() ([]byte, error) {
+		renders++
+		if renders%2 == 0 {
+			return []byte("a"), nil
+		}
+		return []byte("b"), nil
+	}
+
+	server := &Server{}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	server.refreshTitle(ctx, tty, render)
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	if renders < 10 {
+		t.Fatalf("title rendered %d times, want at least 10", renders)
+	}
+	last := "a"
+	for _, msg := range transport.messages {
+		if msg[0] != webtty.SetWindowTitle {
+			t.Fatalf("unexpected message type %q", msg[0])
+		}
+		if title := string(msg[1:]); title == last {
+			t.Errorf("unchanged title %q was sent again", title)
+		} else {
+			last = title
+		}
+	}
+	// 200ms at one title per 50ms, plus slack for the first update
+	if len(transport.messages) == 0 || len(transport.messages) > 5 {
+		t.Errorf("sent %d titles, want between 1 and 5", len(transport.messages))
+	}
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// healthStatus is the JSON body served by the health check endpoint.
+type healthStatus struct {
+	Status  string `json:"status"`
+	Backend string `json:"backend,omitempty"`
+}
+
+// backendProbeInterval is how long the result of a backend probe is reused,
+// so that unauthenticated health checks cannot spawn a backend each.
+const backendProbeInterval = 10 * time.Second
+
+// backendProbe caches the result of the last backend probe.
+type backendProbe struct {
+	mu       sync.Mutex
+	probedAt time.Time
+	err      error
+}
+
+// handleHealth reports whether the server is able to serve terminals.
+// When HealthCheckBackend is set, a backend is created and closed right away
+// to verify the command can actually be started, at most once every
+// backendProbeInterval.
+func (server *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := healthStatus{Status: "ok"}
+	code := http.StatusOK
+
+	if server.options.HealthCheckBackend {
+		if err := server.probeBackend(r.Context()); err != nil {
+			log.Printf("Health check failed: %v", err)
+			status.Status = "unhealthy"
+			status.Backend = "error"
+			code = http.StatusServiceUnavailable
+		} else {
+			status.Backend = "ok"
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
+
+// probeBackend returns the result of checkBackend, reusing the last one
+// for backendProbeInterval. Concurrent health checks wait for one probe.
+func (server *Server) probeBackend(ctx context.Context) error {
+	probe := &server.backendProbe
+	probe.mu.Lock()
+	defer probe.mu.Unlock()
+	if !probe.probedAt.IsZero() && time.Since(probe.probedAt) < backendProbeInterval {
+		return probe.err
+	}
+	probe.err = server.checkBackend(ctx)
+	probe.probedAt = time.Now()
+	return probe.err
+}
+
+// checkBackend creates a slave with no parameters and closes it immediately.
+func (server *Server) checkBackend(ctx context.Context) error {
+	slave, err := server.newSlave(ctx, map[string][]string{}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create backend")
+	}
+	if slave == nil {
+		return errors.New("failed to create backend: no slave returned")
+	}
+	return slave.Close()
+}
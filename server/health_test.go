@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHandleHealth(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = errors.New("should not be called")
+	server := &Server{
+		factory: factory,
+		options: &Options{HealthCheckPath: "healthz"},
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	server.handleHealth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handleHealth() status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want %q", ct, "application/json")
+	}
+
+	var status healthStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode health status: %v", err)
+	}
+	if status.Status != "ok" {
+		t.Errorf("status = %q, want %q", status.Status, "ok")
+	}
+	if status.Backend != "" {
+		t.Errorf("backend = %q, want empty without deep check", status.Backend)
+	}
+}
+
+func TestHandleHealthBackend(t *testing.T) {
+	factory := newConnTestFactory()
+	server := &Server{
+		factory: factory,
+		options: &Options{HealthCheckPath: "healthz", HealthCheckBackend: true},
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	server.handleHealth(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("handleHealth() status = %d, want %d", rr.Code, http.StatusOK)
+	}
+
+	var status healthStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode health status: %v", err)
+	}
+	if status.Status != "ok" || status.Backend != "ok" {
+		t.Errorf("status = %+v, want ok/ok", status)
+	}
+	if !factory.slave.closed {
+		t.Error("backend slave should be closed after the health check")
+	}
+}
+
+func TestHandleHealthBackendCached(t *testing.T) {
+	factory := &countingFactory{connTestFactory: newConnTestFactory()}
+	server := &Server{
+		factory: factory,
+		options: &Options{HealthCheckPath: "healthz", HealthCheckBackend: true},
+	}
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		server.handleHealth(rr, httptest.NewRequest("GET", "/healthz", nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handleHealth() status = %d, want %d", rr.Code, http.StatusOK)
+		}
+	}
+	if created := atomic.LoadInt32(&factory.created); created != 1 {
+		t.Errorf("created %d backends for 3 health checks, want 1", created)
+	}
+
+	// The probe runs again once its result is stale
+	server.backendProbe.probedAt = time.Now().Add(-backendProbeInterval)
+	server.handleHealth(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if created := atomic.LoadInt32(&factory.created); created != 2 {
+		t.Errorf("created %d backends after the probe interval, want 2", created)
+	}
+}
+
+func TestHandleHealthBackendFailure(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = errors.New("command not found")
+	server := &Server{
+		factory: factory,
+		options: &Options{HealthCheckPath: "healthz", HealthCheckBackend: true},
+	}
+
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	server.handleHealth(rr, req)
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("handleHealth() status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+
+	var status healthStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatalf("failed to decode health status: %v", err)
+	}
+	if status.Status != "unhealthy" || status.Backend != "error" {
+		t.Errorf("status = %+v, want unhealthy/error", status)
+	}
+}
+
+func TestHealthCheckPathRouting(t *testing.T) {
+	factory := newConnTestFactory()
+	options := &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+		HealthCheckPath: "/healthz",
+	}
+
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/base/", newCounter(0))
+
+	// Health check is served without basic authentication
+	req := httptest.NewRequest("GET", "/base/healthz", nil)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /base/healthz status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
@@ -4,13 +4,17 @@ import (
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rand"
+	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/pem"
 	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
 	"time"
 )
@@ -216,3 +220,275 @@ func BenchmarkSetupHTTPServer(b *testing.B) {
 		server.setupHTTPServer(handler)
 	}
 }
+
+func TestSetupHTTPServerALPN(t *testing.T) {
+	certFile, keyFile := generateServerCert(t)
+
+	tests := []struct {
+		name      string
+		alpn      string
+		wantProto string
+		wantHTTP  string
+	}{
+		{"default prefers h2", "h2,http/1.1", "h2", "HTTP/2.0"},
+		{"http/1.1 preferred", "http/1.1,h2", "http/1.1", "HTTP/1.1"},
+		{"http/1.1 only", "http/1.1", "http/1.1", "HTTP/1.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := New(newMockFactory(), &Options{
+				TitleFormat: "Test",
+				EnableTLS:   true,
+				TLSCrtFile:  certFile,
+				TLSKeyFile:  keyFile,
+				TLSALPN:     tt.alpn,
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			srv, err := server.setupHTTPServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+			if err != nil {
+				t.Fatalf("setupHTTPServer() error: %v", err)
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			go srv.ServeTLS(listener, "", "")
+			defer srv.Close()
+
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true,
+				NextProtos:         []string{"h2", "http/1.1"},
+			})
+			if err != nil {
+				t.Fatalf("tls.Dial() error: %v", err)
+			}
+			conn.Close()
+			if got := conn.ConnectionState().NegotiatedProtocol; got != tt.wantProto {
+				t.Errorf("negotiated protocol = %q, want %q", got, tt.wantProto)
+			}
+
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: true,
+			}}
+			resp, err := client.Get("https://" + listener.Addr().String() + "/")
+			if err != nil {
+				t.Fatalf("GET error: %v", err)
+			}
+			resp.Body.Close()
+			if resp.Proto != tt.wantHTTP {
+				t.Errorf("response protocol = %q, want %q", resp.Proto, tt.wantHTTP)
+			}
+		})
+	}
+}
+
+func TestParseALPN(t *testing.T) {
+	got, err := parseALPN(" http/1.1 , h2")
+	if err != nil || len(got) != 2 || got[0] != "http/1.1" || got[1] != "h2" {
+		t.Errorf("parseALPN() = %v, %v, want [http/1.1 h2]", got, err)
+	}
+	if got, _ := parseALPN(""); len(got) != 2 || got[0] != "h2" {
+		t.Errorf("parseALPN(\"\") = %v, want the h2 and http/1.1 default", got)
+	}
+	if _, err := parseALPN("h3"); err == nil {
+		t.Error("parseALPN(\"h3\") should fail")
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{"", tls.VersionTLS12, false},
+		{"1.0", tls.VersionTLS10, false},
+		{"1.1", tls.VersionTLS11, false},
+		{"1.2", tls.VersionTLS12, false},
+		{"1.3", tls.VersionTLS13, false},
+		{"1.4", 0, true},
+		{"TLS1.2", 0, true},
+		{"1", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseTLSVersion(tt.version)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseTLSVersion(%q) = %#x, %v, want %#x (error: %v)", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestParseCipherSuites(t *testing.T) {
+	got, err := parseCipherSuites(" TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 ,TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256")
+	want := []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256}
+	if err != nil || !slices.Equal(got, want) {
+		t.Errorf("parseCipherSuites() = %v, %v, want %v", got, err, want)
+	}
+	if got, err := parseCipherSuites(""); err != nil || got != nil {
+		t.Errorf("parseCipherSuites(\"\") = %v, %v, want nil for the defaults", got, err)
+	}
+	if _, err := parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("parseCipherSuites() should reject insecure cipher suites")
+	}
+	if _, err := parseCipherSuites("TLS_NO_SUCH_SUITE"); err == nil {
+		t.Error("parseCipherSuites() should reject unknown cipher suites")
+	}
+}
+
+func TestCheckHTTP2CipherSuites(t *testing.T) {
+	aes256 := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384}
+	if err := checkHTTP2CipherSuites(aes256, []string{"h2", "http/1.1"}); err == nil {
+		t.Error("h2 without an AES-128-GCM cipher suite should be rejected")
+	}
+	if err := checkHTTP2CipherSuites(aes256, []string{"http/1.1"}); err != nil {
+		t.Errorf("http/1.1 only: error = %v, want nil", err)
+	}
+	if err := checkHTTP2CipherSuites(nil, []string{"h2"}); err != nil {
+		t.Errorf("default cipher suites: error = %v, want nil", err)
+	}
+	withAES128 := []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	if err := checkHTTP2CipherSuites(withAES128, []string{"h2"}); err != nil {
+		t.Errorf("h2 with AES-128-GCM: error = %v, want nil", err)
+	}
+}
+
+func TestSetupHTTPServerTLSVersion(t *testing.T) {
+	certFile, keyFile := generateServerCert(t)
+
+	tests := []struct {
+		name       string
+		minVersion string
+		clientMax  uint16
+		wantErr    bool
+	}{
+		{"default rejects TLS 1.1", "", tls.VersionTLS11, true},
+		{"default accepts TLS 1.2", "", tls.VersionTLS12, false},
+		{"1.3 rejects TLS 1.2", "1.3", tls.VersionTLS12, true},
+		{"1.3 accepts TLS 1.3", "1.3", tls.VersionTLS13, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := New(newMockFactory(), &Options{
+				TitleFormat:   "Test",
+				EnableTLS:     true,
+				TLSCrtFile:    certFile,
+				TLSKeyFile:    keyFile,
+				TLSMinVersion: tt.minVersion,
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			srv, err := server.setupHTTPServer(http.NotFoundHandler())
+			if err != nil {
+				t.Fatalf("setupHTTPServer() error: %v", err)
+			}
+
+			listener, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatalf("Failed to listen: %v", err)
+			}
+			go srv.ServeTLS(listener, "", "")
+			defer srv.Close()
+
+			conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+				InsecureSkipVerify: true,
+				MinVersion:         tls.VersionTLS10,
+				MaxVersion:         tt.clientMax,
+			})
+			if err == nil {
+				conn.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("handshake with TLS up to %#x: error = %v, want error: %v", tt.clientMax, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSetupHTTPServerCipherSuites(t *testing.T) {
+	certFile, keyFile := generateServerCert(t)
+
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableTLS:       true,
+		TLSCrtFile:      certFile,
+		TLSKeyFile:      keyFile,
+		TLSALPN:         "http/1.1",
+		TLSCipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	srv, err := server.setupHTTPServer(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("setupHTTPServer() error: %v", err)
+	}
+	if srv.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("MinVersion = %#x, want TLS 1.2", srv.TLSConfig.MinVersion)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	go srv.ServeTLS(listener, "", "")
+	defer srv.Close()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         tls.VersionTLS12,
+	})
+	if err != nil {
+		t.Fatalf("tls.Dial() error: %v", err)
+	}
+	conn.Close()
+	if got := conn.ConnectionState().CipherSuite; got != tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384 {
+		t.Errorf("cipher suite = %s, want TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384", tls.CipherSuiteName(got))
+	}
+}
+
+func TestSetupHTTPServerACME(t *testing.T) {
+	options := &Options{
+		TitleFormat:  "Test",
+		EnableTLS:    true,
+		EnableACME:   true,
+		ACMEDomains:  "example.com",
+		ACMECacheDir: t.TempDir(),
+	}
+	server, err := New(newMockFactory(), options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if server.acme == nil {
+		t.Fatal("ACME manager should be set up")
+	}
+
+	srv, err := server.setupHTTPServer(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("setupHTTPServer() error: %v", err)
+	}
+	if srv.TLSConfig == nil || srv.TLSConfig.GetCertificate == nil {
+		t.Fatal("TLSConfig should get certificates from the ACME manager")
+	}
+	want := []string{"h2", "http/1.1", "acme-tls/1"}
+	if strings.Join(srv.TLSConfig.NextProtos, ",") != strings.Join(want, ",") {
+		t.Errorf("NextProtos = %v, want %v", srv.TLSConfig.NextProtos, want)
+	}
+	if strings.Join(server.alpn, ",") != "h2,http/1.1" {
+		t.Errorf("alpn = %v, should not be changed by ACME", server.alpn)
+	}
+
+	// Hosts outside of the domains are refused without contacting the CA
+	_, err = srv.TLSConfig.GetCertificate(&tls.ClientHelloInfo{ServerName: "other.example.org"})
+	if err == nil {
+		t.Error("GetCertificate() should refuse a host outside of acme-domains")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"webtmux/webtty"
+)
+
+// errIdleTimeout ends a session that saw no activity for Options.IdleTimeout.
+var errIdleTimeout = errors.New("idle timeout")
+
+// idleTransport records when a session was last active: any message from
+// the client but a ping, and output to it when countOutput is set.
+type idleTransport struct {
+	Transport
+
+	countOutput bool
+	start       time.Time
+	// last is the time of the last activity, as time since start
+	last     atomic.Int64
+	timedOut atomic.Bool
+}
+
+func newIdleTransport(transport Transport, countOutput bool) *idleTransport {
+	return &idleTransport{Transport: transport, countOutput: countOutput, start: time.Now()}
+}
+
+func (it *idleTransport) Read(p []byte) (int, error) {
+	n, err := it.Transport.Read(p)
+	if n > 0 && p[0] != webtty.Ping {
+		it.touch()
+	}
+	return n, err
+}
+
+func (it *idleTransport) Write(p []byte) (int, error) {
+	n, err := it.Transport.Write(p)
+	if it.countOutput && n > 0 && (p[0] == webtty.Output || p[0] == webtty.CompressedOutput) {
+		it.touch()
+	}
+	return n, err
+}
+
+func (it *idleTransport) touch() {
+	it.last.Store(int64(time.Since(it.start)))
+}
+
+// watch closes the transport once it was idle for timeout, which ends the
+// session, and returns when ctx is done.
+func (it *idleTransport) watch(ctx context.Context, timeout time.Duration, reqID string) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		idle := time.Since(it.start) - time.Duration(it.last.Load())
+		if idle < timeout {
+			timer.Reset(timeout - idle)
+			continue
+		}
+		log.Printf("Closing session from %s after %s without activity, request: %s", it.RemoteAddr(), timeout, reqID)
+		it.timedOut.Store(true)
+		it.Transport.Close()
+		return
+	}
+}
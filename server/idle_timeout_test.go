@@ -0,0 +1,161 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webtmux/webtty"
+)
+
+// closableTransport is a blockingTransport whose Close ends a pending Read,
+// like closing a real connection does.
+type closableTransport struct {
+	*blockingTransport
+	once sync.Once
+}
+
+func (ct *closableTransport) Close() error {
+	ct.once.Do(func() { close(ct.closed) })
+	return nil
+}
+
+func (ct *closableTransport) isClosed() bool {
+	select {
+	case <-ct.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+func newIdleTestServer(t *testing.T, factory Factory, countOutput bool) *Server {
+	t.Helper()
+	server, err := New(factory, &Options{TitleFormat: "Test", IdleTimeout: 1, IdleCountsOutput: countOutput})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	return server
+}
+
+func TestIdleTimeoutClosesSession(t *testing.T) {
+	factory := newConnTestFactory()
+	server := newIdleTestServer(t, factory, false)
+
+	init, _ := json.Marshal(InitMessage{})
+	transport := &closableTransport{blockingTransport: newBlockingTransport(init)}
+
+	// Output alone does not count as activity
+	stopOutput := make(chan struct{})
+	defer close(stopOutput)
+	go func() {
+		for {
+			select {
+			case <-stopOutput:
+				return
+			case <-time.After(50 * time.Millisecond):
+				factory.slave.writer.Write([]byte("top"))
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := server.processTransportConn(ctx, transport, nil, "")
+
+	if err != errIdleTimeout {
+		t.Fatalf("processTransportConn() error = %v, want %v", err, errIdleTimeout)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("session was closed after %v, before the idle timeout", elapsed)
+	}
+	if !transport.isClosed() {
+		t.Error("transport should be closed after the idle timeout")
+	}
+	if got := server.closeReason(ctx, err); got != "idle timeout" {
+		t.Errorf("closeReason() = %q, want %q", got, "idle timeout")
+	}
+}
+
+func TestIdleTransportInputKeepsSessionAlive(t *testing.T) {
+	base := &closableTransport{blockingTransport: newBlockingTransport()}
+	idle := newIdleTransport(base, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go idle.watch(ctx, 100*time.Millisecond, "")
+
+	// Keep typing for several idle periods
+	for i := 0; i < 10; i++ {
+		time.Sleep(30 * time.Millisecond)
+		idle.touch()
+	}
+	if base.isClosed() {
+		t.Fatal("transport was closed despite input")
+	}
+
+	// Pings alone do not keep it alive
+	base.mu.Lock()
+	base.reads = [][]byte{{webtty.Ping}}
+	base.mu.Unlock()
+	idle.Read(make([]byte, 16))
+	waitFor(t, "the idle transport to be closed", base.isClosed)
+	if !idle.timedOut.Load() {
+		t.Error("timedOut should be set when the transport is closed for idleness")
+	}
+}
+
+func TestIdleTransportCountsOutput(t *testing.T) {
+	base := &closableTransport{blockingTransport: newBlockingTransport()}
+	idle := newIdleTransport(base, true)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go idle.watch(ctx, 100*time.Millisecond, "")
+
+	for i := 0; i < 10; i++ {
+		time.Sleep(30 * time.Millisecond)
+		idle.Write([]byte{webtty.Output, 'x'})
+	}
+	if base.isClosed() {
+		t.Fatal("transport was closed despite output counting as activity")
+	}
+	waitFor(t, "the idle transport to be closed", base.isClosed)
+}
+
+func TestIdleTimeoutReleasesConnection(t *testing.T) {
+	server := newIdleTestServer(t, newConnTestFactory(), false)
+	counter := newCounter(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := httptest.NewServer(server.generateHandleWS(ctx, cancel, counter))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(InitMessage{}); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		if _, _, err := conn.NextReader(); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for counter.count() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("counter.count() = %d after the idle timeout, want 0", counter.count())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,64 @@
+package server
+
+import (
+	"context"
+	"html/template"
+	"log"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// indexReloadPoll is how often the custom index file is checked for changes.
+var indexReloadPoll = 1 * time.Second
+
+// watchIndexFile reloads the index template whenever the file at path
+// changes, until ctx is done. The manifest is embedded and never reloaded.
+func (server *Server) watchIndexFile(ctx context.Context, path string) {
+	var lastMod time.Time
+	var lastSize int64
+	if info, err := os.Stat(path); err == nil {
+		lastMod, lastSize = info.ModTime(), info.Size()
+	}
+
+	ticker := time.NewTicker(indexReloadPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || (info.ModTime().Equal(lastMod) && info.Size() == lastSize) {
+				continue
+			}
+			lastMod, lastSize = info.ModTime(), info.Size()
+
+			if err := server.reloadIndexTemplate(path); err != nil {
+				log.Printf("Keeping previous index template: %v", err)
+				continue
+			}
+			log.Printf("Reloaded index template from %s", path)
+		}
+	}
+}
+
+// reloadIndexTemplate parses the index file at path and swaps it in,
+// leaving the current template in place if the file is invalid.
+func (server *Server) reloadIndexTemplate(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read custom index file at `%s`", path)
+	}
+	indexTemplate, err := template.New("index").Parse(string(data))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse custom index file at `%s`", path)
+	}
+
+	server.templateMu.Lock()
+	server.indexTemplate = indexTemplate
+	server.templateMu.Unlock()
+	return nil
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveIndex(server *Server) string {
+	rr := httptest.NewRecorder()
+	server.handleIndex(rr, httptest.NewRequest("GET", "/", nil))
+	return rr.Body.String()
+}
+
+func writeIndexFile(t *testing.T, path, content string, mod time.Time) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write index file: %v", err)
+	}
+	// Make sure the change is visible even on coarse mtime filesystems
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatalf("failed to set index file time: %v", err)
+	}
+}
+
+func TestWatchIndexFileReloads(t *testing.T) {
+	oldPoll := indexReloadPoll
+	indexReloadPoll = 10 * time.Millisecond
+	defer func() { indexReloadPoll = oldPoll }()
+
+	path := filepath.Join(t.TempDir(), "index.html")
+	start := time.Now().Add(-time.Hour)
+	writeIndexFile(t, path, "<p>old {{ .title }}</p>", start)
+
+	server, err := New(newMockFactory(), &Options{TitleFormat: "WebTmux", IndexFile: path})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if got := serveIndex(server); got != "<p>old WebTmux</p>" {
+		t.Fatalf("initial index = %q", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go server.watchIndexFile(ctx, path)
+	// Let the watcher record the current file state first
+	time.Sleep(5 * indexReloadPoll)
+
+	writeIndexFile(t, path, "<p>new {{ .title }}</p>", start.Add(time.Minute))
+
+	deadline := time.Now().Add(2 * time.Second)
+	for serveIndex(server) != "<p>new WebTmux</p>" {
+		if time.Now().After(deadline) {
+			t.Fatalf("index was not reloaded, got %q", serveIndex(server))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReloadIndexTemplateKeepsOldOnParseError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.html")
+	writeIndexFile(t, path, "<p>{{ .title }}</p>", time.Now())
+
+	server, err := New(newMockFactory(), &Options{TitleFormat: "WebTmux", IndexFile: path})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	writeIndexFile(t, path, "<p>{{ .title </p>", time.Now().Add(time.Minute))
+	err = server.reloadIndexTemplate(path)
+	if err == nil || !strings.Contains(err.Error(), "failed to parse custom index file") {
+		t.Errorf("reloadIndexTemplate() error = %v, want parse error", err)
+	}
+
+	if got := serveIndex(server); got != "<p>WebTmux</p>" {
+		t.Errorf("index after failed reload = %q, want previous template", got)
+	}
+}
@@ -3,4 +3,9 @@ package server
 type InitMessage struct {
 	Arguments string `json:"Arguments,omitempty"`
 	AuthToken string `json:"AuthToken,omitempty"`
+	// ResumeToken identifies the session to resume after a reconnect
+	ResumeToken string `json:"ResumeToken,omitempty"`
+	// ReadOnly asks for a session that only shows output, even when
+	// PermitWrite is set
+	ReadOnly bool `json:"ReadOnly,omitempty"`
 }
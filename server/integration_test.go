@@ -1014,7 +1014,7 @@ func TestIntegrationWebSocketAuthentication(t *testing.T) {
 	dialer := websocket.Dialer{
 		Subprotocols: []string{"webtty"},
 	}
-	authToken := server.authTokens.issue("127.0.0.1")
+	authToken, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
 
 	t.Run("valid auth token", func(t *testing.T) {
 		conn, _, err := dialer.Dial(wsURL, nil)
@@ -1104,7 +1104,7 @@ func TestIntegrationProcessWSConnPaths(t *testing.T) {
 	dialer := websocket.Dialer{
 		Subprotocols: []string{"webtty"},
 	}
-	authToken := server.authTokens.issue("127.0.0.1")
+	authToken, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
 
 	t.Run("with arguments", func(t *testing.T) {
 		conn, _, err := dialer.Dial(wsURL, nil)
@@ -0,0 +1,33 @@
+package server
+
+import (
+	"log"
+	"net/http"
+)
+
+// ipAllowed reports whether a client at ip may connect. DeniedCIDRs take
+// precedence over AllowedCIDRs, and with no AllowedCIDRs every IP not
+// denied is allowed.
+func (server *Server) ipAllowed(ip string) bool {
+	if containsIP(server.deniedNetworks, ip) {
+		return false
+	}
+	return len(server.allowedNetworks) == 0 || containsIP(server.allowedNetworks, ip)
+}
+
+// wrapIPFilter refuses requests from clients that ipAllowed rejects with
+// 403 Forbidden, before anything else is done for them. Without allowed or
+// denied networks it returns handler as is.
+func (server *Server) wrapIPFilter(handler http.Handler) http.Handler {
+	if len(server.allowedNetworks) == 0 && len(server.deniedNetworks) == 0 {
+		return handler
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ip := server.clientIP(r); !server.ipAllowed(ip) {
+			log.Printf("Rejected %s: IP %s is not allowed", r.RemoteAddr, ip)
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,165 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIPFilter(t *testing.T) {
+	tests := []struct {
+		name       string
+		options    Options
+		remoteAddr string
+		forwarded  string
+		wantStatus int
+	}{
+		{
+			name:       "allowed network",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24, 2001:db8::/32"},
+			remoteAddr: "192.0.2.10:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "allowed IPv6 network",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24, 2001:db8::/32"},
+			remoteAddr: "[2001:db8::1]:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "outside allowed networks",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24"},
+			remoteAddr: "198.51.100.1:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "denied network",
+			options:    Options{DeniedCIDRs: "198.51.100.0/24"},
+			remoteAddr: "198.51.100.1:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "not denied",
+			options:    Options{DeniedCIDRs: "198.51.100.0/24"},
+			remoteAddr: "192.0.2.10:1234",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "denied takes precedence",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24", DeniedCIDRs: "192.0.2.66"},
+			remoteAddr: "192.0.2.66:1234",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "spoofed header ignored without trusting it",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24"},
+			remoteAddr: "198.51.100.1:1234",
+			forwarded:  "192.0.2.10",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "spoofed header cannot escape a deny",
+			options:    Options{DeniedCIDRs: "198.51.100.0/24"},
+			remoteAddr: "198.51.100.1:1234",
+			forwarded:  "192.0.2.10",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "spoofed entry before trusted proxy",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24", TrustedProxies: "10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  "192.0.2.10, 198.51.100.1",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "client behind trusted proxy",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24", TrustedProxies: "10.0.0.0/8"},
+			remoteAddr: "10.0.0.1:1234",
+			forwarded:  "192.0.2.10",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "forwarded header trusted from anyone",
+			options:    Options{AllowedCIDRs: "192.0.2.0/24", TrustXForwardedFor: true},
+			remoteAddr: "198.51.100.1:1234",
+			forwarded:  "192.0.2.10",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			options.TitleFormat = "Test"
+			server, err := New(newMockFactory(), &options)
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			handler := server.wrapIPFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			}))
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				req.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rr.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIPFilterDisabled(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	handler := server.wrapIPFilter(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "198.51.100.1:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("status = %d, want every IP allowed without allowed or denied networks", rr.Code)
+	}
+}
+
+func TestIPFilterHandlers(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:  "Test",
+		AllowedCIDRs: "192.0.2.0/24",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/", newCounter(0))
+
+	// The WebSocket endpoint is refused before the upgrade
+	for _, path := range []string{"/", "/ws", "/config.js"} {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = "198.51.100.1:1234"
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("GET %s: status = %d, want %d", path, rr.Code, http.StatusForbidden)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/config.js", nil)
+	req.RemoteAddr = "192.0.2.10:1234"
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Errorf("GET /config.js from an allowed IP: status = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
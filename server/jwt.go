@@ -0,0 +1,249 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/pkg/errors"
+)
+
+// jwtLeeway is how far the clocks of the token issuer and the server may
+// differ when checking the exp and nbf claims.
+const jwtLeeway = 30 * time.Second
+
+// jwtCurveAlgorithms are the algorithms of ECDSA keys by curve.
+var jwtCurveAlgorithms = map[string]string{
+	"P-256": "ES256",
+	"P-384": "ES384",
+	"P-521": "ES512",
+}
+
+// jwtClaims are the claims of a verified JWT.
+type jwtClaims map[string]interface{}
+
+// subject returns the sub claim, if any.
+func (claims jwtClaims) subject() string {
+	sub, _ := claims["sub"].(string)
+	return sub
+}
+
+// has reports whether claim name is value, or is a list containing it.
+func (claims jwtClaims) has(name string, value string) bool {
+	switch claim := claims[name].(type) {
+	case string:
+		return claim == value
+	case []interface{}:
+		for _, element := range claim {
+			if element == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jwtVerifier checks the signature and claims of JWTs presented by clients.
+// Only a key of its own is used; keys published with JWKS are not fetched.
+type jwtVerifier struct {
+	key    crypto.PublicKey
+	parser *jwt.Parser
+	// Required claim, when claimName is set
+	claimName  string
+	claimValue string
+}
+
+// newJWTVerifier returns a verifier for the JWT options.
+func newJWTVerifier(options *Options) (*jwtVerifier, error) {
+	key, err := loadJWTPublicKey(options.JWTPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	algorithms, err := jwtAlgorithms(key)
+	if err != nil {
+		return nil, err
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(algorithms),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(jwtLeeway),
+	}
+	if options.JWTAudience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(options.JWTAudience))
+	}
+	claimName, claimValue, _ := strings.Cut(options.JWTClaim, "=")
+	return &jwtVerifier{
+		key:        key,
+		parser:     jwt.NewParser(parserOptions...),
+		claimName:  claimName,
+		claimValue: claimValue,
+	}, nil
+}
+
+// jwtAlgorithms returns the algorithms accepted for key, so that tokens
+// cannot pick a weaker one.
+func jwtAlgorithms(key crypto.PublicKey) ([]string, error) {
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return []string{"RS256", "RS384", "RS512"}, nil
+	case *ecdsa.PublicKey:
+		alg, ok := jwtCurveAlgorithms[key.Curve.Params().Name]
+		if !ok {
+			return nil, errors.Errorf("unsupported curve %s of JWT public key", key.Curve.Params().Name)
+		}
+		return []string{alg}, nil
+	default:
+		return []string{"EdDSA"}, nil
+	}
+}
+
+// loadJWTPublicKey reads a PEM encoded public key or certificate.
+func loadJWTPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read JWT public key")
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("no PEM data in JWT public key file `%s`", path)
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "CERTIFICATE":
+		var cert *x509.Certificate
+		if cert, err = x509.ParseCertificate(block.Bytes); err == nil {
+			key = cert.PublicKey
+		}
+	default:
+		return nil, errors.Errorf("unsupported PEM block `%s` in JWT public key file `%s`", block.Type, path)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse JWT public key")
+	}
+
+	switch key.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	default:
+		return nil, errors.Errorf("unsupported JWT public key type %T", key)
+	}
+}
+
+// verify returns the claims of token if it is signed with the key, has not
+// expired and carries the required audience and claim.
+func (jv *jwtVerifier) verify(token string) (jwtClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jv.parser.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return jv.key, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if jv.claimName != "" && !jwtClaims(claims).has(jv.claimName, jv.claimValue) {
+		return nil, errors.Errorf("token does not have %s=%s", jv.claimName, jv.claimValue)
+	}
+	return jwtClaims(claims), nil
+}
+
+// isJWT tells JWTs apart from the auth tokens of authTokenStore, which
+// never contain dots.
+func isJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// bearerToken returns the Bearer token of the Authorization header of r,
+// if any.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authClaimsKey holds the JWT claims of a request or connection.
+type authClaimsKey struct{}
+
+// withAuthClaims returns ctx carrying claims.
+func withAuthClaims(ctx context.Context, claims jwtClaims) context.Context {
+	return context.WithValue(ctx, authClaimsKey{}, claims)
+}
+
+// authClaimsFromContext returns the JWT claims carried by ctx, if any.
+func authClaimsFromContext(ctx context.Context) jwtClaims {
+	claims, _ := ctx.Value(authClaimsKey{}).(jwtClaims)
+	return claims
+}
+
+// bearerTokenKey holds the Bearer token of the request that opened a
+// connection, used when its init message carries no auth token.
+type bearerTokenKey struct{}
+
+// wrapAuth lets requests through to handler that authenticate the way
+// AuthMode selects.
+func (server *Server) wrapAuth(handler http.Handler) http.Handler {
+	if server.jwt != nil {
+		return server.wrapJWTAuth(handler)
+	}
+	return server.wrapBasicAuth(handler, server.credentials()...)
+}
+
+// wrapJWTAuth lets requests through to handler that carry a valid JWT as
+// a Bearer token, with its claims in their context.
+func (server *Server) wrapJWTAuth(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := server.clientIP(r)
+
+		if locked, remaining, _ := server.rateLimiter.checkLocked(ip); locked {
+			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
+			log.Printf("IP %s locked out (retry in %v)", ip, remaining)
+			http.Error(w, "Too many failed login attempts. Try again later.", http.StatusTooManyRequests)
+			return
+		}
+
+		token := bearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="WebTmux"`)
+			http.Error(w, "Bad Request", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := server.jwt.verify(token)
+		if err != nil {
+			server.metrics.authAttempt(false)
+			server.rateLimiter.recordFailure(ip)
+			log.Printf("JWT Authentication failed: %s: %v", r.RemoteAddr, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="WebTmux", error="invalid_token"`)
+			http.Error(w, "Authorization failed", http.StatusUnauthorized)
+			return
+		}
+
+		server.metrics.authAttempt(true)
+		server.rateLimiter.recordSuccess(ip)
+		user := claims.subject()
+		log.Printf("JWT Authentication Succeeded: %s (%s)", r.RemoteAddr, user)
+		if holder, ok := r.Context().Value(authUserKey{}).(*string); ok {
+			*holder = user
+		} else {
+			r = r.WithContext(withAuthUser(r.Context(), user))
+		}
+		handler.ServeHTTP(w, r.WithContext(withAuthClaims(r.Context(), claims)))
+	})
+}
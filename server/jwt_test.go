@@ -0,0 +1,350 @@
+package server
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+// signJWT returns a token with claims signed by key with alg.
+func signJWT(t *testing.T, key crypto.Signer, alg string, claims map[string]interface{}) string {
+	t.Helper()
+
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("Failed to marshal claims: %v", err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var signature []byte
+	switch k := key.(type) {
+	case *ecdsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatalf("Failed to sign: %v", err)
+		}
+		signature = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	case *rsa.PrivateKey:
+		sum := sha256.Sum256([]byte(signed))
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(signed))
+	}
+	if err != nil {
+		t.Fatalf("Failed to sign: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+// writeJWTPublicKey writes the public key of key to a PEM file.
+func writeJWTPublicKey(t *testing.T, key crypto.Signer) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key.Public())
+	if err != nil {
+		t.Fatalf("Failed to marshal public key: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatalf("Failed to write public key: %v", err)
+	}
+	return path
+}
+
+func newJWTTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestJWTVerifier(t *testing.T) {
+	key := newJWTTestKey(t)
+	other := newJWTTestKey(t)
+	verifier, err := newJWTVerifier(&Options{
+		JWTPublicKeyFile: writeJWTPublicKey(t, key),
+		JWTAudience:      "webtmux",
+		JWTClaim:         "groups=ops",
+	})
+	if err != nil {
+		t.Fatalf("newJWTVerifier() error: %v", err)
+	}
+
+	now := time.Now()
+	valid := map[string]interface{}{
+		"sub":    "alice",
+		"aud":    []string{"webtmux", "other"},
+		"groups": []string{"dev", "ops"},
+		"exp":    now.Add(time.Hour).Unix(),
+	}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := map[string]interface{}{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		wantErr string
+	}{
+		{"valid", signJWT(t, key, "ES256", valid), ""},
+		{"within leeway", signJWT(t, key, "ES256", with("exp", now.Add(-jwtLeeway/2).Unix())), ""},
+		{"expired", signJWT(t, key, "ES256", with("exp", now.Add(-time.Hour).Unix())), "token is expired"},
+		{"no expiry", signJWT(t, key, "ES256", with("exp", nil)), "exp claim is required"},
+		{"not valid yet", signJWT(t, key, "ES256", with("nbf", now.Add(time.Hour).Unix())), "token is not valid yet"},
+		{"wrong audience", signJWT(t, key, "ES256", with("aud", "other")), "audience"},
+		{"missing claim", signJWT(t, key, "ES256", with("groups", "dev")), "groups=ops"},
+		{"other key", signJWT(t, other, "ES256", valid), "token signature is invalid"},
+		{"algorithm mismatch", signJWT(t, key, "ES384", valid), "signing method ES384 is invalid"},
+		{"malformed", "not-a-token", "token is malformed"},
+	}
+	for _, tt := range tests {
+		claims, err := verifier.verify(tt.token)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: verify() error: %v", tt.name, err)
+			} else if claims.subject() != "alice" {
+				t.Errorf("%s: subject = %q, want alice", tt.name, claims.subject())
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: verify() error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// A token whose signature is stripped and alg set to none is refused
+	parts := strings.Split(signJWT(t, key, "ES256", valid), ".")
+	none := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`)) + "." + parts[1] + "."
+	if _, err := verifier.verify(none); err == nil {
+		t.Error("verify() should refuse unsigned tokens")
+	}
+}
+
+func TestJWTVerifierKeyTypes(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+
+	claims := map[string]interface{}{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()}
+	for _, tt := range []struct {
+		key crypto.Signer
+		alg string
+	}{
+		{rsaKey, "RS256"},
+		{edKey, "EdDSA"},
+	} {
+		verifier, err := newJWTVerifier(&Options{JWTPublicKeyFile: writeJWTPublicKey(t, tt.key)})
+		if err != nil {
+			t.Fatalf("%s: newJWTVerifier() error: %v", tt.alg, err)
+		}
+		if _, err := verifier.verify(signJWT(t, tt.key, tt.alg, claims)); err != nil {
+			t.Errorf("%s: verify() error: %v", tt.alg, err)
+		}
+	}
+}
+
+func TestNewJWTVerifierErrors(t *testing.T) {
+	if _, err := newJWTVerifier(&Options{JWTPublicKeyFile: "/nonexistent/jwt.pem"}); err == nil {
+		t.Error("newJWTVerifier() should fail without the key file")
+	}
+
+	path := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(path, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if _, err := newJWTVerifier(&Options{JWTPublicKeyFile: path}); err == nil {
+		t.Error("newJWTVerifier() should fail without PEM data")
+	}
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	if _, err := newJWTVerifier(&Options{JWTPublicKeyFile: writeJWTPublicKey(t, p224)}); err == nil {
+		t.Error("newJWTVerifier() should fail for a curve without a JWT algorithm")
+	}
+}
+
+func TestWrapJWTAuth(t *testing.T) {
+	key := newJWTTestKey(t)
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:      "Test",
+		EnableBasicAuth:  true,
+		AuthMode:         "jwt",
+		JWTPublicKeyFile: writeJWTPublicKey(t, key),
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/", newCounter(0))
+
+	signed := signJWT(t, key, "ES256", map[string]interface{}{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	expired := signJWT(t, key, "ES256", map[string]interface{}{
+		"sub": "alice",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+
+	tests := []struct {
+		name          string
+		authorization string
+		wantStatus    int
+	}{
+		{"no token", "", http.StatusUnauthorized},
+		{"basic credentials", "Basic YWxpY2U6cGFzcw==", http.StatusUnauthorized},
+		{"expired token", "Bearer " + expired, http.StatusUnauthorized},
+		{"signed token", "Bearer " + signed, http.StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/auth_token.js", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rr.Code, tt.wantStatus)
+		}
+		if rr.Code == http.StatusUnauthorized && !strings.HasPrefix(rr.Header().Get("WWW-Authenticate"), "Bearer") {
+			t.Errorf("%s: WWW-Authenticate = %q, want a Bearer challenge", tt.name, rr.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestValidateAuthTokenJWT(t *testing.T) {
+	key := newJWTTestKey(t)
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:      "{{ .auth_claims.name }} ({{ .auth_user }})",
+		EnableBasicAuth:  true,
+		AuthIPBinding:    true,
+		AuthMode:         "jwt",
+		JWTPublicKeyFile: writeJWTPublicKey(t, key),
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	signed := signJWT(t, key, "ES256", map[string]interface{}{
+		"sub":  "alice",
+		"name": "Alice Liddell",
+		"exp":  time.Now().Add(time.Hour).Unix(),
+	})
+	expired := signJWT(t, key, "ES256", map[string]interface{}{
+		"sub": "alice",
+		"exp": time.Now().Add(-time.Hour).Unix(),
+	})
+	ctx := context.Background()
+
+	if _, _, ok := server.validateAuthToken(ctx, expired, "192.0.2.1"); ok {
+		t.Error("an expired JWT should be refused")
+	}
+	user, claims, ok := server.validateAuthToken(ctx, signed, "192.0.2.1")
+	if !ok || user != "alice" {
+		t.Fatalf("validateAuthToken() = %q, %v, want alice, true", user, ok)
+	}
+
+	// Tokens issued by auth_token.js keep working and carry the claims
+	req := httptest.NewRequest("GET", "/auth_token.js", nil)
+	req = req.WithContext(withAuthClaims(withAuthUser(req.Context(), user), claims))
+	token, err := server.issueAuthToken(req)
+	if err != nil {
+		t.Fatalf("issueAuthToken() error: %v", err)
+	}
+	user, claims, ok = server.validateAuthToken(ctx, token, "192.0.2.1")
+	if !ok || user != "alice" || claims.subject() != "alice" {
+		t.Fatalf("validateAuthToken() = %q, %v, %v, want the user and claims of the JWT", user, claims, ok)
+	}
+
+	// Without an auth token, the Bearer token of the request is used
+	bearerCtx := context.WithValue(ctx, bearerTokenKey{}, signed)
+	if user, _, ok := server.validateAuthToken(bearerCtx, "", "192.0.2.1"); !ok || user != "alice" {
+		t.Errorf("validateAuthToken() with a Bearer token = %q, %v, want alice, true", user, ok)
+	}
+
+	title, err := server.windowTitle("192.0.2.1:1234", user, claims, newMockSlaveForTransport())
+	if err != nil {
+		t.Fatalf("windowTitle() error: %v", err)
+	}
+	if string(title) != "Alice Liddell (alice)" {
+		t.Errorf("windowTitle() = %q, want %q", title, "Alice Liddell (alice)")
+	}
+}
+
+func TestProcessTransportConnJWT(t *testing.T) {
+	key := newJWTTestKey(t)
+	factory := newConnTestFactory()
+	slave := newExitingSlave("hello\r\n", 0)
+	server, err := New(&exitingFactory{factory, slave}, &Options{
+		TitleFormat:      "{{ .auth_claims.sub }}",
+		EnableBasicAuth:  true,
+		AuthMode:         "jwt",
+		JWTPublicKeyFile: writeJWTPublicKey(t, key),
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	signed := signJWT(t, key, "ES256", map[string]interface{}{
+		"sub": "alice",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})
+	data, _ := json.Marshal(InitMessage{AuthToken: signed})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.processTransportConn(ctx, transport, nil, ""); err != webtty.ErrSlaveClosed {
+		t.Fatalf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+	}
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	for _, msg := range transport.messages {
+		if len(msg) > 0 && msg[0] == webtty.SetWindowTitle && string(msg[1:]) == "alice" {
+			return
+		}
+	}
+	t.Error("window title should be rendered from the JWT claims")
+}
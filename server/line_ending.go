@@ -0,0 +1,43 @@
+package server
+
+// lineEndings maps Options.InputLineEnding to the sequence it stands for.
+var lineEndings = map[string][]byte{
+	"lf":   []byte("\n"),
+	"cr":   []byte("\r"),
+	"crlf": []byte("\r\n"),
+}
+
+// lineEndingSlave rewrites CR, LF and CRLF in client input to eol before it
+// reaches the slave.
+type lineEndingSlave struct {
+	Slave
+
+	eol []byte
+	// afterCR is set when the previous input ended with CR, so that an LF
+	// starting the next input completes that CRLF instead of adding a line.
+	afterCR bool
+}
+
+func (ls *lineEndingSlave) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p)+len(p)/8)
+	for _, b := range p {
+		switch b {
+		case '\r':
+			out = append(out, ls.eol...)
+			ls.afterCR = true
+			continue
+		case '\n':
+			if !ls.afterCR {
+				out = append(out, ls.eol...)
+			}
+		default:
+			out = append(out, b)
+		}
+		ls.afterCR = false
+	}
+
+	if _, err := ls.Slave.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
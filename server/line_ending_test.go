@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+// recordingSlave records the input written to it.
+type recordingSlave struct {
+	Slave
+	input bytes.Buffer
+}
+
+func (rs *recordingSlave) Write(p []byte) (int, error) {
+	return rs.input.Write(p)
+}
+
+func TestLineEndingSlave(t *testing.T) {
+	tests := []struct {
+		ending string
+		writes []string
+		want   string
+	}{
+		{ending: "lf", writes: []string{"ls\r\npwd\r"}, want: "ls\npwd\n"},
+		{ending: "lf", writes: []string{"a\nb\n\n"}, want: "a\nb\n\n"},
+		{ending: "lf", writes: []string{"a\r\r\nb"}, want: "a\n\nb"},
+		{ending: "lf", writes: []string{"ls\r", "\npwd\r"}, want: "ls\npwd\n"},
+		{ending: "cr", writes: []string{"ls\r\npwd\n"}, want: "ls\rpwd\r"},
+		{ending: "crlf", writes: []string{"ls\rpwd\n"}, want: "ls\r\npwd\r\n"},
+		{ending: "crlf", writes: []string{"ls\r\n"}, want: "ls\r\n"},
+		{ending: "lf", writes: []string{"\x00\x1b[A\xff"}, want: "\x00\x1b[A\xff"},
+	}
+
+	for _, tt := range tests {
+		rs := &recordingSlave{}
+		ls := &lineEndingSlave{Slave: rs, eol: lineEndings[tt.ending]}
+		for _, w := range tt.writes {
+			n, err := ls.Write([]byte(w))
+			if err != nil || n != len(w) {
+				t.Fatalf("Write(%q) = %d, %v, want %d, nil", w, n, err, len(w))
+			}
+		}
+		if got := rs.input.String(); got != tt.want {
+			t.Errorf("%s: input %q = %q, want %q", tt.ending, tt.writes, got, tt.want)
+		}
+	}
+}
+
+func TestInputLineEnding(t *testing.T) {
+	for _, ending := range []string{"", "lf"} {
+		// The mock slave echoes its input back as output
+		factory := newConnTestFactory()
+		server, err := New(factory, &Options{TitleFormat: "Test", PermitWrite: true, InputLineEnding: ending})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+
+		data, _ := json.Marshal(InitMessage{AuthToken: ""})
+		input := append([]byte{webtty.Input}, "ls\r\n"...)
+		transport := newBlockingTransport(data, input)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		server.processTransportConn(ctx, transport, nil, "")
+		cancel()
+		close(transport.closed)
+
+		want := "ls\r\n"
+		if ending == "lf" {
+			want = "ls\n"
+		}
+		if got := transport.outputText(t); got != want {
+			t.Errorf("InputLineEnding=%q output = %q, want %q", ending, got, want)
+		}
+	}
+}
@@ -4,25 +4,67 @@ import (
 	"net"
 )
 
-func listAddresses() (addresses []string) {
+// interfaceAddrs returns the addresses of the network interfaces that are up.
+var interfaceAddrs = func() ([]net.Addr, error) {
 	ifaces, err := net.Interfaces()
 	if err != nil {
-		return []string{}
+		return nil, err
 	}
 
-	addresses = make([]string, 0, len(ifaces))
-
+	addrs := []net.Addr{}
 	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
 		ifAddrs, _ := iface.Addrs()
-		for _, ifAddr := range ifAddrs {
-			switch v := ifAddr.(type) {
-			case *net.IPNet:
-				addresses = append(addresses, v.IP.String())
-			case *net.IPAddr:
-				addresses = append(addresses, v.IP.String())
-			}
+		addrs = append(addrs, ifAddrs...)
+	}
+	return addrs, nil
+}
+
+// listAddresses returns the addresses of the interfaces that are up.
+// Link-local addresses are left out, as URLs with them are of no use
+// without a zone.
+func listAddresses() (addresses []string) {
+	ifAddrs, err := interfaceAddrs()
+	if err != nil {
+		return []string{}
+	}
+
+	addresses = make([]string, 0, len(ifAddrs))
+
+	for _, ifAddr := range ifAddrs {
+		var ip net.IP
+		switch v := ifAddr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
 		}
+		if ip == nil || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+			continue
+		}
+		addresses = append(addresses, ip.String())
 	}
 
 	return addresses
 }
+
+// advertisedAddresses returns the addresses to show in URLs for a server
+// listening on all interfaces, falling back to 127.0.0.1 when there are no
+// usable interface addresses.
+func advertisedAddresses() []string {
+	if addresses := listAddresses(); len(addresses) > 0 {
+		return addresses
+	}
+	return []string{"127.0.0.1"}
+}
+
+// listensOnAllInterfaces reports whether address is empty or unspecified.
+func listensOnAllInterfaces(address string) bool {
+	if address == "" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsUnspecified()
+}
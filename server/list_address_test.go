@@ -1,6 +1,8 @@
 package server
 
 import (
+	"errors"
+	"net"
 	"testing"
 )
 
@@ -36,3 +38,61 @@ func TestListAddressesReturnsValidIPs(t *testing.T) {
 		}
 	}
 }
+
+func mockInterfaceAddrs(t *testing.T, addrs ...string) {
+	old := interfaceAddrs
+	t.Cleanup(func() { interfaceAddrs = old })
+
+	interfaceAddrs = func() ([]net.Addr, error) {
+		result := []net.Addr{}
+		for _, addr := range addrs {
+			ip, ipNet, _ := net.ParseCIDR(addr)
+			result = append(result, &net.IPNet{IP: ip, Mask: ipNet.Mask})
+		}
+		return result, nil
+	}
+}
+
+func TestListAddressesSkipsLinkLocal(t *testing.T) {
+	mockInterfaceAddrs(t, "127.0.0.1/8", "169.254.10.1/16", "fe80::1/64", "192.0.2.10/24", "2001:db8::10/64")
+
+	addresses := listAddresses()
+	want := []string{"127.0.0.1", "192.0.2.10", "2001:db8::10"}
+	if len(addresses) != len(want) {
+		t.Fatalf("listAddresses() = %v, want %v", addresses, want)
+	}
+	for i := range want {
+		if addresses[i] != want[i] {
+			t.Errorf("listAddresses() = %v, want %v", addresses, want)
+		}
+	}
+}
+
+func TestAdvertisedAddressesFallback(t *testing.T) {
+	mockInterfaceAddrs(t, "169.254.10.1/16", "fe80::1/64")
+
+	addresses := advertisedAddresses()
+	if len(addresses) != 1 || addresses[0] != "127.0.0.1" {
+		t.Errorf("advertisedAddresses() = %v, want [127.0.0.1]", addresses)
+	}
+
+	interfaceAddrs = func() ([]net.Addr, error) { return nil, errors.New("no interfaces") }
+	addresses = advertisedAddresses()
+	if len(addresses) != 1 || addresses[0] != "127.0.0.1" {
+		t.Errorf("advertisedAddresses() without interfaces = %v, want [127.0.0.1]", addresses)
+	}
+}
+
+func TestListensOnAllInterfaces(t *testing.T) {
+	for address, want := range map[string]bool{
+		"":          true,
+		"0.0.0.0":   true,
+		"::":        true,
+		"127.0.0.1": false,
+		"localhost": false,
+	} {
+		if got := listensOnAllInterfaces(address); got != want {
+			t.Errorf("listensOnAllInterfaces(%q) = %t, want %t", address, got, want)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"webtmux/webtty"
+)
+
+// sessionDurationBuckets are the upper bounds, in seconds, of the session
+// duration histogram.
+var sessionDurationBuckets = []float64{1, 10, 60, 300, 900, 3600, 14400}
+
+// transportMetrics are the metrics of the connections over one transport.
+type transportMetrics struct {
+	connections   prometheus.Counter
+	errors        prometheus.Counter
+	bytesReceived prometheus.Counter
+	bytesSent     prometheus.Counter
+	durations     prometheus.Observer
+}
+
+// metrics are registered with a registry of their own, which handleMetrics
+// exports. All methods are safe to call on a nil *metrics, which records
+// nothing.
+type metrics struct {
+	registry *prometheus.Registry
+
+	connections      *prometheus.CounterVec
+	connectionErrors *prometheus.CounterVec
+	bytesReceived    *prometheus.CounterVec
+	bytesSent        *prometheus.CounterVec
+	sessionDurations *prometheus.HistogramVec
+	authAttempts     *prometheus.CounterVec
+
+	// Messages dropped by the write queues of closed connections
+	queueDropped atomic.Int64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		connections: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webtmux_connections_total",
+			Help: "Terminal connections opened.",
+		}, []string{"transport"}),
+		connectionErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webtmux_connection_errors_total",
+			Help: "Terminal connections that ended with an error.",
+		}, []string{"transport"}),
+		bytesReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webtmux_received_bytes_total",
+			Help: "Bytes received from clients.",
+		}, []string{"transport"}),
+		bytesSent: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webtmux_sent_bytes_total",
+			Help: "Bytes sent to clients.",
+		}, []string{"transport"}),
+		sessionDurations: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "webtmux_session_duration_seconds",
+			Help:    "Duration of terminal connections.",
+			Buckets: sessionDurationBuckets,
+		}, []string{"transport"}),
+		authAttempts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "webtmux_auth_attempts_total",
+			Help: "Basic Authentication attempts by result.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(m.connections, m.connectionErrors, m.bytesReceived, m.bytesSent, m.sessionDurations, m.authAttempts)
+	// Both results are exported before the first attempt
+	m.authAttempts.WithLabelValues("success")
+	m.authAttempts.WithLabelValues("failure")
+	return m
+}
+
+// transport returns the metrics of the named transport.
+func (m *metrics) transport(name string) *transportMetrics {
+	if m == nil {
+		return nil
+	}
+	return &transportMetrics{
+		connections:   m.connections.WithLabelValues(name),
+		errors:        m.connectionErrors.WithLabelValues(name),
+		bytesReceived: m.bytesReceived.WithLabelValues(name),
+		bytesSent:     m.bytesSent.WithLabelValues(name),
+		durations:     m.sessionDurations.WithLabelValues(name),
+	}
+}
+
+// connectionOpened counts a new connection over the named transport.
+func (m *metrics) connectionOpened(name string) {
+	if m == nil {
+		return
+	}
+	m.transport(name).connections.Inc()
+}
+
+// connectionClosed records the duration of a connection over the named
+// transport, and whether it ended with an error.
+func (m *metrics) connectionClosed(name string, duration time.Duration, failed bool) {
+	if m == nil {
+		return
+	}
+	tm := m.transport(name)
+	if failed {
+		tm.errors.Inc()
+	}
+	tm.durations.Observe(duration.Seconds())
+}
+
+// authAttempt counts a Basic Authentication attempt.
+func (m *metrics) authAttempt(success bool) {
+	if m == nil {
+		return
+	}
+	if success {
+		m.authAttempts.WithLabelValues("success").Inc()
+	} else {
+		m.authAttempts.WithLabelValues("failure").Inc()
+	}
+}
+
+// connectionRemoved records the write queue drops of a closed connection,
+// which are no longer counted among the active connections.
+func (m *metrics) connectionRemoved(event disconnectEvent) {
+	if m == nil {
+		return
+	}
+	m.queueDropped.Add(event.QueueDropped)
+}
+
+// transportName returns the transport label of t.
+func transportName(t Transport) string {
+	switch t.(type) {
+	case *wsTransport:
+		return "websocket"
+	case *wtTransport:
+		return "webtransport"
+	default:
+		return "other"
+	}
+}
+
+// sessionFailed reports whether err ended a connection abnormally, rather
+// than either side closing it or the server stopping.
+func sessionFailed(ctx context.Context, err error) bool {
+	switch {
+	case err == nil, err == ctx.Err(), err == webtty.ErrSlaveClosed, err == webtty.ErrMasterClosed, err == errIdleTimeout:
+		return false
+	default:
+		return true
+	}
+}
+
+// handleMetrics serves the metrics, with the connections of counter as the
+// active connections.
+func (server *Server) handleMetrics(counter *counter) http.Handler {
+	// The gauges of a handler are gathered along with the server's metrics
+	local := prometheus.NewRegistry()
+	local.MustRegister(
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "webtmux_active_connections",
+			Help: "Terminal connections currently open.",
+		}, func() float64 { return float64(counter.count()) }),
+		&connectionCollector{server: server},
+	)
+	handler := promhttp.HandlerFor(prometheus.Gatherers{server.metrics.registry, local}, promhttp.HandlerOpts{})
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		handler.ServeHTTP(w, r)
+	})
+}
+
+var (
+	connectionRTTDesc = prometheus.NewDesc("webtmux_connection_rtt_seconds",
+		"Estimated round trip time to the client.", []string{"id"}, nil)
+	backendCPUDesc = prometheus.NewDesc("webtmux_backend_cpu_seconds",
+		"CPU time used by the backend of a connection, as last sampled.", []string{"id"}, nil)
+	backendRSSDesc = prometheus.NewDesc("webtmux_backend_rss_bytes",
+		"Resident memory of the backend of a connection, as last sampled.", []string{"id"}, nil)
+	writeQueueDepthDesc = prometheus.NewDesc("webtmux_write_queue_depth",
+		"Messages waiting in the write queue of a connection.", []string{"id"}, nil)
+	writeQueueDroppedDesc = prometheus.NewDesc("webtmux_write_queue_dropped_total",
+		"Messages dropped by write queues.", nil, nil)
+)
+
+// connectionCollector collects the RTT, backend resource usage and write
+// queue depth of each active connection, labeled with its ID, and the
+// messages dropped by all write queues.
+type connectionCollector struct {
+	server *Server
+}
+
+func (c *connectionCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectionRTTDesc
+	ch <- backendCPUDesc
+	ch <- backendRSSDesc
+	ch <- writeQueueDepthDesc
+	ch <- writeQueueDroppedDesc
+}
+
+func (c *connectionCollector) Collect(ch chan<- prometheus.Metric) {
+	var entries []*connectionEntry
+	if c.server.connections != nil {
+		entries = c.server.connections.list()
+	}
+
+	dropped := c.server.metrics.queueDropped.Load()
+	for _, entry := range entries {
+		id := strconv.FormatUint(entry.ID, 10)
+		if rtt, ok := entry.RTT(); ok {
+			ch <- prometheus.MustNewConstMetric(connectionRTTDesc, prometheus.GaugeValue, rtt.Seconds(), id)
+		}
+		if usage, ok := entry.ResourceUsage(); ok {
+			ch <- prometheus.MustNewConstMetric(backendCPUDesc, prometheus.GaugeValue, usage.CPUTime.Seconds(), id)
+			ch <- prometheus.MustNewConstMetric(backendRSSDesc, prometheus.GaugeValue, float64(usage.RSS), id)
+		}
+		if depth, _, queueDropped, ok := entry.WriteQueue(); ok {
+			ch <- prometheus.MustNewConstMetric(writeQueueDepthDesc, prometheus.GaugeValue, float64(depth), id)
+			dropped += queueDropped
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(writeQueueDroppedDesc, prometheus.CounterValue, float64(dropped))
+}
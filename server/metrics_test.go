@@ -0,0 +1,206 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"webtmux/webtty"
+)
+
+func TestMetricsHandler(t *testing.T) {
+	server := &Server{options: &Options{}, metrics: newMetrics()}
+	counter := newCounter(0)
+	counter.add(2)
+
+	server.metrics.connectionOpened("websocket")
+	server.metrics.connectionOpened("websocket")
+	server.metrics.connectionOpened("webtransport")
+	server.metrics.connectionClosed("websocket", 5*time.Second, false)
+	server.metrics.connectionClosed("websocket", 2*time.Hour, true)
+	server.metrics.transport("websocket").bytesSent.Add(1024)
+	server.metrics.authAttempt(true)
+	server.metrics.authAttempt(false)
+	server.metrics.authAttempt(false)
+
+	rr := httptest.NewRecorder()
+	server.handleMetrics(counter).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q, want the Prometheus text format", ct)
+	}
+
+	body := rr.Body.String()
+	for _, line := range []string{
+		"# TYPE webtmux_active_connections gauge",
+		"webtmux_active_connections 2",
+		`webtmux_auth_attempts_total{result="success"} 1`,
+		`webtmux_auth_attempts_total{result="failure"} 2`,
+		`webtmux_connections_total{transport="websocket"} 2`,
+		`webtmux_connections_total{transport="webtransport"} 1`,
+		`webtmux_connection_errors_total{transport="websocket"} 1`,
+		`webtmux_sent_bytes_total{transport="websocket"} 1024`,
+		`webtmux_received_bytes_total{transport="websocket"} 0`,
+		"# TYPE webtmux_session_duration_seconds histogram",
+		`webtmux_session_duration_seconds_bucket{transport="websocket",le="1"} 0`,
+		`webtmux_session_duration_seconds_bucket{transport="websocket",le="10"} 1`,
+		`webtmux_session_duration_seconds_bucket{transport="websocket",le="3600"} 1`,
+		`webtmux_session_duration_seconds_bucket{transport="websocket",le="+Inf"} 2`,
+		`webtmux_session_duration_seconds_sum{transport="websocket"} 7205`,
+		`webtmux_session_duration_seconds_count{transport="websocket"} 2`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsNil(t *testing.T) {
+	var m *metrics
+	m.connectionOpened("websocket")
+	m.connectionClosed("websocket", time.Second, true)
+	m.authAttempt(false)
+	if tm := m.transport("websocket"); tm != nil {
+		t.Errorf("transport() on nil metrics = %v, want nil", tm)
+	}
+}
+
+func TestSessionFailed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"no error", nil, false},
+		{"canceled", ctx.Err(), false},
+		{"backend exited", webtty.ErrSlaveClosed, false},
+		{"client closed", webtty.ErrMasterClosed, false},
+		{"idle timeout", errIdleTimeout, false},
+		{"write error", &webtty.MasterWriteError{Err: errors.New("broken pipe")}, true},
+		{"other error", errors.New("failed to authenticate"), true},
+	}
+	for _, tt := range tests {
+		if got := sessionFailed(ctx, tt.err); got != tt.want {
+			t.Errorf("%s: sessionFailed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsCountSessionBytes(t *testing.T) {
+	factory := newConnTestFactory()
+	slave := newExitingSlave("hello\r\n", 0)
+	server, err := New(&exitingFactory{factory, slave}, &Options{
+		TitleFormat:   "Test",
+		EnableMetrics: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := server.processTransportConn(ctx, transport, nil, ""); err != webtty.ErrSlaveClosed {
+		t.Fatalf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+	}
+
+	sent := testutil.ToFloat64(server.metrics.transport("other").bytesSent)
+	if sent < float64(len("hello\r\n")) {
+		t.Errorf("bytes sent = %g, want at least the output of the session", sent)
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+		EnableMetrics:   true,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/", newCounter(0))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("user", "wrong")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("with wrong credentials: status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+
+	req = httptest.NewRequest("GET", "/metrics", nil)
+	req.SetBasicAuth("user", "pass")
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rr.Code, http.StatusOK)
+	}
+	body := rr.Body.String()
+	for _, line := range []string{
+		"webtmux_active_connections 0",
+		`webtmux_auth_attempts_total{result="success"} 1`,
+		`webtmux_auth_attempts_total{result="failure"} 1`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+}
+
+func TestMetricsConnectionGauges(t *testing.T) {
+	server := &Server{options: &Options{}, metrics: newMetrics(), connections: newConnectionRegistry()}
+
+	entry := server.connections.add(&rttTransport{connTestTransport: newConnTestTransport(), rtt: 250 * time.Millisecond}, "")
+	entry.resources.Store(ResourceUsage{CPUTime: 1500 * time.Millisecond, RSS: 4096})
+	st := newSlowTransport()
+	qt := newQueuedTransport(st, 2, "drop", time.Second)
+	fillQueue(t, qt, st, "1", "2", "3", "4", "5")
+	entry.queue.Store(qt)
+	defer func() {
+		close(st.release)
+		qt.stop()
+	}()
+
+	closed := server.connections.add(newConnTestTransport(), "")
+	server.metrics.connectionRemoved(disconnectEvent{QueueDropped: 3})
+	server.connections.remove(closed)
+
+	rr := httptest.NewRecorder()
+	server.handleMetrics(newCounter(0)).ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+
+	body := rr.Body.String()
+	id := strconv.FormatUint(entry.ID, 10)
+	for _, line := range []string{
+		"# TYPE webtmux_connection_rtt_seconds gauge",
+		`webtmux_connection_rtt_seconds{id="` + id + `"} 0.25`,
+		`webtmux_backend_cpu_seconds{id="` + id + `"} 1.5`,
+		`webtmux_backend_rss_bytes{id="` + id + `"} 4096`,
+		`webtmux_write_queue_depth{id="` + id + `"} 2`,
+		"# TYPE webtmux_write_queue_dropped_total counter",
+		"webtmux_write_queue_dropped_total 5",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("metrics should contain %q, got:\n%s", line, body)
+		}
+	}
+	if strings.Contains(body, `{id="`+strconv.FormatUint(closed.ID, 10)+`"}`) {
+		t.Errorf("metrics should not contain the closed connection, got:\n%s", body)
+	}
+}
@@ -1,10 +1,12 @@
 package server
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
+	"slices"
 	"strings"
 	"sync"
 	"time"
@@ -19,40 +21,88 @@ type rateLimiter struct {
 	globalFailures    []time.Time
 	globalLockedUntil time.Time
 
+	// Per user name tracking (sliding window), independent of the IP
+	userFailures    map[string][]time.Time
+	userLockedUntil map[string]time.Time
+
+	limits rateLimits
+
 	mu sync.RWMutex
 }
 
-type attemptInfo struct {
-	failCount   int
-	lockedUntil time.Time
+// rateLimits are the thresholds of a rateLimiter, zero fields taking their
+// default values
+type rateLimits struct {
+	// Failures from one IP before it is locked out for lockoutBase, with
+	// longer lockouts at twice and four times as many
+	maxFailures int
+	lockoutBase time.Duration
+	// Failures from all IPs within the window before all logins are locked
+	// out, with longer lockouts at twice and five times as many
+	globalThreshold int
+	cleanupInterval time.Duration
 }
 
-// Per-IP lockout thresholds
-var ipLockoutRules = []struct {
-	attempts int
-	duration time.Duration
-}{
-	{5, 1 * time.Minute},
-	{10, 5 * time.Minute},
-	{20, 15 * time.Minute},
+var defaultRateLimits = rateLimits{
+	maxFailures:     5,
+	lockoutBase:     1 * time.Minute,
+	globalThreshold: 100,
+	cleanupInterval: 5 * time.Minute,
+}
+
+// withDefaults returns the limits with zero fields set to their defaults
+func (limits rateLimits) withDefaults() rateLimits {
+	if limits.maxFailures <= 0 {
+		limits.maxFailures = defaultRateLimits.maxFailures
+	}
+	if limits.lockoutBase <= 0 {
+		limits.lockoutBase = defaultRateLimits.lockoutBase
+	}
+	if limits.globalThreshold <= 0 {
+		limits.globalThreshold = defaultRateLimits.globalThreshold
+	}
+	if limits.cleanupInterval <= 0 {
+		limits.cleanupInterval = defaultRateLimits.cleanupInterval
+	}
+	return limits
 }
 
-// Global lockout thresholds (higher initial threshold)
-var globalLockoutRules = []struct {
+type lockoutRule struct {
 	failures int
 	duration time.Duration
-}{
-	{100, 2 * time.Minute},
-	{200, 10 * time.Minute},
-	{500, 30 * time.Minute},
+}
+
+// ipLockoutRules returns the per-IP lockout thresholds
+func (limits rateLimits) ipLockoutRules() []lockoutRule {
+	return []lockoutRule{
+		{limits.maxFailures, limits.lockoutBase},
+		{2 * limits.maxFailures, 5 * limits.lockoutBase},
+		{4 * limits.maxFailures, 15 * limits.lockoutBase},
+	}
+}
+
+// globalLockoutRules returns the global lockout thresholds (higher initial
+// threshold)
+func (limits rateLimits) globalLockoutRules() []lockoutRule {
+	return []lockoutRule{
+		{limits.globalThreshold, 2 * time.Minute},
+		{2 * limits.globalThreshold, 10 * time.Minute},
+		{5 * limits.globalThreshold, 30 * time.Minute},
+	}
+}
+
+type attemptInfo struct {
+	failCount   int
+	lockedUntil time.Time
 }
 
 const globalWindowDuration = 5 * time.Minute
 
-func newRateLimiter() *rateLimiter {
+func newRateLimiter(limits rateLimits) *rateLimiter {
 	rl := &rateLimiter{
 		attempts:       make(map[string]*attemptInfo),
 		globalFailures: make([]time.Time, 0),
+		limits:         limits.withDefaults(),
 	}
 
 	// Start cleanup goroutine
@@ -63,7 +113,8 @@ func newRateLimiter() *rateLimiter {
 
 // cleanupLoop periodically removes old entries
 func (rl *rateLimiter) cleanupLoop() {
-	ticker := time.NewTicker(5 * time.Minute)
+	ticker := time.NewTicker(rl.limits.cleanupInterval)
+	defer ticker.Stop()
 	for range ticker.C {
 		rl.cleanup()
 	}
@@ -86,18 +137,32 @@ func (rl *rateLimiter) cleanup() {
 
 	// Clean up global failures outside window
 	rl.pruneGlobalFailures(now)
+
+	// Clean up user names without recent failures
+	for user, failures := range rl.userFailures {
+		rl.userFailures[user] = pruneFailures(failures, now)
+		if len(rl.userFailures[user]) == 0 && !now.Before(rl.userLockedUntil[user]) {
+			delete(rl.userFailures, user)
+			delete(rl.userLockedUntil, user)
+		}
+	}
 }
 
 // pruneGlobalFailures removes failures outside the sliding window
 func (rl *rateLimiter) pruneGlobalFailures(now time.Time) {
+	rl.globalFailures = pruneFailures(rl.globalFailures, now)
+}
+
+// pruneFailures returns the failures within the sliding window
+func pruneFailures(failures []time.Time, now time.Time) []time.Time {
 	cutoff := now.Add(-globalWindowDuration)
-	newFailures := make([]time.Time, 0, len(rl.globalFailures))
-	for _, t := range rl.globalFailures {
+	newFailures := make([]time.Time, 0, len(failures))
+	for _, t := range failures {
 		if t.After(cutoff) {
 			newFailures = append(newFailures, t)
 		}
 	}
-	rl.globalFailures = newFailures
+	return newFailures
 }
 
 // checkLocked returns lockout duration if IP or global is locked
@@ -144,10 +209,11 @@ func (rl *rateLimiter) recordFailure(ip string) {
 	}
 
 	info.failCount++
+	limits := rl.limits.withDefaults()
 
 	// Apply per-IP lockout
-	for _, rule := range ipLockoutRules {
-		if info.failCount >= rule.attempts {
+	for _, rule := range limits.ipLockoutRules() {
+		if info.failCount >= rule.failures {
 			info.lockedUntil = now.Add(rule.duration)
 		}
 	}
@@ -158,7 +224,7 @@ func (rl *rateLimiter) recordFailure(ip string) {
 
 	// Check global lockout
 	failureCount := len(rl.globalFailures)
-	for _, rule := range globalLockoutRules {
+	for _, rule := range limits.globalLockoutRules() {
 		if failureCount >= rule.failures {
 			rl.globalLockedUntil = now.Add(rule.duration)
 		}
@@ -167,6 +233,40 @@ func (rl *rateLimiter) recordFailure(ip string) {
 	log.Printf("Auth failure from %s (IP attempts: %d, global failures: %d)", ip, info.failCount, failureCount)
 }
 
+// checkUserLocked returns the remaining time a user name is disabled for
+func (rl *rateLimiter) checkUserLocked(user string) (bool, time.Duration) {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	remaining := time.Until(rl.userLockedUntil[user])
+	if remaining <= 0 {
+		return false, 0
+	}
+	return true, remaining
+}
+
+// recordUserFailure records a failed login attempt against a user name and
+// disables it for duration once limit failures fall within the window
+func (rl *rateLimiter) recordUserFailure(user string, limit int, duration time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	if rl.userFailures == nil {
+		rl.userFailures = make(map[string][]time.Time)
+		rl.userLockedUntil = make(map[string]time.Time)
+	}
+
+	now := time.Now()
+	failures := append(pruneFailures(rl.userFailures[user], now), now)
+	rl.userFailures[user] = failures
+
+	if len(failures) >= limit {
+		rl.userLockedUntil[user] = now.Add(duration)
+		rl.userFailures[user] = nil
+		log.Printf("Credential for %q disabled for %v after %d failures", user, duration, len(failures))
+	}
+}
+
 // recordSuccess resets the per-IP counter on successful login
 func (rl *rateLimiter) recordSuccess(ip string) {
 	rl.mu.Lock()
@@ -178,13 +278,15 @@ func (rl *rateLimiter) recordSuccess(ip string) {
 	}
 }
 
-// Global rate limiter instance
-var authRateLimiter = newRateLimiter()
-
 func (server *Server) wrapLogger(handler http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		rw := &logResponseWriter{w, 200}
-		handler.ServeHTTP(rw, r)
+		user := new(string)
+		handler.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), authUserKey{}, user)))
+		if *user != "" {
+			log.Printf("%s (%s) %d %s %s", r.RemoteAddr, *user, rw.status, r.Method, r.URL.Path)
+			return
+		}
 		log.Printf("%s %d %s %s", r.RemoteAddr, rw.status, r.Method, r.URL.Path)
 	})
 }
@@ -196,13 +298,15 @@ func (server *Server) wrapHeaders(handler http.Handler) http.Handler {
 	})
 }
 
-func (server *Server) wrapBasicAuth(handler http.Handler, credential string) http.Handler {
+// wrapBasicAuth lets requests through to handler that authenticate with
+// any of credentials or of the current contents of the credential file.
+func (server *Server) wrapBasicAuth(handler http.Handler, credentials ...string) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Extract IP (handle proxies)
-		ip := clientIPFromRequest(r)
+		ip := server.clientIP(r)
 
 		// Check if locked out
-		if locked, remaining, lockType := authRateLimiter.checkLocked(ip); locked {
+		if locked, remaining, lockType := server.rateLimiter.checkLocked(ip); locked {
 			w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
 			if lockType == "global" {
 				log.Printf("Global lockout active, rejected %s (retry in %v)", ip, remaining)
@@ -228,16 +332,51 @@ func (server *Server) wrapBasicAuth(handler http.Handler, credential string) htt
 			return
 		}
 
-		if credential != string(payload) {
-			authRateLimiter.recordFailure(ip)
+		// Disabled user names are refused even with the right password
+		user := strings.SplitN(string(payload), ":", 2)[0]
+		userLockout := server.options.UserLockout > 0
+		if userLockout {
+			if locked, remaining := server.rateLimiter.checkUserLocked(user); locked {
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(remaining.Seconds())+1))
+				log.Printf("Credential for %q disabled, rejected %s (retry in %v)", user, ip, remaining)
+				http.Error(w, "Too many failed login attempts for this user. Try again later.", http.StatusTooManyRequests)
+				return
+			}
+		}
+
+		credential := string(payload)
+		totpOK := true
+		if server.totpSecret != nil {
+			var code string
+			credential, code = splitTOTP(credential)
+			totpOK = validTOTP(server.totpSecret, code, totpNow())
+		}
+
+		allCredentials := slices.Concat(credentials, server.fileCredentials())
+		matched, ok := matchCredential(allCredentials, credential)
+		if !ok || !totpOK {
+			server.metrics.authAttempt(false)
+			server.rateLimiter.recordFailure(ip)
+			// Only configured user names are tracked, so that made up
+			// ones cannot grow the failures without bound
+			if userLockout && hasCredentialUser(allCredentials, user) {
+				lockoutTime := time.Duration(server.options.UserLockoutTime) * time.Second
+				server.rateLimiter.recordUserFailure(user, server.options.UserLockout, lockoutTime)
+			}
 			w.Header().Set("WWW-Authenticate", `Basic realm="WebTmux"`)
 			http.Error(w, "Authorization failed", http.StatusUnauthorized)
 			return
 		}
 
 		// Success - reset IP counter
-		authRateLimiter.recordSuccess(ip)
-		log.Printf("Basic Authentication Succeeded: %s", r.RemoteAddr)
+		server.metrics.authAttempt(true)
+		server.rateLimiter.recordSuccess(ip)
+		log.Printf("Basic Authentication Succeeded: %s (%s)", r.RemoteAddr, matched)
+		if holder, ok := r.Context().Value(authUserKey{}).(*string); ok {
+			*holder = matched
+		} else {
+			r = r.WithContext(withAuthUser(r.Context(), matched))
+		}
 		handler.ServeHTTP(w, r)
 	})
 }
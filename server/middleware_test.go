@@ -4,12 +4,13 @@ import (
 	"encoding/base64"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 )
 
 func TestNewRateLimiter(t *testing.T) {
-	rl := newRateLimiter()
+	rl := newRateLimiter(defaultRateLimits)
 	if rl == nil {
 		t.Fatal("newRateLimiter returned nil")
 	}
@@ -196,7 +197,8 @@ func TestRateLimiterPruneGlobalFailures(t *testing.T) {
 // Helper function to create a test server for middleware tests
 func createTestServer() *Server {
 	return &Server{
-		options: &Options{},
+		options:     &Options{},
+		rateLimiter: newRateLimiter(defaultRateLimits),
 	}
 }
 
@@ -240,14 +242,6 @@ func TestWrapLogger(t *testing.T) {
 }
 
 func TestWrapBasicAuthValid(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
 	credential := "admin:password"
 
@@ -276,14 +270,6 @@ func TestWrapBasicAuthValid(t *testing.T) {
 }
 
 func TestWrapBasicAuthInvalid(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
 	credential := "admin:password"
 
@@ -311,14 +297,6 @@ func TestWrapBasicAuthInvalid(t *testing.T) {
 }
 
 func TestWrapBasicAuthMissingHeader(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
 	credential := "admin:password"
 
@@ -340,14 +318,6 @@ func TestWrapBasicAuthMissingHeader(t *testing.T) {
 }
 
 func TestWrapBasicAuthLockout(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
 	credential := "admin:password"
 
@@ -356,7 +326,7 @@ func TestWrapBasicAuthLockout(t *testing.T) {
 	wrapped := server.wrapBasicAuth(handler, credential)
 
 	// Lock out the IP
-	authRateLimiter.attempts["192.0.2.1"] = &attemptInfo{
+	server.rateLimiter.attempts["192.0.2.1"] = &attemptInfo{
 		failCount:   10,
 		lockedUntil: time.Now().Add(time.Hour),
 	}
@@ -376,15 +346,8 @@ func TestWrapBasicAuthLockout(t *testing.T) {
 }
 
 func TestWrapBasicAuthXForwardedFor(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
+	server.options.TrustXForwardedFor = true
 	credential := "admin:password"
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
@@ -392,7 +355,7 @@ func TestWrapBasicAuthXForwardedFor(t *testing.T) {
 	wrapped := server.wrapBasicAuth(handler, credential)
 
 	// Lock out the forwarded IP
-	authRateLimiter.attempts["10.0.0.1"] = &attemptInfo{
+	server.rateLimiter.attempts["10.0.0.1"] = &attemptInfo{
 		failCount:   10,
 		lockedUntil: time.Now().Add(time.Hour),
 	}
@@ -410,14 +373,6 @@ func TestWrapBasicAuthXForwardedFor(t *testing.T) {
 }
 
 func TestWrapBasicAuthInvalidBase64(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:       make(map[string]*attemptInfo),
-		globalFailures: make([]time.Time, 0),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
 	credential := "admin:password"
 
@@ -438,16 +393,8 @@ func TestWrapBasicAuthInvalidBase64(t *testing.T) {
 }
 
 func TestWrapBasicAuthGlobalLockout(t *testing.T) {
-	// Use a fresh rate limiter for testing
-	oldLimiter := authRateLimiter
-	authRateLimiter = &rateLimiter{
-		attempts:          make(map[string]*attemptInfo),
-		globalFailures:    make([]time.Time, 0),
-		globalLockedUntil: time.Now().Add(time.Hour),
-	}
-	defer func() { authRateLimiter = oldLimiter }()
-
 	server := createTestServer()
+	server.rateLimiter.globalLockedUntil = time.Now().Add(time.Hour)
 	credential := "admin:password"
 
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
@@ -498,6 +445,81 @@ func TestRateLimiterRecordFailureTriggersLockout(t *testing.T) {
 	}
 }
 
+func TestRateLimiterCustomLimits(t *testing.T) {
+	rl := newRateLimiter(rateLimits{maxFailures: 2, lockoutBase: 10 * time.Second, globalThreshold: 3})
+
+	rl.recordFailure("192.168.1.1")
+	if locked, _, _ := rl.checkLocked("192.168.1.1"); locked {
+		t.Error("IP should not be locked after 1 failure")
+	}
+	rl.recordFailure("192.168.1.1")
+	locked, duration, lockType := rl.checkLocked("192.168.1.1")
+	if !locked || lockType != "ip" {
+		t.Fatalf("checkLocked() = %v, %q, want an ip lockout after 2 failures", locked, lockType)
+	}
+	if duration > 10*time.Second {
+		t.Errorf("Duration = %v, want at most the 10s lockout base", duration)
+	}
+
+	// The third failure from any IP reaches the global threshold
+	rl.recordFailure("192.168.1.2")
+	if _, _, lockType := rl.checkLocked("192.168.1.3"); lockType != "global" {
+		t.Errorf("lockType = %q, want global after 3 failures", lockType)
+	}
+}
+
+func TestNewRateLimiterLimits(t *testing.T) {
+	// Zero limits keep the defaults
+	if rl := newRateLimiter(rateLimits{}); rl.limits != defaultRateLimits {
+		t.Errorf("limits = %+v, want the defaults %+v", rl.limits, defaultRateLimits)
+	}
+
+	rl := newRateLimiter(rateLimits{maxFailures: 20})
+	for i := 0; i < 19; i++ {
+		rl.recordFailure("192.168.1.1")
+	}
+	if locked, _, _ := rl.checkLocked("192.168.1.1"); locked {
+		t.Error("IP should not be locked before 20 failures")
+	}
+	rl.recordFailure("192.168.1.1")
+	if locked, _, _ := rl.checkLocked("192.168.1.1"); !locked {
+		t.Error("IP should be locked after 20 failures")
+	}
+}
+
+func TestServersHaveSeparateRateLimiters(t *testing.T) {
+	strict, err := New(newMockFactory(), &Options{TitleFormat: "Test", AuthMaxFailures: 2})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	lenient, err := New(newMockFactory(), &Options{TitleFormat: "Test", AuthMaxFailures: 50})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if strict.rateLimiter.limits.maxFailures != 2 {
+		t.Errorf("maxFailures = %d, want 2; a later server must not change the limits", strict.rateLimiter.limits.maxFailures)
+	}
+
+	login := func(server *Server) int {
+		wrapped := server.wrapBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "admin:password")
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.0.2.1:1"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:guess")))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	login(strict)
+	login(strict)
+	if code := login(strict); code != http.StatusTooManyRequests {
+		t.Errorf("strict server: status = %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := login(lenient); code != http.StatusUnauthorized {
+		t.Errorf("lenient server: status = %d, want %d; lockouts must not be shared", code, http.StatusUnauthorized)
+	}
+}
+
 // Benchmark rate limiter operations
 func BenchmarkRateLimiterCheckLocked(b *testing.B) {
 	rl := &rateLimiter{
@@ -522,3 +544,67 @@ func BenchmarkRateLimiterRecordFailure(b *testing.B) {
 		rl.recordFailure("192.168.1.1")
 	}
 }
+
+func TestWrapBasicAuthUserLockout(t *testing.T) {
+	server := &Server{options: &Options{UserLockout: 3, UserLockoutTime: 60}, rateLimiter: newRateLimiter(defaultRateLimits)}
+	wrapped := server.wrapBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "admin:password")
+
+	login := func(credential, remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(credential)))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		return rr
+	}
+
+	// A distributed attack on admin, one attempt per address
+	for _, addr := range []string{"192.0.2.1:1", "192.0.2.2:1", "192.0.2.3:1"} {
+		if rr := login("admin:guess", addr); rr.Code != http.StatusUnauthorized {
+			t.Fatalf("Status code = %d, want %d", rr.Code, http.StatusUnauthorized)
+		}
+	}
+
+	rr := login("admin:password", "198.51.100.1:1")
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("Status code for the disabled user = %d, want %d", rr.Code, http.StatusTooManyRequests)
+	}
+	if retryAfter := rr.Header().Get("Retry-After"); retryAfter != "60" && retryAfter != "61" {
+		t.Errorf("Retry-After = %q, want about 60", retryAfter)
+	}
+
+	// Other user names are unaffected
+	if rr := login("guest:guess", "198.51.100.1:1"); rr.Code != http.StatusUnauthorized {
+		t.Errorf("Status code for another user = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+	for i := 0; i < 10; i++ {
+		login("user"+strconv.Itoa(i)+":guess", "203.0.113."+strconv.Itoa(i+1)+":1")
+	}
+	if locked, _ := server.rateLimiter.checkUserLocked("guest"); locked {
+		t.Error("guest should not be disabled")
+	}
+	if len(server.rateLimiter.userFailures) != 1 {
+		t.Errorf("userFailures = %v, want only the configured user tracked", server.rateLimiter.userFailures)
+	}
+
+	server.rateLimiter.userLockedUntil["admin"] = time.Now().Add(-time.Second)
+	if rr := login("admin:password", "198.51.100.1:1"); rr.Code != http.StatusOK {
+		t.Errorf("Status code after the lockout = %d, want %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestWrapBasicAuthUserLockoutDisabled(t *testing.T) {
+	server := createTestServer()
+	wrapped := server.wrapBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "admin:password")
+
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.RemoteAddr = "192.0.2." + strconv.Itoa(i+1) + ":1"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:guess")))
+		wrapped.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	if locked, _ := server.rateLimiter.checkUserLocked("admin"); locked {
+		t.Error("user names should not be disabled without user-lockout")
+	}
+}
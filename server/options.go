@@ -1,47 +1,157 @@
 package server
 
 import (
+	"crypto/tls"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+
 	"github.com/pkg/errors"
+
+	"webtmux/webtty"
 )
 
 type Options struct {
 	Address             string `hcl:"address" flagName:"address" flagSName:"a" flagDescribe:"IP address to listen" default:"0.0.0.0"`
 	Port                string `hcl:"port" flagName:"port" flagSName:"p" flagDescribe:"Port number to liten" default:"8080"`
+	AddressFamily       string `hcl:"address_family" flagName:"address-family" flagDescribe:"Address family to listen on: ipv4, ipv6 or dual (empty for the platform default)" default:""`
 	Path                string `hcl:"path" flagName:"path" flagSName:"m" flagDescribe:"Base path" default:"/"`
 	PermitWrite         bool   `hcl:"permit_write" flagName:"permit-write" flagSName:"w" flagDescribe:"Permit clients to write to the TTY (BE CAREFUL)" default:"false"`
+	InputLineEnding     string `hcl:"input_line_ending" flagName:"input-line-ending" flagDescribe:"Normalize line endings in client input to lf, cr or crlf (empty to pass input through unchanged)" default:""`
 	EnableBasicAuth     bool   `hcl:"enable_basic_auth" default:"true"`
 	AuthIPBinding       bool   `hcl:"auth_ip_binding" flagName:"auth-ip-binding" flagDescribe:"Bind auth tokens to client IP (set false behind proxies)" default:"true"`
-	Credential          string `hcl:"credential" flagName:"credential" flagSName:"c" flagDescribe:"Credential for Basic Authentication (ex: user:pass)" default:""`
+	TrustedProxies      string `hcl:"trusted_proxies" flagName:"trusted-proxies" flagDescribe:"Comma separated IPs or CIDRs of proxies whose Forwarded, X-Forwarded-For or X-Real-IP headers are trusted to find the client IP (empty to use the peer address)" default:""`
+	TrustXForwardedFor  bool   `hcl:"trust_x_forwarded_for" flagName:"trust-x-forwarded-for" flagDescribe:"Believe the first X-Forwarded-For entry from anyone when trusted-proxies is empty, letting clients that connect directly spoof their IP (prefer trusted-proxies)" default:"false"`
+	AllowedCIDRs        string `hcl:"allowed_cidrs" flagName:"allowed-cidrs" flagDescribe:"Comma separated IPs or CIDRs of the only clients allowed to connect, others get 403 Forbidden (empty to allow all)" default:""`
+	DeniedCIDRs         string `hcl:"denied_cidrs" flagName:"denied-cidrs" flagDescribe:"Comma separated IPs or CIDRs of clients refused with 403 Forbidden, even when in allowed-cidrs" default:""`
+	CredentialFile      string `hcl:"credential_file" flagName:"credential-file" flagDescribe:"File of user:password lines accepted for Basic Authentication, like htpasswd with bcrypt or argon2id hashes, reloaded when it changes" default:""`
+	Credential          string `hcl:"credential" flagName:"credential" flagSName:"c" flagDescribe:"Credential for Basic Authentication (ex: user:pass), the password may be a bcrypt or argon2id hash" default:""`
+	TOTPSecret          string `hcl:"totp_secret" flagName:"totp-secret" flagDescribe:"Base32 TOTP secret; Basic Authentication then takes the password as password:123456 with the current code" default:""`
+	UserLockout         int    `hcl:"user_lockout" flagName:"user-lockout" flagDescribe:"Failed logins against one user name within 5 minutes, from any address, before it is disabled (0 to disable)" default:"0"`
+	UserLockoutTime     int    `hcl:"user_lockout_time" flagName:"user-lockout-time" flagDescribe:"Seconds a user name stays disabled after user-lockout failed logins" default:"300"`
+	AuthMaxFailures     int    `hcl:"auth_max_failures" flagName:"auth-max-failures" flagDescribe:"Failed logins from one IP before it is locked out, for longer at twice and four times as many; behind a proxy without trusted-proxies all clients share its IP" default:"5"`
+	AuthLockoutBase     int    `hcl:"auth_lockout_base" flagName:"auth-lockout-base" flagDescribe:"Seconds an IP is locked out after auth-max-failures, 5 and 15 times as long after more failures" default:"60"`
+	AuthGlobalThreshold int    `hcl:"auth_global_threshold" flagName:"auth-global-threshold" flagDescribe:"Failed logins from all IPs within 5 minutes before every login is locked out, for longer at twice and five times as many" default:"100"`
+	AuthCleanupInterval int    `hcl:"auth_cleanup_interval" flagName:"auth-cleanup-interval" flagDescribe:"Seconds between removals of expired failed login records" default:"300"`
+	AuthMode            string `hcl:"auth_mode" flagName:"auth-mode" flagDescribe:"How clients authenticate: basic, or jwt for Bearer tokens verified with jwt-public-key" default:"basic"`
+	JWTPublicKeyFile    string `hcl:"jwt_public_key_file" flagName:"jwt-public-key" flagDescribe:"PEM file with the RSA, ECDSA or Ed25519 public key, or a certificate, that JWTs are signed for in auth-mode jwt" default:""`
+	JWTAudience         string `hcl:"jwt_audience" flagName:"jwt-audience" flagDescribe:"Audience (aud) JWTs must be issued for (empty to accept any)" default:""`
+	JWTClaim            string `hcl:"jwt_claim" flagName:"jwt-claim" flagDescribe:"Claim JWTs must carry as name=value, matching a string claim or an element of a list claim (empty for none)" default:""`
 	NoAuth              bool   `hcl:"no_auth" flagName:"no-auth" flagDescribe:"Disable authentication (NOT RECOMMENDED)" default:"false"`
+	AuthPaths           string `hcl:"auth_paths" flagName:"auth-paths" flagDescribe:"Comma separated paths under the base path that require authentication even with --no-auth (ex: ws)" default:""`
 	EnableRandomUrl     bool   `hcl:"enable_random_url" flagName:"random-url" flagSName:"r" flagDescribe:"Add a random string to the URL" default:"false"`
 	RandomUrlLength     int    `hcl:"random_url_length" flagName:"random-url-length" flagDescribe:"Random URL length" default:"8"`
 	EnableTLS           bool   `hcl:"enable_tls" flagName:"tls" flagSName:"t" flagDescribe:"Enable TLS/SSL" default:"false"`
 	TLSCrtFile          string `hcl:"tls_crt_file" flagName:"tls-crt" flagDescribe:"TLS/SSL certificate file path" default:"~/.gotty.crt"`
 	TLSKeyFile          string `hcl:"tls_key_file" flagName:"tls-key" flagDescribe:"TLS/SSL key file path" default:"~/.gotty.key"`
+	EnableACME          bool   `hcl:"enable_acme" flagName:"acme" flagDescribe:"Obtain and renew TLS certificates from Let's Encrypt instead of using tls-crt and tls-key (needs port 443 reachable from the internet)" default:"false"`
+	ACMEDomains         string `hcl:"acme_domains" flagName:"acme-domains" flagDescribe:"Comma separated domains to obtain certificates for with ACME" default:""`
+	ACMECacheDir        string `hcl:"acme_cache_dir" flagName:"acme-cache-dir" flagDescribe:"Directory keeping ACME certificates and account keys" default:"~/.gotty.acme"`
 	EnableTLSClientAuth bool   `hcl:"enable_tls_client_auth" default:"false"`
 	TLSCACrtFile        string `hcl:"tls_ca_crt_file" flagName:"tls-ca-crt" flagDescribe:"TLS/SSL CA certificate file for client certifications" default:"~/.gotty.ca.crt"`
+	TLSALPN             string `hcl:"tls_alpn" flagName:"tls-alpn" flagDescribe:"Comma separated ALPN protocols offered by the TLS server in order of preference: h2 and http/1.1 (WebTransport always uses h3)" default:"h2,http/1.1"`
+	TLSMinVersion       string `hcl:"tls_min_version" flagName:"tls-min-version" flagDescribe:"Minimum TLS version accepted by the TLS server: 1.0, 1.1, 1.2 or 1.3 (WebTransport always uses 1.3)" default:"1.2"`
+	TLSCipherSuites     string `hcl:"tls_cipher_suites" flagName:"tls-cipher-suites" flagDescribe:"Comma separated cipher suites of the TLS server for TLS 1.2 and below, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 (default: Go's secure suites)" default:""`
 	IndexFile           string `hcl:"index_file" flagName:"index" flagDescribe:"Custom index.html file" default:""`
+	ReloadIndex         bool   `hcl:"reload_index" flagName:"reload-index" flagDescribe:"Reload the custom index.html file when it changes" default:"false"`
 	TitleFormat         string `hcl:"title_format" flagName:"title-format" flagSName:"" flagDescribe:"Title format of browser window" default:"{{ .command }}@{{ .hostname }}"`
+	SessionLogPath      string `hcl:"session_log_path" flagName:"session-log-path" flagDescribe:"Path template to record each session's output to (variables: ip, ts, session, id), e.g. /logs/{{ .ip }}-{{ .ts }}.log" default:""`
+	RecordDir           string `hcl:"record_dir" flagName:"record-dir" flagDescribe:"Directory to record each session to as an asciinema v2 cast file, with client input when permit-write is set" default:""`
+	RecordMaxBytes      int    `hcl:"record_max_bytes" flagName:"record-max-bytes" flagDescribe:"Stop a session recording before it grows past this many bytes (0 for unlimited)" default:"0"`
+	TitleInterval       int    `hcl:"title_interval" flagName:"title-interval" flagDescribe:"Refresh the window title from the backend, sending changes at most once per this many seconds (0 to disable)" default:"0"`
 	EnableReconnect     bool   `hcl:"enable_reconnect" flagName:"reconnect" flagDescribe:"Enable reconnection" default:"true"`
 	ReconnectTime       int    `hcl:"reconnect_time" flagName:"reconnect-time" flagDescribe:"Time to reconnect" default:"3"`
-	MaxConnection       int    `hcl:"max_connection" flagName:"max-connection" flagDescribe:"Maximum connection to gotty" default:"0"`
+	ResumeTimeout       int    `hcl:"resume_timeout" flagName:"resume-timeout" flagDescribe:"Seconds to keep the backend of a disconnected client so that the same user and IP can resume its session, over WebSocket or WebTransport (0 to disable)" default:"0"`
+	ReplayBufferSize    int    `hcl:"replay_buffer_size" flagName:"replay-buffer-size" flagDescribe:"Bytes of recent output to replay to clients reconnecting to a session, 0 to disable" default:"0"`
+	StartupBufferSize   int    `hcl:"startup_buffer_size" flagName:"startup-buffer-size" flagDescribe:"Bytes of output a backend may produce while its connection is set up, sent once the client is ready (0 to leave it in the backend until then)" default:"0"`
+	ClearOnConnect      bool   `hcl:"clear_on_connect" flagName:"clear-on-connect" flagDescribe:"Clear the client's terminal before sending any output" default:"false"`
+	CompressOutput      bool   `hcl:"compress_output" flagName:"compress-output" flagDescribe:"Compress terminal output with DEFLATE and a preset dictionary of common escape sequences (needs a browser with DecompressionStream)" default:"false"`
+	OutputCoalesce      int    `hcl:"output_coalesce" flagName:"output-coalesce" flagDescribe:"Milliseconds to batch terminal output into fewer messages, 0 to send it right away" default:"0"`
+	CoalesceFlushOn     string `hcl:"coalesce_flush_on" flagName:"coalesce-flush-on" flagDescribe:"Comma separated byte patterns, with Go escapes, that send batched output right away (ex: \\a,$ )" default:"\\a"`
+	MaxConnection       int    `hcl:"max_connection" flagName:"max-connection" flagDescribe:"Maximum connection to gotty (0 for unlimited)" default:"0"`
+	FullPage            string `hcl:"full_page" flagName:"full-page" flagDescribe:"HTML or JSON file served with 503 when max-connection is reached, by its extension (empty for a built-in page)" default:""`
+	FullRetryAfter      int    `hcl:"full_retry_after" flagName:"full-retry-after" flagDescribe:"Seconds clients are asked to wait before retrying when max-connection is reached" default:"10"`
+	CloseGracePeriod    int    `hcl:"close_grace_period" flagName:"close-grace-period" flagDescribe:"Milliseconds to wait for the client to acknowledge a WebSocket close, 0 to close immediately" default:"0"`
+	WriteQueueDepth     int    `hcl:"write_queue_depth" flagName:"write-queue-depth" flagDescribe:"Messages queued per connection for a slow client, 0 to write to the client directly" default:"0"`
+	WriteQueuePolicy    string `hcl:"write_queue_policy" flagName:"write-queue-policy" flagDescribe:"What to do when a write queue is full: block waits up to write-queue-timeout and then closes the connection, evict drops the oldest message, drop drops the new one" default:"block"`
+	WriteQueueTimeout   int    `hcl:"write_queue_timeout" flagName:"write-queue-timeout" flagDescribe:"Milliseconds to wait for room in a full write queue, and for it to drain on disconnect" default:"5000"`
+	OverloadCooldown    int    `hcl:"overload_cooldown" flagName:"overload-cooldown" flagDescribe:"Seconds to reject new connections after the backend reports it is overloaded" default:"10"`
+	ShedConnections     int    `hcl:"shed_connections" flagName:"shed-connections" flagDescribe:"Reject new connections with 503 while this many are active, before they are upgraded (0 to disable)" default:"0"`
+	ShedRetryAfter      int    `hcl:"shed_retry_after" flagName:"shed-retry-after" flagDescribe:"Seconds clients are asked to wait before retrying a connection rejected under high load" default:"5"`
+	TmuxSessionRate     int    `hcl:"tmux_session_rate" flagName:"tmux-session-rate" flagDescribe:"Maximum new tmux sessions per minute when each connection creates one, rejecting more with 429 (0 for unlimited)" default:"0"`
+	MaxTmuxSessions     int    `hcl:"max_tmux_sessions" flagName:"max-tmux-sessions" flagDescribe:"Maximum tmux sessions alive at a time on the tmux server, including those left by closed connections, when each connection creates one, rejecting more with 503 (0 for unlimited)" default:"0"`
+	ExposeTmuxSession   bool   `hcl:"expose_tmux_session" flagName:"expose-tmux-session" flagDescribe:"Tell the frontend the name of the attached tmux session as gotty_tmux_session in config.js" default:"false"`
+	ExposeClientIP      bool   `hcl:"expose_client_ip" flagName:"expose-client-ip" flagDescribe:"Tell the frontend the client IP seen by the server, after trusted proxies, in the ready message" default:"false"`
+	BlockConnections    bool   `hcl:"block_connections" flagName:"block-connections" flagDescribe:"Reject all new terminal connections" default:"false"`
+	ImmediateExitWindow int    `hcl:"immediate_exit_window" flagName:"immediate-exit-window" flagDescribe:"Seconds within which a failing command exit is reported to the client, 0 to disable" default:"0"`
+	ConnLogInterval     int    `hcl:"conn_log_interval" flagName:"conn-log-interval" flagDescribe:"Log the number of active connections and their peak every this many seconds (0 to disable)" default:"0"`
 	Once                bool   `hcl:"once" flagName:"once" flagDescribe:"Accept only one client and exit on disconnection" default:"false"`
+	MaxSessions         int    `hcl:"max_sessions" flagName:"max-sessions" flagDescribe:"Exit after serving this many sessions (0 for unlimited)" default:"0"`
 	Timeout             int    `hcl:"timeout" flagName:"timeout" flagDescribe:"Timeout seconds for waiting a client(0 to disable)" default:"0"`
+	IdleTimeout         int    `hcl:"idle_timeout" flagName:"idle-timeout" flagDescribe:"Close a session after this many seconds without input from its client (0 to disable)" default:"0"`
+	IdleCountsOutput    bool   `hcl:"idle_counts_output" flagName:"idle-counts-output" flagDescribe:"Let output to the client keep a session from reaching idle-timeout" default:"false"`
 	PermitArguments     bool   `hcl:"permit_arguments" flagName:"permit-arguments" flagDescribe:"Permit clients to send command line arguments in URL (e.g. http://example.com:8080/?arg=AAA&arg=BBB)" default:"false"`
+	ArgumentDenylist    string `hcl:"argument_denylist" flagName:"argument-denylist" flagDescribe:"Regular expression rejecting connections whose permitted arg values match it, such as [;&|$\\x60<>\\n]|\\.\\. for shell metacharacters and .. (empty to allow all)" default:""`
+	DuplicateInit       string `hcl:"duplicate_init" flagName:"duplicate-init" flagDescribe:"Handling of init messages sent after the handshake: reject closes the connection with a protocol error, ignore drops them" default:"reject"`
+	AuthTokenCSRF       bool   `hcl:"auth_token_csrf" flagName:"auth-token-csrf" flagDescribe:"Require an X-Requested-With header to fetch auth tokens, blocking cross-site requests (for cookie-based auth)" default:"false"`
+	ReauthOnReconnect   bool   `hcl:"reauth_on_reconnect" flagName:"reauth-on-reconnect" flagDescribe:"Accept each auth token only once, so that every reconnect authenticates again" default:"false"`
+	AuthTokenIdle       int    `hcl:"auth_token_idle" flagName:"auth-token-idle" flagDescribe:"Also expire auth tokens after this many seconds without use, extending them on each use up to one hour after issue (0 to disable)" default:"0"`
+	SecureEndpoints     bool   `hcl:"secure_endpoints" flagName:"secure-endpoints" flagDescribe:"Refuse auth_token.js and config.js over plain HTTP, unless one of the trusted-proxies sets X-Forwarded-Proto to https" default:"false"`
 	PassHeaders         bool   `hcl:"pass_headers" flagName:"pass-headers" flagDescribe:"Pass HTTP request headers as environment variables (e.g. Cookie becomes HTTP_COOKIE)" default:"false"`
 	Width               int    `hcl:"width" flagName:"width" flagDescribe:"Static width of the screen, 0(default) means dynamically resize" default:"0"`
 	Height              int    `hcl:"height" flagName:"height" flagDescribe:"Static height of the screen, 0(default) means dynamically resize" default:"0"`
+	ResizePresets       string `hcl:"resize_presets" flagName:"resize-presets" flagDescribe:"Comma separated terminal sizes to snap client resizes to (e.g. 80x24,120x40)" default:""`
+	MaxResizeArea       int    `hcl:"max_resize_area" flagName:"max-resize-area" flagDescribe:"Maximum terminal area (columns * rows) for client resizes, 0 to disable" default:"0"`
 	WSOrigin            string `hcl:"ws_origin" flagName:"ws-origin" flagDescribe:"A regular expression that matches origin URLs to be accepted by WebSocket. No cross origin requests are acceptable by default" default:""`
+	AutoOrigin          bool   `hcl:"auto_origin" flagName:"auto-origin" flagDescribe:"Only accept WebSocket/WebTransport requests with an Origin matching the requested host" default:"false"`
 	WSQueryArgs         string `hcl:"ws_query_args" flagName:"ws-query-args" flagDescribe:"Querystring arguments to append to the websocket instantiation" default:""`
+	WSSubprotocols      string `hcl:"ws_subprotocols" flagName:"ws-subprotocols" flagDescribe:"Comma separated WebSocket subprotocols to accept, in order of preference; the one agreed on is echoed to the client" default:"webtty"`
+	WSRequireProtocol   bool   `hcl:"ws_require_protocol" flagName:"ws-require-protocol" flagDescribe:"Fail WebSocket upgrades that offer none of the accepted subprotocols" default:"false"`
+	WSPingInterval      int    `hcl:"ws_ping_interval" flagName:"ws-ping-interval" flagDescribe:"Seconds between keep-alive pings, which also measure the round trip time over WebSocket and are sent by QUIC over WebTransport (0 to disable)" default:"0"`
+	WSPongWait          int    `hcl:"ws_pong_wait" flagName:"ws-pong-wait" flagDescribe:"Drop a client that sends nothing, not even a pong, for this many seconds, as the QUIC idle timeout over WebTransport (0 to disable, leaving WebTransport at the QUIC default of 30)" default:"0"`
+	WSPingPayload       string `hcl:"ws_ping_payload" flagName:"ws-ping-payload" flagDescribe:"Payload of WebSocket keep-alive pings, a sequence number by default" default:""`
+	TrimPartialOutput   bool   `hcl:"trim_partial_output" flagName:"trim-partial-output" flagDescribe:"Hold back escape sequences split across reads and drop an incomplete one on disconnect" default:"false"`
 	EnableWebGL         bool   `hcl:"enable_webgl" flagName:"enable-webgl" flagDescribe:"Enable WebGL renderer" default:"true"`
 	Quiet               bool   `hcl:"quiet" flagName:"quiet" flagDescribe:"Don't log" default:"false"`
+	HealthCheckPath     string `hcl:"health_check_path" flagName:"health-check-path" flagDescribe:"Subpath of the health check endpoint, empty to disable (e.g. healthz)" default:""`
+	HealthCheckBackend  bool   `hcl:"health_check_backend" flagName:"health-check-backend" flagDescribe:"Create and close a backend on health checks, at most every 10 seconds (spawns the command)" default:"false"`
+	EnableStream        bool   `hcl:"enable_stream" flagName:"stream" flagDescribe:"Serve the output of running sessions to read-only viewers as Server-Sent Events at /stream, the most recent session or ?session=<id> (requires authentication)" default:"false"`
+	EnableMetrics       bool   `hcl:"enable_metrics" flagName:"metrics" flagDescribe:"Serve Prometheus metrics at /metrics (behind Basic Authentication when enabled)" default:"false"`
+	SelfTest            bool   `hcl:"self_test" flagName:"self-test" flagDescribe:"Serve a throwaway terminal over loopback connections at startup and exit if it does not work (spawns the command)" default:"false"`
 
 	// WebTransport options (uses same port as HTTP server, but UDP instead of TCP)
 	EnableWebTransport bool `hcl:"enable_webtransport" flagName:"webtransport" flagDescribe:"Enable WebTransport support (requires TLS, uses same port over UDP)" default:"false"`
+	WTStreamMaxBytes   int  `hcl:"wt_stream_max_bytes" flagName:"wt-stream-max-bytes" flagDescribe:"Move WebTransport output to a new stream after this many bytes on one stream, 0 to disable" default:"0"`
+	WTChecksum         bool `hcl:"wt_checksum" flagName:"wt-checksum" flagDescribe:"Append a CRC32 checksum to each WebTransport frame and close the connection on a mismatch" default:"false"`
+	WTMaxStreams       int  `hcl:"wt_max_streams" flagName:"wt-max-streams" flagDescribe:"Reset streams a WebTransport client opens beyond this many per session, 0 to disable" default:"0"`
+	WTDatagrams        bool `hcl:"wt_datagrams" flagName:"wt-datagrams" flagDescribe:"Let WebTransport clients send small input as datagrams, which avoid head-of-line blocking but may be lost" default:"false"`
+
+	// Credentials are more `user:pass` entries accepted besides Credential.
+	// They are only read from the config file, as the commas separating
+	// list flags may appear in password hashes.
+	Credentials []string `hcl:"credentials"`
 
 	TitleVariables map[string]interface{}
+	// OutputTransform wraps the writer receiving the output of each session,
+	// e.g. to strip colors or add timestamps, before it is sent to the client.
+	OutputTransform func(io.Writer) io.Writer
+	// OutputSinks returns extra writers receiving a copy of the output of the
+	// session with the given request ID, e.g. to forward it to a webhook or a
+	// log. A failing sink is dropped without affecting the client, and sinks
+	// implementing io.Closer are closed when the session ends.
+	OutputSinks func(requestID string) []io.Writer
+	// LoadFunc reports whether the server is under high load, e.g. from
+	// system metrics. New connections are rejected while it returns true.
+	LoadFunc func() bool
 }
 
+// Defaults of the certificate files, which are not used with ACME.
+const (
+	defaultTLSCrtFile = "~/.gotty.crt"
+	defaultTLSKeyFile = "~/.gotty.key"
+)
+
 func (options *Options) Validate() error {
 	if options.EnableTLSClientAuth && !options.EnableTLS {
 		return errors.New("TLS client authentication is enabled, but TLS is not enabled")
@@ -49,11 +159,365 @@ func (options *Options) Validate() error {
 	if options.EnableWebTransport && !options.EnableTLS {
 		return errors.New("WebTransport requires TLS to be enabled")
 	}
+	if options.EnableACME {
+		if !options.EnableTLS {
+			return errors.New("ACME requires TLS to be enabled")
+		}
+		if len(parseACMEDomains(options.ACMEDomains)) == 0 {
+			return errors.New("ACME requires acme-domains")
+		}
+		if (options.TLSCrtFile != "" && options.TLSCrtFile != defaultTLSCrtFile) ||
+			(options.TLSKeyFile != "" && options.TLSKeyFile != defaultTLSKeyFile) {
+			return errors.New("ACME and tls-crt or tls-key cannot be used together")
+		}
+	}
+	if _, err := parseTLSVersion(options.TLSMinVersion); err != nil {
+		return err
+	}
+	cipherSuites, err := parseCipherSuites(options.TLSCipherSuites)
+	if err != nil {
+		return err
+	}
+	if alpn, err := parseALPN(options.TLSALPN); err == nil {
+		if err := checkHTTP2CipherSuites(cipherSuites, alpn); err != nil {
+			return err
+		}
+	}
+	if options.WTChecksum && !options.EnableWebTransport {
+		return errors.New("wt-checksum requires WebTransport to be enabled")
+	}
+	if options.WTDatagrams && !options.EnableWebTransport {
+		return errors.New("wt-datagrams requires WebTransport to be enabled")
+	}
+	switch options.AuthMode {
+	case "", "basic":
+		if options.JWTPublicKeyFile != "" || options.JWTAudience != "" || options.JWTClaim != "" {
+			return errors.New("jwt-public-key, jwt-audience and jwt-claim require auth-mode jwt")
+		}
+	case "jwt":
+		if !options.EnableBasicAuth {
+			return errors.New("auth-mode jwt requires authentication to be enabled")
+		}
+		if options.JWTPublicKeyFile == "" {
+			return errors.New("auth-mode jwt requires jwt-public-key")
+		}
+		if options.JWTClaim != "" && !strings.Contains(options.JWTClaim, "=") {
+			return errors.New("jwt-claim must be name=value")
+		}
+	default:
+		return errors.New("auth-mode must be one of basic or jwt")
+	}
+	if options.TOTPSecret != "" {
+		if !options.EnableBasicAuth || options.AuthMode == "jwt" {
+			return errors.New("totp-secret requires Basic Authentication to be enabled")
+		}
+		if _, err := parseTOTPSecret(options.TOTPSecret); err != nil {
+			return err
+		}
+	}
 	if options.PermitArguments && !options.EnableBasicAuth {
 		return errors.New("permit-arguments requires authentication to be enabled")
 	}
 	if options.PassHeaders && !options.EnableBasicAuth {
 		return errors.New("pass-headers requires authentication to be enabled")
 	}
+	if options.EnableStream && !options.EnableBasicAuth {
+		return errors.New("stream requires authentication to be enabled")
+	}
+	if options.ReauthOnReconnect && !options.EnableBasicAuth {
+		return errors.New("reauth-on-reconnect requires authentication to be enabled")
+	}
+	if _, ok := lineEndings[options.InputLineEnding]; !ok && options.InputLineEnding != "" {
+		return errors.New("input-line-ending must be one of lf, cr or crlf")
+	}
+	switch options.DuplicateInit {
+	case "", "reject", "ignore":
+	default:
+		return errors.New("duplicate-init must be one of reject or ignore")
+	}
+	if options.WriteQueueDepth < 0 {
+		return errors.New("write-queue-depth must not be negative")
+	}
+	switch options.WriteQueuePolicy {
+	case "", "block", "evict", "drop":
+	default:
+		return errors.New("write-queue-policy must be one of block, evict or drop")
+	}
+	if options.WriteQueueTimeout < 0 {
+		return errors.New("write-queue-timeout must not be negative")
+	}
+	switch options.AddressFamily {
+	case "", "ipv4", "ipv6", "dual":
+	default:
+		return errors.New("address-family must be one of ipv4, ipv6 or dual")
+	}
+	if options.AuthPaths != "" && !options.EnableBasicAuth && options.Credential == "" && len(options.Credentials) == 0 && options.CredentialFile == "" {
+		return errors.New("auth-paths requires a credential")
+	}
+	if _, err := parseNetworks(options.AllowedCIDRs); err != nil {
+		return errors.Wrapf(err, "allowed-cidrs")
+	}
+	if _, err := parseNetworks(options.DeniedCIDRs); err != nil {
+		return errors.Wrapf(err, "denied-cidrs")
+	}
+	if options.AutoOrigin && options.WSOrigin != "" {
+		return errors.New("auto-origin and ws-origin cannot be used together")
+	}
+	if options.AuthMaxFailures < 0 {
+		return errors.New("auth-max-failures must not be negative")
+	}
+	if options.AuthLockoutBase < 0 {
+		return errors.New("auth-lockout-base must not be negative")
+	}
+	if options.AuthGlobalThreshold < 0 {
+		return errors.New("auth-global-threshold must not be negative")
+	}
+	if options.AuthCleanupInterval < 0 {
+		return errors.New("auth-cleanup-interval must not be negative")
+	}
+	if options.RecordMaxBytes < 0 {
+		return errors.New("record-max-bytes must not be negative")
+	}
+	if options.UserLockout < 0 {
+		return errors.New("user-lockout must not be negative")
+	}
+	if options.UserLockoutTime < 0 {
+		return errors.New("user-lockout-time must not be negative")
+	}
+	if options.AuthTokenIdle < 0 {
+		return errors.New("auth-token-idle must not be negative")
+	}
+	if options.OutputCoalesce < 0 {
+		return errors.New("output-coalesce must not be negative")
+	}
+	if options.MaxResizeArea < 0 {
+		return errors.New("max-resize-area must not be negative")
+	}
+	if options.ShedConnections < 0 {
+		return errors.New("shed-connections must not be negative")
+	}
+	if options.ShedRetryAfter < 0 {
+		return errors.New("shed-retry-after must not be negative")
+	}
+	if options.TmuxSessionRate < 0 {
+		return errors.New("tmux-session-rate must not be negative")
+	}
+	if options.MaxTmuxSessions < 0 {
+		return errors.New("max-tmux-sessions must not be negative")
+	}
+	if options.MaxSessions < 0 {
+		return errors.New("max-sessions must not be negative")
+	}
+	if options.ReplayBufferSize < 0 {
+		return errors.New("replay-buffer-size must not be negative")
+	}
+	if options.StartupBufferSize < 0 {
+		return errors.New("startup-buffer-size must not be negative")
+	}
+	if options.OverloadCooldown < 0 {
+		return errors.New("overload-cooldown must not be negative")
+	}
+	if options.CloseGracePeriod < 0 {
+		return errors.New("close-grace-period must not be negative")
+	}
+	if options.TitleInterval < 0 {
+		return errors.New("title-interval must not be negative")
+	}
+	if options.ResumeTimeout < 0 {
+		return errors.New("resume-timeout must not be negative")
+	}
+	if options.ConnLogInterval < 0 {
+		return errors.New("conn-log-interval must not be negative")
+	}
+	if options.WTStreamMaxBytes < 0 {
+		return errors.New("wt-stream-max-bytes must not be negative")
+	}
+	if options.MaxConnection < 0 {
+		return errors.New("max-connection must not be negative (use 0 for unlimited)")
+	}
+	if options.FullRetryAfter < 0 {
+		return errors.New("full-retry-after must not be negative")
+	}
+	if err := validateCredential(options.Credential); err != nil {
+		return err
+	}
+	for _, credential := range options.Credentials {
+		if strings.Count(credential, ":") != 1 {
+			return errors.Errorf("credential for `%s` must contain exactly one colon", strings.SplitN(credential, ":", 2)[0])
+		}
+		if err := validateCredential(credential); err != nil {
+			return err
+		}
+	}
+	if options.IdleTimeout < 0 {
+		return errors.New("idle-timeout must not be negative")
+	}
+	if options.WTMaxStreams < 0 {
+		return errors.New("wt-max-streams must not be negative")
+	}
+	if options.WSPingInterval < 0 {
+		return errors.New("ws-ping-interval must not be negative")
+	}
+	if options.WSPongWait < 0 {
+		return errors.New("ws-pong-wait must not be negative")
+	}
+	if options.WSPongWait > 0 && (options.WSPingInterval == 0 || options.WSPongWait <= options.WSPingInterval) {
+		return errors.New("ws-pong-wait must be longer than ws-ping-interval")
+	}
+	if len(options.WSPingPayload) > 125 {
+		return errors.New("ws-ping-payload must not be longer than 125 bytes")
+	}
 	return nil
 }
+
+// parseResizePresets parses a comma separated list of sizes such as "80x24,120x40".
+func parseResizePresets(presets string) ([]webtty.TerminalSize, error) {
+	sizes := []webtty.TerminalSize{}
+	for _, preset := range strings.Split(presets, ",") {
+		preset = strings.TrimSpace(preset)
+		if preset == "" {
+			continue
+		}
+		parts := strings.SplitN(strings.ToLower(preset), "x", 2)
+		if len(parts) != 2 {
+			return nil, errors.Errorf("invalid resize preset `%s`, expected COLUMNSxROWS", preset)
+		}
+		columns, err := strconv.Atoi(parts[0])
+		if err != nil || columns <= 0 {
+			return nil, errors.Errorf("invalid columns in resize preset `%s`", preset)
+		}
+		rows, err := strconv.Atoi(parts[1])
+		if err != nil || rows <= 0 {
+			return nil, errors.Errorf("invalid rows in resize preset `%s`", preset)
+		}
+		sizes = append(sizes, webtty.TerminalSize{Columns: columns, Rows: rows})
+	}
+	return sizes, nil
+}
+
+// parseFlushPatterns turns the comma separated CoalesceFlushOn option into
+// byte patterns, interpreting Go escapes such as \a or \x1b.
+func parseFlushPatterns(patterns string) ([][]byte, error) {
+	result := [][]byte{}
+	for _, pattern := range strings.Split(patterns, ",") {
+		if pattern == "" {
+			continue
+		}
+		unquoted, err := strconv.Unquote(`"` + strings.ReplaceAll(pattern, `"`, `\"`) + `"`)
+		if err != nil {
+			return nil, errors.Errorf("invalid flush pattern `%s`", pattern)
+		}
+		result = append(result, []byte(unquoted))
+	}
+	return result, nil
+}
+
+// parseSubprotocols turns the comma separated WSSubprotocols option into a
+// list, falling back to the webtty protocol.
+func parseSubprotocols(subprotocols string) []string {
+	result := []string{}
+	for _, subprotocol := range strings.Split(subprotocols, ",") {
+		if subprotocol = strings.TrimSpace(subprotocol); subprotocol != "" {
+			result = append(result, subprotocol)
+		}
+	}
+	if len(result) == 0 {
+		return webtty.Protocols
+	}
+	return result
+}
+
+// parseALPN turns the comma separated TLSALPN option into a list of the
+// protocols the TLS server supports, falling back to h2 and http/1.1.
+func parseALPN(protocols string) ([]string, error) {
+	result := []string{}
+	for _, protocol := range strings.Split(protocols, ",") {
+		protocol = strings.TrimSpace(protocol)
+		switch protocol {
+		case "":
+			continue
+		case "h2", "http/1.1":
+			result = append(result, protocol)
+		default:
+			return nil, errors.Errorf("tls-alpn must only contain h2 or http/1.1, got `%s`", protocol)
+		}
+	}
+	if len(result) == 0 {
+		return []string{"h2", "http/1.1"}, nil
+	}
+	return result, nil
+}
+
+// tlsVersions are the values of the TLSMinVersion option.
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion turns the TLSMinVersion option into a TLS version,
+// falling back to TLS 1.2.
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return tls.VersionTLS12, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, errors.Errorf("tls-min-version must be one of 1.0, 1.1, 1.2 or 1.3, got `%s`", version)
+	}
+	return v, nil
+}
+
+// parseCipherSuites turns the comma separated TLSCipherSuites option into
+// cipher suite IDs. Only the suites Go considers secure are accepted, and
+// nil means Go's defaults.
+func parseCipherSuites(names string) ([]uint16, error) {
+	var result []uint16
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := cipherSuiteID(name)
+		if !ok {
+			return nil, errors.Errorf("tls-cipher-suites contains an unknown or insecure cipher suite `%s`", name)
+		}
+		result = append(result, id)
+	}
+	return result, nil
+}
+
+// checkHTTP2CipherSuites fails when h2 is offered with cipher suites that
+// HTTP/2 does not allow, which the HTTP server would refuse to serve.
+func checkHTTP2CipherSuites(suites []uint16, alpn []string) error {
+	if suites == nil || !slices.Contains(alpn, "h2") {
+		return nil
+	}
+	if slices.Contains(suites, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) ||
+		slices.Contains(suites, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256) {
+		return nil
+	}
+	return errors.New("tls-cipher-suites must contain TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 when tls-alpn offers h2")
+}
+
+// cipherSuiteID returns the ID of the secure cipher suite with name.
+func cipherSuiteID(name string) (uint16, bool) {
+	for _, suite := range tls.CipherSuites() {
+		if suite.Name == name {
+			return suite.ID, true
+		}
+	}
+	return 0, false
+}
+
+// parseACMEDomains turns the comma separated ACMEDomains option into a list.
+func parseACMEDomains(domains string) []string {
+	result := []string{}
+	for _, domain := range strings.Split(domains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			result = append(result, domain)
+		}
+	}
+	return result
+}
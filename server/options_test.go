@@ -1,6 +1,7 @@
 package server
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -87,6 +88,444 @@ func TestOptionsValidate(t *testing.T) {
 			// Should fail on the first check (TLS client auth)
 			errMsg: "TLS client authentication is enabled, but TLS is not enabled",
 		},
+		{
+			name: "invalid - wt checksum without WebTransport",
+			options: &Options{
+				WTChecksum: true,
+			},
+			wantErr: true,
+			errMsg:  "wt-checksum requires WebTransport to be enabled",
+		},
+		{
+			name: "valid - TLS 1.3 minimum with cipher suites",
+			options: &Options{
+				EnableTLS:       true,
+				TLSMinVersion:   "1.3",
+				TLSCipherSuites: "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - unknown TLS version",
+			options: &Options{
+				TLSMinVersion: "1.4",
+			},
+			wantErr: true,
+			errMsg:  "tls-min-version must be one of 1.0, 1.1, 1.2 or 1.3, got `1.4`",
+		},
+		{
+			name: "invalid - insecure cipher suite",
+			options: &Options{
+				TLSCipherSuites: "TLS_RSA_WITH_RC4_128_SHA",
+			},
+			wantErr: true,
+			errMsg:  "tls-cipher-suites contains an unknown or insecure cipher suite `TLS_RSA_WITH_RC4_128_SHA`",
+		},
+		{
+			name: "invalid - cipher suites not allowed by HTTP/2",
+			options: &Options{
+				TLSCipherSuites: "TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384",
+			},
+			wantErr: true,
+			errMsg:  "tls-cipher-suites must contain TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256 or TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 when tls-alpn offers h2",
+		},
+		{
+			name: "valid - JWT auth mode",
+			options: &Options{
+				EnableBasicAuth:  true,
+				AuthMode:         "jwt",
+				JWTPublicKeyFile: "jwt.pem",
+				JWTAudience:      "webtmux",
+				JWTClaim:         "groups=ops",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - unknown auth mode",
+			options: &Options{
+				AuthMode: "oauth",
+			},
+			wantErr: true,
+			errMsg:  "auth-mode must be one of basic or jwt",
+		},
+		{
+			name: "invalid - JWT auth mode without a public key",
+			options: &Options{
+				EnableBasicAuth: true,
+				AuthMode:        "jwt",
+			},
+			wantErr: true,
+			errMsg:  "auth-mode jwt requires jwt-public-key",
+		},
+		{
+			name: "invalid - JWT auth mode without authentication",
+			options: &Options{
+				AuthMode:         "jwt",
+				JWTPublicKeyFile: "jwt.pem",
+			},
+			wantErr: true,
+			errMsg:  "auth-mode jwt requires authentication to be enabled",
+		},
+		{
+			name: "invalid - JWT claim without a value",
+			options: &Options{
+				EnableBasicAuth:  true,
+				AuthMode:         "jwt",
+				JWTPublicKeyFile: "jwt.pem",
+				JWTClaim:         "groups",
+			},
+			wantErr: true,
+			errMsg:  "jwt-claim must be name=value",
+		},
+		{
+			name: "invalid - JWT audience in basic auth mode",
+			options: &Options{
+				JWTAudience: "webtmux",
+			},
+			wantErr: true,
+			errMsg:  "jwt-public-key, jwt-audience and jwt-claim require auth-mode jwt",
+		},
+		{
+			name: "valid - TOTP with Basic Authentication",
+			options: &Options{
+				EnableBasicAuth: true,
+				TOTPSecret:      "JBSWY3DPEHPK3PXP",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - TOTP without Basic Authentication",
+			options: &Options{
+				TOTPSecret: "JBSWY3DPEHPK3PXP",
+			},
+			wantErr: true,
+			errMsg:  "totp-secret requires Basic Authentication to be enabled",
+		},
+		{
+			name: "invalid - TOTP secret not base32",
+			options: &Options{
+				EnableBasicAuth: true,
+				TOTPSecret:      "not base32!",
+			},
+			wantErr: true,
+			errMsg:  "totp-secret must be base32: illegal base32 data at input byte 9",
+		},
+		{
+			name: "valid - allowed and denied CIDRs",
+			options: &Options{
+				AllowedCIDRs: "192.0.2.0/24, 2001:db8::/32",
+				DeniedCIDRs:  "192.0.2.66",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - allowed CIDR",
+			options: &Options{
+				AllowedCIDRs: "192.0.2.0/33",
+			},
+			wantErr: true,
+			errMsg:  "allowed-cidrs: invalid IP or CIDR `192.0.2.0/33`",
+		},
+		{
+			name: "invalid - denied CIDR",
+			options: &Options{
+				DeniedCIDRs: "office",
+			},
+			wantErr: true,
+			errMsg:  "denied-cidrs: invalid IP or CIDR `office`",
+		},
+		{
+			name: "invalid - wt datagrams without WebTransport",
+			options: &Options{
+				WTDatagrams: true,
+			},
+			wantErr: true,
+			errMsg:  "wt-datagrams requires WebTransport to be enabled",
+		},
+		{
+			name: "invalid - reauth on reconnect without auth",
+			options: &Options{
+				ReauthOnReconnect: true,
+			},
+			wantErr: true,
+			errMsg:  "reauth-on-reconnect requires authentication to be enabled",
+		},
+		{
+			name: "invalid - auth paths without credential",
+			options: &Options{
+				AuthPaths: "ws",
+			},
+			wantErr: true,
+			errMsg:  "auth-paths requires a credential",
+		},
+		{
+			name: "invalid - unknown input line ending",
+			options: &Options{
+				InputLineEnding: "lfcr",
+			},
+			wantErr: true,
+			errMsg:  "input-line-ending must be one of lf, cr or crlf",
+		},
+		{
+			name: "invalid - unknown duplicate init handling",
+			options: &Options{
+				DuplicateInit: "accept",
+			},
+			wantErr: true,
+			errMsg:  "duplicate-init must be one of reject or ignore",
+		},
+		{
+			name: "invalid - unknown address family",
+			options: &Options{
+				AddressFamily: "ipx",
+			},
+			wantErr: true,
+			errMsg:  "address-family must be one of ipv4, ipv6 or dual",
+		},
+		{
+			name: "invalid - auto origin with ws origin",
+			options: &Options{
+				AutoOrigin: true,
+				WSOrigin:   ".*",
+			},
+			wantErr: true,
+			errMsg:  "auto-origin and ws-origin cannot be used together",
+		},
+		{
+			name: "invalid - negative shed connections",
+			options: &Options{
+				ShedConnections: -1,
+			},
+			wantErr: true,
+			errMsg:  "shed-connections must not be negative",
+		},
+		{
+			name: "invalid - negative auth token idle",
+			options: &Options{
+				AuthTokenIdle: -1,
+			},
+			wantErr: true,
+			errMsg:  "auth-token-idle must not be negative",
+		},
+		{
+			name: "invalid - write queue policy",
+			options: &Options{
+				WriteQueuePolicy: "discard",
+			},
+			wantErr: true,
+			errMsg:  "write-queue-policy must be one of block, evict or drop",
+		},
+		{
+			name: "invalid - stream without auth",
+			options: &Options{
+				EnableStream: true,
+			},
+			wantErr: true,
+			errMsg:  "stream requires authentication to be enabled",
+		},
+		{
+			name: "invalid - negative auth max failures",
+			options: &Options{
+				AuthMaxFailures: -1,
+			},
+			wantErr: true,
+			errMsg:  "auth-max-failures must not be negative",
+		},
+		{
+			name: "invalid - negative auth lockout base",
+			options: &Options{
+				AuthLockoutBase: -1,
+			},
+			wantErr: true,
+			errMsg:  "auth-lockout-base must not be negative",
+		},
+		{
+			name: "invalid - negative user lockout",
+			options: &Options{
+				UserLockout: -1,
+			},
+			wantErr: true,
+			errMsg:  "user-lockout must not be negative",
+		},
+		{
+			name: "invalid - negative output coalesce",
+			options: &Options{
+				OutputCoalesce: -1,
+			},
+			wantErr: true,
+			errMsg:  "output-coalesce must not be negative",
+		},
+		{
+			name: "invalid - negative tmux session rate",
+			options: &Options{
+				TmuxSessionRate: -1,
+			},
+			wantErr: true,
+			errMsg:  "tmux-session-rate must not be negative",
+		},
+		{
+			name: "invalid - negative max tmux sessions",
+			options: &Options{
+				MaxTmuxSessions: -1,
+			},
+			wantErr: true,
+			errMsg:  "max-tmux-sessions must not be negative",
+		},
+		{
+			name: "invalid - negative max sessions",
+			options: &Options{
+				MaxSessions: -1,
+			},
+			wantErr: true,
+			errMsg:  "max-sessions must not be negative",
+		},
+		{
+			name: "invalid - negative replay buffer size",
+			options: &Options{
+				ReplayBufferSize: -1,
+			},
+			wantErr: true,
+			errMsg:  "replay-buffer-size must not be negative",
+		},
+		{
+			name: "invalid - negative startup buffer size",
+			options: &Options{
+				StartupBufferSize: -1,
+			},
+			wantErr: true,
+			errMsg:  "startup-buffer-size must not be negative",
+		},
+		{
+			name: "invalid - negative overload cooldown",
+			options: &Options{
+				OverloadCooldown: -1,
+			},
+			wantErr: true,
+			errMsg:  "overload-cooldown must not be negative",
+		},
+		{
+			name: "invalid - negative close grace period",
+			options: &Options{
+				CloseGracePeriod: -1,
+			},
+			wantErr: true,
+			errMsg:  "close-grace-period must not be negative",
+		},
+		{
+			name: "invalid - negative idle timeout",
+			options: &Options{
+				IdleTimeout: -1,
+			},
+			wantErr: true,
+			errMsg:  "idle-timeout must not be negative",
+		},
+		{
+			name: "invalid - negative WebTransport stream cap",
+			options: &Options{
+				WTMaxStreams: -1,
+			},
+			wantErr: true,
+			errMsg:  "wt-max-streams must not be negative",
+		},
+		{
+			name: "invalid - negative ping interval",
+			options: &Options{
+				WSPingInterval: -1,
+			},
+			wantErr: true,
+			errMsg:  "ws-ping-interval must not be negative",
+		},
+		{
+			name: "invalid - pong wait not longer than ping interval",
+			options: &Options{
+				WSPingInterval: 10,
+				WSPongWait:     10,
+			},
+			wantErr: true,
+			errMsg:  "ws-pong-wait must be longer than ws-ping-interval",
+		},
+		{
+			name: "invalid - pong wait without pings",
+			options: &Options{
+				WSPongWait: 30,
+			},
+			wantErr: true,
+			errMsg:  "ws-pong-wait must be longer than ws-ping-interval",
+		},
+		{
+			name: "invalid - ping payload too long",
+			options: &Options{
+				WSPingPayload: strings.Repeat("x", 126),
+			},
+			wantErr: true,
+			errMsg:  "ws-ping-payload must not be longer than 125 bytes",
+		},
+		{
+			name: "invalid - negative title refresh interval",
+			options: &Options{
+				TitleInterval: -1,
+			},
+			wantErr: true,
+			errMsg:  "title-interval must not be negative",
+		},
+		{
+			name: "invalid - negative WebTransport stream limit",
+			options: &Options{
+				WTStreamMaxBytes: -1,
+			},
+			wantErr: true,
+			errMsg:  "wt-stream-max-bytes must not be negative",
+		},
+		{
+			name: "invalid - negative max connection",
+			options: &Options{
+				MaxConnection: -1,
+			},
+			wantErr: true,
+			errMsg:  "max-connection must not be negative (use 0 for unlimited)",
+		},
+		{
+			name: "valid - ACME with default certificate files",
+			options: &Options{
+				EnableTLS:   true,
+				EnableACME:  true,
+				ACMEDomains: "example.com, www.example.com",
+				TLSCrtFile:  "~/.gotty.crt",
+				TLSKeyFile:  "~/.gotty.key",
+			},
+			wantErr: false,
+		},
+		{
+			name: "invalid - ACME without TLS",
+			options: &Options{
+				EnableACME:  true,
+				ACMEDomains: "example.com",
+			},
+			wantErr: true,
+			errMsg:  "ACME requires TLS to be enabled",
+		},
+		{
+			name: "invalid - ACME without domains",
+			options: &Options{
+				EnableTLS:   true,
+				EnableACME:  true,
+				ACMEDomains: " , ",
+			},
+			wantErr: true,
+			errMsg:  "ACME requires acme-domains",
+		},
+		{
+			name: "invalid - ACME with certificate files",
+			options: &Options{
+				EnableTLS:   true,
+				EnableACME:  true,
+				ACMEDomains: "example.com",
+				TLSCrtFile:  "/etc/ssl/server.crt",
+				TLSKeyFile:  "~/.gotty.key",
+			},
+			wantErr: true,
+			errMsg:  "ACME and tls-crt or tls-key cannot be used together",
+		},
 	}
 
 	for _, tt := range tests {
@@ -177,3 +616,51 @@ func TestOptionsWebTransportConfiguration(t *testing.T) {
 		})
 	}
 }
+
+func TestParseResizePresets(t *testing.T) {
+	sizes, err := parseResizePresets("80x24, 120X40")
+	if err != nil {
+		t.Fatalf("parseResizePresets() unexpected error: %v", err)
+	}
+	if len(sizes) != 2 || sizes[0].Columns != 80 || sizes[0].Rows != 24 || sizes[1].Columns != 120 || sizes[1].Rows != 40 {
+		t.Errorf("parseResizePresets() = %v, want [{80 24} {120 40}]", sizes)
+	}
+
+	sizes, err = parseResizePresets("")
+	if err != nil || len(sizes) != 0 {
+		t.Errorf("parseResizePresets(\"\") = %v, %v, want empty", sizes, err)
+	}
+
+	for _, invalid := range []string{"80", "ax24", "80x0", "-1x24"} {
+		if _, err := parseResizePresets(invalid); err == nil {
+			t.Errorf("parseResizePresets(%q) expected error", invalid)
+		}
+	}
+}
+
+func TestParseFlushPatterns(t *testing.T) {
+	patterns, err := parseFlushPatterns(`\a,$ ,\x1b]133;A,"\x2c`)
+	if err != nil {
+		t.Fatalf("parseFlushPatterns() unexpected error: %v", err)
+	}
+	want := []string{"\a", "$ ", "\x1b]133;A", `",`}
+	if len(patterns) != len(want) {
+		t.Fatalf("parseFlushPatterns() = %q, want %q", patterns, want)
+	}
+	for i := range want {
+		if string(patterns[i]) != want[i] {
+			t.Errorf("parseFlushPatterns() = %q, want %q", patterns, want)
+		}
+	}
+
+	patterns, err = parseFlushPatterns("")
+	if err != nil || len(patterns) != 0 {
+		t.Errorf("parseFlushPatterns(\"\") = %q, %v, want empty", patterns, err)
+	}
+
+	for _, invalid := range []string{`\q`, `\x1`, `a\`} {
+		if _, err := parseFlushPatterns(invalid); err == nil {
+			t.Errorf("parseFlushPatterns(%q) expected error", invalid)
+		}
+	}
+}
@@ -34,3 +34,12 @@ func sameOrigin(r *http.Request) bool {
 	}
 	return originPort == reqPort
 }
+
+// pinnedOrigin accepts only requests that carry an Origin header whose host
+// matches the host the request was sent to.
+func pinnedOrigin(r *http.Request) bool {
+	if r.Header.Get("Origin") == "" {
+		return false
+	}
+	return sameOrigin(r)
+}
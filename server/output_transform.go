@@ -0,0 +1,45 @@
+package server
+
+import (
+	"bytes"
+	"io"
+)
+
+// transformSlave passes the output of a slave through a writer built by
+// Options.OutputTransform before it reaches the client.
+type transformSlave struct {
+	Slave
+
+	out       bytes.Buffer
+	transform io.Writer
+	err       error
+}
+
+func newTransformSlave(slave Slave, transform func(io.Writer) io.Writer) *transformSlave {
+	ts := &transformSlave{Slave: slave}
+	ts.transform = transform(&ts.out)
+	return ts
+}
+
+func (ts *transformSlave) Read(p []byte) (int, error) {
+	// The transform may hold output back or drop it entirely, so keep
+	// reading until it produces something.
+	for ts.out.Len() == 0 && ts.err == nil {
+		n, err := ts.Slave.Read(p)
+		if n > 0 {
+			ts.transform.Write(p[:n])
+		}
+		if err != nil {
+			// Let the transform flush what it held back
+			if closer, ok := ts.transform.(io.Closer); ok {
+				closer.Close()
+			}
+			ts.err = err
+		}
+	}
+
+	if ts.out.Len() > 0 {
+		return ts.out.Read(p)
+	}
+	return 0, ts.err
+}
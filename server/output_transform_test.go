@@ -0,0 +1,97 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+)
+
+// upperWriter uppercases ASCII letters written to it
+type upperWriter struct {
+	w io.Writer
+}
+
+func (uw *upperWriter) Write(p []byte) (int, error) {
+	return uw.w.Write(bytes.ToUpper(p))
+}
+
+// lineWriter holds output back until a full line is written
+type lineWriter struct {
+	w       io.Writer
+	pending []byte
+}
+
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.pending = append(lw.pending, p...)
+	if i := bytes.LastIndexByte(lw.pending, '\n'); i >= 0 {
+		lw.w.Write(lw.pending[:i+1])
+		lw.pending = lw.pending[i+1:]
+	}
+	return len(p), nil
+}
+
+func (lw *lineWriter) Close() error {
+	_, err := lw.w.Write(lw.pending)
+	return err
+}
+
+func TestTransformSlave(t *testing.T) {
+	slave := newExitingSlave("hello world", 0)
+	ts := newTransformSlave(slave, func(w io.Writer) io.Writer {
+		return &upperWriter{w: w}
+	})
+
+	// Small reads drain the transformed output in pieces
+	var got []byte
+	buf := make([]byte, 4)
+	for {
+		n, err := ts.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			break
+		}
+	}
+	if string(got) != "HELLO WORLD" {
+		t.Errorf("output = %q, want %q", got, "HELLO WORLD")
+	}
+}
+
+func TestTransformSlaveFlushesOnClose(t *testing.T) {
+	slave := newExitingSlave("no newline", 0)
+	ts := newTransformSlave(slave, func(w io.Writer) io.Writer {
+		return &lineWriter{w: w}
+	})
+
+	got, _ := io.ReadAll(ts)
+	if string(got) != "no newline" {
+		t.Errorf("output = %q, want the held back output flushed", got)
+	}
+}
+
+func TestOutputTransformApplied(t *testing.T) {
+	factory := &exitingFactory{connTestFactory: newConnTestFactory(), slave: newExitingSlave("hello", 0)}
+	server, err := New(factory, &Options{
+		TitleFormat: "Test",
+		OutputTransform: func(w io.Writer) io.Writer {
+			return &upperWriter{w: w}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.processTransportConn(ctx, transport, nil, "")
+
+	if got := transport.outputText(t); got != "HELLO" {
+		t.Errorf("client output = %q, want %q", got, "HELLO")
+	}
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// startOverloadCooldown rejects new connections for retryAfter, falling back
+// to the configured cooldown. An ongoing longer cooldown is kept.
+func (server *Server) startOverloadCooldown(retryAfter time.Duration) {
+	if retryAfter <= 0 {
+		retryAfter = time.Duration(server.options.OverloadCooldown) * time.Second
+	}
+	if retryAfter <= 0 {
+		return
+	}
+
+	until := time.Now().Add(retryAfter).UnixNano()
+	for {
+		current := atomic.LoadInt64(&server.overloadedUntil)
+		if current >= until {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&server.overloadedUntil, current, until) {
+			log.Printf("Backend overloaded, rejecting new connections for %s", retryAfter)
+			return
+		}
+	}
+}
+
+// overloadRemaining returns how long new connections are still rejected
+// because of an overloaded backend, or zero.
+func (server *Server) overloadRemaining() time.Duration {
+	until := atomic.LoadInt64(&server.overloadedUntil)
+	if until == 0 {
+		return 0
+	}
+	remaining := time.Until(time.Unix(0, until))
+	if remaining < 0 {
+		return 0
+	}
+	return remaining
+}
+
+// shedLoad reports whether a new connection should be turned away to protect
+// the active ones, either because ShedConnections are already being served or
+// because LoadFunc reports high load.
+func (server *Server) shedLoad(active int) bool {
+	if server.options.ShedConnections > 0 && active >= server.options.ShedConnections {
+		return true
+	}
+	return server.options.LoadFunc != nil && server.options.LoadFunc()
+}
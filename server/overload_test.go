@@ -0,0 +1,150 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+func TestOverloadedFactoryStartsCooldown(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = errors.Wrap(&OverloadedError{}, "remote shell provider")
+	server, err := New(factory, &Options{TitleFormat: "Test", OverloadCooldown: 30})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	transport := newConnTestTransport()
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport.SetReadData(data)
+
+	err = server.processTransportConn(context.Background(), transport, nil, "")
+	if err == nil || !strings.Contains(err.Error(), "backend overloaded") {
+		t.Fatalf("processTransportConn() error = %v, want overloaded error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	handler := server.generateHandleWS(ctx, cancel, counter)
+
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", "/ws", nil))
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+		}
+		if rr.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After = %q, want %q", rr.Header().Get("Retry-After"), "30")
+		}
+	}
+	if counter.count() != 0 {
+		t.Errorf("counter = %d, want 0", counter.count())
+	}
+}
+
+func TestOverloadCooldownExpires(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	server.startOverloadCooldown(20 * time.Millisecond)
+	if server.overloadRemaining() <= 0 {
+		t.Fatal("connections should be rejected during the cooldown")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.generateHandleWS(ctx, cancel, newCounter(0))
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/ws", nil))
+
+	if rr.Code == http.StatusServiceUnavailable {
+		t.Error("connections should be accepted again after the cooldown")
+	}
+}
+
+func TestOverloadCooldownKeepsLonger(t *testing.T) {
+	server := &Server{options: &Options{OverloadCooldown: 0}}
+
+	server.startOverloadCooldown(time.Minute)
+	server.startOverloadCooldown(time.Second)
+	if remaining := server.overloadRemaining(); remaining < 50*time.Second {
+		t.Errorf("remaining = %s, the longer cooldown should be kept", remaining)
+	}
+
+	// Without RetryAfter or a configured cooldown nothing changes
+	other := &Server{options: &Options{}}
+	other.startOverloadCooldown(0)
+	if other.overloadRemaining() != 0 {
+		t.Error("no cooldown should start when it is disabled")
+	}
+}
+
+func TestShedLoadWithLoadFunc(t *testing.T) {
+	high := true
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat:    "Test",
+		ShedRetryAfter: 7,
+		LoadFunc:       func() bool { return high },
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	handlers := map[string]http.HandlerFunc{
+		"WebSocket":    server.generateHandleWS(ctx, cancel, counter),
+		"WebTransport": server.generateHandleWT(ctx, cancel, counter),
+	}
+
+	for name, handler := range handlers {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest("GET", "/ws", nil))
+
+		if rr.Code != http.StatusServiceUnavailable {
+			t.Errorf("%s status = %d, want %d", name, rr.Code, http.StatusServiceUnavailable)
+		}
+		if rr.Header().Get("Retry-After") != "7" {
+			t.Errorf("%s Retry-After = %q, want %q", name, rr.Header().Get("Retry-After"), "7")
+		}
+	}
+	if counter.count() != 0 {
+		t.Errorf("counter = %d, shed connections should not be counted", counter.count())
+	}
+
+	high = false
+	rr := httptest.NewRecorder()
+	handlers["WebSocket"](rr, httptest.NewRequest("GET", "/ws", nil))
+	if rr.Code == http.StatusServiceUnavailable {
+		t.Error("connections should be accepted once the load is low")
+	}
+}
+
+func TestShedLoadWithActiveConnections(t *testing.T) {
+	server := &Server{options: &Options{ShedConnections: 2}}
+
+	if server.shedLoad(1) {
+		t.Error("shedLoad(1) = true, want false below the threshold")
+	}
+	if !server.shedLoad(2) {
+		t.Error("shedLoad(2) = false, want true at the threshold")
+	}
+
+	disabled := &Server{options: &Options{}}
+	if disabled.shedLoad(1000) {
+		t.Error("shedLoad() should be disabled by default")
+	}
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+func TestReadOnlySession(t *testing.T) {
+	tests := []struct {
+		name    string
+		init    InitMessage
+		options Options
+		want    string
+	}{
+		{"writable", InitMessage{}, Options{}, "ls\r"},
+		{"read-only flag", InitMessage{ReadOnly: true}, Options{}, ""},
+		{"readonly argument", InitMessage{Arguments: "?readonly=1"}, Options{PermitArguments: true}, ""},
+		{"readonly argument not permitted", InitMessage{Arguments: "?readonly=1"}, Options{}, "ls\r"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The mock slave echoes its input back as output
+			options := tt.options
+			options.TitleFormat = "Test"
+			options.PermitWrite = true
+			server, err := New(newConnTestFactory(), &options)
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			data, _ := json.Marshal(tt.init)
+			input := append([]byte{webtty.Input}, "ls\r"...)
+			transport := newBlockingTransport(data, input)
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			server.processTransportConn(ctx, transport, nil, "")
+			cancel()
+			close(transport.closed)
+
+			if got := transport.outputText(t); got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadOnlyAuthToken(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:     "Test",
+		PermitWrite:     true,
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"/auth_token.js", "ls\r"},
+		{"/auth_token.js?readonly", ""},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		req.RemoteAddr = "127.0.0.1:1234"
+		rr := httptest.NewRecorder()
+		server.handleAuthToken(rr, req)
+		token := strings.TrimSuffix(strings.TrimPrefix(rr.Body.String(), `var gotty_auth_token = "`), `";`)
+
+		// A viewer token cannot be used for a writable session
+		data, _ := json.Marshal(InitMessage{AuthToken: token})
+		input := append([]byte{webtty.Input}, "ls\r"...)
+		transport := newBlockingTransport(data, input)
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		server.processTransportConn(ctx, transport, nil, "")
+		cancel()
+		close(transport.closed)
+
+		if got := transport.outputText(t); got != tt.want {
+			t.Errorf("%s: output = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// ringBuffer keeps the last size bytes written to it.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{
+		buf:  make([]byte, 0, size),
+		size: size,
+	}
+}
+
+func (rb *ringBuffer) Write(p []byte) (int, error) {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	n := len(p)
+	if n >= rb.size {
+		rb.buf = append(rb.buf[:0], p[n-rb.size:]...)
+		return n, nil
+	}
+	if overflow := len(rb.buf) + n - rb.size; overflow > 0 {
+		rb.buf = append(rb.buf[:0], rb.buf[overflow:]...)
+	}
+	rb.buf = append(rb.buf, p...)
+	return n, nil
+}
+
+// Bytes returns a copy of the buffered output, without a leading partial
+// UTF-8 character cut off by the wrap-around.
+func (rb *ringBuffer) Bytes() []byte {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	data := rb.buf
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.RuneStart(data[0]); i++ {
+		data = data[1:]
+	}
+	return append([]byte(nil), data...)
+}
+
+// resumeTokenMinLength is the shortest resume token accepted, so that
+// tokens cannot be guessed.
+const resumeTokenMinLength = 16
+
+// replaySessionTimeout is how long the output of a session is kept for a
+// client reconnecting with its resume token.
+var replaySessionTimeout = 5 * time.Minute
+
+// replayStore keeps the last output of ended sessions so that it can be
+// replayed to the next client connecting to the same session, or to the
+// client reconnecting with the same resume token.
+type replayStore struct {
+	mu       sync.Mutex
+	last     map[string][]byte
+	sessions map[string]*replaySession
+}
+
+// replaySession is the output kept for a resume token.
+type replaySession struct {
+	output []byte
+	owner  sessionOwner
+	// exited is set when the backend exited rather than the client leaving
+	exited bool
+	timer  *time.Timer
+}
+
+func newReplayStore() *replayStore {
+	return &replayStore{
+		last:     make(map[string][]byte),
+		sessions: make(map[string]*replaySession),
+	}
+}
+
+func (store *replayStore) save(key string, output []byte) {
+	if len(output) == 0 {
+		return
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	store.last[key] = output
+}
+
+func (store *replayStore) load(key string) []byte {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	return store.last[key]
+}
+
+// saveSession keeps output under token for owner for replaySessionTimeout.
+// Output kept for another owner under the same token is not replaced.
+func (store *replayStore) saveSession(token string, owner sessionOwner, output []byte, exited bool) {
+	if len(token) < resumeTokenMinLength || len(output) == 0 {
+		return
+	}
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	if previous, ok := store.sessions[token]; ok {
+		if previous.owner != owner {
+			return
+		}
+		previous.timer.Stop()
+	}
+	session := &replaySession{output: output, owner: owner, exited: exited}
+	session.timer = time.AfterFunc(replaySessionTimeout, func() {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		if store.sessions[token] == session {
+			delete(store.sessions, token)
+		}
+	})
+	store.sessions[token] = session
+}
+
+// takeSession removes and returns the output kept under token for owner,
+// and whether its backend exited. Output of another owner is left alone.
+func (store *replayStore) takeSession(token string, owner sessionOwner) ([]byte, bool) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	session, ok := store.sessions[token]
+	if !ok || session.owner != owner {
+		return nil, false
+	}
+	session.timer.Stop()
+	delete(store.sessions, token)
+	return session.output, session.exited
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+func TestRingBufferKeepsLastBytes(t *testing.T) {
+	rb := newRingBuffer(8)
+
+	rb.Write([]byte("hello"))
+	if got := string(rb.Bytes()); got != "hello" {
+		t.Errorf("Bytes() = %q, want %q", got, "hello")
+	}
+
+	rb.Write([]byte(" world"))
+	if got := string(rb.Bytes()); got != "lo world" {
+		t.Errorf("Bytes() = %q, want %q", got, "lo world")
+	}
+
+	rb.Write([]byte("a very long write"))
+	if got := string(rb.Bytes()); got != "ng write" {
+		t.Errorf("Bytes() = %q, want %q", got, "ng write")
+	}
+}
+
+func TestRingBufferDropsPartialCharacter(t *testing.T) {
+	rb := newRingBuffer(4)
+	rb.Write([]byte("a→bc"))
+
+	// "→" is three bytes; the wrap-around cut off its first byte
+	if got := string(rb.Bytes()); got != "bc" {
+		t.Errorf("Bytes() = %q, want %q", got, "bc")
+	}
+}
+
+// sequenceFactory returns the given slaves one by one
+type sequenceFactory struct {
+	*connTestFactory
+	slaves []Slave
+}
+
+func (f *sequenceFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	slave := f.slaves[0]
+	f.slaves = f.slaves[1:]
+	return slave, nil
+}
+
+func TestReplayOnReconnect(t *testing.T) {
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves: []Slave{
+			newExitingSlave("first session output", 0),
+			newExitingSlave("$ ", 0),
+		},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", ReplayBufferSize: 6})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	// Every client attaches to the same tmux session
+	server.tmuxSession = "main"
+
+	connect := func() string {
+		data, _ := json.Marshal(InitMessage{AuthToken: ""})
+		transport := newBlockingTransport(data)
+		defer close(transport.closed)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		server.processTransportConn(ctx, transport, nil, "")
+		return transport.outputText(t)
+	}
+
+	if got := connect(); got != "first session output" {
+		t.Errorf("first connection output = %q, want no replay", got)
+	}
+	if got := connect(); got != "output$ " {
+		t.Errorf("reconnect output = %q, want the last 6 bytes replayed first", got)
+	}
+}
+
+func TestReplayNotSharedBetweenSessions(t *testing.T) {
+	first, second := newMockSlaveForTransport(), newMockSlaveForTransport()
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{first, second},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", ReplayBufferSize: 1024})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	// Two clients of a local command, each with a backend of its own
+	connect := func(slave *mockSlaveForTransport, resumeToken string, output string) string {
+		data, _ := json.Marshal(InitMessage{ResumeToken: resumeToken})
+		transport := newBlockingTransport(data)
+		done := make(chan struct{})
+		go func() {
+			server.processTransportConn(context.Background(), transport, nil, "")
+			close(done)
+		}()
+
+		go slave.writer.Write([]byte(output))
+		waitFor(t, "the output to be sent", func() bool {
+			return strings.Contains(transport.outputText(t), output)
+		})
+		close(transport.closed)
+		<-done
+		return transport.outputText(t)
+	}
+
+	connect(first, "0123456789abcdef", "secret\r\n")
+	if got := connect(second, "fedcba9876543210", "$ "); got != "$ " {
+		t.Errorf("second session output = %q, want none of the first session's output", got)
+	}
+}
+
+func TestReplayOnReconnectWithResumeToken(t *testing.T) {
+	first, second := newMockSlaveForTransport(), newMockSlaveForTransport()
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{first, second},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", ReplayBufferSize: 1024})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	connect := func(slave *mockSlaveForTransport, output string, want string) {
+		data, _ := json.Marshal(InitMessage{ResumeToken: "0123456789abcdef"})
+		transport := newBlockingTransport(data)
+		done := make(chan struct{})
+		go func() {
+			server.processTransportConn(context.Background(), transport, nil, "")
+			close(done)
+		}()
+
+		go slave.writer.Write([]byte(output))
+		waitFor(t, "the output to be sent", func() bool {
+			return transport.outputText(t) == want
+		})
+
+		// The client goes away with the backend still running
+		close(transport.closed)
+		<-done
+	}
+
+	connect(first, "scrollback\r\n", "scrollback\r\n")
+	connect(second, "$ ", "scrollback\r\n$ ")
+}
+
+func TestReplayExitedSessionOnReconnect(t *testing.T) {
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{newExitingSlave("logout\r\n", 0)},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", ReplayBufferSize: 1024})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	connect := func() string {
+		data, _ := json.Marshal(InitMessage{ResumeToken: "0123456789abcdef"})
+		transport := newBlockingTransport(data)
+		defer close(transport.closed)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := server.processTransportConn(ctx, transport, nil, ""); err != webtty.ErrSlaveClosed {
+			t.Errorf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+		}
+		return transport.outputText(t)
+	}
+
+	if got := connect(); got != "logout\r\n" {
+		t.Errorf("first connection output = %q, want the backend output", got)
+	}
+	// No backend is created for the exited session
+	if got := connect(); got != "logout\r\n" {
+		t.Errorf("reconnect output = %q, want the output of the exited session", got)
+	}
+}
+
+func TestReplayStoreSessionExpires(t *testing.T) {
+	oldTimeout := replaySessionTimeout
+	replaySessionTimeout = 10 * time.Millisecond
+	defer func() { replaySessionTimeout = oldTimeout }()
+
+	store := newReplayStore()
+	store.saveSession("short", testOwner, []byte("output"), false)
+	if output, _ := store.takeSession("short", testOwner); output != nil {
+		t.Errorf("takeSession() = %q, want nothing kept for a short token", output)
+	}
+
+	store.saveSession("0123456789abcdef", testOwner, []byte("output"), true)
+	waitFor(t, "the session output to expire", func() bool {
+		store.mu.Lock()
+		defer store.mu.Unlock()
+		return len(store.sessions) == 0
+	})
+}
+
+func TestReplayStoreSessionOwner(t *testing.T) {
+	store := newReplayStore()
+	store.saveSession(testResumeToken, testOwner, []byte("alice$ "), false)
+
+	mallory := sessionOwner{user: "mallory", ip: testOwner.ip}
+	if output, _ := store.takeSession(testResumeToken, mallory); output != nil {
+		t.Errorf("takeSession() by another user = %q, want nothing", output)
+	}
+	store.saveSession(testResumeToken, mallory, []byte("mallory$ "), false)
+	if output, _ := store.takeSession(testResumeToken, testOwner); string(output) != "alice$ " {
+		t.Errorf("takeSession() by the owner = %q, want %q", output, "alice$ ")
+	}
+}
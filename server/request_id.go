@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http"
+
+	"webtmux/pkg/randomstring"
+)
+
+// requestIDHeader carries the correlation ID of a connection, both from
+// clients or proxies and on to the backend.
+const requestIDHeader = "X-Request-Id"
+
+// requestIDLength is the length of generated request IDs.
+const requestIDLength = 16
+
+type requestIDKey struct{}
+
+// requestID returns the X-Request-ID of r if it is usable, or a new ID.
+func requestID(r *http.Request) string {
+	if id := r.Header.Get(requestIDHeader); validRequestID(id) {
+		return id
+	}
+	return randomstring.Generate(requestIDLength)
+}
+
+// validRequestID accepts IDs that are safe to log and to pass on in an
+// environment variable.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func withRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestIDFromContext returns the request ID of a connection context, or
+// an empty string.
+func requestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// withRequestIDHeader returns a copy of headers including the request ID
+// for the backend.
+func withRequestIDHeader(headers map[string][]string, id string) map[string][]string {
+	if id == "" {
+		return headers
+	}
+	merged := make(map[string][]string, len(headers)+1)
+	for key, values := range headers {
+		merged[key] = values
+	}
+	merged[requestIDHeader] = []string{id}
+	return merged
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestRequestID(t *testing.T) {
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("X-Request-ID", "trace-42:a.b_c")
+	if id := requestID(req); id != "trace-42:a.b_c" {
+		t.Errorf("requestID() = %q, want the incoming ID", id)
+	}
+
+	for _, invalid := range []string{"", "has space", "line\nbreak", strings.Repeat("a", 129)} {
+		req.Header.Set("X-Request-ID", invalid)
+		if id := requestID(req); id == invalid || len(id) != requestIDLength {
+			t.Errorf("requestID() with %q = %q, want a generated ID", invalid, id)
+		}
+	}
+
+	req.Header.Del("X-Request-ID")
+	if requestID(req) == requestID(req) {
+		t.Error("generated request IDs should differ")
+	}
+}
+
+// headerFactory records the headers passed to New
+type headerFactory struct {
+	*connTestFactory
+	headers map[string][]string
+}
+
+func (f *headerFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	f.headers = headers
+	return f.connTestFactory.New(params, headers)
+}
+
+func TestRequestIDPropagation(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	factory := &headerFactory{connTestFactory: newConnTestFactory()}
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	init, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(init)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		server.processTransportConn(withRequestID(ctx, "trace-42"), transport, map[string][]string{"User-Agent": {"test"}}, "")
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	if entries := server.connections.list(); len(entries) != 1 || entries[0].RequestID != "trace-42" {
+		t.Errorf("active connections = %+v, want one with request ID trace-42", entries)
+	}
+	<-done
+
+	if got := factory.headers["X-Request-Id"]; len(got) != 1 || got[0] != "trace-42" {
+		t.Errorf("factory headers X-Request-Id = %v, want [trace-42]", got)
+	}
+	if got := factory.headers["User-Agent"]; len(got) != 1 || got[0] != "test" {
+		t.Errorf("factory headers User-Agent = %v, want the passed headers kept", got)
+	}
+	if !strings.Contains(logBuf.String(), `"request_id":"trace-42"`) {
+		t.Errorf("log = %q, want the request ID in the disconnect event", logBuf.String())
+	}
+}
+
+func TestRequestIDInConnectionLogs(t *testing.T) {
+	var logBuf bytes.Buffer
+	log.SetOutput(&logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	factory := &headerFactory{connTestFactory: newConnTestFactory()}
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.generateHandleWS(ctx, cancel, newCounter(0))
+	done := make(chan struct{})
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		close(done)
+	}))
+	defer ts.Close()
+
+	header := http.Header{"X-Request-Id": {"trace-42"}}
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), header)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	init, _ := json.Marshal(InitMessage{AuthToken: ""})
+	conn.WriteMessage(websocket.TextMessage, init)
+	conn.ReadMessage()
+	conn.Close()
+
+	<-done
+	for _, prefix := range []string{"New client connected", "Connection closed"} {
+		found := false
+		for _, line := range strings.Split(logBuf.String(), "\n") {
+			if strings.Contains(line, prefix) && strings.HasSuffix(line, "request: trace-42") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("log = %q, want %q with the request ID", logBuf.String(), prefix)
+		}
+	}
+	if got := factory.headers["X-Request-Id"]; len(got) != 1 || got[0] != "trace-42" {
+		t.Errorf("factory headers X-Request-Id = %v, want [trace-42]", got)
+	}
+}
@@ -0,0 +1,242 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// resumeBufferSize is the most output kept for a parked session, the oldest
+// being dropped first.
+const resumeBufferSize = 64 * 1024
+
+// resumeStore parks the backends of sessions whose client went away, so
+// that a client reconnecting with the same resume token gets its session
+// back. Sessions are keyed by token only, so that they can be resumed over
+// WebSocket as well as WebTransport, and are only handed back to their owner.
+type resumeStore struct {
+	mu      sync.Mutex
+	timeout time.Duration
+	parked  map[string]*parkedSession
+}
+
+type parkedSession struct {
+	slave *resumableSlave
+	owner sessionOwner
+	timer *time.Timer
+}
+
+// sessionOwner is the authenticated user and client IP a session was
+// started by. A resume token presented by anyone else is refused.
+type sessionOwner struct {
+	user string
+	ip   string
+}
+
+var errNotSessionOwner = errors.New("resume token belongs to another client")
+
+func newResumeStore(timeout time.Duration) *resumeStore {
+	return &resumeStore{
+		timeout: timeout,
+		parked:  make(map[string]*parkedSession),
+	}
+}
+
+// take removes and returns the session parked under token, or nil. A
+// session parked by another owner is left parked and errNotSessionOwner
+// returned.
+func (store *resumeStore) take(token string, owner sessionOwner) (*resumableSlave, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	parked, ok := store.parked[token]
+	if !ok {
+		return nil, nil
+	}
+	if parked.owner != owner {
+		return nil, errNotSessionOwner
+	}
+	if !parked.timer.Stop() {
+		return nil, nil
+	}
+	delete(store.parked, token)
+	return parked.slave, nil
+}
+
+// park keeps slave under token for owner until it is taken or the timeout
+// expires, closing a session parked under the same token before.
+func (store *resumeStore) park(token string, owner sessionOwner, slave *resumableSlave) {
+	store.mu.Lock()
+	previous, replaced := store.parked[token]
+	parked := &parkedSession{slave: slave, owner: owner}
+	parked.timer = time.AfterFunc(store.timeout, func() {
+		store.mu.Lock()
+		if store.parked[token] == parked {
+			delete(store.parked, token)
+		}
+		store.mu.Unlock()
+		log.Printf("Closing parked session after %s without a resume", store.timeout)
+		slave.Close()
+	})
+	store.parked[token] = parked
+	store.mu.Unlock()
+
+	if replaced && previous.timer.Stop() {
+		closeSlave(previous.slave, "")
+	}
+}
+
+// closeAll closes all parked sessions.
+func (store *resumeStore) closeAll() {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	for token, parked := range store.parked {
+		if parked.timer.Stop() {
+			parked.slave.Close()
+		}
+		delete(store.parked, token)
+	}
+}
+
+// resumableSlave keeps reading a backend between connections. Output is
+// handed to the attached connection, or buffered while none is attached.
+type resumableSlave struct {
+	Slave
+	cancel context.CancelFunc
+
+	mu       sync.Mutex
+	cond     *sync.Cond
+	pending  []byte
+	err      error
+	attached *slaveAttachment
+}
+
+func newResumableSlave(slave Slave, cancel context.CancelFunc) *resumableSlave {
+	rs := &resumableSlave{Slave: slave, cancel: cancel}
+	rs.cond = sync.NewCond(&rs.mu)
+	go rs.pump()
+	return rs
+}
+
+func (rs *resumableSlave) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := rs.Slave.Read(buf)
+
+		rs.mu.Lock()
+		// Hold back an attached connection's backend instead of dropping output
+		for rs.attached != nil && len(rs.pending) >= resumeBufferSize {
+			rs.cond.Wait()
+		}
+		rs.pending = append(rs.pending, buf[:n]...)
+		if overflow := len(rs.pending) - resumeBufferSize; overflow > 0 {
+			rs.pending = append(rs.pending[:0], rs.pending[overflow:]...)
+		}
+		if err != nil {
+			rs.err = err
+		}
+		rs.cond.Broadcast()
+		rs.mu.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// attach returns a slave reading the backend's output for a new connection.
+func (rs *resumableSlave) attach() *slaveAttachment {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	attachment := &slaveAttachment{Slave: rs.Slave, rs: rs}
+	rs.attached = attachment
+	rs.cond.Broadcast()
+	return attachment
+}
+
+// exited reports whether the backend stopped producing output.
+func (rs *resumableSlave) exited() bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.err != nil
+}
+
+func (rs *resumableSlave) Close() error {
+	rs.cancel()
+	return rs.Slave.Close()
+}
+
+// slaveAttachment is the view of a resumableSlave of one connection.
+type slaveAttachment struct {
+	Slave
+	rs       *resumableSlave
+	detached bool
+}
+
+func (sa *slaveAttachment) Read(p []byte) (int, error) {
+	rs := sa.rs
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	for len(rs.pending) == 0 && rs.err == nil && !sa.detached {
+		rs.cond.Wait()
+	}
+	if sa.detached {
+		return 0, io.EOF
+	}
+	if len(rs.pending) == 0 {
+		return 0, rs.err
+	}
+	n := copy(p, rs.pending)
+	rs.pending = rs.pending[n:]
+	rs.cond.Broadcast()
+	return n, nil
+}
+
+// detach stops the connection from reading output, which is buffered until
+// the next attach.
+func (sa *slaveAttachment) detach() {
+	rs := sa.rs
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+
+	sa.detached = true
+	if rs.attached == sa {
+		rs.attached = nil
+	}
+	rs.cond.Broadcast()
+}
+
+// openSlave resumes the session parked under token by owner, or creates a
+// backend that can be parked later when token is set and sessions can be
+// resumed. A token of another owner gets a backend that cannot be parked,
+// so that the session parked under it is left alone.
+func (server *Server) openSlave(ctx context.Context, token string, owner sessionOwner, params map[string][]string, headers map[string][]string) (Slave, *resumableSlave, error) {
+	if server.resumes == nil || len(token) < resumeTokenMinLength {
+		slave, err := server.newSlave(ctx, params, headers)
+		return slave, nil, err
+	}
+	resumable, err := server.resumes.take(token, owner)
+	if err != nil {
+		log.Printf("Refusing to resume a session for %s: %v", owner.ip, err)
+		slave, err := server.newSlave(ctx, params, headers)
+		return slave, nil, err
+	}
+	if resumable != nil {
+		return resumable.Slave, resumable, nil
+	}
+
+	// The backend must outlive the connection while it is parked
+	backendCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	slave, err := server.newSlave(backendCtx, params, headers)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return slave, newResumableSlave(slave, cancel), nil
+}
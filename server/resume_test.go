@@ -0,0 +1,272 @@
+package server
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webtmux/webtty"
+)
+
+const testResumeToken = "0123456789abcdef0123456789abcdef"
+
+var testOwner = sessionOwner{user: "alice", ip: "192.0.2.1"}
+
+// countingFactory counts the backends created from its shared slave.
+type countingFactory struct {
+	*connTestFactory
+	created int32
+}
+
+func (f *countingFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	atomic.AddInt32(&f.created, 1)
+	return f.connTestFactory.New(params, headers)
+}
+
+func (store *resumeStore) isParked(token string) bool {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	_, ok := store.parked[token]
+	return ok
+}
+
+func TestResumeAcrossTransports(t *testing.T) {
+	factory := &countingFactory{connTestFactory: newConnTestFactory()}
+	server, err := New(factory, &Options{TitleFormat: "Test", ResumeTimeout: 60})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer server.resumes.closeAll()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ts := httptest.NewServer(server.generateHandleWS(ctx, cancel, newCounter(0)))
+	defer ts.Close()
+
+	// Start the session over WebSocket
+	dialer := &websocket.Dialer{Subprotocols: webtty.Protocols}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	init, _ := json.Marshal(InitMessage{ResumeToken: testResumeToken})
+	conn.WriteMessage(websocket.TextMessage, init)
+	go factory.slave.writer.Write([]byte("before"))
+
+	var output string
+	for !strings.Contains(output, "before") {
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error: %v, output so far %q", err, output)
+		}
+		if len(msg) > 0 && msg[0] == webtty.Output {
+			decoded, _ := base64.StdEncoding.DecodeString(string(msg[1:]))
+			output += string(decoded)
+		}
+	}
+	conn.Close()
+
+	waitFor(t, "the session to be parked", func() bool { return server.resumes.isParked(testResumeToken) })
+	if factory.slave.closed {
+		t.Fatal("Parked backend should not be closed")
+	}
+	go factory.slave.writer.Write([]byte("during"))
+
+	// Resume it over WebTransport
+	transport := newBlockingTransport(init)
+	defer close(transport.closed)
+	done := make(chan struct{})
+	go func() {
+		server.processTransportConn(ctx, transport, nil, "")
+		close(done)
+	}()
+
+	waitFor(t, "the buffered output", func() bool { return strings.Contains(transport.outputText(t), "during") })
+	go factory.slave.writer.Write([]byte("after"))
+	waitFor(t, "new output", func() bool { return strings.Contains(transport.outputText(t), "after") })
+
+	if got := atomic.LoadInt32(&factory.created); got != 1 {
+		t.Errorf("backends created = %d, want 1 for a resumed session", got)
+	}
+	if server.resumes.isParked(testResumeToken) {
+		t.Error("Resumed session should no longer be parked")
+	}
+
+	cancel()
+	<-done
+}
+
+// closeSignalingSlave closes its channel when it is closed.
+type closeSignalingSlave struct {
+	*mockSlaveForTransport
+	closed chan struct{}
+}
+
+func newCloseSignalingSlave() *closeSignalingSlave {
+	return &closeSignalingSlave{mockSlaveForTransport: newMockSlaveForTransport(), closed: make(chan struct{})}
+}
+
+func (s *closeSignalingSlave) Close() error {
+	close(s.closed)
+	return nil
+}
+
+func TestResumeStoreTimeout(t *testing.T) {
+	store := newResumeStore(20 * time.Millisecond)
+	slave := newCloseSignalingSlave()
+	store.park(testResumeToken, testOwner, newResumableSlave(slave, func() {}))
+
+	select {
+	case <-slave.closed:
+	case <-time.After(time.Second):
+		t.Fatal("Parked session was not closed after the timeout")
+	}
+	if got, _ := store.take(testResumeToken, testOwner); got != nil {
+		t.Error("take() should not return an expired session")
+	}
+}
+
+func TestResumeStoreReplacesSession(t *testing.T) {
+	store := newResumeStore(time.Minute)
+	first := newCloseSignalingSlave()
+	second := newCloseSignalingSlave()
+	store.park(testResumeToken, testOwner, newResumableSlave(first, func() {}))
+	store.park(testResumeToken, testOwner, newResumableSlave(second, func() {}))
+
+	select {
+	case <-first.closed:
+	default:
+		t.Error("Replaced session should be closed")
+	}
+	if got, _ := store.take(testResumeToken, testOwner); got == nil || got.Slave != second {
+		t.Errorf("take() = %v, want the latest session", got)
+	}
+}
+
+func TestResumeStoreRefusesOtherOwner(t *testing.T) {
+	store := newResumeStore(time.Minute)
+	slave := newCloseSignalingSlave()
+	store.park(testResumeToken, testOwner, newResumableSlave(slave, func() {}))
+
+	for _, owner := range []sessionOwner{
+		{user: "mallory", ip: testOwner.ip},
+		{user: testOwner.user, ip: "198.51.100.1"},
+	} {
+		if got, err := store.take(testResumeToken, owner); got != nil || err != errNotSessionOwner {
+			t.Errorf("take() by %+v = %v, %v, want %v", owner, got, err, errNotSessionOwner)
+		}
+	}
+	if got, err := store.take(testResumeToken, testOwner); got == nil || err != nil {
+		t.Errorf("take() by the owner = %v, %v, want the parked session", got, err)
+	}
+}
+
+func TestResumeRefusedForAnotherUser(t *testing.T) {
+	first, second := newCloseSignalingSlave(), newMockSlaveForTransport()
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{first, second},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", ResumeTimeout: 60})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	defer server.resumes.closeAll()
+
+	connect := func(user string) (*blockingTransport, chan struct{}) {
+		transport := newBlockingTransport()
+		done := make(chan struct{})
+		go func() {
+			ctx := withAuthUser(context.Background(), user)
+			server.serveTerminal(ctx, transport, &InitMessage{ResumeToken: testResumeToken}, nil, "192.0.2.1")
+			close(done)
+		}()
+		return transport, done
+	}
+
+	transport, done := connect("alice")
+	go first.writer.Write([]byte("alice$ "))
+	waitFor(t, "alice's output", func() bool { return transport.outputText(t) == "alice$ " })
+	close(transport.closed)
+	<-done
+	waitFor(t, "the session to be parked", func() bool { return server.resumes.isParked(testResumeToken) })
+	go first.writer.Write([]byte("secret"))
+
+	// Another user presenting the token gets a backend of its own
+	transport, done = connect("mallory")
+	go second.writer.Write([]byte("mallory$ "))
+	waitFor(t, "mallory's output", func() bool { return transport.outputText(t) == "mallory$ " })
+	close(transport.closed)
+	<-done
+
+	if !server.resumes.isParked(testResumeToken) {
+		t.Fatal("alice's session should still be parked")
+	}
+	select {
+	case <-first.closed:
+		t.Fatal("alice's backend should not be closed")
+	default:
+	}
+
+	transport, done = connect("alice")
+	defer func() {
+		close(transport.closed)
+		<-done
+	}()
+	waitFor(t, "the buffered output", func() bool { return transport.outputText(t) == "secret" })
+}
+
+func TestResumableSlaveBuffersWhileDetached(t *testing.T) {
+	slave := newMockSlaveForTransport()
+	resumable := newResumableSlave(slave, func() {})
+
+	first := resumable.attach()
+	first.detach()
+	if n, err := first.Read(make([]byte, 8)); n != 0 || err == nil {
+		t.Errorf("Read() after detach = %d, %v, want an error", n, err)
+	}
+
+	big := strings.Repeat("x", resumeBufferSize) + "tail"
+	go slave.writer.Write([]byte(big))
+	waitFor(t, "the output to be buffered", func() bool {
+		resumable.mu.Lock()
+		defer resumable.mu.Unlock()
+		return strings.HasSuffix(string(resumable.pending), "tail")
+	})
+
+	second := resumable.attach()
+	buf := make([]byte, 2*resumeBufferSize)
+	n, err := second.Read(buf)
+	if err != nil {
+		t.Fatalf("Read() error: %v", err)
+	}
+	if n != resumeBufferSize || !strings.HasSuffix(string(buf[:n]), "tail") {
+		t.Errorf("Read() = %d bytes, want the last %d bytes of the output", n, resumeBufferSize)
+	}
+}
+
+func TestOpenSlaveWithoutResume(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", ResumeTimeout: 60})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	_, resumable, err := server.openSlave(context.Background(), "short", testOwner, nil, nil)
+	if err != nil || resumable != nil {
+		t.Errorf("openSlave() with a short token = %v, %v, want a plain backend", resumable, err)
+	}
+
+	server.resumes = nil
+	_, resumable, err = server.openSlave(context.Background(), testResumeToken, testOwner, nil, nil)
+	if err != nil || resumable != nil {
+		t.Errorf("openSlave() without resume = %v, %v, want a plain backend", resumable, err)
+	}
+}
@@ -2,11 +2,18 @@ package server
 
 import (
 	"context"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
 )
 
 // RunOptions holds a set of configurations for Server.Run().
 type RunOptions struct {
 	gracefullCtx context.Context
+	connContext  func(ctx context.Context, r *http.Request) context.Context
+	signals      []os.Signal
+	drainTimeout time.Duration
 }
 
 // RunOption is an option of Server.Run().
@@ -19,3 +26,34 @@ func WithGracefullContext(ctx context.Context) RunOption {
 		options.gracefullCtx = ctx
 	}
 }
+
+// WithConnectionContext sets a function deriving the context of each
+// terminal connection from the request that opened it, e.g. to attach
+// tracing spans or the authenticated identity. Factories implementing
+// ContextFactory receive the derived context.
+func WithConnectionContext(fn func(ctx context.Context, r *http.Request) context.Context) RunOption {
+	return func(options *RunOptions) {
+		options.connContext = fn
+	}
+}
+
+// WithSignalHandling makes Run shut down gracefully on the first of the
+// given signals, SIGINT and SIGTERM by default, and abort existing
+// connections on the second.
+func WithSignalHandling(signals ...os.Signal) RunOption {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM}
+	}
+	return func(options *RunOptions) {
+		options.signals = signals
+	}
+}
+
+// WithDrainTimeout limits how long a graceful shutdown waits for existing
+// connections to finish. Connections still open after d are closed. Without
+// it, or with d of zero, the shutdown waits for them indefinitely.
+func WithDrainTimeout(d time.Duration) RunOption {
+	return func(options *RunOptions) {
+		options.drainTimeout = d
+	}
+}
@@ -2,7 +2,11 @@ package server
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
+	"syscall"
 	"testing"
+	"time"
 )
 
 func TestRunOptionsDefault(t *testing.T) {
@@ -56,3 +60,58 @@ func TestMultipleRunOptions(t *testing.T) {
 		t.Error("Last applied context should be used")
 	}
 }
+
+type connContextKey struct{}
+
+func TestWithConnectionContext(t *testing.T) {
+	opts := &RunOptions{}
+	WithConnectionContext(func(ctx context.Context, r *http.Request) context.Context {
+		return context.WithValue(ctx, connContextKey{}, r.Header.Get("X-Trace-Id"))
+	})(opts)
+
+	if opts.connContext == nil {
+		t.Fatal("WithConnectionContext should set connContext")
+	}
+
+	server := &Server{connContext: opts.connContext}
+	req := httptest.NewRequest("GET", "/ws", nil)
+	req.Header.Set("X-Trace-Id", "trace-123")
+
+	ctx := server.connectionContext(context.Background(), req)
+	if ctx.Value(connContextKey{}) != "trace-123" {
+		t.Errorf("connection context value = %v, want %q", ctx.Value(connContextKey{}), "trace-123")
+	}
+}
+
+func TestConnectionContextDefault(t *testing.T) {
+	server := &Server{}
+	ctx := context.Background()
+
+	if got := server.connectionContext(ctx, httptest.NewRequest("GET", "/ws", nil)); got != ctx {
+		t.Error("connectionContext should return ctx unchanged without WithConnectionContext")
+	}
+}
+
+func TestWithSignalHandling(t *testing.T) {
+	opts := &RunOptions{}
+	WithSignalHandling()(opts)
+	if len(opts.signals) != 2 || opts.signals[0] != syscall.SIGINT || opts.signals[1] != syscall.SIGTERM {
+		t.Errorf("signals = %v, want [SIGINT SIGTERM] by default", opts.signals)
+	}
+
+	WithSignalHandling(syscall.SIGHUP)(opts)
+	if len(opts.signals) != 1 || opts.signals[0] != syscall.SIGHUP {
+		t.Errorf("signals = %v, want [SIGHUP]", opts.signals)
+	}
+}
+
+func TestWithDrainTimeout(t *testing.T) {
+	opts := &RunOptions{}
+	if opts.drainTimeout != 0 {
+		t.Error("drainTimeout should be zero by default")
+	}
+	WithDrainTimeout(30 * time.Second)(opts)
+	if opts.drainTimeout != 30*time.Second {
+		t.Errorf("drainTimeout = %v, want 30s", opts.drainTimeout)
+	}
+}
@@ -0,0 +1,203 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/quic-go/webtransport-go"
+
+	"webtmux/webtty"
+)
+
+// selfTestTimeout bounds the whole startup self-test.
+const selfTestTimeout = 10 * time.Second
+
+// selfTest serves a throwaway terminal over a loopback WebSocket, and over
+// WebTransport when enabled, and checks that the init handshake and a ping
+// round trip complete. Self-test terminals are not counted as sessions.
+func (server *Server) selfTest(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	if err := server.selfTestWebSocket(ctx); err != nil {
+		return errors.Wrapf(err, "WebSocket self-test failed")
+	}
+	if server.options.EnableWebTransport {
+		if err := server.selfTestWebTransport(ctx); err != nil {
+			return errors.Wrapf(err, "WebTransport self-test failed")
+		}
+	}
+	return nil
+}
+
+func (server *Server) selfTestWebSocket(ctx context.Context) error {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen")
+	}
+
+	upgrader := &websocket.Upgrader{Subprotocols: webtty.Protocols}
+	served := make(chan error, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			served <- err
+			return
+		}
+		transport := newWSTransport(conn, 0)
+		defer transport.Close()
+		served <- server.serveSelfTest(ctx, transport)
+	})}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	dialer := &websocket.Dialer{Subprotocols: webtty.Protocols}
+	conn, _, err := dialer.DialContext(ctx, "ws://"+listener.Addr().String()+"/ws", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to dial")
+	}
+	client := newWSTransport(conn, 0)
+	err = selfTestClient(client)
+	client.Close()
+	if err != nil {
+		return err
+	}
+	return selfTestServed(ctx, served)
+}
+
+func (server *Server) selfTestWebTransport(ctx context.Context) error {
+	// Serve the certificates of the TLS server, as the WebTransport server
+	// does
+	getCertificate := server.getCertificate()
+	if getCertificate == nil {
+		return errors.New("no TLS certificates")
+	}
+	serverName := ""
+	if server.acme != nil {
+		// ACME certificates are only served for the configured domains
+		serverName = parseACMEDomains(server.options.ACMEDomains)[0]
+	}
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return errors.Wrapf(err, "failed to listen")
+	}
+	defer conn.Close()
+
+	served := make(chan error, 1)
+	wts := &webtransport.Server{
+		H3: &http3.Server{
+			TLSConfig: &tls.Config{GetCertificate: getCertificate, NextProtos: []string{http3.NextProtoH3}},
+		},
+		CheckOrigin: func(r *http.Request) bool { return true },
+	}
+	webtransport.ConfigureHTTP3Server(wts.H3)
+	wts.H3.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := wts.Upgrade(w, r)
+		if err != nil {
+			served <- err
+			return
+		}
+		stream, err := session.AcceptStream(ctx)
+		if err != nil {
+			served <- err
+			return
+		}
+		transport := newWTTransport(session, stream)
+		transport.checksum = server.options.WTChecksum
+		defer transport.Close()
+		served <- server.serveSelfTest(ctx, transport)
+	})
+	go wts.Serve(conn)
+	defer wts.Close()
+
+	// The certificate is our own and the peer is this process, so there is
+	// nothing to verify.
+	dialer := &webtransport.Dialer{TLSClientConfig: &tls.Config{ServerName: serverName, InsecureSkipVerify: true}}
+	_, session, err := dialer.Dial(ctx, "https://"+conn.LocalAddr().String()+"/wt", nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to dial")
+	}
+	stream, err := session.OpenStreamSync(ctx)
+	if err != nil {
+		session.CloseWithError(0, "")
+		return errors.Wrapf(err, "failed to open stream")
+	}
+	client := newWTTransport(session, stream)
+	client.checksum = server.options.WTChecksum
+	err = selfTestClient(client)
+	client.Close()
+	if err != nil {
+		return err
+	}
+	return selfTestServed(ctx, served)
+}
+
+// serveSelfTest bridges transport with a backend created from the factory
+// until the client disconnects.
+func (server *Server) serveSelfTest(ctx context.Context, transport Transport) error {
+	initBuf := make([]byte, 4096)
+	if _, err := transport.Read(initBuf); err != nil {
+		return errors.Wrapf(err, "failed to read init message")
+	}
+
+	slave, err := server.newSlave(ctx, map[string][]string{}, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create backend")
+	}
+	defer slave.Close()
+
+	tty, err := webtty.New(transport, slave)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create webtty")
+	}
+	// The client checks the round trip, so how the bridge ends is irrelevant
+	tty.Run(ctx)
+	return nil
+}
+
+// selfTestClient performs the handshake of a client and waits for the pong
+// to a ping.
+func selfTestClient(transport Transport) error {
+	init, _ := json.Marshal(InitMessage{})
+	if _, err := transport.Write(init); err != nil {
+		return errors.Wrapf(err, "failed to send init message")
+	}
+	if err := selfTestAwait(transport, webtty.ConnectionReady); err != nil {
+		return err
+	}
+	if _, err := transport.Write([]byte{webtty.Ping}); err != nil {
+		return errors.Wrapf(err, "failed to send ping")
+	}
+	return selfTestAwait(transport, webtty.Pong)
+}
+
+// selfTestAwait reads messages until one of type msgType arrives.
+func selfTestAwait(transport Transport, msgType byte) error {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := transport.Read(buf)
+		if err != nil {
+			return errors.Wrapf(err, "connection closed while waiting for message type %c", msgType)
+		}
+		if n > 0 && buf[0] == msgType {
+			return nil
+		}
+	}
+}
+
+// selfTestServed waits for the server side of the self-test to finish.
+func selfTestServed(ctx context.Context, served <-chan error) error {
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+		return errors.Wrapf(ctx.Err(), "server side did not finish")
+	}
+}
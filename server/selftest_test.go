@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// generateServerCert writes a self-signed certificate for 127.0.0.1 and its
+// key, returning their paths.
+func generateServerCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}), 0600); err != nil {
+		t.Fatalf("Failed to write cert: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestSelfTestWebSocket(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	if err := server.selfTest(context.Background()); err != nil {
+		t.Errorf("selfTest() error: %v", err)
+	}
+	if n := len(server.connections.list()); n != 0 {
+		t.Errorf("self-test registered %d connections, want 0", n)
+	}
+}
+
+func TestSelfTestBrokenFactory(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = errors.New("command not found")
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	err = server.selfTest(context.Background())
+	if err == nil {
+		t.Fatal("selfTest() should fail when the backend cannot be created")
+	}
+	if !strings.Contains(err.Error(), "WebSocket self-test failed") {
+		t.Errorf("selfTest() error = %v, want a WebSocket self-test failure", err)
+	}
+}
+
+func TestSelfTestWebTransport(t *testing.T) {
+	certFile, keyFile := generateServerCert(t)
+	for _, checksum := range []bool{false, true} {
+		server, err := New(newConnTestFactory(), &Options{
+			TitleFormat:        "Test",
+			EnableTLS:          true,
+			TLSCrtFile:         certFile,
+			TLSKeyFile:         keyFile,
+			EnableWebTransport: true,
+			WTChecksum:         checksum,
+		})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+
+		// Run sets up the certificates before the self-test
+		if _, err := server.setupHTTPServer(http.NotFoundHandler()); err != nil {
+			t.Fatalf("setupHTTPServer() error: %v", err)
+		}
+		if err := server.selfTest(context.Background()); err != nil {
+			t.Errorf("selfTest() with checksum=%v error: %v", checksum, err)
+		}
+	}
+}
+
+func TestSelfTestWebTransportServerCertificates(t *testing.T) {
+	certFile, keyFile := generateServerCert(t)
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:        "Test",
+		EnableTLS:          true,
+		TLSCrtFile:         certFile,
+		TLSKeyFile:         keyFile,
+		EnableWebTransport: true,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if err := server.selfTestWebTransport(context.Background()); err == nil {
+		t.Error("selfTestWebTransport() should fail before the TLS server is set up")
+	}
+
+	if _, err := server.setupHTTPServer(http.NotFoundHandler()); err != nil {
+		t.Fatalf("setupHTTPServer() error: %v", err)
+	}
+	// The loaded certificates are served, not the files
+	os.Remove(certFile)
+	os.Remove(keyFile)
+	if err := server.selfTestWebTransport(context.Background()); err != nil {
+		t.Errorf("selfTestWebTransport() error: %v", err)
+	}
+}
+
+func TestRunFailsSelfTest(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = errors.New("command not found")
+	server, err := New(factory, &Options{TitleFormat: "Test", Address: "127.0.0.1", Port: "0", SelfTest: true})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = server.Run(ctx)
+	if err == nil || !strings.Contains(err.Error(), "self-test failed") {
+		t.Errorf("Run() error = %v, want a self-test failure", err)
+	}
+}
@@ -5,19 +5,25 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"html/template"
+	"io"
 	"io/fs"
 	"log"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	noesctmpl "text/template"
 	"time"
 
-	"github.com/NYTimes/gziphandler"
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
 
 	"webtmux/bindata"
 	"webtmux/pkg/homedir"
@@ -35,15 +41,71 @@ type Server struct {
 	indexTemplate    *template.Template
 	titleTemplate    *noesctmpl.Template
 	manifestTemplate *template.Template
+	// Set when each session's output is recorded to a file
+	logPathTemplate *noesctmpl.Template
+
+	// Guards indexTemplate while it is reloaded from IndexFile
+	templateMu sync.RWMutex
+	// Credentials from CredentialFile, replaced when it is reloaded
+	credentialMu      sync.RWMutex
+	loadedCredentials []string
 
 	// Tmux support
 	tmuxSession string
 	tmuxCtrl    *tmux.Controller
+	// Set when connections create tmux sessions under a limit
+	tmuxSessions *tmuxSessionLimiter
 
 	// WebTransport support
 	wtServer *WebTransportServer
 
-	authTokens *authTokenStore
+	resizePresets []webtty.TerminalSize
+	flushPatterns [][]byte
+	alpn          []string // ALPN protocols of the TLS server
+	tlsMinVersion uint16
+	cipherSuites  []uint16 // nil for Go's defaults
+	// Set when permitted arguments are checked against a denylist
+	argumentDenylist *regexp.Regexp
+	// Set when TLS certificates are obtained with ACME
+	acme *autocert.Manager
+	// Set when TLS certificates are loaded from files
+	certs *certReloader
+	// Set when a custom page is served at MaxConnection
+	fullPage *fullPage
+
+	// Set once a graceful shutdown has started
+	draining int32
+	// Unix nanoseconds until which new connections are rejected
+	// because the backend reported it is overloaded
+	overloadedUntil int64
+	// Sessions admitted and finished under MaxSessions
+	sessionsAdmitted int64
+	sessionsFinished int64
+	// Last backend probe of the health check
+	backendProbe backendProbe
+
+	connContext func(ctx context.Context, r *http.Request) context.Context
+	// Set when a graceful shutdown closes connections left after it
+	drainTimeout time.Duration
+
+	// Set while Run is serving, so that Shutdown can stop it
+	runMu   sync.Mutex
+	running *runControl
+
+	rateLimiter    *rateLimiter // locks out clients guessing credentials
+	authTokens     *authTokenStore
+	authPaths      []string     // prefixes requiring auth when it is otherwise disabled
+	jwt            *jwtVerifier // set in auth-mode jwt
+	totpSecret     []byte       // set when Basic Authentication needs a TOTP code
+	trustedProxies []*net.IPNet // proxies whose X-Forwarded-For is believed
+	connections    *connectionRegistry
+	replays        *replayStore
+	resumes        *resumeStore // set when sessions can be resumed
+	metrics        *metrics     // set when metrics are served
+
+	// Clients allowed to connect, all when empty, and those refused
+	allowedNetworks []*net.IPNet
+	deniedNetworks  []*net.IPNet
 }
 
 // New creates a new instance of Server.
@@ -79,8 +141,108 @@ func New(factory Factory, options *Options) (*Server, error) {
 		return nil, errors.Wrapf(err, "failed to parse window title format `%s`", options.TitleFormat)
 	}
 
+	var logPathTemplate *noesctmpl.Template
+	if options.SessionLogPath != "" {
+		logPathTemplate, err = noesctmpl.New("session_log").Option("missingkey=error").Parse(homedir.Expand(options.SessionLogPath))
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse session log path `%s`", options.SessionLogPath)
+		}
+	}
+
+	resizePresets, err := parseResizePresets(options.ResizePresets)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse resize presets")
+	}
+
+	flushPatterns, err := parseFlushPatterns(options.CoalesceFlushOn)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse coalesce flush patterns")
+	}
+
+	trustedProxies, err := parseNetworks(options.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse trusted proxies")
+	}
+	allowedNetworks, err := parseNetworks(options.AllowedCIDRs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse allowed CIDRs")
+	}
+	deniedNetworks, err := parseNetworks(options.DeniedCIDRs)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse denied CIDRs")
+	}
+	if len(allowedNetworks)+len(deniedNetworks) > 0 && len(trustedProxies) == 0 && options.TrustXForwardedFor {
+		log.Printf("Warning: clients can pass allowed-cidrs and denied-cidrs with a forged X-Forwarded-For, set trusted-proxies or trust-x-forwarded-for=false")
+	}
+
+	alpn, err := parseALPN(options.TLSALPN)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse ALPN protocols")
+	}
+
+	tlsMinVersion, err := parseTLSVersion(options.TLSMinVersion)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse TLS version")
+	}
+	cipherSuites, err := parseCipherSuites(options.TLSCipherSuites)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse cipher suites")
+	}
+	if err := checkHTTP2CipherSuites(cipherSuites, alpn); err != nil {
+		return nil, err
+	}
+
+	if options.EnableTLS && !slices.Contains(alpn, "http/1.1") {
+		log.Printf("Warning: tls-alpn does not offer http/1.1, which WebSocket connections need")
+	}
+
+	var acmeManager *autocert.Manager
+	if options.EnableACME {
+		domains := parseACMEDomains(options.ACMEDomains)
+		acmeManager = &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(domains...),
+			Cache:      autocert.DirCache(homedir.Expand(options.ACMECacheDir)),
+		}
+		log.Printf("Using ACME certificates for %s", strings.Join(domains, ", "))
+	}
+
+	var full *fullPage
+	if options.FullPage != "" {
+		full, err = loadFullPage(homedir.Expand(options.FullPage))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var argumentDenylist *regexp.Regexp
+	if options.PermitArguments && options.ArgumentDenylist != "" {
+		argumentDenylist, err = regexp.Compile(options.ArgumentDenylist)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to compile argument denylist `%s`", options.ArgumentDenylist)
+		}
+	}
+
+	var jwt *jwtVerifier
+	if options.AuthMode == "jwt" {
+		jwt, err = newJWTVerifier(options)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to set up JWT authentication")
+		}
+	}
+
+	var totpSecret []byte
+	if options.TOTPSecret != "" {
+		totpSecret, err = parseTOTPSecret(options.TOTPSecret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	var originChekcer func(r *http.Request) bool
-	if options.WSOrigin != "" {
+	if options.AutoOrigin {
+		originChekcer = pinnedOrigin
+	} else if options.WSOrigin != "" {
 		matcher, err := regexp.Compile(options.WSOrigin)
 		if err != nil {
 			return nil, errors.Wrapf(err, "failed to compile regular expression of Websocket Origin: %s", options.WSOrigin)
@@ -100,13 +262,43 @@ func New(factory Factory, options *Options) (*Server, error) {
 		upgrader: &websocket.Upgrader{
 			ReadBufferSize:  1024,
 			WriteBufferSize: 1024,
-			Subprotocols:    webtty.Protocols,
+			Subprotocols:    parseSubprotocols(options.WSSubprotocols),
 			CheckOrigin:     originChekcer,
 		},
 		indexTemplate:    indexTemplate,
 		titleTemplate:    titleTemplate,
 		manifestTemplate: manifestTemplate,
+		logPathTemplate:  logPathTemplate,
+		rateLimiter: newRateLimiter(rateLimits{
+			maxFailures:     options.AuthMaxFailures,
+			lockoutBase:     time.Duration(options.AuthLockoutBase) * time.Second,
+			globalThreshold: options.AuthGlobalThreshold,
+			cleanupInterval: time.Duration(options.AuthCleanupInterval) * time.Second,
+		}),
 		authTokens:       newAuthTokenStore(authTokenTTL),
+		jwt:              jwt,
+		totpSecret:       totpSecret,
+		resizePresets:    resizePresets,
+		flushPatterns:    flushPatterns,
+		trustedProxies:   trustedProxies,
+		allowedNetworks:  allowedNetworks,
+		deniedNetworks:   deniedNetworks,
+		fullPage:         full,
+		alpn:             alpn,
+		tlsMinVersion:    tlsMinVersion,
+		cipherSuites:     cipherSuites,
+		argumentDenylist: argumentDenylist,
+		acme:             acmeManager,
+		connections:      newConnectionRegistry(),
+		replays:          newReplayStore(),
+	}
+
+	server.authTokens.idle = time.Duration(options.AuthTokenIdle) * time.Second
+	if options.EnableMetrics {
+		server.metrics = newMetrics()
+	}
+	if options.ResumeTimeout > 0 {
+		server.resumes = newResumeStore(time.Duration(options.ResumeTimeout) * time.Second)
 	}
 
 	// Detect tmux session from command
@@ -114,16 +306,64 @@ func New(factory Factory, options *Options) (*Server, error) {
 	if server.tmuxSession != "" {
 		log.Printf("Detected tmux session: %s", server.tmuxSession)
 	}
+	if options.TmuxSessionRate > 0 || options.MaxTmuxSessions > 0 {
+		if server.createsTmuxSessions() {
+			server.tmuxSessions = newTmuxSessionLimiter(options.TmuxSessionRate, options.MaxTmuxSessions)
+			server.tmuxSessions.sessions = tmuxSessionCounter(factory.Command())
+		} else {
+			log.Printf("Warning: the command does not create a tmux session per connection, ignoring tmux session limits")
+		}
+	}
+	if options.CredentialFile != "" {
+		if err := server.ReloadCredentials(); err != nil {
+			return nil, err
+		}
+	}
 
 	return server, nil
 }
 
-// detectTmuxSession checks if we're running tmux and extracts the session name
-func (server *Server) detectTmuxSession() string {
+// tmuxCommand splits the command of the factory into a tmux subcommand and
+// its arguments. The subcommand is empty for a bare tmux, and ok is false
+// when the command is not tmux or runs a shell command with tmux -c.
+func (server *Server) tmuxCommand() (subcommand string, args []string, ok bool) {
 	cmd, argv := server.factory.Command()
 
 	// Check if command is tmux
 	if !strings.HasSuffix(cmd, "tmux") && cmd != "tmux" {
+		return "", nil, false
+	}
+
+	// Skip global options to find the subcommand
+	i := 0
+	for ; i < len(argv) && strings.HasPrefix(argv[i], "-"); i++ {
+		switch argv[i] {
+		case "-c":
+			// tmux -c runs a shell command, not a session
+			return "", nil, false
+		case "-f", "-L", "-S", "-T":
+			i++
+		}
+	}
+	if i >= len(argv) {
+		return "", nil, true
+	}
+	return argv[i], argv[i+1:], true
+}
+
+// detectTmuxSession checks if we're running tmux and extracts the session name.
+// Only attach and new-session commands bear a session; management commands
+// such as kill-session or list-sessions return an empty name.
+func (server *Server) detectTmuxSession() string {
+	subcommand, args, ok := server.tmuxCommand()
+	if !ok {
+		return ""
+	}
+	if subcommand == "" {
+		// A bare tmux starts a new session
+		return "0"
+	}
+	if !isTmuxSessionCommand(subcommand) {
 		return ""
 	}
 
@@ -132,9 +372,9 @@ func (server *Server) detectTmuxSession() string {
 	// tmux new-session -A -s <name>
 	// tmux attach -t <name>
 	// tmux attach-session -t <name>
-	for i, arg := range argv {
-		if (arg == "-s" || arg == "-t") && i+1 < len(argv) {
-			return argv[i+1]
+	for j, arg := range args {
+		if (arg == "-s" || arg == "-t") && j+1 < len(args) {
+			return args[j+1]
 		}
 	}
 
@@ -142,6 +382,52 @@ func (server *Server) detectTmuxSession() string {
 	return "0"
 }
 
+// createsTmuxSessions reports whether every connection creates a new tmux
+// session, which is the case for new-session without -A.
+func (server *Server) createsTmuxSessions() bool {
+	subcommand, args, ok := server.tmuxCommand()
+	if !ok {
+		return false
+	}
+	if subcommand == "" {
+		return true
+	}
+	if !isTmuxNewSessionCommand(subcommand) {
+		return false
+	}
+	for _, arg := range args {
+		if arg == "-A" {
+			return false
+		}
+	}
+	return true
+}
+
+// sharedSession returns the tmux session every client attaches to, whose
+// output may be replayed to any of them, or "" when each client gets a
+// backend of its own.
+func (server *Server) sharedSession() string {
+	if server.tmuxSession == "" || server.createsTmuxSessions() {
+		return ""
+	}
+	return server.tmuxSession
+}
+
+// isTmuxSessionCommand reports whether a tmux subcommand attaches to or
+// creates a session, accepting aliases and unambiguous prefixes.
+func isTmuxSessionCommand(command string) bool {
+	if isTmuxNewSessionCommand(command) {
+		return true
+	}
+	return strings.HasPrefix("attach-session", command)
+}
+
+// isTmuxNewSessionCommand reports whether a tmux subcommand is new-session,
+// accepting its alias and unambiguous prefixes.
+func isTmuxNewSessionCommand(command string) bool {
+	return command == "new" || (len(command) >= len("new-s") && strings.HasPrefix("new-session", command))
+}
+
 // Run starts the main process of the Server.
 // The cancelation of ctx will shutdown the server immediately with aborting
 // existing connections. Use WithGracefullContext() to support gracefull shutdown.
@@ -151,6 +437,24 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	for _, opt := range options {
 		opt(opts)
 	}
+	var shutdown context.CancelFunc
+	opts.gracefullCtx, shutdown = context.WithCancel(opts.gracefullCtx)
+	defer shutdown()
+	defer server.startRun(shutdown, cancel)()
+	server.connContext = opts.connContext
+	server.drainTimeout = opts.drainTimeout
+
+	if len(opts.signals) > 0 {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, opts.signals...)
+		defer signal.Stop(sigChan)
+
+		var gracefulCancel context.CancelFunc
+		opts.gracefullCtx, gracefulCancel = context.WithCancel(opts.gracefullCtx)
+		sigCtx, stopSignals := context.WithCancel(cctx)
+		defer stopSignals()
+		go handleSignals(sigCtx, sigChan, gracefulCancel, cancel)
+	}
 
 	// Start tmux controller if we detected a tmux session
 	if server.tmuxSession != "" {
@@ -170,6 +474,13 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	}
 
 	counter := newCounter(time.Duration(server.options.Timeout) * time.Second)
+	counter.onZero(func() {
+		log.Printf("No clients for %d seconds, exiting", server.options.Timeout)
+		cancel()
+	})
+	if server.resumes != nil {
+		defer server.resumes.closeAll()
+	}
 
 	path := server.options.Path
 	if server.options.EnableRandomUrl {
@@ -193,12 +504,37 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	if server.options.Once {
 		log.Printf("Once option is provided, accepting only one client")
 	}
+	if server.options.MaxSessions > 0 {
+		log.Printf("Max sessions option is provided, exiting after %d sessions", server.options.MaxSessions)
+	}
+	if server.options.BlockConnections {
+		log.Printf("Block connections option is provided, rejecting all clients")
+	}
+	if server.options.CredentialFile != "" {
+		go server.watchCredentialFile(opts.gracefullCtx, homedir.Expand(server.options.CredentialFile))
+	}
+	if server.options.ConnLogInterval > 0 {
+		go logConnectionCounts(cctx, counter, time.Duration(server.options.ConnLogInterval)*time.Second)
+	}
+	if server.options.ReloadIndex && server.options.IndexFile != "" {
+		go server.watchIndexFile(cctx, homedir.Expand(server.options.IndexFile))
+	}
+	if server.certs != nil {
+		go server.certs.watch(cctx)
+	}
+
+	if server.options.SelfTest {
+		if err := server.selfTest(cctx); err != nil {
+			return errors.Wrapf(err, "self-test failed")
+		}
+		log.Printf("Self-test passed")
+	}
 
 	if server.options.Port == "0" {
 		log.Printf("Port number configured to `0`, choosing a random port")
 	}
 	hostPort := net.JoinHostPort(server.options.Address, server.options.Port)
-	listener, err := net.Listen("tcp", hostPort)
+	listener, err := net.Listen(listenNetwork("tcp", server.options.AddressFamily), hostPort)
 	if err != nil {
 		return errors.Wrapf(err, "failed to listen at `%s`", hostPort)
 	}
@@ -209,21 +545,18 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 	}
 	host, port, _ := net.SplitHostPort(listener.Addr().String())
 	log.Printf("HTTP server is listening at: %s", scheme+"://"+net.JoinHostPort(host, port)+path)
-	if server.options.Address == "0.0.0.0" {
-		for _, address := range listAddresses() {
+	if listensOnAllInterfaces(server.options.Address) {
+		for _, address := range advertisedAddresses() {
 			log.Printf("Alternative URL: %s", scheme+"://"+net.JoinHostPort(address, port)+path)
 		}
 	}
 
 	srvErr := make(chan error, 1)
 	go func() {
+		var err error
 		if server.options.EnableTLS {
-			crtFile := homedir.Expand(server.options.TLSCrtFile)
-			keyFile := homedir.Expand(server.options.TLSKeyFile)
-			log.Printf("TLS crt file: %s", crtFile)
-			log.Printf("TLS key file: %s", keyFile)
-
-			err = srv.ServeTLS(listener, crtFile, keyFile)
+			// Certificates come from srv.TLSConfig.GetCertificate
+			err = srv.ServeTLS(listener, "", "")
 		} else {
 			err = srv.Serve(listener)
 		}
@@ -243,57 +576,139 @@ func (server *Server) Run(ctx context.Context, options ...RunOption) error {
 
 		// Setup WebTransport handlers
 		wtMux := http.NewServeMux()
-		wtMux.HandleFunc(path+"wt", server.generateHandleWT(cctx, cancel, counter))
+		wtMux.Handle(path+"wt", server.wrapIPFilter(server.generateHandleWT(cctx, cancel, counter)))
 
 		go func() {
-			crtFile := homedir.Expand(server.options.TLSCrtFile)
-			keyFile := homedir.Expand(server.options.TLSKeyFile)
-			if err := wtServer.ListenAndServeTLS(cctx, crtFile, keyFile, wtMux); err != nil {
+			if err := wtServer.ListenAndServeAutoTLS(cctx, server.getCertificate(), wtMux); err != nil {
 				wtErr <- err
 			}
 		}()
 
 		log.Printf("WebTransport server enabled on UDP port %s (same as HTTP)", server.options.Port)
+		if server.cipherSuites != nil {
+			// QUIC mandates TLS 1.3, whose cipher suites are not configurable
+			log.Printf("WebTransport always uses TLS 1.3, tls-cipher-suites only applies to the HTTP server")
+		}
 	}
 
-	go func() {
-		select {
-		case <-opts.gracefullCtx.Done():
-			srv.Shutdown(context.Background())
-			if server.wtServer != nil {
-				server.wtServer.Close()
-			}
-		case <-cctx.Done():
+	var wts io.Closer
+	if server.wtServer != nil {
+		wts = server.wtServer
+	}
+
+	err = server.awaitShutdown(cctx, cancel, opts.gracefullCtx, srv, wts, counter, srvErr, wtErr)
+
+	conn := counter.count()
+	if conn > 0 {
+		log.Printf("Waiting for %d connections to be closed", conn)
+	}
+	counter.wait()
+
+	return err
+}
+
+// awaitShutdown serves until one of the contexts is canceled or a server
+// fails. Cancelation of gracefulCtx drains active connections first, while
+// cancelation of ctx closes everything right away, even during draining or
+// when both happen at once. It returns nil after a graceful shutdown and
+// ctx.Err() after a forced one.
+func (server *Server) awaitShutdown(ctx context.Context, cancel context.CancelFunc, gracefulCtx context.Context, srv httpServer, wts io.Closer, counter *counter, srvErr <-chan error, wtErr <-chan error) error {
+	forceStop := func() error {
+		srv.Close()
+		if wts != nil {
+			wts.Close()
 		}
-	}()
+		return ctx.Err()
+	}
 
 	select {
-	case err = <-srvErr:
+	case <-gracefulCtx.Done():
+		if ctx.Err() != nil {
+			return forceStop()
+		}
+		return server.shutdownGracefully(ctx, srv, wts, counter)
+	case err := <-srvErr:
 		if err == http.ErrServerClosed { // by gracefull ctx
-			err = nil
-		} else {
-			cancel()
+			return nil
 		}
-	case err = <-wtErr:
+		cancel()
+		return err
+	case err := <-wtErr:
 		log.Printf("WebTransport server error: %v", err)
 		cancel()
-	case <-cctx.Done():
-		srv.Close()
-		if server.wtServer != nil {
-			server.wtServer.Close()
-		}
-		err = cctx.Err()
+		return err
+	case <-ctx.Done():
+		return forceStop()
 	}
+}
 
-	conn := counter.count()
-	if conn > 0 {
-		log.Printf("Waiting for %d connections to be closed", conn)
+// httpServer is the part of *http.Server used to shut it down.
+type httpServer interface {
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+// shutdownGracefully stops accepting new connections on both transports,
+// waits for active sessions to finish, then closes the TCP server followed
+// by the HTTP/3 server. Sessions still active after drainTimeout, if set,
+// have their transports closed. If ctx is canceled while draining, both
+// servers are closed right away and ctx.Err() is returned.
+func (server *Server) shutdownGracefully(ctx context.Context, srv httpServer, wts io.Closer, counter *counter) error {
+	atomic.StoreInt32(&server.draining, 1)
+	srv.Shutdown(context.Background())
+
+	if conn := counter.count(); conn > 0 {
+		log.Printf("Draining %d connections before shutdown", conn)
+	}
+	drained := make(chan struct{})
+	go func() {
+		counter.wait()
+		close(drained)
+	}()
+
+	var deadline <-chan time.Time
+	if server.drainTimeout > 0 {
+		timer := time.NewTimer(server.drainTimeout)
+		defer timer.Stop()
+		deadline = timer.C
 	}
-	counter.wait()
 
+	var err error
+	select {
+	case <-drained:
+	case <-deadline:
+		server.closeConnections()
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	srv.Close()
+	if wts != nil {
+		wts.Close()
+	}
 	return err
 }
 
+// closeConnections closes the transports of all active sessions, ending
+// them as if their clients went away.
+func (server *Server) closeConnections() {
+	entries := server.connections.list()
+	log.Printf("Drain timeout of %s reached, closing %d connections", server.drainTimeout, len(entries))
+	for _, entry := range entries {
+		entry.transport.Close()
+	}
+}
+
+// isDraining reports whether the server stopped accepting new connections.
+func (server *Server) isDraining() bool {
+	return atomic.LoadInt32(&server.draining) == 1
+}
+
 func (server *Server) setupHandlers(ctx context.Context, cancel context.CancelFunc, pathPrefix string, counter *counter) http.Handler {
 	fs, err := fs.Sub(bindata.Fs, "static")
 	if err != nil {
@@ -312,25 +727,59 @@ func (server *Server) setupHandlers(ctx context.Context, cancel context.CancelFu
 	siteMux.HandleFunc(pathPrefix+"manifest.json", server.handleManifest)
 	siteMux.HandleFunc(pathPrefix+"auth_token.js", server.handleAuthToken)
 	siteMux.HandleFunc(pathPrefix+"config.js", server.handleConfig)
+	if server.metrics != nil {
+		siteMux.Handle(pathPrefix+"metrics", server.handleMetrics(counter))
+	}
 
 	siteHandler := http.Handler(siteMux)
 
 	if server.options.EnableBasicAuth {
-		log.Printf("Using Basic Authentication")
-		siteHandler = server.wrapBasicAuth(siteHandler, server.options.Credential)
+		if server.jwt != nil {
+			log.Printf("Using JWT Authentication")
+		} else {
+			log.Printf("Using Basic Authentication")
+		}
+		siteMux.HandleFunc(pathPrefix+"internal/auth_status.json", server.handleAuthStatus)
+		siteHandler = server.wrapAuth(siteHandler)
+	} else if server.options.AuthPaths != "" {
+		server.authPaths = parseAuthPaths(pathPrefix, server.options.AuthPaths)
+		log.Printf("Using Basic Authentication for %s", strings.Join(server.authPaths, ", "))
+		siteHandler = server.wrapPathAuth(siteHandler)
 	}
 
-	withGz := gziphandler.GzipHandler(server.wrapHeaders(siteHandler))
-	siteHandler = server.wrapLogger(withGz)
+	withCompression := compressHandler(server.wrapHeaders(siteHandler))
+	siteHandler = server.wrapLogger(withCompression)
 
 	wsMux := http.NewServeMux()
 	wsMux.Handle("/", siteHandler)
 	wsMux.HandleFunc(pathPrefix+"ws", server.generateHandleWS(ctx, cancel, counter))
-	siteHandler = http.Handler(wsMux)
+	if server.options.EnableStream {
+		// Outside siteHandler, whose compression would hold events back
+		streamHandler := server.wrapAuth(server.generateHandleStream(ctx, cancel, counter))
+		wsMux.Handle(pathPrefix+"stream", streamHandler)
+	}
+	if server.options.HealthCheckPath != "" {
+		wsMux.HandleFunc(pathPrefix+strings.TrimPrefix(server.options.HealthCheckPath, "/"), server.handleHealth)
+	}
+	siteHandler = server.wrapIPFilter(wsMux)
 
 	return siteHandler
 }
 
+// getCertificate returns the GetCertificate of the TLS server, which takes
+// certificates from ACME or the certificate files, or nil before the server
+// is set up.
+func (server *Server) getCertificate() func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	switch {
+	case server.acme != nil:
+		return server.acme.GetCertificate
+	case server.certs != nil:
+		return server.certs.GetCertificate
+	default:
+		return nil
+	}
+}
+
 func (server *Server) setupHTTPServer(handler http.Handler) (*http.Server, error) {
 	srv := &http.Server{
 		Handler: handler,
@@ -344,6 +793,41 @@ func (server *Server) setupHTTPServer(handler http.Handler) (*http.Server, error
 		srv.TLSConfig = tlsConfig
 	}
 
+	if server.options.EnableTLS {
+		if srv.TLSConfig == nil {
+			srv.TLSConfig = &tls.Config{}
+		}
+		srv.TLSConfig.NextProtos = server.alpn
+		srv.TLSConfig.MinVersion = server.tlsMinVersion
+		srv.TLSConfig.CipherSuites = server.cipherSuites
+		if server.acme != nil {
+			srv.TLSConfig.GetCertificate = server.acme.GetCertificate
+			// Answers tls-alpn-01 challenges
+			srv.TLSConfig.NextProtos = append(slices.Clone(server.alpn), acme.ALPNProto)
+		} else {
+			crtFile := homedir.Expand(server.options.TLSCrtFile)
+			keyFile := homedir.Expand(server.options.TLSKeyFile)
+			log.Printf("TLS crt file: %s", crtFile)
+			log.Printf("TLS key file: %s", keyFile)
+
+			certs, err := newCertReloader(crtFile, keyFile)
+			if err != nil {
+				return nil, err
+			}
+			server.certs = certs
+			srv.TLSConfig.GetCertificate = certs.GetCertificate
+		}
+		srv.Protocols = new(http.Protocols)
+		for _, protocol := range server.alpn {
+			switch protocol {
+			case "h2":
+				srv.Protocols.SetHTTP2(true)
+			case "http/1.1":
+				srv.Protocols.SetHTTP1(true)
+			}
+		}
+	}
+
 	return srv, nil
 }
 
@@ -1,7 +1,14 @@
 package server
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 // mockFactory is a mock implementation of Factory for testing
@@ -85,6 +92,39 @@ func TestNewServerWithWSOrigin(t *testing.T) {
 	}
 }
 
+func TestNewServerWithAutoOrigin(t *testing.T) {
+	factory := newMockFactory()
+	options := &Options{
+		TitleFormat: "WebTmux",
+		AutoOrigin:  true,
+	}
+
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	tests := []struct {
+		origin      string
+		shouldAllow bool
+	}{
+		{"http://webtmux.local:8080", true},
+		{"http://evil.com:8080", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://webtmux.local:8080/ws", nil)
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+
+		if allowed := server.upgrader.CheckOrigin(req); allowed != tt.shouldAllow {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", tt.origin, allowed, tt.shouldAllow)
+		}
+	}
+}
+
 func TestNewServerInvalidWSOrigin(t *testing.T) {
 	factory := newMockFactory()
 	options := &Options{
@@ -150,10 +190,28 @@ func TestDetectTmuxSession(t *testing.T) {
 			want:    "0", // defaults to "0" when no session specified
 		},
 		{
-			name:    "tmux with other command",
+			name:    "tmux new-session with -s flag",
+			command: "/usr/bin/tmux",
+			argv:    []string{"new-session", "-A", "-s", "work"},
+			want:    "work",
+		},
+		{
+			name:    "tmux new with global socket option",
 			command: "tmux",
-			argv:    []string{"list-sessions"},
-			want:    "0", // defaults to "0" when no session specified
+			argv:    []string{"-L", "sock", "new", "-s", "work"},
+			want:    "work",
+		},
+		{
+			name:    "bare tmux",
+			command: "tmux",
+			argv:    []string{},
+			want:    "0",
+		},
+		{
+			name:    "tmux shell command",
+			command: "tmux",
+			argv:    []string{"-c", "ls -t foo"},
+			want:    "",
 		},
 	}
 
@@ -180,6 +238,43 @@ func TestDetectTmuxSession(t *testing.T) {
 	}
 }
 
+func TestDetectTmuxSessionManagementCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		argv []string
+	}{
+		{"kill-session", []string{"kill-session", "-t", "foo"}},
+		{"kill-server", []string{"kill-server"}},
+		{"rename-session", []string{"rename-session", "-t", "foo", "bar"}},
+		{"list-sessions", []string{"list-sessions"}},
+		{"ls alias", []string{"ls"}},
+		{"detach-client", []string{"detach-client", "-s", "foo"}},
+		{"has-session", []string{"has-session", "-t", "foo"}},
+		{"switch-client", []string{"switch-client", "-t", "foo"}},
+		{"new-window", []string{"new-window", "-t", "foo"}},
+		{"with global option", []string{"-S", "/tmp/sock", "kill-session", "-t", "foo"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := &mockFactory{
+				name:    "mock",
+				command: "tmux",
+				argv:    tt.argv,
+			}
+
+			server, err := New(factory, &Options{TitleFormat: "test"})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			if server.tmuxSession != "" {
+				t.Errorf("tmuxSession = %q, want no session for %v", server.tmuxSession, tt.argv)
+			}
+		})
+	}
+}
+
 func TestNewServerWithCustomIndexFile(t *testing.T) {
 	factory := newMockFactory()
 	options := &Options{
@@ -206,3 +301,327 @@ func BenchmarkNewServer(b *testing.B) {
 		New(factory, options)
 	}
 }
+
+// shutdownRecorder records the order in which servers are shut down
+type shutdownRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *shutdownRecorder) record(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *shutdownRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.events...)
+}
+
+type mockHTTPServer struct{ recorder *shutdownRecorder }
+
+func (m *mockHTTPServer) Shutdown(ctx context.Context) error {
+	m.recorder.record("tcp shutdown")
+	return nil
+}
+
+func (m *mockHTTPServer) Close() error {
+	m.recorder.record("tcp close")
+	return nil
+}
+
+type mockWTServer struct{ recorder *shutdownRecorder }
+
+func (m *mockWTServer) Close() error {
+	m.recorder.record("h3 close")
+	return nil
+}
+
+func TestShutdownGracefullyOrdering(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+	counter := newCounter(0)
+	counter.add(1)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.shutdownGracefully(context.Background(), &mockHTTPServer{recorder}, &mockWTServer{recorder}, counter)
+	}()
+
+	// New connections are refused while the active one drains
+	time.Sleep(50 * time.Millisecond)
+	if !server.isDraining() {
+		t.Error("server should be draining")
+	}
+	if events := recorder.get(); !reflect.DeepEqual(events, []string{"tcp shutdown"}) {
+		t.Errorf("events before drain = %v, want [tcp shutdown]", events)
+	}
+
+	counter.done()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("shutdownGracefully() error = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdownGracefully() did not return after draining")
+	}
+
+	want := []string{"tcp shutdown", "tcp close", "h3 close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestShutdownGracefullyForceClose(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+	counter := newCounter(0)
+	counter.add(1)
+	defer counter.done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- server.shutdownGracefully(ctx, &mockHTTPServer{recorder}, &mockWTServer{recorder}, counter)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("shutdownGracefully() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdownGracefully() did not return after cancelation")
+	}
+
+	want := []string{"tcp shutdown", "tcp close", "h3 close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestShutdownGracefullyWithoutWebTransport(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+
+	err := server.shutdownGracefully(context.Background(), &mockHTTPServer{recorder}, nil, newCounter(0))
+	if err != nil {
+		t.Errorf("shutdownGracefully() error = %v", err)
+	}
+
+	want := []string{"tcp shutdown", "tcp close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+// drainTestTransport is a long-lived connection that ends its session,
+// counted by counter, only when closed
+type drainTestTransport struct {
+	*connTestTransport
+	counter *counter
+	closed  chan struct{}
+	once    sync.Once
+}
+
+func (d *drainTestTransport) Close() error {
+	d.once.Do(func() {
+		close(d.closed)
+		d.counter.done()
+	})
+	return nil
+}
+
+func TestShutdownGracefullyDrainTimeout(t *testing.T) {
+	server := &Server{options: &Options{}, connections: newConnectionRegistry(), drainTimeout: 100 * time.Millisecond}
+	recorder := &shutdownRecorder{}
+	counter := newCounter(0)
+	counter.add(1)
+	transport := &drainTestTransport{connTestTransport: newConnTestTransport(), counter: counter, closed: make(chan struct{})}
+	server.connections.add(transport, "")
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.shutdownGracefully(context.Background(), &mockHTTPServer{recorder}, &mockWTServer{recorder}, counter)
+	}()
+
+	select {
+	case <-transport.closed:
+		if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+			t.Errorf("connection closed after %v, before the drain timeout", elapsed)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connection was not closed after the drain timeout")
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("shutdownGracefully() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdownGracefully() did not return after closing the connection")
+	}
+
+	want := []string{"tcp shutdown", "tcp close", "h3 close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestShutdownGracefullyDrainedBeforeTimeout(t *testing.T) {
+	server := &Server{options: &Options{}, connections: newConnectionRegistry(), drainTimeout: time.Minute}
+	counter := newCounter(0)
+	counter.add(1)
+	transport := &drainTestTransport{connTestTransport: newConnTestTransport(), counter: counter, closed: make(chan struct{})}
+	server.connections.add(transport, "")
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.shutdownGracefully(context.Background(), &mockHTTPServer{&shutdownRecorder{}}, nil, counter)
+	}()
+
+	// The session finishes on its own
+	counter.done()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("shutdownGracefully() error = %v, want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("shutdownGracefully() did not return once drained")
+	}
+	select {
+	case <-transport.closed:
+		t.Error("connection should not be closed when drained before the timeout")
+	default:
+	}
+}
+
+func TestHandleWSRejectedWhileDraining(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{TitleFormat: "WebTmux"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	atomic.StoreInt32(&server.draining, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	handler := server.generateHandleWS(ctx, cancel, counter)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/ws", nil))
+
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	if counter.count() != 0 {
+		t.Errorf("counter = %d, want 0", counter.count())
+	}
+}
+
+func TestAwaitShutdownMainOnly(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+	counter := newCounter(0)
+	counter.add(1)
+	defer counter.done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := server.awaitShutdown(ctx, cancel, context.Background(), &mockHTTPServer{recorder}, &mockWTServer{recorder}, counter, nil, nil)
+	if err != context.Canceled {
+		t.Errorf("awaitShutdown() error = %v, want %v", err, context.Canceled)
+	}
+
+	// Closed right away without draining the active connection
+	if server.isDraining() {
+		t.Error("server should not drain on a forced stop")
+	}
+	want := []string{"tcp close", "h3 close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestAwaitShutdownGracefulThenMain(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+	counter := newCounter(0)
+	counter.add(1)
+	defer counter.done()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	gracefulCtx, gracefulCancel := context.WithCancel(context.Background())
+	gracefulCancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- server.awaitShutdown(ctx, cancel, gracefulCtx, &mockHTTPServer{recorder}, &mockWTServer{recorder}, counter, nil, nil)
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	if events := recorder.get(); !reflect.DeepEqual(events, []string{"tcp shutdown"}) {
+		t.Errorf("events while draining = %v, want [tcp shutdown]", events)
+	}
+
+	// The main context interrupts the drain
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("awaitShutdown() error = %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("awaitShutdown() did not return after the main context was canceled")
+	}
+
+	want := []string{"tcp shutdown", "tcp close", "h3 close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
+
+func TestAwaitShutdownBothCanceled(t *testing.T) {
+	for i := 0; i < 20; i++ {
+		server := &Server{options: &Options{}}
+		recorder := &shutdownRecorder{}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		gracefulCtx, gracefulCancel := context.WithCancel(context.Background())
+		gracefulCancel()
+		cancel()
+
+		err := server.awaitShutdown(ctx, cancel, gracefulCtx, &mockHTTPServer{recorder}, nil, newCounter(0), nil, nil)
+		if err != context.Canceled {
+			t.Fatalf("awaitShutdown() error = %v, want the forced stop to win", err)
+		}
+		if events := recorder.get(); !reflect.DeepEqual(events, []string{"tcp close"}) {
+			t.Fatalf("events = %v, want [tcp close]", events)
+		}
+	}
+}
+
+func TestAwaitShutdownGracefulOnly(t *testing.T) {
+	server := &Server{options: &Options{}}
+	recorder := &shutdownRecorder{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gracefulCtx, gracefulCancel := context.WithCancel(context.Background())
+	gracefulCancel()
+
+	err := server.awaitShutdown(ctx, cancel, gracefulCtx, &mockHTTPServer{recorder}, nil, newCounter(0), nil, nil)
+	if err != nil {
+		t.Errorf("awaitShutdown() error = %v, want nil", err)
+	}
+	want := []string{"tcp shutdown", "tcp close"}
+	if events := recorder.get(); !reflect.DeepEqual(events, want) {
+		t.Errorf("events = %v, want %v", events, want)
+	}
+}
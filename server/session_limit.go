@@ -0,0 +1,57 @@
+package server
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+)
+
+// errSessionsExhausted is returned for connections beyond MaxSessions.
+var errSessionsExhausted = errors.New("all max-sessions have been served")
+
+// sessionAdmission records whether a connection was admitted as one of the
+// MaxSessions sessions, which happens once it is authenticated and about to
+// start a backend, so that failed handshakes do not use up the sessions.
+type sessionAdmission struct {
+	admitted bool
+}
+
+type sessionAdmissionKey struct{}
+
+func withSessionAdmission(ctx context.Context, admission *sessionAdmission) context.Context {
+	return context.WithValue(ctx, sessionAdmissionKey{}, admission)
+}
+
+// sessionsExhausted reports whether all MaxSessions sessions have been
+// handed out, so that new connections can be refused before their upgrade.
+func (server *Server) sessionsExhausted() bool {
+	if server.options.MaxSessions <= 0 {
+		return false
+	}
+	return atomic.LoadInt64(&server.sessionsAdmitted) >= int64(server.options.MaxSessions)
+}
+
+// admitSession reserves one of the MaxSessions sessions the server serves
+// for the connection of ctx. It reports false once all of them have been
+// handed out. Connections without a sessionAdmission are not counted.
+func (server *Server) admitSession(ctx context.Context) bool {
+	admission, ok := ctx.Value(sessionAdmissionKey{}).(*sessionAdmission)
+	if server.options.MaxSessions <= 0 || !ok {
+		return true
+	}
+	if atomic.AddInt64(&server.sessionsAdmitted, 1) > int64(server.options.MaxSessions) {
+		return false
+	}
+	admission.admitted = true
+	return true
+}
+
+// finishSession records the end of a connection and reports whether it was
+// the last admitted session, after which the server exits.
+func (server *Server) finishSession(admission *sessionAdmission) bool {
+	if server.options.MaxSessions <= 0 || !admission.admitted {
+		return false
+	}
+	return atomic.AddInt64(&server.sessionsFinished, 1) == int64(server.options.MaxSessions)
+}
@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialSession opens a WebSocket session to url and waits for it to end.
+func dialSession(t *testing.T, url string) {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(InitMessage{}); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+func TestMaxSessionsRefusesExtraConnection(t *testing.T) {
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{newExitingSlave("one", 0), newExitingSlave("two", 0)},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", MaxSessions: 2})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	ts := httptest.NewServer(server.generateHandleWS(ctx, cancel, counter))
+	defer ts.Close()
+
+	for i := 0; i < 2; i++ {
+		if ctx.Err() != nil {
+			t.Fatalf("server stopped after %d sessions, want 2", i)
+		}
+		dialSession(t, ts.URL)
+	}
+	waitFor(t, "the server to stop after 2 sessions", func() bool {
+		return ctx.Err() != nil
+	})
+
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("third session = %v, want status %d", err, http.StatusServiceUnavailable)
+	}
+}
+
+func TestMaxSessionsIgnoresFailedHandshakes(t *testing.T) {
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{newExitingSlave("one", 0)},
+	}
+	server, err := New(factory, &Options{TitleFormat: "Test", MaxSessions: 1, WSRequireProtocol: true})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.generateHandleWS(ctx, cancel, newCounter(0))
+
+	// Requests failing the method check, the subprotocol negotiation or the
+	// upgrade are not sessions
+	for _, req := range []*http.Request{
+		httptest.NewRequest("POST", "/ws", nil),
+		httptest.NewRequest("GET", "/ws", nil),
+	} {
+		handler(httptest.NewRecorder(), req)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("failed handshakes used up the only session")
+	}
+
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	dialer := websocket.Dialer{Subprotocols: []string{"webtty"}}
+	conn, _, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	defer conn.Close()
+	conn.WriteJSON(InitMessage{})
+	waitFor(t, "the server to stop after its session", func() bool {
+		return ctx.Err() != nil
+	})
+}
+
+func TestMaxSessionsIgnoresFailedAuth(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:     "Test",
+		MaxSessions:     1,
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: "wrong"})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+	admission := &sessionAdmission{}
+	ctx := withSessionAdmission(context.Background(), admission)
+	if err := server.processTransportConn(ctx, transport, nil, ""); err == nil {
+		t.Fatal("processTransportConn() should fail with a wrong token")
+	}
+	if admission.admitted || server.sessionsExhausted() {
+		t.Error("a connection failing authentication used up the only session")
+	}
+}
+
+func TestMaxSessionsUnlimited(t *testing.T) {
+	server := &Server{options: &Options{}}
+
+	for i := 0; i < 5; i++ {
+		admission := &sessionAdmission{}
+		if !server.admitSession(withSessionAdmission(context.Background(), admission)) {
+			t.Fatalf("session %d was refused without a limit", i+1)
+		}
+		if server.finishSession(admission) {
+			t.Fatalf("session %d should not be the last without a limit", i+1)
+		}
+	}
+}
+
+func TestRunReturnsAfterMaxSessions(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error: %v", err)
+	}
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	ln.Close()
+
+	factory := &sequenceFactory{
+		connTestFactory: newConnTestFactory(),
+		slaves:          []Slave{newExitingSlave("one", 0), newExitingSlave("two", 0)},
+	}
+	server, err := New(factory, &Options{
+		Address:     "127.0.0.1",
+		Port:        port,
+		Path:        "/",
+		TitleFormat: "Test",
+		MaxSessions: 2,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Run(context.Background())
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	// A request that is no session does not count
+	resp, err := http.Get("http://" + net.JoinHostPort("127.0.0.1", port) + "/ws")
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	resp.Body.Close()
+
+	for i := 0; i < 2; i++ {
+		dialSession(t, "http://"+net.JoinHostPort("127.0.0.1", port)+"/ws")
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil && err != context.Canceled {
+			t.Errorf("Run() returned error: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return after the last session")
+	}
+}
@@ -0,0 +1,93 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	noesctmpl "text/template"
+
+	"github.com/pkg/errors"
+)
+
+// sessionLogTimeFormat is the format of the `ts` session log path variable.
+const sessionLogTimeFormat = "20060102-150405"
+
+// sessionLogVariables returns the variables available to the session log
+// path template for conn.
+func (server *Server) sessionLogVariables(conn *connectionEntry) map[string]string {
+	return map[string]string{
+		"ip":      ipFromAddr(conn.RemoteAddr),
+		"ts":      conn.StartedAt.Format(sessionLogTimeFormat),
+		"session": server.tmuxSession,
+		"id":      strconv.FormatUint(conn.ID, 10),
+	}
+}
+
+// renderSessionLogPath fills tmpl with vars. Values are reduced to safe
+// file name characters so that they cannot add directories or escape
+// the directory chosen by the template.
+func renderSessionLogPath(tmpl *noesctmpl.Template, vars map[string]string) (string, error) {
+	safeVars := map[string]string{}
+	for key, val := range vars {
+		safeVars[key] = sanitizePathElement(val)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := tmpl.Execute(buf, safeVars); err != nil {
+		return "", errors.Wrapf(err, "failed to fill session log path template")
+	}
+
+	path := buf.String()
+	for _, elem := range strings.Split(filepath.ToSlash(path), "/") {
+		if elem == ".." {
+			return "", errors.Errorf("session log path `%s` must not contain `..`", path)
+		}
+	}
+	return filepath.Clean(path), nil
+}
+
+// sanitizePathElement replaces anything but letters, digits, `.`, `-` and `_`
+// with `_`, and never returns `.` or `..`.
+func sanitizePathElement(val string) string {
+	safe := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, val)
+	if safe == "" || strings.Trim(safe, ".") == "" {
+		return strings.Repeat("_", max(len(safe), 1))
+	}
+	return safe
+}
+
+// openSessionLog opens the session log at path for appending,
+// creating missing directories.
+func openSessionLog(path string) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create session log directory")
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open session log `%s`", path)
+	}
+	return file, nil
+}
+
+// loggingSlave copies everything read from the slave to a session log.
+type loggingSlave struct {
+	Slave
+	log io.Writer
+}
+
+func (ls *loggingSlave) Read(p []byte) (int, error) {
+	n, err := ls.Slave.Read(p)
+	if n > 0 {
+		ls.log.Write(p[:n])
+	}
+	return n, err
+}
@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	noesctmpl "text/template"
+	"time"
+)
+
+func TestRenderSessionLogPath(t *testing.T) {
+	tmpl := noesctmpl.Must(noesctmpl.New("session_log").Option("missingkey=error").Parse("/logs/{{ .session }}/{{ .ip }}-{{ .ts }}.cast"))
+
+	tests := []struct {
+		name    string
+		vars    map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain variables",
+			vars: map[string]string{"ip": "10.0.0.1", "ts": "20261015-120000", "session": "main"},
+			want: "/logs/main/10.0.0.1-20261015-120000.cast",
+		},
+		{
+			name: "traversal in values",
+			vars: map[string]string{"ip": "../../etc", "ts": "x/y", "session": ".."},
+			want: "/logs/__/.._.._etc-x_y.cast",
+		},
+		{
+			name: "ipv6 address",
+			vars: map[string]string{"ip": "::1", "ts": "1", "session": "0"},
+			want: "/logs/0/__1-1.cast",
+		},
+		{
+			name:    "missing variable",
+			vars:    map[string]string{"ip": "10.0.0.1"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := renderSessionLogPath(tmpl, tt.vars)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("renderSessionLogPath() = %q, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("renderSessionLogPath() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("renderSessionLogPath() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderSessionLogPathRejectsTraversalInTemplate(t *testing.T) {
+	tmpl := noesctmpl.Must(noesctmpl.New("session_log").Parse("/logs/../{{ .ip }}.log"))
+	if _, err := renderSessionLogPath(tmpl, map[string]string{"ip": "1"}); err == nil {
+		t.Error("renderSessionLogPath() should reject `..` in the template")
+	}
+}
+
+func TestSessionLogRecordsOutput(t *testing.T) {
+	dir := t.TempDir()
+	factory := newConnTestFactory()
+	slave := newExitingSlave("hello from the backend\r\n", 0)
+	server, err := New(&exitingFactory{factory, slave}, &Options{
+		TitleFormat:    "Test",
+		SessionLogPath: filepath.Join(dir, "{{ .ip }}", "{{ .id }}-{{ .ts }}.log"),
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.processTransportConn(ctx, transport, nil, "")
+
+	matches, _ := filepath.Glob(filepath.Join(dir, "127.0.0.1", "1-*.log"))
+	if len(matches) != 1 {
+		t.Fatalf("session logs = %v, want one file in %s/127.0.0.1", matches, dir)
+	}
+	logged, err := os.ReadFile(matches[0])
+	if err != nil {
+		t.Fatalf("failed to read session log: %v", err)
+	}
+	if string(logged) != "hello from the backend\r\n" {
+		t.Errorf("session log = %q, want the backend output", logged)
+	}
+}
+
+func TestNewWithInvalidSessionLogPath(t *testing.T) {
+	_, err := New(newMockFactory(), &Options{TitleFormat: "Test", SessionLogPath: "/logs/{{ .ip"})
+	if err == nil {
+		t.Error("New() should fail with an invalid session log path")
+	}
+}
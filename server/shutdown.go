@@ -0,0 +1,56 @@
+package server
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// runControl lets Shutdown stop a running Run.
+type runControl struct {
+	graceful context.CancelFunc
+	force    context.CancelFunc
+	done     chan struct{}
+}
+
+// startRun registers a Run being served, returning a function to call
+// once it has returned.
+func (server *Server) startRun(graceful, force context.CancelFunc) func() {
+	control := &runControl{graceful: graceful, force: force, done: make(chan struct{})}
+	server.runMu.Lock()
+	server.running = control
+	server.runMu.Unlock()
+
+	return func() {
+		server.runMu.Lock()
+		if server.running == control {
+			server.running = nil
+		}
+		server.runMu.Unlock()
+		close(control.done)
+	}
+}
+
+// Shutdown gracefully shuts down a running Server, like a cancelation of
+// the context passed with WithGracefullContext: new connections are
+// rejected, active ones are drained and both transport servers are closed.
+// It returns once Run has returned. If ctx is done first, active connections
+// are closed right away and ctx.Err() is returned.
+func (server *Server) Shutdown(ctx context.Context) error {
+	server.runMu.Lock()
+	control := server.running
+	server.runMu.Unlock()
+	if control == nil {
+		return errors.New("server is not running")
+	}
+
+	control.graceful()
+	select {
+	case <-control.done:
+		return nil
+	case <-ctx.Done():
+		control.force()
+		<-control.done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,140 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webtmux/webtty"
+)
+
+// runForShutdown runs a server on a free port, returning its address and
+// the result of Run.
+func runForShutdown(t *testing.T) (*Server, string, <-chan error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	addr := listener.Addr().String()
+	_, port, _ := net.SplitHostPort(addr)
+	listener.Close()
+
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", Address: "127.0.0.1", Port: port})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	runErr := make(chan error, 1)
+	go func() { runErr <- server.Run(ctx) }()
+
+	waitFor(t, "the server to listen", func() bool {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+	return server, addr, runErr
+}
+
+// openSession starts a terminal session on the server at addr.
+func openSession(t *testing.T, addr string) *websocket.Conn {
+	t.Helper()
+	dialer := &websocket.Dialer{Subprotocols: webtty.Protocols}
+	conn, _, err := dialer.Dial("ws://"+addr+"/ws", nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	init, _ := json.Marshal(InitMessage{})
+	conn.WriteMessage(websocket.TextMessage, init)
+	if _, _, err := conn.ReadMessage(); err != nil {
+		t.Fatalf("ReadMessage() error: %v", err)
+	}
+	return conn
+}
+
+func TestShutdownIdle(t *testing.T) {
+	server, _, runErr := runForShutdown(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Errorf("Shutdown() error: %v", err)
+	}
+	select {
+	case err := <-runErr:
+		if err != nil {
+			t.Errorf("Run() error = %v, want nil after Shutdown", err)
+		}
+	default:
+		t.Error("Run() should have returned when Shutdown returns")
+	}
+}
+
+func TestShutdownDrainsConnections(t *testing.T) {
+	server, addr, runErr := runForShutdown(t)
+	conn := openSession(t, addr)
+
+	shutdownErr := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+
+	select {
+	case err := <-shutdownErr:
+		t.Fatalf("Shutdown() returned %v before the connection was drained", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if !server.isDraining() {
+		t.Error("Server should reject new connections during Shutdown")
+	}
+
+	conn.Close()
+	select {
+	case err := <-shutdownErr:
+		if err != nil {
+			t.Errorf("Shutdown() error: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Shutdown() did not return after the connection closed")
+	}
+	if err := <-runErr; err != nil {
+		t.Errorf("Run() error = %v, want nil", err)
+	}
+}
+
+func TestShutdownContextExpires(t *testing.T) {
+	server, addr, runErr := runForShutdown(t)
+	conn := openSession(t, addr)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Shutdown() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	select {
+	case <-runErr:
+	default:
+		t.Error("Run() should have returned when Shutdown returns")
+	}
+}
+
+func TestShutdownNotRunning(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	if err := server.Shutdown(context.Background()); err == nil {
+		t.Error("Shutdown() of a server that is not running should fail")
+	}
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"context"
+	"log"
+	"os"
+)
+
+// handleSignals starts a graceful shutdown on the first signal received
+// from sigChan and aborts existing connections on the second.
+// It returns when ctx is done.
+func handleSignals(ctx context.Context, sigChan <-chan os.Signal, gracefulCancel context.CancelFunc, cancel context.CancelFunc) {
+	select {
+	case s := <-sigChan:
+		log.Printf("Received %s, shutting down gracefully (send again to force)", s)
+		gracefulCancel()
+	case <-ctx.Done():
+		return
+	}
+
+	select {
+	case s := <-sigChan:
+		log.Printf("Received %s, forcing shutdown", s)
+		cancel()
+	case <-ctx.Done():
+	}
+}
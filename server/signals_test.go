@@ -0,0 +1,62 @@
+package server
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHandleSignals(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	defer stop()
+	gracefulCtx, gracefulCancel := context.WithCancel(context.Background())
+	forceCtx, cancel := context.WithCancel(context.Background())
+
+	sigChan := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	go func() {
+		handleSignals(ctx, sigChan, gracefulCancel, cancel)
+		close(done)
+	}()
+
+	sigChan <- os.Interrupt
+	select {
+	case <-gracefulCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("first signal should start a graceful shutdown")
+	}
+	if forceCtx.Err() != nil {
+		t.Error("first signal should not force the shutdown")
+	}
+
+	sigChan <- os.Interrupt
+	select {
+	case <-forceCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("second signal should force the shutdown")
+	}
+	<-done
+}
+
+func TestHandleSignalsReturnsOnContextDone(t *testing.T) {
+	ctx, stop := context.WithCancel(context.Background())
+	gracefulCtx, gracefulCancel := context.WithCancel(context.Background())
+	defer gracefulCancel()
+
+	done := make(chan struct{})
+	go func() {
+		handleSignals(ctx, make(chan os.Signal), gracefulCancel, func() {})
+		close(done)
+	}()
+
+	stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("handleSignals should return when ctx is done")
+	}
+	if gracefulCtx.Err() != nil {
+		t.Error("graceful shutdown should not start without a signal")
+	}
+}
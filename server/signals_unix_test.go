@@ -0,0 +1,44 @@
+//go:build unix
+
+package server
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRunWithSignalHandling(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{
+		Address:     "127.0.0.1",
+		Port:        "0",
+		Path:        "/",
+		TitleFormat: "Test",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	// SIGWINCH is ignored by default, so the test survives if it arrives
+	// before the handler is installed.
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.Run(context.Background(), WithSignalHandling(syscall.SIGWINCH))
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGWINCH)
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Run() returned error: %v", err)
+		}
+		if !server.isDraining() {
+			t.Error("Run() should have shut down gracefully")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not shut down on signal")
+	}
+}
@@ -1,6 +1,10 @@
 package server
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"webtmux/webtty"
 )
 
@@ -11,9 +15,69 @@ type Slave interface {
 	Close() error
 }
 
+// ExitCoder is implemented by slaves that can report the exit code of
+// their process. ok is false if the process has not exited.
+type ExitCoder interface {
+	ExitCode() (code int, ok bool)
+}
+
+// ForegroundReporter is implemented by slaves that can tell the name of
+// the process running in the foreground of their terminal.
+type ForegroundReporter interface {
+	ForegroundProcess() (string, error)
+}
+
+// ResourceUsage is the resource consumption of a slave's processes.
+type ResourceUsage struct {
+	CPUTime time.Duration // user and system time consumed so far
+	RSS     int64         // resident memory in bytes
+}
+
+// ResourceReporter is implemented by slaves that can report the resources
+// used by their processes.
+type ResourceReporter interface {
+	ResourceUsage() (ResourceUsage, error)
+}
+
+// OverloadedError is returned by Factory.New when the backend cannot take
+// new sessions for now. The server then rejects new connections with 503
+// for RetryAfter, or for the configured cooldown when RetryAfter is zero.
+type OverloadedError struct {
+	RetryAfter time.Duration
+}
+
+func (err *OverloadedError) Error() string {
+	if err.RetryAfter > 0 {
+		return fmt.Sprintf("backend overloaded, retry after %s", err.RetryAfter)
+	}
+	return "backend overloaded"
+}
+
+// NoPTYError is returned by Factory.New when no pseudo-terminal could be
+// allocated for the session, typically because the system ran out of them.
+type NoPTYError struct {
+	Err error
+}
+
+func (err *NoPTYError) Error() string {
+	return "no pty available: " + err.Err.Error()
+}
+
+func (err *NoPTYError) Unwrap() error {
+	return err.Err
+}
+
 type Factory interface {
 	Name() string
 	New(params map[string][]string, headers map[string][]string) (Slave, error)
 	// Command returns the command and arguments
 	Command() (string, []string)
 }
+
+// ContextFactory is implemented by factories that want a per-connection
+// context. The context carries values injected with WithConnectionContext
+// and is canceled when the connection ends.
+type ContextFactory interface {
+	Factory
+	NewWithContext(ctx context.Context, params map[string][]string, headers map[string][]string) (Slave, error)
+}
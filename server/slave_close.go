@@ -0,0 +1,27 @@
+package server
+
+import (
+	"log"
+	"time"
+)
+
+// slaveCloseTimeout bounds how long closing a slave may block the teardown
+// of its connection.
+var slaveCloseTimeout = 5 * time.Second
+
+// closeSlave closes slave, giving up after slaveCloseTimeout so that a
+// backend stuck in Close, e.g. a process in uninterruptible sleep, does not
+// hold on to the connection. The stuck Close keeps running in the background.
+func closeSlave(slave Slave, reqID string) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		slave.Close()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(slaveCloseTimeout):
+		log.Printf("Gave up closing backend after %s, request: %s", slaveCloseTimeout, reqID)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// stuckSlave blocks in Close until release is closed.
+type stuckSlave struct {
+	*exitingSlave
+	release chan struct{}
+}
+
+func (s *stuckSlave) Close() error {
+	<-s.release
+	return nil
+}
+
+func TestCloseSlaveTimeout(t *testing.T) {
+	orig := slaveCloseTimeout
+	slaveCloseTimeout = 50 * time.Millisecond
+	defer func() { slaveCloseTimeout = orig }()
+
+	slave := &stuckSlave{exitingSlave: newExitingSlave("", 0), release: make(chan struct{})}
+	defer close(slave.release)
+
+	done := make(chan struct{})
+	go func() {
+		closeSlave(slave, "test")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("closeSlave() did not give up on a stuck Close")
+	}
+}
+
+func TestProcessTransportConnStuckSlaveClose(t *testing.T) {
+	orig := slaveCloseTimeout
+	slaveCloseTimeout = 50 * time.Millisecond
+	defer func() { slaveCloseTimeout = orig }()
+
+	slave := &stuckSlave{exitingSlave: newExitingSlave("bye", 0), release: make(chan struct{})}
+	defer close(slave.release)
+	factory := &exitingFactory{connTestFactory: newConnTestFactory(), slave: slave}
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	done := make(chan struct{})
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.processTransportConn(ctx, transport, nil, "")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("processTransportConn() did not return while the slave's Close was stuck")
+	}
+	if got := transport.outputText(t); got != "bye" {
+		t.Errorf("client output = %q, want %q", got, "bye")
+	}
+}
@@ -0,0 +1,95 @@
+package server
+
+import (
+	"io"
+	"sync"
+)
+
+// startupSlave reads the output of a backend from its creation, while the
+// connection is still being set up, and hands it to the first reads of the
+// connection. Once limit bytes are held, the backend is left waiting, as it
+// would be without the buffer. After the connection started reading, the
+// buffer is handed over: once it is drained, reads go to the backend
+// directly.
+type startupSlave struct {
+	Slave
+	limit int
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []byte
+	err     error
+	stopped bool
+	// reading is set by the first read of the connection, and handedOver
+	// once the pump stopped reading the backend for it
+	reading    bool
+	handedOver bool
+}
+
+func newStartupSlave(slave Slave, limit int) *startupSlave {
+	ss := &startupSlave{Slave: slave, limit: limit}
+	ss.cond = sync.NewCond(&ss.mu)
+	go ss.pump()
+	return ss
+}
+
+func (ss *startupSlave) pump() {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := ss.Slave.Read(buf[:min(len(buf), ss.limit)])
+
+		ss.mu.Lock()
+		ss.pending = append(ss.pending, buf[:n]...)
+		if err != nil {
+			ss.err = err
+		}
+		for len(ss.pending) >= ss.limit && !ss.stopped && !ss.reading {
+			ss.cond.Wait()
+		}
+		done := err != nil || ss.stopped || ss.reading
+		if done && err == nil {
+			ss.handedOver = true
+		}
+		ss.cond.Broadcast()
+		ss.mu.Unlock()
+
+		if done {
+			return
+		}
+	}
+}
+
+func (ss *startupSlave) Read(p []byte) (int, error) {
+	ss.mu.Lock()
+	if !ss.reading {
+		ss.reading = true
+		ss.cond.Broadcast()
+	}
+	for len(ss.pending) == 0 && ss.err == nil && !ss.stopped && !ss.handedOver {
+		ss.cond.Wait()
+	}
+	switch {
+	case len(ss.pending) > 0:
+		n := copy(p, ss.pending)
+		ss.pending = ss.pending[n:]
+		ss.mu.Unlock()
+		return n, nil
+	case ss.stopped:
+		ss.mu.Unlock()
+		return 0, io.EOF
+	case ss.err != nil:
+		err := ss.err
+		ss.mu.Unlock()
+		return 0, err
+	}
+	ss.mu.Unlock()
+	return ss.Slave.Read(p)
+}
+
+// stop releases the pump once the connection is done with the backend.
+func (ss *startupSlave) stop() {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	ss.stopped = true
+	ss.cond.Broadcast()
+}
@@ -0,0 +1,141 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"webtmux/webtty"
+)
+
+// bannerSlave writes its banner to a pipe as soon as it is created, like a
+// backend printing before anyone reads it. written is closed once the
+// banner went through.
+func bannerSlave(banner []byte) (*mockSlaveForTransport, chan struct{}) {
+	r, w := io.Pipe()
+	slave := &mockSlaveForTransport{reader: r, writer: io.Discard}
+	written := make(chan struct{})
+	go func() {
+		w.Write(banner)
+		close(written)
+		w.Close()
+	}()
+	return slave, written
+}
+
+func TestStartupSlaveReadsBeforeConnection(t *testing.T) {
+	slave, written := bannerSlave([]byte("Welcome\r\n"))
+	startup := newStartupSlave(slave, 1024)
+	defer startup.stop()
+
+	select {
+	case <-written:
+	case <-time.After(time.Second):
+		t.Fatal("backend output was not read before the connection read it")
+	}
+
+	got, err := io.ReadAll(startup)
+	if err != nil || string(got) != "Welcome\r\n" {
+		t.Errorf("ReadAll() = %q, %v, want the banner", got, err)
+	}
+}
+
+func TestStartupSlaveLimit(t *testing.T) {
+	banner := bytes.Repeat([]byte("0123456789"), 1000)
+	slave, written := bannerSlave(banner)
+	startup := newStartupSlave(slave, 100)
+	defer startup.stop()
+
+	// The backend waits once the buffer is full
+	select {
+	case <-written:
+		t.Fatal("backend output beyond the limit was buffered")
+	case <-time.After(50 * time.Millisecond):
+	}
+	startup.mu.Lock()
+	held := len(startup.pending)
+	startup.mu.Unlock()
+	if held < 100 || held >= 200 {
+		t.Errorf("held %d bytes, want the limit of 100 and at most one more read", held)
+	}
+
+	got, err := io.ReadAll(startup)
+	if err != nil || !bytes.Equal(got, banner) {
+		t.Errorf("ReadAll() returned %d bytes, %v, want all %d bytes in order", len(got), err, len(banner))
+	}
+}
+
+func TestStartupSlaveStop(t *testing.T) {
+	slave, _ := bannerSlave(bytes.Repeat([]byte("x"), 1000))
+	startup := newStartupSlave(slave, 10)
+	waitFor(t, "the buffer to fill", func() bool {
+		startup.mu.Lock()
+		defer startup.mu.Unlock()
+		return len(startup.pending) >= 10
+	})
+
+	startup.stop()
+	if _, err := startup.Read(make([]byte, 100)); err != nil {
+		t.Errorf("Read() after stop() error: %v, want the held output", err)
+	}
+	if _, err := startup.Read(make([]byte, 100)); err != io.EOF {
+		t.Errorf("Read() after the held output = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestStartupSlaveHandsOver(t *testing.T) {
+	slave := newMockSlaveForTransport()
+	startup := newStartupSlave(slave, 1024)
+	defer startup.stop()
+
+	go slave.writer.Write([]byte("banner"))
+	buf := make([]byte, 100)
+	if n, err := startup.Read(buf); err != nil || string(buf[:n]) != "banner" {
+		t.Fatalf("Read() = %q, %v, want the banner", buf[:n], err)
+	}
+
+	// The pump stops with the next output, which is still delivered
+	go slave.writer.Write([]byte("next"))
+	if n, err := startup.Read(buf); err != nil || string(buf[:n]) != "next" {
+		t.Fatalf("Read() = %q, %v, want the next output", buf[:n], err)
+	}
+	startup.mu.Lock()
+	handedOver := startup.handedOver
+	startup.mu.Unlock()
+	if !handedOver {
+		t.Fatal("the buffer should be handed over once the connection reads")
+	}
+
+	go slave.writer.Write([]byte("direct"))
+	if n, err := startup.Read(buf); err != nil || string(buf[:n]) != "direct" {
+		t.Errorf("Read() = %q, %v, want output read from the backend", buf[:n], err)
+	}
+}
+
+func TestProcessTransportConnImmediateOutput(t *testing.T) {
+	banner := bytes.Repeat([]byte("ready\r\n"), 10000)
+	slave, _ := bannerSlave(banner)
+	server, err := New(&exitingFactory{newConnTestFactory(), slave}, &Options{
+		TitleFormat:       "Test",
+		StartupBufferSize: 1024,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.processTransportConn(ctx, transport, nil, ""); err != webtty.ErrSlaveClosed {
+		t.Fatalf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+	}
+	if output := transport.outputText(t); output != string(banner) {
+		t.Errorf("output has %d bytes, want all %d bytes written on creation", len(output), len(banner))
+	}
+}
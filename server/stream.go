@@ -0,0 +1,260 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+
+	"webtmux/webtty"
+)
+
+// streamEvents names the Server-Sent Events that webtty messages are sent
+// as to stream viewers. Other messages are dropped.
+var streamEvents = map[byte]string{
+	webtty.Output:           "output",            // base64
+	webtty.CompressedOutput: "compressed-output", // base64, see compress-output
+	webtty.SetWindowTitle:   "title",
+	webtty.ConnectionReady:  "ready",
+}
+
+// sseTransport streams terminal output to a read-only viewer as
+// Server-Sent Events. Viewers never send input, so reads block until the
+// viewer goes away.
+type sseTransport struct {
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	remoteAddr string
+	gone       <-chan struct{}
+
+	mu        sync.Mutex
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func newSSETransport(w http.ResponseWriter, flusher http.Flusher, r *http.Request) *sseTransport {
+	return &sseTransport{
+		w:          w,
+		flusher:    flusher,
+		remoteAddr: r.RemoteAddr,
+		gone:       r.Context().Done(),
+		closed:     make(chan struct{}),
+	}
+}
+
+func (t *sseTransport) Read(p []byte) (int, error) {
+	select {
+	case <-t.gone:
+	case <-t.closed:
+	}
+	return 0, io.EOF
+}
+
+func (t *sseTransport) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	event, ok := streamEvents[p[0]]
+	if !ok {
+		return len(p), nil
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	select {
+	case <-t.closed:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+
+	// Payloads are base64 or single-line JSON, so one data line suffices
+	if _, err := fmt.Fprintf(t.w, "event: %s\ndata: %s\n\n", event, p[1:]); err != nil {
+		return 0, err
+	}
+	t.flusher.Flush()
+	return len(p), nil
+}
+
+// Close stops writes to the response, which must not outlive the handler.
+func (t *sseTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.closeOnce.Do(func() { close(t.closed) })
+	return nil
+}
+
+func (t *sseTransport) RemoteAddr() string {
+	return t.remoteAddr
+}
+
+// streamViewerBacklog is how many writes of output a stream viewer may fall
+// behind before it is dropped.
+const streamViewerBacklog = 256
+
+// errViewerBehind ends the stream of a viewer that cannot keep up with the
+// output of the session.
+var errViewerBehind = errors.New("stream viewer fell behind the output")
+
+// streamViewer is a sink of a session's output feeding a stream viewer. Its
+// writes never block the session.
+type streamViewer struct {
+	output chan []byte
+	behind chan struct{}
+}
+
+func newStreamViewer() *streamViewer {
+	return &streamViewer{
+		output: make(chan []byte, streamViewerBacklog),
+		behind: make(chan struct{}),
+	}
+}
+
+// Write queues a copy of p for the viewer. Once the backlog is full it
+// fails, which drops the viewer from the sinks, so it fails only once.
+func (v *streamViewer) Write(p []byte) (int, error) {
+	select {
+	case v.output <- bytes.Clone(p):
+		return len(p), nil
+	default:
+		close(v.behind)
+		return 0, errViewerBehind
+	}
+}
+
+// generateHandleStream serves read-only viewers that cannot use WebSocket.
+// A viewer attaches to the output of the running session given by the
+// session parameter, the most recent one by default, and receives it as
+// Server-Sent Events. Viewers go through the same admission as WebSocket
+// clients but do not create backends.
+func (server *Server) generateHandleStream(ctx context.Context, cancel context.CancelFunc, counter *counter) http.HandlerFunc {
+	once := new(int64)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if server.isDraining() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Method != "GET" {
+			http.Error(w, "Method not allowed", 405)
+			return
+		}
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+			return
+		}
+		if retryAfter := server.overloadRemaining(); retryAfter > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+			http.Error(w, "Backend is overloaded", http.StatusServiceUnavailable)
+			return
+		}
+		if server.shedLoad(counter.count()) {
+			w.Header().Set("Retry-After", strconv.Itoa(server.options.ShedRetryAfter))
+			http.Error(w, "Server is under high load", http.StatusServiceUnavailable)
+			return
+		}
+		if retryAfter, ok := server.tmuxSessions.admit(); !ok {
+			if retryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())+1))
+				http.Error(w, "Too many new tmux sessions", http.StatusTooManyRequests)
+			} else {
+				http.Error(w, "Too many tmux sessions", http.StatusServiceUnavailable)
+			}
+			return
+		}
+		defer server.tmuxSessions.release()
+
+		if server.options.Once {
+			success := atomic.CompareAndSwapInt64(once, 0, 1)
+			if !success {
+				http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+				return
+			}
+		}
+		if server.sessionsExhausted() {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		admission := &sessionAdmission{}
+
+		num := counter.add(1)
+		defer func() {
+			counter.done()
+			lastSession := server.finishSession(admission)
+			if server.options.Once || lastSession {
+				cancel()
+			}
+		}()
+		if err := server.checkCapacity(num); err != nil {
+			if err == errServerFull {
+				server.writeFull(w, r)
+			} else {
+				http.Error(w, "Too many connections", http.StatusServiceUnavailable)
+			}
+			return
+		}
+
+		id, _ := strconv.ParseUint(r.URL.Query().Get("session"), 10, 64)
+		entry := server.connections.viewable(id)
+		if entry == nil {
+			http.Error(w, "No such session", http.StatusNotFound)
+			return
+		}
+		reqID := requestID(r)
+		connCtx := withSessionAdmission(withRequestID(server.connectionContext(ctx, r), reqID), admission)
+		if !server.admitSession(connCtx) {
+			http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		log.Printf("New stream viewer of session %d connected: %s, connections: %d/%d, request: %s", entry.ID, r.RemoteAddr, num, server.options.MaxConnection, reqID)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		transport := newSSETransport(w, flusher, r)
+		defer transport.Close()
+		err := server.serveViewer(connCtx, transport, entry)
+
+		log.Printf("Stream viewer disconnected by %s: %s, request: %s", server.closeReason(ctx, err), r.RemoteAddr, reqID)
+	}
+}
+
+// serveViewer sends the output of the session of entry to transport until
+// either ends.
+func (server *Server) serveViewer(ctx context.Context, transport *sseTransport, entry *connectionEntry) error {
+	output := entry.output.Load().(*teeWriter)
+	viewer := newStreamViewer()
+	output.add("stream viewer "+transport.RemoteAddr(), viewer)
+	defer output.remove(viewer)
+
+	if _, err := transport.Write(append([]byte{webtty.ConnectionReady}, `{"type":"ready"}`...)); err != nil {
+		return &webtty.MasterWriteError{Err: err}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-transport.gone:
+			return webtty.ErrMasterClosed
+		case <-entry.done:
+			return webtty.ErrSlaveClosed
+		case <-viewer.behind:
+			return errViewerBehind
+		case data := <-viewer.output:
+			message := append([]byte{webtty.Output}, base64.StdEncoding.EncodeToString(data)...)
+			if _, err := transport.Write(message); err != nil {
+				return &webtty.MasterWriteError{Err: err}
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// readEvent reads the next Server-Sent Event, returning its name and data.
+func readEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var event, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("Failed to read event: %v", err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			return event, data
+		case strings.HasPrefix(line, "event: "):
+			event = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// newStreamServer returns a server with stream viewers enabled.
+func newStreamServer(t *testing.T, factory Factory, options *Options) *Server {
+	t.Helper()
+	options.TitleFormat = "Test"
+	options.EnableBasicAuth = true
+	options.Credential = "user:pass"
+	options.EnableStream = true
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	return server
+}
+
+// startViewableSession runs a session over transport until it is closed,
+// and waits for stream viewers to be able to attach to it.
+func startViewableSession(t *testing.T, ctx context.Context, server *Server, transport Transport) <-chan error {
+	t.Helper()
+	done := make(chan error, 1)
+	go func() {
+		done <- server.serveTerminal(ctx, transport, &InitMessage{}, nil, "")
+	}()
+	waitFor(t, "a viewable session", func() bool { return server.connections.viewable(0) != nil })
+	return done
+}
+
+func TestHandleStream(t *testing.T) {
+	factory := &countingFactory{connTestFactory: newConnTestFactory()}
+	server := newStreamServer(t, factory, &Options{PermitWrite: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	transport := newBlockingTransport()
+	session := startViewableSession(t, ctx, server, transport)
+
+	counter := newCounter(0)
+	ts := httptest.NewServer(server.generateHandleStream(ctx, cancel, counter))
+	defer ts.Close()
+
+	// Both viewers attach to the running session
+	var readers []*bufio.Reader
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Get() error: %v", err)
+		}
+		defer resp.Body.Close()
+		if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+			t.Fatalf("Content-Type = %q, want text/event-stream", contentType)
+		}
+		reader := bufio.NewReader(resp.Body)
+		if event, _ := readEvent(t, reader); event != "ready" {
+			t.Fatalf("first event = %q, want ready", event)
+		}
+		readers = append(readers, reader)
+	}
+	sinks := server.connections.viewable(0).output.Load().(*teeWriter)
+	waitFor(t, "both viewers", func() bool {
+		sinks.mu.Lock()
+		defer sinks.mu.Unlock()
+		return len(sinks.sinks) == 2
+	})
+
+	go factory.slave.writer.Write([]byte("hello"))
+	for i, reader := range readers {
+		event, data := readEvent(t, reader)
+		if event != "output" || data != base64.StdEncoding.EncodeToString([]byte("hello")) {
+			t.Errorf("output event of viewer %d = %q %q, want hello", i+1, event, data)
+		}
+	}
+	if created := atomic.LoadInt32(&factory.created); created != 1 {
+		t.Errorf("backends created = %d, want only the session's", created)
+	}
+
+	// Viewers go away with the session
+	close(transport.closed)
+	<-session
+	for i, reader := range readers {
+		if _, err := reader.ReadString('\n'); err != io.EOF {
+			t.Errorf("viewer %d read error = %v, want EOF once the session ended", i+1, err)
+		}
+	}
+	waitFor(t, "the viewers to be gone", func() bool { return counter.count() == 0 })
+}
+
+func TestHandleStreamSession(t *testing.T) {
+	server := newStreamServer(t, newConnTestFactory(), &Options{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	counter := newCounter(0)
+	handler := server.generateHandleStream(ctx, cancel, counter)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/stream", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status without sessions = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+
+	transport := newBlockingTransport()
+	defer close(transport.closed)
+	startViewableSession(t, ctx, server, transport)
+	id := server.connections.viewable(0).ID
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/stream?session="+strconv.FormatUint(id+1, 10), nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("status for another session = %d, want %d", rr.Code, http.StatusNotFound)
+	}
+	if counter.count() != 0 {
+		t.Errorf("counter = %d, want refused viewers not counted", counter.count())
+	}
+}
+
+func TestHandleStreamAdmission(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		setup   func(server *Server)
+		want    int
+	}{
+		{"blocked", Options{BlockConnections: true}, nil, http.StatusServiceUnavailable},
+		{"full", Options{MaxConnection: 1}, nil, http.StatusServiceUnavailable},
+		{"overloaded", Options{OverloadCooldown: 60}, func(server *Server) { server.startOverloadCooldown(0) }, http.StatusServiceUnavailable},
+		{"sessions exhausted", Options{MaxSessions: 1}, func(server *Server) { server.sessionsAdmitted = 1 }, http.StatusServiceUnavailable},
+		{"tmux sessions", Options{}, func(server *Server) {
+			server.tmuxSessions = newTmuxSessionLimiter(0, 1)
+			server.tmuxSessions.admit()
+		}, http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			options := tt.options
+			server := newStreamServer(t, newConnTestFactory(), &options)
+			if tt.setup != nil {
+				tt.setup(server)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			counter := newCounter(0)
+			// The session's connection takes the only one of MaxConnection
+			counter.add(1)
+
+			rr := httptest.NewRecorder()
+			server.generateHandleStream(ctx, cancel, counter)(rr, httptest.NewRequest("GET", "/stream", nil))
+			if rr.Code != tt.want {
+				t.Errorf("status = %d, want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandleStreamCountsSessions(t *testing.T) {
+	server := newStreamServer(t, newConnTestFactory(), &Options{Once: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// Sessions created outside the handlers are not admitted
+	transport := newBlockingTransport()
+	defer close(transport.closed)
+	startViewableSession(t, ctx, server, transport)
+
+	viewerCtx, stopServer := context.WithCancel(context.Background())
+	handler := server.generateHandleStream(viewerCtx, stopServer, newCounter(0))
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Get() error: %v", err)
+	}
+	readEvent(t, bufio.NewReader(resp.Body))
+
+	// Once lets a single viewer in, and stops the server after it
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest("GET", "/stream", nil))
+	if rr.Code != http.StatusServiceUnavailable {
+		t.Errorf("status of a second viewer = %d, want %d", rr.Code, http.StatusServiceUnavailable)
+	}
+	resp.Body.Close()
+	select {
+	case <-viewerCtx.Done():
+	case <-time.After(time.Second):
+		t.Error("server should stop after the only viewer left")
+	}
+}
+
+func TestStreamViewerBehind(t *testing.T) {
+	viewer := newStreamViewer()
+	for i := 0; i < streamViewerBacklog; i++ {
+		if _, err := viewer.Write([]byte("x")); err != nil {
+			t.Fatalf("Write(%d) error: %v", i+1, err)
+		}
+	}
+	if _, err := viewer.Write([]byte("x")); err != errViewerBehind {
+		t.Errorf("Write() past the backlog error = %v, want errViewerBehind", err)
+	}
+	select {
+	case <-viewer.behind:
+	default:
+		t.Error("viewer should be marked behind")
+	}
+}
+
+func TestSSETransportDropsOtherMessages(t *testing.T) {
+	rr := httptest.NewRecorder()
+	transport := newSSETransport(rr, rr, httptest.NewRequest("GET", "/stream", nil))
+
+	if n, err := transport.Write([]byte("2")); n != 1 || err != nil {
+		t.Errorf("Write(Pong) = %d, %v, want it dropped", n, err)
+	}
+	if rr.Body.Len() != 0 {
+		t.Errorf("body = %q, want no event for a pong", rr.Body.String())
+	}
+
+	transport.Close()
+	if _, err := transport.Write([]byte("1aGk=")); err == nil {
+		t.Error("Write() after Close() should fail")
+	}
+	if n, err := transport.Read(make([]byte, 1)); n != 0 || err == nil {
+		t.Errorf("Read() = %d, %v, want EOF once closed", n, err)
+	}
+}
+
+func TestStreamRequiresAuth(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		Credential:      "user:pass",
+		EnableStream:    true,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.setupHandlers(ctx, cancel, "/", newCounter(0))
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest("GET", "/stream", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", rr.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// dialSubprotocols opens a WebSocket to a server with options, offering
+// subprotocols.
+func dialSubprotocols(t *testing.T, options *Options, subprotocols ...string) (*websocket.Conn, *http.Response, error) {
+	t.Helper()
+	options.TitleFormat = "Test"
+	server, err := New(newConnTestFactory(), options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	ts := httptest.NewServer(server.generateHandleWS(ctx, cancel, newCounter(0)))
+	t.Cleanup(ts.Close)
+
+	dialer := &websocket.Dialer{Subprotocols: subprotocols}
+	conn, resp, err := dialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if conn != nil {
+		t.Cleanup(func() { conn.Close() })
+	}
+	return conn, resp, err
+}
+
+func TestWSSubprotocolEcho(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		offered    []string
+		want       string
+	}{
+		{"default", "", []string{"other", "webtty"}, "webtty"},
+		{"server preference", "v2.webtty, webtty", []string{"webtty", "v2.webtty"}, "v2.webtty"},
+		{"none agreed", "", []string{"other"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, resp, err := dialSubprotocols(t, &Options{WSSubprotocols: tt.configured}, tt.offered...)
+			if err != nil {
+				t.Fatalf("Dial() error: %v", err)
+			}
+			if got := resp.Header.Values("Sec-WebSocket-Protocol"); strings.Join(got, ",") != tt.want {
+				t.Errorf("Sec-WebSocket-Protocol = %q, want %q", got, tt.want)
+			}
+			if conn.Subprotocol() != tt.want {
+				t.Errorf("Subprotocol() = %q, want %q", conn.Subprotocol(), tt.want)
+			}
+		})
+	}
+}
+
+func TestWSRequireProtocol(t *testing.T) {
+	_, resp, err := dialSubprotocols(t, &Options{WSRequireProtocol: true}, "other")
+	if err == nil {
+		t.Fatal("Dial() without an acceptable subprotocol should fail")
+	}
+	if resp == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("response = %v, want %d", resp, http.StatusBadRequest)
+	}
+
+	_, _, err = dialSubprotocols(t, &Options{WSRequireProtocol: true})
+	if err == nil {
+		t.Error("Dial() without subprotocols should fail")
+	}
+
+	conn, _, err := dialSubprotocols(t, &Options{WSRequireProtocol: true}, "webtty")
+	if err != nil {
+		t.Fatalf("Dial() with webtty error: %v", err)
+	}
+	if conn.Subprotocol() != "webtty" {
+		t.Errorf("Subprotocol() = %q, want webtty", conn.Subprotocol())
+	}
+}
+
+func TestParseSubprotocols(t *testing.T) {
+	if got := parseSubprotocols(" a, b ,,"); strings.Join(got, " ") != "a b" {
+		t.Errorf("parseSubprotocols() = %q, want [a b]", got)
+	}
+	if got := parseSubprotocols(""); strings.Join(got, " ") != "webtty" {
+		t.Errorf("parseSubprotocols(\"\") = %q, want [webtty]", got)
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"io"
+	"log"
+	"slices"
+	"sync"
+)
+
+// teeSink is a secondary destination of a session's output.
+type teeSink struct {
+	name string
+	w    io.Writer
+}
+
+// teeWriter copies a session's output to secondary sinks such as the session
+// log or the replay buffer. A sink that fails is logged and dropped for the
+// rest of the session, so it never interrupts delivery to the client.
+type teeWriter struct {
+	mu    sync.Mutex
+	sinks []teeSink
+}
+
+// add registers w under name, used when reporting its failure.
+func (tw *teeWriter) add(name string, w io.Writer) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.sinks = append(tw.sinks, teeSink{name: name, w: w})
+}
+
+// remove unregisters w, if it was not dropped already.
+func (tw *teeWriter) remove(w io.Writer) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	tw.sinks = slices.DeleteFunc(tw.sinks, func(sink teeSink) bool { return sink.w == w })
+}
+
+// empty reports whether no sinks are registered.
+func (tw *teeWriter) empty() bool {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+	return len(tw.sinks) == 0
+}
+
+// Write always reports success: the errors of individual sinks are handled
+// here and never reach the caller.
+func (tw *teeWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	healthy := tw.sinks[:0]
+	for _, sink := range tw.sinks {
+		n, err := sink.w.Write(p)
+		if err == nil && n < len(p) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			log.Printf("Dropping output sink %s: %s", sink.name, err)
+			continue
+		}
+		healthy = append(healthy, sink)
+	}
+	for i := len(healthy); i < len(tw.sinks); i++ {
+		tw.sinks[i] = teeSink{}
+	}
+	tw.sinks = healthy
+	return len(p), nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// failingSink fails every write after the first `ok` ones.
+type failingSink struct {
+	mu     sync.Mutex
+	ok     int
+	writes int
+	closed bool
+}
+
+func (fs *failingSink) Write(p []byte) (int, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.writes++
+	if fs.writes > fs.ok {
+		return 0, errors.New("sink unavailable")
+	}
+	return len(p), nil
+}
+
+func (fs *failingSink) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+	fs.closed = true
+	return nil
+}
+
+// syncBuffer is a bytes.Buffer safe for use by a session and a test.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (sb *syncBuffer) Write(p []byte) (int, error) {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.Write(p)
+}
+
+func (sb *syncBuffer) String() string {
+	sb.mu.Lock()
+	defer sb.mu.Unlock()
+	return sb.buf.String()
+}
+
+func TestTeeWriterDropsFailingSink(t *testing.T) {
+	failing := &failingSink{ok: 1}
+	var good bytes.Buffer
+	tw := &teeWriter{}
+	tw.add("failing", failing)
+	tw.add("good", &good)
+
+	for _, chunk := range []string{"a", "b", "c"} {
+		n, err := tw.Write([]byte(chunk))
+		if n != 1 || err != nil {
+			t.Fatalf("Write(%q) = %d, %v, want 1, nil", chunk, n, err)
+		}
+	}
+
+	if good.String() != "abc" {
+		t.Errorf("healthy sink got %q, want %q", good.String(), "abc")
+	}
+	if failing.writes != 2 {
+		t.Errorf("failing sink was written %d times, want 2 (dropped after its first error)", failing.writes)
+	}
+	if len(tw.sinks) != 1 {
+		t.Errorf("teeWriter has %d sinks, want 1", len(tw.sinks))
+	}
+}
+
+func TestTeeWriterShortWrite(t *testing.T) {
+	tw := &teeWriter{}
+	tw.add("short", writerFunc(func(p []byte) (int, error) { return len(p) - 1, nil }))
+
+	if n, err := tw.Write([]byte("abc")); n != 3 || err != nil {
+		t.Fatalf("Write() = %d, %v, want 3, nil", n, err)
+	}
+	if !tw.empty() {
+		t.Error("sink with a short write should be dropped")
+	}
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+func TestFailingOutputSinkKeepsClientStream(t *testing.T) {
+	failing := &failingSink{}
+	recorded := &syncBuffer{}
+	factory := &exitingFactory{connTestFactory: newConnTestFactory(), slave: newExitingSlave("hello", 0)}
+	server, err := New(factory, &Options{
+		TitleFormat: "Test",
+		OutputSinks: func(requestID string) []io.Writer {
+			return []io.Writer{failing, recorded}
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.processTransportConn(ctx, transport, nil, "")
+
+	if got := transport.outputText(t); got != "hello" {
+		t.Errorf("client output = %q, want %q", got, "hello")
+	}
+	if got := recorded.String(); got != "hello" {
+		t.Errorf("healthy sink got %q, want %q", got, "hello")
+	}
+	failing.mu.Lock()
+	defer failing.mu.Unlock()
+	if !failing.closed {
+		t.Error("sink implementing io.Closer should be closed when the session ends")
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tmuxSessionWindow is the period TmuxSessionRate applies to.
+const tmuxSessionWindow = time.Minute
+
+// tmuxSessionLimiter throttles connections whose command creates a new tmux
+// session, so that a flood of connections cannot spawn sessions unchecked.
+type tmuxSessionLimiter struct {
+	rate int // sessions created per tmuxSessionWindow, 0 for unlimited
+	max  int // sessions alive at a time, 0 for unlimited
+	now  func() time.Time
+	// Set to count the sessions alive on the tmux server, which outlive
+	// the connections that created them
+	sessions func() (int, error)
+
+	mu      sync.Mutex
+	created []time.Time // creations within the last window, oldest first
+	active  int         // connections holding a session
+}
+
+func newTmuxSessionLimiter(rate, max int) *tmuxSessionLimiter {
+	return &tmuxSessionLimiter{rate: rate, max: max, now: time.Now}
+}
+
+// admit reserves a session for a new connection, to be handed back with
+// release. When the connection is refused because of the rate, retryAfter
+// tells when the next session may be created; it is zero when too many
+// sessions are alive. A nil limiter admits everything.
+func (l *tmuxSessionLimiter) admit() (retryAfter time.Duration, ok bool) {
+	if l == nil {
+		return 0, true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	expired := 0
+	for expired < len(l.created) && now.Sub(l.created[expired]) >= tmuxSessionWindow {
+		expired++
+	}
+	l.created = l.created[expired:]
+
+	if l.max > 0 && l.alive() >= l.max {
+		return 0, false
+	}
+	if l.rate > 0 && len(l.created) >= l.rate {
+		return l.created[0].Add(tmuxSessionWindow).Sub(now), false
+	}
+
+	l.created = append(l.created, now)
+	l.active++
+	return 0, true
+}
+
+// alive returns the number of sessions alive, which is at least the number
+// of connections holding one. l.mu must be held.
+func (l *tmuxSessionLimiter) alive() int {
+	if l.sessions == nil {
+		return l.active
+	}
+	n, err := l.sessions()
+	if err != nil {
+		return l.active
+	}
+	return max(n, l.active)
+}
+
+// release hands back a session reserved by admit.
+func (l *tmuxSessionLimiter) release() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	l.active--
+	l.mu.Unlock()
+}
+
+// tmuxSessionCounter returns a function counting the sessions of the tmux
+// server that command, run with argv, talks to.
+func tmuxSessionCounter(command string, argv []string) func() (int, error) {
+	// Keep the global options selecting the server
+	args := []string{}
+	for i := 0; i < len(argv) && strings.HasPrefix(argv[i], "-"); i++ {
+		switch argv[i] {
+		case "-L", "-S":
+			if i+1 < len(argv) {
+				args = append(args, argv[i], argv[i+1])
+			}
+			i++
+		case "-f", "-T":
+			i++
+		}
+	}
+	args = append(args, "list-sessions", "-F", "#{session_id}")
+
+	return func() (int, error) {
+		out, err := exec.Command(command, args...).Output()
+		if err != nil {
+			// Without a running tmux server there are no sessions
+			if _, ok := err.(*exec.ExitError); ok {
+				return 0, nil
+			}
+			return 0, err
+		}
+		return bytes.Count(out, []byte("\n")), nil
+	}
+}
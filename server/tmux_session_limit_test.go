@@ -0,0 +1,230 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// fakeTmuxFactory runs "tmux new-session" and counts the sessions created
+type fakeTmuxFactory struct {
+	args     []string
+	sessions int64
+}
+
+func (f *fakeTmuxFactory) Name() string { return "tmux" }
+
+func (f *fakeTmuxFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	atomic.AddInt64(&f.sessions, 1)
+	return newMockSlaveForTransport(), nil
+}
+
+func (f *fakeTmuxFactory) Command() (string, []string) {
+	return "/usr/bin/tmux", f.args
+}
+
+func TestTmuxSessionLimiterRate(t *testing.T) {
+	clock := time.Unix(0, 0)
+	limiter := newTmuxSessionLimiter(2, 0)
+	limiter.now = func() time.Time { return clock }
+
+	for i := 0; i < 2; i++ {
+		if _, ok := limiter.admit(); !ok {
+			t.Fatalf("session %d should be admitted", i+1)
+		}
+		limiter.release()
+		clock = clock.Add(10 * time.Second)
+	}
+
+	retryAfter, ok := limiter.admit()
+	if ok {
+		t.Fatal("third session within a minute should be rejected")
+	}
+	if retryAfter != 40*time.Second {
+		t.Errorf("retryAfter = %s, want 40s", retryAfter)
+	}
+
+	clock = clock.Add(40 * time.Second)
+	if _, ok := limiter.admit(); !ok {
+		t.Error("session should be admitted once the first one left the window")
+	}
+}
+
+func TestTmuxSessionLimiterMax(t *testing.T) {
+	limiter := newTmuxSessionLimiter(0, 1)
+
+	if _, ok := limiter.admit(); !ok {
+		t.Fatal("first session should be admitted")
+	}
+	if retryAfter, ok := limiter.admit(); ok || retryAfter != 0 {
+		t.Errorf("admit() = %s, %t, want a rejection without retry delay", retryAfter, ok)
+	}
+	limiter.release()
+	if _, ok := limiter.admit(); !ok {
+		t.Error("session should be admitted after one was released")
+	}
+
+	var unlimited *tmuxSessionLimiter
+	if _, ok := unlimited.admit(); !ok {
+		t.Error("nil limiter should admit every session")
+	}
+	unlimited.release()
+}
+
+func TestTmuxSessionLimiterCountsLiveSessions(t *testing.T) {
+	limiter := newTmuxSessionLimiter(0, 2)
+	live := 0
+	limiter.sessions = func() (int, error) { return live, nil }
+
+	if _, ok := limiter.admit(); !ok {
+		t.Fatal("first session should be admitted")
+	}
+	limiter.release()
+
+	// The session outlived its connection, next to one created elsewhere
+	live = 2
+	if _, ok := limiter.admit(); ok {
+		t.Error("session should be rejected while the tmux server has two sessions")
+	}
+	live = 1
+	if _, ok := limiter.admit(); !ok {
+		t.Error("session should be admitted once a tmux session ended")
+	}
+
+	limiter.sessions = func() (int, error) { return 0, errors.New("tmux failed") }
+	if _, ok := limiter.admit(); !ok {
+		t.Error("second connection should be admitted when sessions cannot be counted")
+	}
+	if _, ok := limiter.admit(); ok {
+		t.Error("connections holding sessions should count when sessions cannot be counted")
+	}
+}
+
+func TestTmuxSessionCounter(t *testing.T) {
+	tmux, err := exec.LookPath("tmux")
+	if err != nil {
+		t.Skip("tmux is not installed")
+	}
+	socket := filepath.Join(t.TempDir(), "tmux.sock")
+	count := tmuxSessionCounter(tmux, []string{"-S", socket, "-f", "/dev/null", "new-session"})
+
+	if n, err := count(); err != nil || n != 0 {
+		t.Fatalf("count() without a tmux server = %d, %v, want 0", n, err)
+	}
+	for _, name := range []string{"one", "two"} {
+		if out, err := exec.Command(tmux, "-S", socket, "-f", "/dev/null", "new-session", "-d", "-s", name).CombinedOutput(); err != nil {
+			t.Fatalf("tmux new-session error: %v: %s", err, out)
+		}
+	}
+	defer exec.Command(tmux, "-S", socket, "kill-server").Run()
+
+	if n, err := count(); err != nil || n != 2 {
+		t.Errorf("count() = %d, %v, want 2", n, err)
+	}
+}
+
+func TestCreatesTmuxSessions(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{nil, true},
+		{[]string{"new-session"}, true},
+		{[]string{"-L", "web", "new", "-s", "main"}, true},
+		{[]string{"new-session", "-A", "-s", "main"}, false},
+		{[]string{"attach", "-t", "main"}, false},
+		{[]string{"-c", "new-session"}, false},
+	}
+
+	for _, tt := range tests {
+		server := &Server{factory: &fakeTmuxFactory{args: tt.args}}
+		if got := server.createsTmuxSessions(); got != tt.want {
+			t.Errorf("createsTmuxSessions() with %q = %t, want %t", tt.args, got, tt.want)
+		}
+	}
+
+	server := &Server{factory: newConnTestFactory()}
+	if server.createsTmuxSessions() {
+		t.Error("createsTmuxSessions() should be false for other commands")
+	}
+}
+
+func TestTmuxSessionCreationThrottled(t *testing.T) {
+	factory := &fakeTmuxFactory{args: []string{"new-session"}}
+	server, err := New(factory, &Options{TitleFormat: "Test", TmuxSessionRate: 3, MaxTmuxSessions: 2})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	// No tmux sessions outlive the fake ones
+	server.tmuxSessions.sessions = nil
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := server.generateHandleWS(ctx, cancel, newCounter(0))
+	finished := make(chan struct{}, 4)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		finished <- struct{}{}
+	}))
+	defer ts.Close()
+
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+	connect := func() (*websocket.Conn, *http.Response, error) {
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			return nil, resp, err
+		}
+		init, _ := json.Marshal(InitMessage{AuthToken: ""})
+		conn.WriteMessage(websocket.TextMessage, init)
+		conn.ReadMessage()
+		return conn, resp, nil
+	}
+
+	first, _, err := connect()
+	if err != nil {
+		t.Fatalf("first connection error: %v", err)
+	}
+	second, _, err := connect()
+	if err != nil {
+		t.Fatalf("second connection error: %v", err)
+	}
+	defer second.Close()
+
+	// Two sessions are alive
+	if _, resp, err := connect(); err == nil || resp == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("third connection = %v, want 503", err)
+	}
+	<-finished
+
+	first.Close()
+	<-finished
+	third, _, err := connect()
+	if err != nil {
+		t.Fatalf("connection after one closed error: %v", err)
+	}
+	third.Close()
+	<-finished
+
+	// Three sessions were created within the minute
+	_, resp, err := connect()
+	if err == nil || resp == nil || resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("fourth connection = %v, want 429", err)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("rate limited connection should carry Retry-After")
+	}
+
+	if got := atomic.LoadInt64(&factory.sessions); got != 3 {
+		t.Errorf("tmux sessions created = %d, want 3", got)
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	totpStep = 30 * time.Second
+	// totpWindow is how many steps codes may be off by, for clock skew
+	// and codes entered just before the step ends
+	totpWindow = 1
+)
+
+// totpNow is the clock TOTP codes are checked against.
+var totpNow = time.Now
+
+// parseTOTPSecret decodes the base32 TOTPSecret option, as shown by
+// authenticator apps with or without padding, spaces and in any case.
+func parseTOTPSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.TrimRight(secret, "="))
+	if err != nil {
+		return nil, errors.Wrapf(err, "totp-secret must be base32")
+	}
+	if len(key) == 0 {
+		return nil, errors.New("totp-secret must not be empty")
+	}
+	return key, nil
+}
+
+// totpCode returns the six digit RFC 6238 code of key for the step
+// containing t.
+func totpCode(key []byte, t time.Time) string {
+	var counter [8]byte
+	binary.BigEndian.PutUint64(counter[:], uint64(t.Unix()/int64(totpStep/time.Second)))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%06d", value%1000000)
+}
+
+// validTOTP reports whether code is the code of key at now, or of the
+// steps within totpWindow of it.
+func validTOTP(key []byte, code string, now time.Time) bool {
+	if len(code) != 6 {
+		return false
+	}
+	valid := false
+	for step := -totpWindow; step <= totpWindow; step++ {
+		expected := totpCode(key, now.Add(time.Duration(step)*totpStep))
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			valid = true
+		}
+	}
+	return valid
+}
+
+// splitTOTP splits the code off a user:password:code credential.
+func splitTOTP(credential string) (string, string) {
+	i := strings.LastIndex(credential, ":")
+	if i < 0 || strings.Count(credential, ":") < 2 {
+		return credential, ""
+	}
+	return credential[:i], credential[i+1:]
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// totpTestSecret is the SHA-1 secret of the RFC 6238 test vectors,
+// "12345678901234567890", in base32.
+const totpTestSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTOTPCode(t *testing.T) {
+	key, err := parseTOTPSecret(totpTestSecret)
+	if err != nil {
+		t.Fatalf("parseTOTPSecret() error: %v", err)
+	}
+
+	// The last six digits of the RFC 6238 SHA-1 vectors
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, time.Unix(tt.unix, 0)); got != tt.want {
+			t.Errorf("totpCode(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidTOTP(t *testing.T) {
+	key, _ := parseTOTPSecret(totpTestSecret)
+	issued := time.Unix(59, 0)
+
+	tests := []struct {
+		name string
+		code string
+		now  time.Time
+		want bool
+	}{
+		{"current step", "287082", issued, true},
+		{"one step later", "287082", issued.Add(totpStep), true},
+		{"one step earlier", "287082", issued.Add(-totpStep), true},
+		{"two steps later", "287082", issued.Add(2 * totpStep), false},
+		{"wrong code", "287083", issued, false},
+		{"no code", "", issued, false},
+		{"too long", "2870820", issued, false},
+	}
+	for _, tt := range tests {
+		if got := validTOTP(key, tt.code, tt.now); got != tt.want {
+			t.Errorf("%s: validTOTP(%q) = %v, want %v", tt.name, tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestParseTOTPSecret(t *testing.T) {
+	for _, secret := range []string{totpTestSecret, "gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "GEZDGNBVGY======"} {
+		if _, err := parseTOTPSecret(secret); err != nil {
+			t.Errorf("parseTOTPSecret(%q) error: %v", secret, err)
+		}
+	}
+	for _, secret := range []string{"not base32!", "===="} {
+		if _, err := parseTOTPSecret(secret); err == nil {
+			t.Errorf("parseTOTPSecret(%q) should fail", secret)
+		}
+	}
+}
+
+func TestWrapBasicAuthTOTP(t *testing.T) {
+	oldNow := totpNow
+	totpNow = func() time.Time { return time.Unix(59, 0) }
+	defer func() { totpNow = oldNow }()
+
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		TOTPSecret:      totpTestSecret,
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	wrapped := server.wrapBasicAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}), "admin:pass:word")
+
+	tests := []struct {
+		name       string
+		credential string
+		ip         string
+		wantStatus int
+	}{
+		{"password and code", "admin:pass:word:287082", "192.0.2.1", http.StatusOK},
+		{"password without code", "admin:pass:word", "192.0.2.2", http.StatusUnauthorized},
+		{"wrong code", "admin:pass:word:123456", "192.0.2.2", http.StatusUnauthorized},
+		{"wrong password", "admin:wrong:287082", "192.0.2.2", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.ip + ":1234"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.credential)))
+		rr := httptest.NewRecorder()
+		wrapped.ServeHTTP(rr, req)
+		if rr.Code != tt.wantStatus {
+			t.Errorf("%s: status = %d, want %d", tt.name, rr.Code, tt.wantStatus)
+		}
+	}
+
+	// Guessing codes counts towards the lockout of the IP
+	for i := 0; i < defaultRateLimits.maxFailures; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = "192.0.2.3:1234"
+		req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:pass:word:000000")))
+		wrapped.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "192.0.2.3:1234"
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("admin:pass:word:287082")))
+	rr := httptest.NewRecorder()
+	wrapped.ServeHTTP(rr, req)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Errorf("after %d wrong codes: status = %d, want %d", defaultRateLimits.maxFailures, rr.Code, http.StatusTooManyRequests)
+	}
+}
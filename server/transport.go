@@ -2,6 +2,7 @@ package server
 
 import (
 	"io"
+	"time"
 )
 
 // Transport represents a bidirectional connection for terminal I/O.
@@ -11,3 +12,9 @@ type Transport interface {
 	Close() error
 	RemoteAddr() string
 }
+
+// RTTReporter is implemented by transports that can estimate the round trip
+// time to the client. ok is false until an estimate is available.
+type RTTReporter interface {
+	RTT() (rtt time.Duration, ok bool)
+}
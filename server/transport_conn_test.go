@@ -3,12 +3,18 @@ package server
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
+
+	"webtmux/webtty"
 )
 
 // connTestTransport implements the Transport interface for testing processTransportConn
@@ -210,7 +216,7 @@ func TestProcessTransportConnAuthFailure(t *testing.T) {
 	}
 
 	transport := newConnTestTransport()
-	server.authTokens.issue("127.0.0.1")
+	server.authTokens.issue("127.0.0.1", "", nil, false)
 	initMsg := InitMessage{AuthToken: "wrong:password"}
 	data, _ := json.Marshal(initMsg)
 	transport.SetReadData(data)
@@ -269,7 +275,7 @@ func TestProcessTransportConnWithArguments(t *testing.T) {
 	}
 
 	transport := newConnTestTransport()
-	authToken := server.authTokens.issue("127.0.0.1")
+	authToken, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
 	initMsg := InitMessage{
 		AuthToken: authToken,
 		Arguments: "?cols=80&rows=24",
@@ -301,7 +307,7 @@ func TestProcessTransportConnInvalidArguments(t *testing.T) {
 	}
 
 	transport := newConnTestTransport()
-	authToken := server.authTokens.issue("127.0.0.1")
+	authToken, _ := server.authTokens.issue("127.0.0.1", "", nil, false)
 	initMsg := InitMessage{
 		AuthToken: authToken,
 		Arguments: "://invalid-url", // Invalid URL
@@ -354,7 +360,399 @@ func TestProcessTransportConnWithOptions(t *testing.T) {
 	t.Logf("processTransportConn with options result: %v (expected)", err)
 }
 
+func TestProcessTransportConnTitleWithSlaveCommand(t *testing.T) {
+	factory := newConnTestFactory()
+	options := &Options{
+		TitleFormat: "{{ .command }}@{{ .hostname }}",
+		TitleVariables: map[string]interface{}{
+			"command":  "server-command",
+			"hostname": "test-host",
+		},
+	}
+
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	transport := newConnTestTransport()
+	initMsg := InitMessage{AuthToken: ""}
+	data, _ := json.Marshal(initMsg)
+	transport.SetReadData(data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	server.processTransportConn(ctx, transport, nil, "")
+
+	// The slave's command takes precedence over the server's variables
+	written := string(transport.GetWrittenData())
+	if !containsString(written, "3test@test-host") {
+		t.Errorf("Window title should be rendered from slave command, got: %q", written)
+	}
+}
+
+func TestProcessTransportConnCloseReasonWriteError(t *testing.T) {
+	factory := newConnTestFactory()
+	options := &Options{
+		TitleFormat: "Test",
+	}
+
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	transport := newConnTestTransport()
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport.SetReadData(data)
+	transport.writeErr = errors.New("broken pipe")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	err = server.processTransportConn(ctx, transport, nil, "")
+	if err == nil {
+		t.Fatal("processTransportConn should fail on write error")
+	}
+
+	reason := server.closeReason(ctx, err)
+	if !containsString(reason, "client (write error") || !containsString(reason, "broken pipe") {
+		t.Errorf("closeReason() = %q, want a client write error", reason)
+	}
+}
+
+func TestProcessTransportConnCloseReasonReadError(t *testing.T) {
+	factory := newConnTestFactory()
+	options := &Options{
+		TitleFormat: "Test",
+	}
+
+	server, err := New(factory, options)
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	// Once the init message is consumed the transport reports EOF
+	transport := newConnTestTransport()
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport.SetReadData(data)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	err = server.processTransportConn(ctx, transport, nil, "")
+	reason := server.closeReason(ctx, err)
+	if reason != "client (read error)" {
+		t.Errorf("closeReason() = %q, want %q", reason, "client (read error)")
+	}
+}
+
+// blockingTransport returns the given messages one per read and then
+// blocks until closed, like a client that stays connected
+type blockingTransport struct {
+	*connTestTransport
+	reads    [][]byte
+	closed   chan struct{}
+	messages [][]byte
+}
+
+func newBlockingTransport(reads ...[]byte) *blockingTransport {
+	return &blockingTransport{
+		connTestTransport: newConnTestTransport(),
+		reads:             reads,
+		closed:            make(chan struct{}),
+	}
+}
+
+func (b *blockingTransport) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	if len(b.reads) > 0 {
+		n := copy(p, b.reads[0])
+		b.reads = b.reads[1:]
+		b.mu.Unlock()
+		return n, nil
+	}
+	b.mu.Unlock()
+
+	<-b.closed
+	return 0, io.EOF
+}
+
+func (b *blockingTransport) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.messages = append(b.messages, append([]byte(nil), p...))
+	return len(p), nil
+}
+
+// exitingSlave writes its output and exits right away with a code
+type exitingSlave struct {
+	*mockSlaveForTransport
+	code int
+}
+
+func (s *exitingSlave) ExitCode() (int, bool) {
+	return s.code, true
+}
+
+func newExitingSlave(output string, code int) *exitingSlave {
+	slave := &exitingSlave{mockSlaveForTransport: newMockSlaveForTransport(), code: code}
+	slave.reader = bytes.NewBufferString(output)
+	return slave
+}
+
+func TestProcessTransportConnImmediateExit(t *testing.T) {
+	tests := []struct {
+		name        string
+		code        int
+		window      int
+		wantMessage string
+	}{
+		{"failing command", 127, 2, "Command `test` exited immediately with exit code 127"},
+		{"successful command", 0, 2, ""},
+		{"disabled", 127, 0, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := newConnTestFactory()
+			slave := newExitingSlave("sh: tset: not found\r\n", tt.code)
+			server, err := New(&exitingFactory{factory, slave}, &Options{
+				TitleFormat:         "Test",
+				ImmediateExitWindow: tt.window,
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			data, _ := json.Marshal(InitMessage{AuthToken: ""})
+			transport := newBlockingTransport(data)
+			defer close(transport.closed)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err = server.processTransportConn(ctx, transport, nil, "")
+			if err != webtty.ErrSlaveClosed {
+				t.Fatalf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+			}
+
+			output := transport.outputText(t)
+			if !containsString(output, "sh: tset: not found") {
+				t.Errorf("output should contain the command's output, got %q", output)
+			}
+			if tt.wantMessage == "" {
+				if containsString(output, "exited immediately") {
+					t.Errorf("output should not report an immediate exit, got %q", output)
+				}
+			} else if !containsString(output, tt.wantMessage) {
+				t.Errorf("output should contain %q, got %q", tt.wantMessage, output)
+			}
+		})
+	}
+}
+
+func TestProcessTransportConnFailedFirstRead(t *testing.T) {
+	tests := []struct {
+		name        string
+		reader      io.Reader
+		wantMessage bool
+	}{
+		{"first read fails", iotest.ErrReader(errors.New("connection refused")), true},
+		{"fails after output", io.MultiReader(strings.NewReader("$ "), iotest.ErrReader(errors.New("connection reset"))), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			factory := newConnTestFactory()
+			factory.slave.reader = tt.reader
+			server, err := New(factory, &Options{TitleFormat: "Test"})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			data, _ := json.Marshal(InitMessage{AuthToken: ""})
+			transport := newBlockingTransport(data)
+			defer close(transport.closed)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			err = server.processTransportConn(ctx, transport, nil, "")
+			if err != webtty.ErrSlaveClosed {
+				t.Fatalf("processTransportConn() = %v, want %v", err, webtty.ErrSlaveClosed)
+			}
+
+			output := transport.outputText(t)
+			message := "Backend `mock-transport` failed before producing any output: connection refused"
+			if tt.wantMessage && !containsString(output, message) {
+				t.Errorf("output should contain %q, got %q", message, output)
+			}
+			if !tt.wantMessage && containsString(output, "failed before producing any output") {
+				t.Errorf("output should not report a failed start, got %q", output)
+			}
+		})
+	}
+}
+
+// exitingFactory always returns the given slave
+type exitingFactory struct {
+	*connTestFactory
+	slave Slave
+}
+
+func (f *exitingFactory) New(params map[string][]string, headers map[string][]string) (Slave, error) {
+	return f.slave, nil
+}
+
+// outputText concatenates the decoded payloads of all Output messages
+func (b *blockingTransport) outputText(t *testing.T) string {
+	t.Helper()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var output []byte
+	for _, msg := range b.messages {
+		if len(msg) == 0 || msg[0] != webtty.Output {
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(msg[1:]))
+		if err != nil {
+			t.Fatalf("failed to decode output message: %v", err)
+		}
+		output = append(output, decoded...)
+	}
+	return string(output)
+}
+
+// contextFactory records the context passed to NewWithContext
+type contextFactory struct {
+	*connTestFactory
+	ctx context.Context
+}
+
+func (f *contextFactory) NewWithContext(ctx context.Context, params map[string][]string, headers map[string][]string) (Slave, error) {
+	f.ctx = ctx
+	return f.slave, nil
+}
+
+func TestProcessTransportConnContextFactory(t *testing.T) {
+	factory := &contextFactory{connTestFactory: newConnTestFactory()}
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	transport := newConnTestTransport()
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport.SetReadData(data)
+
+	ctx := context.WithValue(context.Background(), connContextKey{}, "trace-123")
+	server.processTransportConn(ctx, transport, nil, "")
+
+	if factory.ctx == nil {
+		t.Fatal("NewWithContext should be used for a ContextFactory")
+	}
+	if factory.ctx.Value(connContextKey{}) != "trace-123" {
+		t.Errorf("context value = %v, want %q", factory.ctx.Value(connContextKey{}), "trace-123")
+	}
+
+	// The backend's context is canceled once the connection ended
+	select {
+	case <-factory.ctx.Done():
+	case <-time.After(time.Second):
+		t.Error("backend context should be canceled after the connection ended")
+	}
+}
+
 // containsString checks if the string s contains the substring substr
 func containsString(s, substr string) bool {
 	return bytes.Contains([]byte(s), []byte(substr))
 }
+
+func TestClearOnConnect(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		factory := &exitingFactory{connTestFactory: newConnTestFactory(), slave: newExitingSlave("$ ", 0)}
+		server, err := New(factory, &Options{TitleFormat: "Test", ClearOnConnect: enabled})
+		if err != nil {
+			t.Fatalf("New() error: %v", err)
+		}
+
+		data, _ := json.Marshal(InitMessage{AuthToken: ""})
+		transport := newBlockingTransport(data)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		server.processTransportConn(ctx, transport, nil, "")
+		cancel()
+		close(transport.closed)
+
+		want := "$ "
+		if enabled {
+			want = "\x1b[H\x1b[2J$ "
+		}
+		if got := transport.outputText(t); got != want {
+			t.Errorf("ClearOnConnect=%v output = %q, want %q", enabled, got, want)
+		}
+	}
+}
+
+func TestProcessTransportConnReadyBeforeOutput(t *testing.T) {
+	factory := &exitingFactory{connTestFactory: newConnTestFactory(), slave: newExitingSlave("$ ", 0)}
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	defer close(transport.closed)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	server.processTransportConn(ctx, transport, nil, "")
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	ready := -1
+	for i, msg := range transport.messages {
+		switch msg[0] {
+		case webtty.ConnectionReady:
+			if string(msg[1:]) != `{"type":"ready"}` {
+				t.Errorf("ready payload = %s, want %s", msg[1:], `{"type":"ready"}`)
+			}
+			ready = i
+		case webtty.Output:
+			if ready < 0 {
+				t.Fatalf("output message %d was sent before the ready message", i)
+			}
+		}
+	}
+	if ready < 0 {
+		t.Error("no ready message was sent")
+	}
+}
+
+func TestProcessTransportConnNoPTY(t *testing.T) {
+	factory := newConnTestFactory()
+	factory.newError = fmt.Errorf("failed to start command: %w", &NoPTYError{Err: errors.New("open /dev/ptmx: no space left on device")})
+	server, err := New(factory, &Options{TitleFormat: "Test"})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	data, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(data)
+	close(transport.closed)
+
+	err = server.processTransportConn(context.Background(), transport, nil, "")
+	var noPTY *NoPTYError
+	if !errors.As(err, &noPTY) {
+		t.Fatalf("processTransportConn() error = %v, want a NoPTYError", err)
+	}
+	if !strings.Contains(err.Error(), "no pty available") {
+		t.Errorf("processTransportConn() error = %q, want it to mention the missing pty", err)
+	}
+	if got := transport.outputText(t); !strings.Contains(got, noPTYMessage) {
+		t.Errorf("output = %q, want the no pty message", got)
+	}
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// parseNetworks turns a comma separated list of IPs and CIDRs, like the
+// TrustedProxies option, into networks. Plain IPs are treated as single
+// host networks.
+func parseNetworks(networks string) ([]*net.IPNet, error) {
+	result := []*net.IPNet{}
+	for _, entry := range strings.Split(networks, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP or CIDR `%s`", entry)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, errors.Errorf("invalid IP or CIDR `%s`", entry)
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// containsIP reports whether ip belongs to any of networks.
+func containsIP(networks []*net.IPNet, ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client sending r, used for rate limiting,
+// auth token binding and the IP filter. Without trusted proxies it is the
+// peer address, or with TrustXForwardedFor the first X-Forwarded-For entry
+// from anyone. With them, the forwarding headers are only believed when the
+// request comes from a trusted proxy, and are walked from the right,
+// skipping trusted hops, so that entries prepended by the client cannot
+// spoof it.
+func (server *Server) clientIP(r *http.Request) string {
+	if len(server.trustedProxies) == 0 {
+		if !server.options.TrustXForwardedFor {
+			return ipFromAddr(r.RemoteAddr)
+		}
+		return clientIPFromRequest(r)
+	}
+
+	ip := ipFromAddr(r.RemoteAddr)
+	if !containsIP(server.trustedProxies, ip) {
+		return ip
+	}
+	hops := forwardedHops(r.Header)
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := forwardedIP(hops[i])
+		if hop == "" {
+			// Obfuscated or malformed, nothing further left can be believed
+			break
+		}
+		ip = hop
+		if !containsIP(server.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// forwardedHops returns the addresses a request was forwarded for, from
+// the client to the last proxy. The standard Forwarded header takes
+// precedence over X-Forwarded-For, and X-Real-IP, which only names the
+// client, is the last resort.
+func forwardedHops(header http.Header) []string {
+	hops := []string{}
+	if values := header.Values("Forwarded"); len(values) > 0 {
+		for _, element := range strings.Split(strings.Join(values, ","), ",") {
+			for _, pair := range strings.Split(element, ";") {
+				key, value, _ := strings.Cut(strings.TrimSpace(pair), "=")
+				if strings.EqualFold(key, "for") {
+					hops = append(hops, value)
+				}
+			}
+		}
+		return hops
+	}
+	if values := header.Values("X-Forwarded-For"); len(values) > 0 {
+		for _, hop := range strings.Split(strings.Join(values, ","), ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+		return hops
+	}
+	if realIP := header.Get("X-Real-IP"); realIP != "" {
+		hops = append(hops, realIP)
+	}
+	return hops
+}
+
+// forwardedIP returns the IP of a forwarding header entry, which may be
+// quoted and carry a port, as in `"[2001:db8::1]:4711"`, or "" when it is
+// not an IP, like the `unknown` and `_hidden` identifiers of Forwarded.
+func forwardedIP(hop string) string {
+	hop = strings.Trim(strings.TrimSpace(hop), `"`)
+	if host, _, err := net.SplitHostPort(hop); err == nil {
+		hop = host
+	}
+	ip := net.ParseIP(strings.Trim(hop, "[]"))
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// remoteAddr returns the address of the client sending r for display: the
+// forwarded client IP behind a trusted proxy, the peer address otherwise.
+func (server *Server) remoteAddr(r *http.Request) string {
+	if ip := server.clientIP(r); ip != ipFromAddr(r.RemoteAddr) {
+		return ip
+	}
+	return r.RemoteAddr
+}
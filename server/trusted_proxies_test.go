@@ -0,0 +1,265 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"webtmux/webtty"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	networks, err := parseNetworks(" 10.0.0.0/8, 192.168.1.1,::1 ,")
+	if err != nil {
+		t.Fatalf("parseNetworks() error: %v", err)
+	}
+	want := []string{"10.0.0.0/8", "192.168.1.1/32", "::1/128"}
+	if len(networks) != len(want) {
+		t.Fatalf("parseNetworks() = %v, want %v", networks, want)
+	}
+	for i, network := range networks {
+		if network.String() != want[i] {
+			t.Errorf("network %d = %s, want %s", i, network, want[i])
+		}
+	}
+
+	for _, invalid := range []string{"proxy.example.com", "10.0.0.0/33"} {
+		if _, err := parseNetworks(invalid); err == nil {
+			t.Errorf("parseNetworks(%q) should fail", invalid)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, _ := parseNetworks("10.0.0.0/8")
+
+	tests := []struct {
+		name       string
+		proxies    bool
+		trustXFF   bool
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxies uses first entry", false, true, "10.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2"}, "1.1.1.1"},
+		{"no proxies without header", false, true, "3.3.3.3:1234", nil, "3.3.3.3"},
+		{"no proxies without trusting header", false, false, "10.0.0.1:1234", []string{"1.1.1.1"}, "10.0.0.1"},
+		{"untrusted peer ignores header", true, true, "3.3.3.3:1234", []string{"1.1.1.1"}, "3.3.3.3"},
+		{"single hop", true, true, "10.0.0.1:1234", []string{"2.2.2.2"}, "2.2.2.2"},
+		{"spoofed leftmost entry", true, true, "10.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2"}, "2.2.2.2"},
+		{"proxy chain", true, true, "10.0.0.1:1234", []string{"1.1.1.1, 2.2.2.2, 10.0.0.5, 10.0.0.6"}, "2.2.2.2"},
+		{"repeated headers", true, true, "10.0.0.1:1234", []string{"1.1.1.1", "2.2.2.2, 10.0.0.5"}, "2.2.2.2"},
+		{"only trusted hops", true, true, "10.0.0.1:1234", []string{"10.0.0.3, 10.0.0.4"}, "10.0.0.3"},
+		{"trusted peer without header", true, true, "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"trusted peer with trusting header off", true, false, "10.0.0.1:1234", []string{"2.2.2.2"}, "2.2.2.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := &Server{options: &Options{TrustXForwardedFor: tt.trustXFF}}
+			if tt.proxies {
+				server.trustedProxies = trusted
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				req.Header.Add("X-Forwarded-For", value)
+			}
+
+			if got := server.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPForwardingHeaders(t *testing.T) {
+	trusted, _ := parseNetworks("10.0.0.0/8")
+	server := &Server{options: &Options{}, trustedProxies: trusted}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		header     string
+		value      string
+		want       string
+	}{
+		{"forwarded from trusted peer", "10.0.0.1:1234", "Forwarded", "for=192.0.2.60;proto=https", "192.0.2.60"},
+		{"forwarded from untrusted peer", "3.3.3.3:1234", "Forwarded", "for=192.0.2.60", "3.3.3.3"},
+		{"forwarded spoofed leftmost entry", "10.0.0.1:1234", "Forwarded", "for=1.1.1.1, For=198.51.100.17;by=10.0.0.1", "198.51.100.17"},
+		{"forwarded quoted IPv6 with port", "10.0.0.1:1234", "Forwarded", `for="[2001:db8:cafe::17]:4711"`, "2001:db8:cafe::17"},
+		{"forwarded obfuscated client", "10.0.0.1:1234", "Forwarded", "for=unknown, for=10.0.0.2", "10.0.0.2"},
+		{"real IP from trusted peer", "10.0.0.1:1234", "X-Real-IP", "192.0.2.60", "192.0.2.60"},
+		{"real IP from untrusted peer", "3.3.3.3:1234", "X-Real-IP", "192.0.2.60", "3.3.3.3"},
+		{"malformed forwarded for", "10.0.0.1:1234", "X-Forwarded-For", "not-an-ip", "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(tt.header, tt.value)
+
+			if got := server.clientIP(req); got != tt.want {
+				t.Errorf("clientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientIPForwardedPrecedence(t *testing.T) {
+	trusted, _ := parseNetworks("10.0.0.0/8")
+	server := &Server{options: &Options{}, trustedProxies: trusted}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("Forwarded", "for=192.0.2.1")
+	req.Header.Set("X-Forwarded-For", "192.0.2.2")
+	req.Header.Set("X-Real-IP", "192.0.2.3")
+	if got := server.clientIP(req); got != "192.0.2.1" {
+		t.Errorf("clientIP() = %q, want the Forwarded client 192.0.2.1", got)
+	}
+
+	req.Header.Del("Forwarded")
+	if got := server.clientIP(req); got != "192.0.2.2" {
+		t.Errorf("clientIP() = %q, want the X-Forwarded-For client 192.0.2.2", got)
+	}
+}
+
+func TestIndexVariablesForwardedRemoteAddr(t *testing.T) {
+	tests := []struct {
+		name       string
+		proxies    string
+		remoteAddr string
+		want       string
+	}{
+		{"spoofed header from untrusted peer", "", "3.3.3.3:1234", "3.3.3.3:1234"},
+		{"header from trusted proxy", "10.0.0.0/8", "10.0.0.1:1234", "192.0.2.60"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := New(newMockFactory(), &Options{
+				TitleFormat:    "{{ .remote_addr }}",
+				TrustedProxies: tt.proxies,
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set("X-Real-IP", "192.0.2.60")
+
+			vars, err := server.indexVariables(req)
+			if err != nil {
+				t.Fatalf("indexVariables() error: %v", err)
+			}
+			if vars["title"] != tt.want {
+				t.Errorf("title = %v, want %q", vars["title"], tt.want)
+			}
+		})
+	}
+}
+
+func TestTrustedProxiesTokenBinding(t *testing.T) {
+	server, err := New(newMockFactory(), &Options{
+		TitleFormat:     "Test",
+		EnableBasicAuth: true,
+		AuthIPBinding:   true,
+		TrustedProxies:  "10.0.0.0/8",
+	})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	req := httptest.NewRequest("GET", "/auth_token.js", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "6.6.6.6, 2.2.2.2, 10.0.0.5")
+	token, err := server.issueAuthToken(req)
+	if err != nil {
+		t.Fatalf("issueAuthToken() error: %v", err)
+	}
+
+	if !server.authTokens.validate(token, "2.2.2.2") {
+		t.Error("Token should be bound to the IP added by the first trusted proxy")
+	}
+	if server.authTokens.validate(token, "6.6.6.6") {
+		t.Error("Token should not be bound to the spoofable leftmost IP")
+	}
+}
+
+func TestNewInvalidTrustedProxies(t *testing.T) {
+	if _, err := New(newMockFactory(), &Options{TitleFormat: "Test", TrustedProxies: "nope"}); err == nil {
+		t.Error("New() should fail with invalid trusted proxies")
+	}
+}
+
+func TestExposeClientIP(t *testing.T) {
+	tests := []struct {
+		name      string
+		forwarded string
+		want      string
+	}{
+		{name: "direct", want: "127.0.0.1"},
+		{name: "proxied", forwarded: "6.6.6.6, 2.2.2.2", want: "2.2.2.2"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, err := New(newConnTestFactory(), &Options{
+				TitleFormat:    "Test",
+				ExposeClientIP: true,
+				TrustedProxies: "127.0.0.1",
+			})
+			if err != nil {
+				t.Fatalf("New() error: %v", err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			testServer := httptest.NewServer(server.generateHandleWS(ctx, cancel, newCounter(0)))
+			defer testServer.Close()
+
+			header := http.Header{}
+			if tt.forwarded != "" {
+				header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			wsURL := "ws" + strings.TrimPrefix(testServer.URL, "http")
+			conn, _, err := websocket.DefaultDialer.Dial(wsURL, header)
+			if err != nil {
+				t.Fatalf("Dial() error: %v", err)
+			}
+			defer conn.Close()
+
+			if err := conn.WriteJSON(InitMessage{}); err != nil {
+				t.Fatalf("WriteJSON() error: %v", err)
+			}
+			conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+			for {
+				_, msg, err := conn.ReadMessage()
+				if err != nil {
+					t.Fatalf("no ready message: %v", err)
+				}
+				if len(msg) == 0 || msg[0] != webtty.ConnectionReady {
+					continue
+				}
+				var ready struct {
+					ClientIP string `json:"clientIP"`
+				}
+				if err := json.Unmarshal(msg[1:], &ready); err != nil {
+					t.Fatalf("invalid ready message %q: %v", msg, err)
+				}
+				if ready.ClientIP != tt.want {
+					t.Errorf("ready clientIP = %q, want %q", ready.ClientIP, tt.want)
+				}
+				return
+			}
+		})
+	}
+}
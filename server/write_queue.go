@@ -0,0 +1,166 @@
+package server
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// errWriteQueueTimeout ends a connection whose client did not make room in
+// a full write queue in time under the block policy.
+var errWriteQueueTimeout = errors.New("write queue full: client too slow")
+
+// queuedTransport decouples writes to a connection from the client's pace
+// through a queue of at most depth messages, which bounds the memory a slow
+// client can tie up. When the queue is full, policy decides:
+//   - block: wait up to timeout for room, then fail the write
+//   - evict: drop the oldest queued message to make room
+//   - drop: drop the new message
+type queuedTransport struct {
+	Transport
+
+	policy  string
+	timeout time.Duration
+
+	mu      sync.RWMutex // held for writing while the queue is closed
+	stopped bool
+	queue   chan []byte
+	drained chan struct{}
+	failed  chan struct{}
+	err     error // set before failed is closed
+
+	dropped int64
+	peak    int64
+}
+
+func newQueuedTransport(transport Transport, depth int, policy string, timeout time.Duration) *queuedTransport {
+	qt := &queuedTransport{
+		Transport: transport,
+		policy:    policy,
+		timeout:   timeout,
+		queue:     make(chan []byte, depth),
+		drained:   make(chan struct{}),
+		failed:    make(chan struct{}),
+	}
+	go qt.writeLoop()
+	return qt
+}
+
+func (qt *queuedTransport) writeLoop() {
+	defer close(qt.drained)
+	for message := range qt.queue {
+		if _, err := qt.Transport.Write(message); err != nil {
+			qt.err = err
+			close(qt.failed)
+			// Let writers and stop go on
+			for range qt.queue {
+			}
+			return
+		}
+	}
+}
+
+func (qt *queuedTransport) Write(p []byte) (int, error) {
+	qt.mu.RLock()
+	defer qt.mu.RUnlock()
+
+	select {
+	case <-qt.failed:
+		return 0, qt.err
+	default:
+	}
+	if qt.stopped {
+		return 0, errors.New("write queue stopped")
+	}
+
+	message := append([]byte(nil), p...)
+	select {
+	case qt.queue <- message:
+		qt.recordDepth()
+		return len(p), nil
+	default:
+	}
+
+	switch qt.policy {
+	case "evict":
+		for {
+			select {
+			case <-qt.queue:
+				atomic.AddInt64(&qt.dropped, 1)
+			default:
+			}
+			select {
+			case qt.queue <- message:
+				qt.recordDepth()
+				return len(p), nil
+			default:
+			}
+		}
+	case "drop":
+		atomic.AddInt64(&qt.dropped, 1)
+		return len(p), nil
+	default:
+		timer := time.NewTimer(qt.timeout)
+		defer timer.Stop()
+		select {
+		case qt.queue <- message:
+			qt.recordDepth()
+			return len(p), nil
+		case <-qt.failed:
+			return 0, qt.err
+		case <-timer.C:
+			return 0, errWriteQueueTimeout
+		}
+	}
+}
+
+func (qt *queuedTransport) recordDepth() {
+	depth := int64(len(qt.queue))
+	for {
+		peak := atomic.LoadInt64(&qt.peak)
+		if depth <= peak || atomic.CompareAndSwapInt64(&qt.peak, peak, depth) {
+			return
+		}
+	}
+}
+
+// Depth returns the number of messages waiting to be written.
+func (qt *queuedTransport) Depth() int {
+	return len(qt.queue)
+}
+
+// Peak returns the largest depth the queue reached.
+func (qt *queuedTransport) Peak() int {
+	return int(atomic.LoadInt64(&qt.peak))
+}
+
+// Dropped returns the number of messages dropped by the evict and drop
+// policies.
+func (qt *queuedTransport) Dropped() int64 {
+	return atomic.LoadInt64(&qt.dropped)
+}
+
+// stop refuses further writes and waits up to timeout for the queued
+// messages to be written. The underlying transport is left open.
+func (qt *queuedTransport) stop() {
+	qt.mu.Lock()
+	if !qt.stopped {
+		qt.stopped = true
+		close(qt.queue)
+	}
+	qt.mu.Unlock()
+
+	timer := time.NewTimer(qt.timeout)
+	defer timer.Stop()
+	select {
+	case <-qt.drained:
+	case <-timer.C:
+	}
+}
+
+func (qt *queuedTransport) Close() error {
+	qt.stop()
+	return qt.Transport.Close()
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// slowTransport holds every write until it is released
+type slowTransport struct {
+	*connTestTransport
+
+	writing chan struct{}
+	release chan struct{}
+
+	mu       sync.Mutex
+	messages []string
+}
+
+func newSlowTransport() *slowTransport {
+	return &slowTransport{
+		connTestTransport: newConnTestTransport(),
+		writing:           make(chan struct{}, 100),
+		release:           make(chan struct{}),
+	}
+}
+
+func (st *slowTransport) Write(p []byte) (int, error) {
+	st.writing <- struct{}{}
+	<-st.release
+	st.mu.Lock()
+	st.messages = append(st.messages, string(p))
+	st.mu.Unlock()
+	return len(p), nil
+}
+
+func (st *slowTransport) written() []string {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return append([]string(nil), st.messages...)
+}
+
+// fillQueue writes messages to qt once the first one is held by the
+// transport, so that the rest stay queued.
+func fillQueue(t *testing.T, qt *queuedTransport, st *slowTransport, messages ...string) []error {
+	t.Helper()
+	errs := []error{}
+	for i, message := range messages {
+		_, err := qt.Write([]byte(message))
+		errs = append(errs, err)
+		if i == 0 {
+			<-st.writing
+		}
+	}
+	return errs
+}
+
+func TestQueuedTransportBlock(t *testing.T) {
+	st := newSlowTransport()
+	qt := newQueuedTransport(st, 2, "block", 30*time.Millisecond)
+
+	errs := fillQueue(t, qt, st, "1", "2", "3", "4")
+	for i, err := range errs[:3] {
+		if err != nil {
+			t.Errorf("Write(%d) error: %v", i+1, err)
+		}
+	}
+	if errs[3] != errWriteQueueTimeout {
+		t.Errorf("Write() to a full queue error = %v, want %v", errs[3], errWriteQueueTimeout)
+	}
+	if qt.Depth() != 2 || qt.Peak() != 2 || qt.Dropped() != 0 {
+		t.Errorf("depth, peak, dropped = %d, %d, %d, want 2, 2, 0", qt.Depth(), qt.Peak(), qt.Dropped())
+	}
+
+	close(st.release)
+	qt.stop()
+	if got := strings.Join(st.written(), ""); got != "123" {
+		t.Errorf("written = %q, want %q", got, "123")
+	}
+}
+
+func TestQueuedTransportEvict(t *testing.T) {
+	st := newSlowTransport()
+	qt := newQueuedTransport(st, 2, "evict", time.Second)
+
+	for i, err := range fillQueue(t, qt, st, "1", "2", "3", "4", "5") {
+		if err != nil {
+			t.Errorf("Write(%d) error: %v", i+1, err)
+		}
+	}
+	if qt.Depth() != 2 || qt.Dropped() != 2 {
+		t.Errorf("depth, dropped = %d, %d, want 2, 2", qt.Depth(), qt.Dropped())
+	}
+
+	close(st.release)
+	qt.stop()
+	if got := strings.Join(st.written(), ""); got != "145" {
+		t.Errorf("written = %q, want the oldest messages evicted", got)
+	}
+}
+
+func TestQueuedTransportDrop(t *testing.T) {
+	st := newSlowTransport()
+	qt := newQueuedTransport(st, 2, "drop", time.Second)
+
+	for i, err := range fillQueue(t, qt, st, "1", "2", "3", "4", "5") {
+		if err != nil {
+			t.Errorf("Write(%d) error: %v", i+1, err)
+		}
+	}
+	if qt.Depth() != 2 || qt.Dropped() != 2 {
+		t.Errorf("depth, dropped = %d, %d, want 2, 2", qt.Depth(), qt.Dropped())
+	}
+
+	close(st.release)
+	qt.stop()
+	if got := strings.Join(st.written(), ""); got != "123" {
+		t.Errorf("written = %q, want the newest messages dropped", got)
+	}
+}
+
+func TestQueuedTransportWriteError(t *testing.T) {
+	transport := newConnTestTransport()
+	transport.writeErr = errors.New("broken pipe")
+	qt := newQueuedTransport(transport, 2, "block", time.Second)
+	defer qt.stop()
+
+	qt.Write([]byte("1"))
+	deadline := time.Now().Add(time.Second)
+	var err error
+	for err == nil && time.Now().Before(deadline) {
+		_, err = qt.Write([]byte("2"))
+		time.Sleep(time.Millisecond)
+	}
+	if err == nil || err.Error() != "broken pipe" {
+		t.Errorf("Write() error = %v, want the transport's write error", err)
+	}
+}
+
+func TestServeTerminalWriteQueue(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", WriteQueueDepth: 4, WriteQueuePolicy: "drop", WriteQueueTimeout: 100})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+
+	init, _ := json.Marshal(InitMessage{AuthToken: ""})
+	transport := newBlockingTransport(init)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		server.processTransportConn(ctx, transport, nil, "")
+		close(done)
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	entries := server.connections.list()
+	if len(entries) != 1 {
+		t.Fatalf("active connections = %d, want 1", len(entries))
+	}
+	if _, _, _, ok := entries[0].WriteQueue(); !ok {
+		t.Error("connection should have a write queue")
+	}
+	close(transport.closed)
+	<-done
+
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+	// Window title, buffer size and ready
+	if len(transport.messages) != 3 {
+		t.Errorf("messages = %q, want the initialization messages through the queue", transport.messages)
+	}
+}
@@ -1,7 +1,11 @@
 package server
 
 import (
+	"context"
 	"io"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/pkg/errors"
@@ -10,47 +14,205 @@ import (
 // wsTransport wraps a WebSocket connection to implement the Transport interface.
 type wsTransport struct {
 	*websocket.Conn
+
+	// closeGrace is how long Close waits for the client to acknowledge
+	// the close frame before dropping the connection.
+	closeGrace time.Duration
+
+	// pongWait is how long the client may stay silent, not even answering
+	// a ping, before Read fails. Zero disables the read deadline.
+	pongWait time.Duration
+
+	// pingPayload is sent with every ping instead of a sequence number.
+	pingPayload string
+
+	// writeMu serializes writers, as websocket.Conn supports only one
+	// concurrent writer.
+	writeMu sync.Mutex
+
+	mu       sync.Mutex
+	peerGone chan struct{}
+	closing  bool
+
+	// Last RTT probe, guarded by mu
+	pingSeq     uint64
+	pingSentAt  time.Time
+	pingPending bool
+	rtt         time.Duration
+}
+
+// newWSTransport creates a new WebSocket transport wrapper.
+func newWSTransport(conn *websocket.Conn, closeGrace time.Duration) *wsTransport {
+	wst := &wsTransport{
+		Conn:       conn,
+		closeGrace: closeGrace,
+	}
+	conn.SetPongHandler(wst.handlePong)
+	return wst
 }
 
 // Write sends data over the WebSocket connection as a TextMessage.
+// It is safe to call from multiple goroutines.
 func (wst *wsTransport) Write(p []byte) (n int, err error) {
-	writer, err := wst.Conn.NextWriter(websocket.TextMessage)
-	if err != nil {
+	wst.writeMu.Lock()
+	defer wst.writeMu.Unlock()
+
+	// Unlike NextWriter, WriteMessage frames p without allocating
+	if err := wst.Conn.WriteMessage(websocket.TextMessage, p); err != nil {
 		return 0, err
 	}
-	defer writer.Close()
-	return writer.Write(p)
+	return len(p), nil
 }
 
 // Read reads data from the WebSocket connection, only accepting TextMessages.
+// Any message from the client extends the read deadline set by pongWait;
+// output sent to the client does not.
 func (wst *wsTransport) Read(p []byte) (n int, err error) {
 	for {
 		msgType, reader, err := wst.Conn.NextReader()
 		if err != nil {
+			// The client acknowledged our close frame or went away
+			wst.markPeerGone()
 			return 0, err
 		}
+		wst.extendReadDeadline()
 
 		if msgType != websocket.TextMessage {
 			continue
 		}
 
-		b, err := io.ReadAll(reader)
+		// Read the message right into p, which it must fit
+		n, err = io.ReadFull(reader, p)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return n, nil
+		}
 		if err != nil {
 			return 0, err
 		}
-		if len(b) > len(p) {
+		var extra [1]byte
+		if _, err := io.ReadFull(reader, extra[:]); err != io.EOF {
 			return 0, errors.New("client message exceeded buffer size")
 		}
-		n = copy(p, b)
 		return n, nil
 	}
 }
 
-// Close closes the WebSocket connection.
+// Close sends a close frame and, when a grace period is set, waits for the
+// client's acknowledgment before closing the underlying connection so that
+// pending output is not cut off by a TCP reset.
+// The acknowledgment is observed by a concurrent Read.
 func (wst *wsTransport) Close() error {
+	if wst.closeGrace > 0 {
+		deadline := time.Now().Add(wst.closeGrace)
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		if err := wst.Conn.WriteControl(websocket.CloseMessage, msg, deadline); err == nil {
+			wst.mu.Lock()
+			wst.closing = true
+			wst.mu.Unlock()
+			wst.Conn.SetReadDeadline(deadline)
+			timer := time.NewTimer(wst.closeGrace)
+			select {
+			case <-wst.peerGoneChan():
+			case <-timer.C:
+			}
+			timer.Stop()
+		}
+	}
 	return wst.Conn.Close()
 }
 
+// extendReadDeadline gives the client another pongWait to be heard from,
+// unless Close already set its own deadline.
+func (wst *wsTransport) extendReadDeadline() {
+	if wst.pongWait <= 0 {
+		return
+	}
+	wst.mu.Lock()
+	defer wst.mu.Unlock()
+	if !wst.closing {
+		wst.Conn.SetReadDeadline(time.Now().Add(wst.pongWait))
+	}
+}
+
+func (wst *wsTransport) peerGoneChan() chan struct{} {
+	wst.mu.Lock()
+	defer wst.mu.Unlock()
+	if wst.peerGone == nil {
+		wst.peerGone = make(chan struct{})
+	}
+	return wst.peerGone
+}
+
+func (wst *wsTransport) markPeerGone() {
+	ch := wst.peerGoneChan()
+	wst.mu.Lock()
+	defer wst.mu.Unlock()
+	select {
+	case <-ch:
+	default:
+		close(ch)
+	}
+}
+
+// probeRTT pings the client every interval until ctx is done and estimates
+// the round trip time from its pongs, which are handled by a concurrent Read.
+// A new ping is only sent once the previous one was answered.
+func (wst *wsTransport) probeRTT(ctx context.Context, interval time.Duration) {
+	wst.extendReadDeadline()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		wst.mu.Lock()
+		var payload []byte
+		if !wst.pingPending {
+			wst.pingSeq++
+			wst.pingSentAt = time.Now()
+			wst.pingPending = true
+			payload = []byte(wst.pingData())
+		}
+		wst.mu.Unlock()
+
+		if payload != nil {
+			if err := wst.Conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(interval)); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// pingData returns the payload of the current ping, guarded by mu.
+func (wst *wsTransport) pingData() string {
+	if wst.pingPayload != "" {
+		return wst.pingPayload
+	}
+	return strconv.FormatUint(wst.pingSeq, 10)
+}
+
+// handlePong completes the pending RTT probe answered by a pong.
+func (wst *wsTransport) handlePong(data string) error {
+	wst.extendReadDeadline()
+	wst.mu.Lock()
+	if wst.pingPending && data == wst.pingData() {
+		wst.rtt = time.Since(wst.pingSentAt)
+		wst.pingPending = false
+	}
+	wst.mu.Unlock()
+	return nil
+}
+
+// RTT returns the round trip time measured by the last answered probe.
+func (wst *wsTransport) RTT() (time.Duration, bool) {
+	wst.mu.Lock()
+	defer wst.mu.Unlock()
+	return wst.rtt, wst.rtt > 0
+}
+
 // RemoteAddr returns the remote address of the WebSocket connection.
 func (wst *wsTransport) RemoteAddr() string {
 	return wst.Conn.RemoteAddr().String()
@@ -58,3 +220,4 @@ func (wst *wsTransport) RemoteAddr() string {
 
 // Ensure wsTransport implements Transport interface
 var _ Transport = (*wsTransport)(nil)
+var _ RTTReporter = (*wsTransport)(nil)
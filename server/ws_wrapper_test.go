@@ -2,10 +2,13 @@ package server
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -19,7 +22,7 @@ func TestWsTransportImplementsTransport(t *testing.T) {
 }
 
 // setupWebSocketPair creates a client-server WebSocket pair for testing
-func setupWebSocketPair(t *testing.T) (*wsTransport, *websocket.Conn, func()) {
+func setupWebSocketPair(t testing.TB) (*wsTransport, *websocket.Conn, func()) {
 	t.Helper()
 
 	upgrader := websocket.Upgrader{
@@ -46,7 +49,7 @@ func setupWebSocketPair(t *testing.T) (*wsTransport, *websocket.Conn, func()) {
 
 	select {
 	case serverConn := <-serverConnCh:
-		transport := &wsTransport{serverConn}
+		transport := newWSTransport(serverConn, 0)
 		return transport, clientConn, func() {
 			clientConn.Close()
 			serverConn.Close()
@@ -181,6 +184,20 @@ func TestWsTransportReadBufferOverflow(t *testing.T) {
 	}
 }
 
+func TestWsTransportReadFillsBuffer(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+
+	if err := clientConn.WriteMessage(websocket.TextMessage, []byte("0123456789")); err != nil {
+		t.Fatalf("Client WriteMessage() error: %v", err)
+	}
+	buf := make([]byte, 10)
+	n, err := transport.Read(buf)
+	if err != nil || string(buf[:n]) != "0123456789" {
+		t.Errorf("Read() = %q, %v, want a message that fills the buffer", buf[:n], err)
+	}
+}
+
 func TestWsTransportMultipleWriteRead(t *testing.T) {
 	transport, clientConn, cleanup := setupWebSocketPair(t)
 	defer cleanup()
@@ -207,6 +224,49 @@ func TestWsTransportMultipleWriteRead(t *testing.T) {
 	}
 }
 
+func TestWsTransportConcurrentWrite(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+
+	const writers = 8
+	const perWriter = 50
+
+	want := make(map[string]bool)
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		msgs := make([]string, perWriter)
+		for i := range msgs {
+			msgs[i] = strings.Repeat(fmt.Sprintf("w%d-m%d;", w, i), 100)
+			want[msgs[i]] = true
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, msg := range msgs {
+				if _, err := transport.Write([]byte(msg)); err != nil {
+					t.Errorf("Write() error: %v", err)
+					return
+				}
+			}
+		}()
+	}
+
+	// Every frame must arrive intact, exactly once
+	clientConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < writers*perWriter; i++ {
+		_, msg, err := clientConn.ReadMessage()
+		if err != nil {
+			t.Fatalf("Client ReadMessage() error after %d messages: %v", i, err)
+		}
+		if !want[string(msg)] {
+			t.Fatalf("Received corrupted or duplicate frame of %d bytes", len(msg))
+		}
+		delete(want, string(msg))
+	}
+	wg.Wait()
+}
+
 func TestWsTransportReadAfterClose(t *testing.T) {
 	transport, clientConn, cleanup := setupWebSocketPair(t)
 	defer cleanup()
@@ -244,19 +304,22 @@ func BenchmarkWsTransportWrite(b *testing.B) {
 	serverConn := <-serverConnCh
 	defer serverConn.Close()
 
-	transport := &wsTransport{serverConn}
+	transport := &wsTransport{Conn: serverConn}
 
-	// Start a goroutine to read messages
+	// Start a goroutine to read messages, discarding them so that only the
+	// allocations of Write are counted
 	go func() {
 		for {
-			_, _, err := clientConn.ReadMessage()
+			_, reader, err := clientConn.NextReader()
 			if err != nil {
 				return
 			}
+			io.Copy(io.Discard, reader)
 		}
 	}()
 
 	data := []byte("benchmark test data")
+	b.ReportAllocs()
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
@@ -264,6 +327,30 @@ func BenchmarkWsTransportWrite(b *testing.B) {
 	}
 }
 
+func BenchmarkWsTransportRead(b *testing.B) {
+	transport, clientConn, cleanup := setupWebSocketPair(b)
+	defer cleanup()
+
+	go func() {
+		data := []byte("1benchmark test input")
+		for {
+			if err := clientConn.WriteMessage(websocket.TextMessage, data); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := transport.Read(buf); err != nil {
+			b.Fatalf("Read() error: %v", err)
+		}
+	}
+}
+
 // Test that wsTransport implements io.ReadWriter
 func TestWsTransportAsReadWriter(t *testing.T) {
 	transport, clientConn, cleanup := setupWebSocketPair(t)
@@ -289,3 +376,233 @@ func TestWsTransportAsReadWriter(t *testing.T) {
 	var rw io.ReadWriter = transport
 	_ = rw
 }
+
+func TestWsTransportCloseSendsCloseFrame(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+	transport.closeGrace = time.Second
+
+	// The server side keeps reading, as webtty does during teardown
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			if _, err := transport.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	// The client reads the close frame and gorilla acknowledges it
+	clientErr := make(chan error, 1)
+	go func() {
+		_, _, err := clientConn.ReadMessage()
+		clientErr <- err
+	}()
+
+	start := time.Now()
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("Close() took %v, should return once the close is acknowledged", elapsed)
+	}
+
+	select {
+	case err := <-clientErr:
+		if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			t.Errorf("client error = %v, want normal close frame", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("client did not receive close frame")
+	}
+}
+
+func TestWsTransportCloseWaitsForGracePeriod(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+	transport.closeGrace = 100 * time.Millisecond
+
+	// Nobody on the client side reads, so no acknowledgment arrives
+	start := time.Now()
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Close() returned after %v, want it to linger for the grace period", elapsed)
+	}
+
+	// The close frame was still sent before the connection was dropped
+	_, _, err := clientConn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Errorf("client error = %v, want normal close frame", err)
+	}
+}
+
+func TestWsTransportCloseWithoutGracePeriod(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+
+	if err := transport.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	_, _, err := clientConn.ReadMessage()
+	if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Error("no close frame should be sent without a grace period")
+	}
+}
+
+func TestWsTransportProbeRTT(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+
+	// The client answers pings after a delay
+	const pongDelay = 50 * time.Millisecond
+	clientConn.SetPingHandler(func(data string) error {
+		time.Sleep(pongDelay)
+		return clientConn.WriteControl(websocket.PongMessage, []byte(data), time.Now().Add(time.Second))
+	})
+	go func() {
+		for {
+			if _, _, err := clientConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := transport.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	if _, ok := transport.RTT(); ok {
+		t.Error("RTT() should not be available before the first pong")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go transport.probeRTT(ctx, 20*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if rtt, ok := transport.RTT(); ok {
+			if rtt < pongDelay || rtt > 500*time.Millisecond {
+				t.Errorf("RTT() = %s, want about %s", rtt, pongDelay)
+			}
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("RTT() was not measured")
+}
+
+func TestWsTransportKeepAliveResponsiveClient(t *testing.T) {
+	transport, clientConn, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+	transport.pongWait = 300 * time.Millisecond
+	transport.pingPayload = "keep-alive"
+
+	// The client only reads, answering pings with pongs as it goes
+	go func() {
+		for {
+			if _, _, err := clientConn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go transport.probeRTT(ctx, 100*time.Millisecond)
+
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := transport.Read(make([]byte, 1024))
+		readErr <- err
+	}()
+
+	// Output keeps flowing across several ping cycles
+	deadline := time.After(time.Second)
+	for done := false; !done; {
+		select {
+		case err := <-readErr:
+			t.Fatalf("responsive client was dropped: %v", err)
+		case <-deadline:
+			done = true
+		case <-time.After(20 * time.Millisecond):
+			if _, err := transport.Write([]byte("output")); err != nil {
+				t.Fatalf("Write() error: %v", err)
+			}
+		}
+	}
+	if _, ok := transport.RTT(); !ok {
+		t.Error("RTT() should be measured from pongs echoing the ping payload")
+	}
+}
+
+func TestWsTransportKeepAliveUnresponsiveClient(t *testing.T) {
+	transport, _, cleanup := setupWebSocketPair(t)
+	defer cleanup()
+	transport.pongWait = 300 * time.Millisecond
+
+	// The client never reads, so pings go unanswered
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go transport.probeRTT(ctx, 100*time.Millisecond)
+
+	start := time.Now()
+	readErr := make(chan error, 1)
+	go func() {
+		_, err := transport.Read(make([]byte, 1024))
+		readErr <- err
+	}()
+
+	select {
+	case err := <-readErr:
+		if err == nil {
+			t.Fatal("Read() should fail for an unresponsive client")
+		}
+		if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+			t.Errorf("client was dropped after %v, before the pong wait", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("unresponsive client was not dropped after the pong wait")
+	}
+}
+
+func TestKeepAliveReleasesDeadClient(t *testing.T) {
+	server, err := New(newConnTestFactory(), &Options{TitleFormat: "Test", WSPingInterval: 1, WSPongWait: 2})
+	if err != nil {
+		t.Fatalf("New() error: %v", err)
+	}
+	counter := newCounter(0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	ts := httptest.NewServer(server.generateHandleWS(ctx, cancel, counter))
+	defer ts.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial() error: %v", err)
+	}
+	defer conn.Close()
+	if err := conn.WriteJSON(InitMessage{}); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+
+	// The client goes silent without closing, so no pong answers the pings
+	start := time.Now()
+	deadline := start.Add(4 * time.Second)
+	for counter.count() != 0 || time.Since(start) < 100*time.Millisecond {
+		if time.Now().After(deadline) {
+			t.Fatalf("counter.count() = %d after the pong wait, want 0", counter.count())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if elapsed := time.Since(start); elapsed < 1500*time.Millisecond {
+		t.Errorf("client was dropped after %v, before the pong wait", elapsed)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"encoding/binary"
+	"log"
+
+	"github.com/pkg/errors"
+)
+
+// wtDatagramMaxPayload is the largest message sent as a datagram, leaving
+// room for the headers in a QUIC packet of the minimum size.
+const wtDatagramMaxPayload = 1000
+
+// datagramSession is the part of *webtransport.Session carrying datagrams.
+type datagramSession interface {
+	SendDatagram([]byte) error
+	ReceiveDatagram(ctx context.Context) ([]byte, error)
+}
+
+// wtMessage is a message read from either a stream or a datagram.
+type wtMessage struct {
+	data []byte
+	err  error
+	buf  *[]byte // the pooled buffer holding data, if any
+}
+
+// enableDatagrams lets messages also arrive as datagrams of session. Each
+// datagram is a 4-byte big-endian sequence number followed by a message.
+//
+// Unlike stream frames, datagrams are not retransmitted: a message lost on
+// the network is gone, and one overtaken by a later datagram is dropped.
+// In exchange, a lost packet does not hold back the messages after it, so
+// keystrokes keep flowing over lossy networks. Clients only send short
+// input this way, while output always takes the stream.
+func (wtt *wtTransport) enableDatagrams(session datagramSession) {
+	wtt.datagrams = session
+	wtt.incoming = make(chan wtMessage)
+	wtt.datagramCtx, wtt.stopDatagrams = context.WithCancel(context.Background())
+}
+
+// useDatagram reports whether a message of size bytes is sent as a datagram
+// rather than a stream frame.
+func (wtt *wtTransport) useDatagram(size int) bool {
+	return wtt.sendDatagrams && wtt.datagrams != nil && size > 0 && size <= wtDatagramMaxPayload
+}
+
+// writeDatagram sends p as the next datagram. Must be called with mu held.
+func (wtt *wtTransport) writeDatagram(p []byte) error {
+	buf := getBuffer(4 + len(p))
+	defer putBuffer(buf)
+	datagram := (*buf)[:4]
+	binary.BigEndian.PutUint32(datagram, wtt.datagramSeq+1)
+	if err := wtt.datagrams.SendDatagram(append(datagram, p...)); err != nil {
+		return err
+	}
+	wtt.datagramSeq++
+	return nil
+}
+
+// readMessage returns the next message from either the streams or the
+// datagrams. The first call starts reading both with buffers of size.
+func (wtt *wtTransport) readMessage(size int) (wtMessage, error) {
+	wtt.pumpOnce.Do(func() {
+		go wtt.pumpFrames(size)
+		go wtt.pumpDatagrams()
+	})
+
+	select {
+	case msg := <-wtt.incoming:
+		return msg, nil
+	case <-wtt.datagramCtx.Done():
+		return wtMessage{}, errors.New("WebTransport transport closed")
+	}
+}
+
+// pumpFrames passes the stream frames on to incoming until a read fails.
+func (wtt *wtTransport) pumpFrames(size int) {
+	for {
+		buf := getBuffer(size)
+		n, err := wtt.readFrame(*buf)
+		select {
+		case wtt.incoming <- wtMessage{data: (*buf)[:n], err: err, buf: buf}:
+		case <-wtt.datagramCtx.Done():
+			putBuffer(buf)
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// pumpDatagrams passes the datagrams on to incoming, in order, dropping
+// those arriving after a later one. It stops quietly when datagrams
+// cannot be received, leaving the stream to report the end of the session.
+func (wtt *wtTransport) pumpDatagrams() {
+	var last uint32
+	for {
+		datagram, err := wtt.datagrams.ReceiveDatagram(wtt.datagramCtx)
+		if err != nil {
+			if wtt.datagramCtx.Err() == nil {
+				log.Printf("WebTransport datagrams stopped, reading input from the stream only: %v", err)
+			}
+			return
+		}
+		if len(datagram) <= 4 {
+			continue
+		}
+		seq := binary.BigEndian.Uint32(datagram)
+		if seq <= last {
+			continue
+		}
+		last = seq
+
+		select {
+		case wtt.incoming <- wtMessage{data: datagram[4:]}:
+		case <-wtt.datagramCtx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mockDatagramSession records sent datagrams and receives those pushed to
+// received.
+type mockDatagramSession struct {
+	sent     [][]byte
+	sendErr  error
+	received chan []byte
+}
+
+func newMockDatagramSession() *mockDatagramSession {
+	return &mockDatagramSession{received: make(chan []byte, 8)}
+}
+
+func (s *mockDatagramSession) SendDatagram(b []byte) error {
+	if s.sendErr != nil {
+		return s.sendErr
+	}
+	s.sent = append(s.sent, append([]byte(nil), b...))
+	return nil
+}
+
+func (s *mockDatagramSession) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	select {
+	case b := <-s.received:
+		return b, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func datagram(seq uint32, msg string) []byte {
+	return append(binary.BigEndian.AppendUint32(nil, seq), msg...)
+}
+
+func TestWTTransportUseDatagram(t *testing.T) {
+	tests := []struct {
+		name      string
+		enabled   bool
+		sending   bool
+		size      int
+		wantDgram bool
+	}{
+		{"short input", true, true, 2, true},
+		{"largest datagram", true, true, wtDatagramMaxPayload, true},
+		{"too large", true, true, wtDatagramMaxPayload + 1, false},
+		{"empty", true, true, 0, false},
+		{"not sending datagrams", true, false, 2, false},
+		{"datagrams not enabled", false, true, 2, false},
+	}
+	for _, tt := range tests {
+		wtt, _ := newMockWTTransport(newMockStream(), 0)
+		if tt.enabled {
+			wtt.enableDatagrams(newMockDatagramSession())
+		}
+		wtt.sendDatagrams = tt.sending
+		if got := wtt.useDatagram(tt.size); got != tt.wantDgram {
+			t.Errorf("%s: useDatagram(%d) = %v, want %v", tt.name, tt.size, got, tt.wantDgram)
+		}
+	}
+}
+
+func TestWTTransportWritesShortMessagesAsDatagrams(t *testing.T) {
+	stream := newMockStream()
+	session := newMockDatagramSession()
+	wtt, _ := newMockWTTransport(stream, 0)
+	wtt.enableDatagrams(session)
+	wtt.sendDatagrams = true
+	defer wtt.Close()
+
+	long := string(bytes.Repeat([]byte("x"), wtDatagramMaxPayload))
+	for _, msg := range []string{"1a", "1" + long, "1b"} {
+		if n, err := wtt.Write([]byte(msg)); err != nil || n != len(msg) {
+			t.Fatalf("Write(%d bytes) = %d, %v", len(msg), n, err)
+		}
+	}
+
+	want := [][]byte{datagram(1, "1a"), datagram(2, "1b")}
+	if len(session.sent) != 2 || !bytes.Equal(session.sent[0], want[0]) || !bytes.Equal(session.sent[1], want[1]) {
+		t.Errorf("datagrams = %q, want %q", session.sent, want)
+	}
+	if frames := stream.frames(t); len(frames) != 1 || frames[0] != "1"+long {
+		t.Errorf("stream got %d frames, want only the long message", len(frames))
+	}
+}
+
+func TestWTTransportDatagramFallback(t *testing.T) {
+	stream := newMockStream()
+	session := newMockDatagramSession()
+	session.sendErr = errors.New("datagrams not supported")
+	wtt, _ := newMockWTTransport(stream, 0)
+	wtt.enableDatagrams(session)
+	wtt.sendDatagrams = true
+	defer wtt.Close()
+
+	for _, msg := range []string{"1a", "1b"} {
+		if _, err := wtt.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write(%q) error: %v", msg, err)
+		}
+	}
+	if wtt.sendDatagrams {
+		t.Error("a failed datagram should stop sending datagrams")
+	}
+	if frames := stream.frames(t); len(frames) != 2 || frames[0] != "1a" || frames[1] != "1b" {
+		t.Errorf("stream frames = %q, want both messages", frames)
+	}
+}
+
+func TestWTTransportReadsDatagramsAndFrames(t *testing.T) {
+	session := newMockDatagramSession()
+	stream := newBlockingStream("1frame")
+	wtt, _ := newMockWTTransport(stream.mockStream, 0)
+	wtt.readers[0] = stream
+	wtt.enableDatagrams(session)
+	defer wtt.Close()
+
+	// The late datagram 1, arriving after 2, and the duplicate are dropped
+	session.received <- datagram(2, "1second")
+	session.received <- datagram(1, "1first")
+	session.received <- datagram(2, "1second")
+	session.received <- datagram(3, "1third")
+
+	got := map[string]bool{}
+	buf := make([]byte, 64)
+	for i := 0; i < 3; i++ {
+		n, err := wtt.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error: %v", err)
+		}
+		got[string(buf[:n])] = true
+	}
+	for _, want := range []string{"1frame", "1second", "1third"} {
+		if !got[want] {
+			t.Errorf("Read() did not return %q, got %v", want, got)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := wtt.Read(buf)
+		done <- err
+	}()
+	close(stream.release)
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Read() after the end of the stream should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Read() did not return the end of the stream")
+	}
+}
+
+func TestWTTransportCloseStopsDatagramReads(t *testing.T) {
+	session := newMockDatagramSession()
+	stream := newBlockingStream()
+	defer close(stream.release)
+	wtt, _ := newMockWTTransport(stream.mockStream, 0)
+	wtt.readers[0] = stream
+	wtt.enableDatagrams(session)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := wtt.Read(make([]byte, 64))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	wtt.Close()
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("Read() after Close() should fail")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not end a pending Read()")
+	}
+}
+
+// blockingStream serves its frames and then blocks reads until released
+type blockingStream struct {
+	*mockStream
+	release chan struct{}
+}
+
+func newBlockingStream(frames ...string) *blockingStream {
+	return &blockingStream{mockStream: newMockStream(frames...), release: make(chan struct{})}
+}
+
+func (s *blockingStream) Read(p []byte) (int, error) {
+	if s.in.Len() > 0 {
+		return s.in.Read(p)
+	}
+	<-s.release
+	return 0, errors.New("released")
+}
@@ -5,13 +5,25 @@ import (
 	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"regexp"
+	"time"
 
+	"github.com/quic-go/quic-go"
 	"github.com/quic-go/quic-go/http3"
 	"github.com/quic-go/webtransport-go"
 )
 
+// quicConnContextKey carries the *quic.Conn of a WebTransport request.
+type quicConnContextKey struct{}
+
+// quicConnFromRequest returns the QUIC connection r arrived on, if known.
+func quicConnFromRequest(r *http.Request) (*quic.Conn, bool) {
+	conn, ok := r.Context().Value(quicConnContextKey{}).(*quic.Conn)
+	return conn, ok
+}
+
 // WebTransportServer handles WebTransport connections over HTTP/3.
 type WebTransportServer struct {
 	server       *webtransport.Server
@@ -32,13 +44,19 @@ func NewWebTransportServer(options *Options, pathPrefix string) (*WebTransportSe
 		}
 	}
 
-	addr := fmt.Sprintf("%s:%s", options.Address, options.Port)
+	addr := net.JoinHostPort(options.Address, options.Port)
 
 	wtServer := &webtransport.Server{
 		H3: &http3.Server{
 			Addr: addr,
+			ConnContext: func(ctx context.Context, conn *quic.Conn) context.Context {
+				return context.WithValue(ctx, quicConnContextKey{}, conn)
+			},
 		},
 		CheckOrigin: func(r *http.Request) bool {
+			if options.AutoOrigin {
+				return pinnedOrigin(r)
+			}
 			if originRegexp != nil {
 				return originRegexp.MatchString(r.Header.Get("Origin"))
 			}
@@ -46,6 +64,16 @@ func NewWebTransportServer(options *Options, pathPrefix string) (*WebTransportSe
 			return sameOrigin(r)
 		},
 	}
+	// Detect clients whose network dropped silently the same way as over
+	// WebSocket: QUIC sends the pings and closes connections left idle.
+	wtServer.H3.QUICConfig = &quic.Config{
+		Allow0RTT:       true,
+		KeepAlivePeriod: time.Duration(options.WSPingInterval) * time.Second,
+		MaxIdleTimeout:  time.Duration(options.WSPongWait) * time.Second,
+	}
+	// Enables WebTransport in the HTTP/3 settings and makes the QUIC
+	// connection available to Upgrade.
+	webtransport.ConfigureHTTP3Server(wtServer.H3)
 
 	return &WebTransportServer{
 		server:       wtServer,
@@ -67,11 +95,24 @@ func (wts *WebTransportServer) ListenAndServeTLS(ctx context.Context, certFile,
 		return fmt.Errorf("failed to load TLS certificates: %w", err)
 	}
 
-	wts.server.H3.TLSConfig = &tls.Config{
+	return wts.serve(ctx, &tls.Config{
 		Certificates: []tls.Certificate{cert},
 		NextProtos:   []string{"h3"},
-	}
+	}, handler)
+}
+
+// ListenAndServeAutoTLS starts the WebTransport server with TLS, using
+// certificates from getCertificate, e.g. an ACME manager shared with the
+// HTTP server.
+func (wts *WebTransportServer) ListenAndServeAutoTLS(ctx context.Context, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), handler http.Handler) error {
+	return wts.serve(ctx, &tls.Config{
+		GetCertificate: getCertificate,
+		NextProtos:     []string{"h3"},
+	}, handler)
+}
 
+func (wts *WebTransportServer) serve(ctx context.Context, tlsConfig *tls.Config, handler http.Handler) error {
+	wts.server.H3.TLSConfig = tlsConfig
 	wts.server.H3.Handler = handler
 
 	log.Printf("WebTransport server listening on %s:%s (UDP)", wts.options.Address, wts.options.Port)
@@ -79,7 +120,13 @@ func (wts *WebTransportServer) ListenAndServeTLS(ctx context.Context, certFile,
 	// Run in a goroutine and handle context cancellation
 	errChan := make(chan error, 1)
 	go func() {
-		errChan <- wts.server.ListenAndServe()
+		conn, err := net.ListenPacket(listenNetwork("udp", wts.options.AddressFamily), wts.server.H3.Addr)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer conn.Close()
+		errChan <- wts.server.Serve(conn)
 	}()
 
 	select {
@@ -1,8 +1,14 @@
 package server
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestNewWebTransportServer(t *testing.T) {
@@ -98,6 +104,31 @@ func TestWebTransportServerClose(t *testing.T) {
 	}
 }
 
+func TestWebTransportServerKeepAlive(t *testing.T) {
+	options := &Options{
+		Address:        "127.0.0.1",
+		Port:           "8443",
+		WSPingInterval: 10,
+		WSPongWait:     25,
+	}
+
+	wts, err := NewWebTransportServer(options, "/")
+	if err != nil {
+		t.Fatalf("NewWebTransportServer() error: %v", err)
+	}
+
+	config := wts.Server().H3.QUICConfig
+	if config == nil {
+		t.Fatal("QUICConfig is nil")
+	}
+	if config.KeepAlivePeriod != 10*time.Second {
+		t.Errorf("KeepAlivePeriod = %v, want 10s", config.KeepAlivePeriod)
+	}
+	if config.MaxIdleTimeout != 25*time.Second {
+		t.Errorf("MaxIdleTimeout = %v, want 25s", config.MaxIdleTimeout)
+	}
+}
+
 func TestWebTransportServerServer(t *testing.T) {
 	options := &Options{
 		Address: "127.0.0.1",
@@ -234,6 +265,66 @@ func TestWebTransportServerOriginCheck(t *testing.T) {
 	}
 }
 
+func TestWebTransportServerUpgradeRejectsCrossOrigin(t *testing.T) {
+	wts, err := NewWebTransportServer(&Options{Address: "localhost", Port: "8443"}, "/")
+	if err != nil {
+		t.Fatalf("NewWebTransportServer() error: %v", err)
+	}
+
+	upgrade := func(origin string) error {
+		req := httptest.NewRequest(http.MethodConnect, "https://example.com/wt", nil)
+		req.Host = "example.com"
+		req.Proto = "webtransport"
+		req.Header.Set("Origin", origin)
+		_, err := wts.Upgrade(httptest.NewRecorder(), req)
+		return err
+	}
+
+	// Without WSOrigin, only same-origin upgrades pass the origin check
+	if err := upgrade("https://evil.com"); err == nil || !strings.Contains(err.Error(), "origin not allowed") {
+		t.Errorf("cross-origin Upgrade() error = %v, want origin rejection", err)
+	}
+	if err := upgrade("https://example.com"); err == nil || strings.Contains(err.Error(), "origin not allowed") {
+		t.Errorf("same-origin Upgrade() error = %v, want it to pass the origin check", err)
+	}
+}
+
+func TestWebTransportServerAutoOrigin(t *testing.T) {
+	options := &Options{
+		Address:    "localhost",
+		Port:       "8443",
+		AutoOrigin: true,
+	}
+
+	wts, err := NewWebTransportServer(options, "/")
+	if err != nil {
+		t.Fatalf("NewWebTransportServer() error: %v", err)
+	}
+
+	tests := []struct {
+		origin      string
+		shouldAllow bool
+	}{
+		{"https://example.com:8443", true},
+		{"https://EXAMPLE.com", true},
+		{"https://evil.com:8443", false},
+		{"https://example.com:9999", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "https://example.com:8443/", nil)
+		req.Host = "example.com:8443"
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+
+		if allowed := wts.server.CheckOrigin(req); allowed != tt.shouldAllow {
+			t.Errorf("Origin check for %q under AutoOrigin = %v, want %v", tt.origin, allowed, tt.shouldAllow)
+		}
+	}
+}
+
 func TestWebTransportServerPathPrefix(t *testing.T) {
 	tests := []struct {
 		name       string
@@ -336,3 +427,44 @@ func BenchmarkNewWebTransportServer(b *testing.B) {
 		}
 	}
 }
+
+func TestNewWebTransportServerConfiguresHTTP3(t *testing.T) {
+	wts, err := NewWebTransportServer(&Options{Address: "127.0.0.1", Port: "0"}, "/")
+	if err != nil {
+		t.Fatalf("NewWebTransportServer() error: %v", err)
+	}
+
+	h3 := wts.Server().H3
+	if !h3.EnableDatagrams || len(h3.AdditionalSettings) == 0 {
+		t.Error("HTTP/3 server should advertise WebTransport support")
+	}
+}
+
+func TestWebTransportServerAutoTLS(t *testing.T) {
+	server, err := NewWebTransportServer(&Options{Address: "127.0.0.1", Port: "0"}, "/")
+	if err != nil {
+		t.Fatalf("NewWebTransportServer() error: %v", err)
+	}
+
+	requested := make(chan string, 1)
+	getCertificate := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		requested <- hello.ServerName
+		return nil, errors.New("no certificate")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	server.ListenAndServeAutoTLS(ctx, getCertificate, http.NotFoundHandler())
+
+	config := server.Server().H3.TLSConfig
+	if config == nil || config.GetCertificate == nil {
+		t.Fatal("HTTP/3 TLS config should get certificates from getCertificate")
+	}
+	if strings.Join(config.NextProtos, ",") != "h3" {
+		t.Errorf("NextProtos = %v, want [h3]", config.NextProtos)
+	}
+	config.GetCertificate(&tls.ClientHelloInfo{ServerName: "example.com"})
+	if got := <-requested; got != "example.com" {
+		t.Errorf("getCertificate() called for %q, want %q", got, "example.com")
+	}
+}
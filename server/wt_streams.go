@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"log"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// wtStreamRejected is the error code of the reset sent for a stream over
+// the per-session cap.
+const wtStreamRejected webtransport.StreamErrorCode = 1
+
+// incomingStream is the part of a stream opened by a client that is needed
+// to hold or reject it.
+type incomingStream interface {
+	CancelRead(webtransport.StreamErrorCode)
+	CancelWrite(webtransport.StreamErrorCode)
+	Close() error
+}
+
+// limitWTStreams accepts the streams a client opens besides its terminal
+// stream until accept fails, which happens once the session or ctx ends.
+// Up to maxStreams streams, the terminal stream included, are held open
+// until then; any further stream is reset right away.
+func limitWTStreams(ctx context.Context, accept func(context.Context) (incomingStream, error), maxStreams int, reqID string) {
+	var held []incomingStream
+	defer func() {
+		for _, stream := range held {
+			stream.Close()
+		}
+	}()
+
+	streams := 1
+	for {
+		stream, err := accept(ctx)
+		if err != nil {
+			return
+		}
+		streams++
+		if streams > maxStreams {
+			log.Printf("Resetting WebTransport stream %d: client exceeded %d streams, request: %s", streams, maxStreams, reqID)
+			stream.CancelRead(wtStreamRejected)
+			stream.CancelWrite(wtStreamRejected)
+			continue
+		}
+		held = append(held, stream)
+	}
+}
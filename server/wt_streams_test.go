@@ -0,0 +1,149 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/webtransport-go"
+)
+
+// mockIncomingStream records how a stream opened by a client was handled.
+type mockIncomingStream struct {
+	mu         sync.Mutex
+	readReset  webtransport.StreamErrorCode
+	writeReset webtransport.StreamErrorCode
+	reset      bool
+	closed     bool
+}
+
+func (s *mockIncomingStream) CancelRead(code webtransport.StreamErrorCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.readReset = code
+	s.reset = true
+}
+
+func (s *mockIncomingStream) CancelWrite(code webtransport.StreamErrorCode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.writeReset = code
+	s.reset = true
+}
+
+func (s *mockIncomingStream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func (s *mockIncomingStream) state() (reset, closed bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.reset, s.closed
+}
+
+// mockStreamSession hands out the streams a client opens, then fails once
+// the session ends.
+type mockStreamSession struct {
+	streams chan incomingStream
+	ended   chan struct{}
+}
+
+func newMockStreamSession(streams ...*mockIncomingStream) *mockStreamSession {
+	session := &mockStreamSession{
+		streams: make(chan incomingStream, len(streams)),
+		ended:   make(chan struct{}),
+	}
+	for _, stream := range streams {
+		session.streams <- stream
+	}
+	return session
+}
+
+func (session *mockStreamSession) accept(ctx context.Context) (incomingStream, error) {
+	select {
+	case stream := <-session.streams:
+		return stream, nil
+	case <-session.ended:
+		return nil, errors.New("session closed")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestLimitWTStreamsResetsExcessStreams(t *testing.T) {
+	logBuf := &syncBuffer{}
+	log.SetOutput(logBuf)
+	defer log.SetOutput(os.Stderr)
+
+	streams := []*mockIncomingStream{{}, {}, {}, {}}
+	session := newMockStreamSession(streams...)
+
+	done := make(chan struct{})
+	go func() {
+		limitWTStreams(context.Background(), session.accept, 3, "req-1")
+		close(done)
+	}()
+
+	// The terminal stream and the first two extra streams fit in a cap of 3
+	waitFor(t, "excess streams to be reset", func() bool {
+		reset, _ := streams[2].state()
+		reset3, _ := streams[3].state()
+		return reset && reset3
+	})
+	for i, stream := range streams[:2] {
+		if reset, closed := stream.state(); reset || closed {
+			t.Errorf("stream %d: reset = %v, closed = %v, want it held open", i, reset, closed)
+		}
+	}
+	streams[3].mu.Lock()
+	if streams[3].readReset != wtStreamRejected || streams[3].writeReset != wtStreamRejected {
+		t.Errorf("reset codes = %d/%d, want %d", streams[3].readReset, streams[3].writeReset, wtStreamRejected)
+	}
+	streams[3].mu.Unlock()
+	if got := logBuf.String(); !strings.Contains(got, "client exceeded 3 streams, request: req-1") {
+		t.Errorf("log = %q, want the reason for the reset", got)
+	}
+
+	close(session.ended)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("limitWTStreams did not return when the session ended")
+	}
+	for i, stream := range streams[:2] {
+		if _, closed := stream.state(); !closed {
+			t.Errorf("held stream %d was not closed when the session ended", i)
+		}
+	}
+}
+
+func TestLimitWTStreamsOnlyTerminalStream(t *testing.T) {
+	log.SetOutput(&bytes.Buffer{})
+	defer log.SetOutput(os.Stderr)
+
+	stream := &mockIncomingStream{}
+	session := newMockStreamSession(stream)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		limitWTStreams(ctx, session.accept, 1, "")
+		close(done)
+	}()
+
+	waitFor(t, "extra stream to be reset", func() bool {
+		reset, _ := stream.state()
+		return reset
+	})
+	cancel()
+	<-done
+}
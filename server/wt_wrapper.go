@@ -1,9 +1,13 @@
 package server
 
 import (
+	"context"
 	"encoding/binary"
+	"hash/crc32"
 	"io"
+	"log"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/quic-go/webtransport-go"
@@ -11,70 +15,287 @@ import (
 
 // wtTransport wraps a WebTransport bidirectional stream to implement the Transport interface.
 // It uses length-prefixed framing to match WebSocket's message semantics.
+//
+// When maxStreamBytes is set, the transport migrates to a fresh server-initiated
+// stream once the current one has carried that many bytes. A zero-length frame
+// on the old stream tells the client to switch; reads continue on the old stream
+// until the client closes it.
 type wtTransport struct {
 	session *webtransport.Session
-	stream  *webtransport.Stream
+	stream  io.ReadWriteCloser
 	mu      sync.Mutex
+
+	openStream     func() (io.ReadWriteCloser, error)
+	maxStreamBytes int
+	streamBytes    int
+
+	// checksum appends a CRC32 to each frame, flagged in its header
+	checksum bool
+	// wideFrames uses 4-byte length prefixes, lifting the 64 KiB limit of
+	// frames for clients that ask for it
+	wideFrames bool
+
+	// Set by enableDatagrams when messages may also arrive as datagrams
+	datagrams     datagramSession
+	incoming      chan wtMessage
+	pumpOnce      sync.Once
+	datagramCtx   context.Context
+	stopDatagrams context.CancelFunc
+	// sendDatagrams sends short messages as datagrams, as clients do
+	sendDatagrams bool
+	datagramSeq   uint32
+
+	readMu  sync.Mutex
+	readers []io.ReadWriteCloser
+	// header and checksum of the frame being read, by the only reader
+	readBuf [4]byte
+
+	// smoothedRTT reports QUIC's RTT estimate for the session's connection
+	smoothedRTT func() time.Duration
 }
 
+// wtChecksumFlag marks a frame whose payload is followed by its big-endian
+// CRC32 (IEEE). The remaining 15 bits of the header hold the length.
+const wtChecksumFlag = 0x8000
+
+// wtWideChecksumFlag is wtChecksumFlag for wide frames, whose remaining 31
+// bits of the header hold the length.
+const wtWideChecksumFlag = 0x80000000
+
+// wtWideFramesQuery is the query parameter with which clients ask for wide
+// frames. Clients without it get 2-byte length prefixes.
+const wtWideFramesQuery = "frames"
+
+// errWTChecksum is returned by Read when a frame fails its checksum.
+var errWTChecksum = errors.New("WebTransport frame checksum mismatch")
+
 // newWTTransport creates a new WebTransport transport wrapper.
 func newWTTransport(session *webtransport.Session, stream *webtransport.Stream) *wtTransport {
-	return &wtTransport{
+	wtt := &wtTransport{
 		session: session,
 		stream:  stream,
+		readers: []io.ReadWriteCloser{stream},
 	}
+	if session != nil {
+		wtt.openStream = func() (io.ReadWriteCloser, error) {
+			return session.OpenStreamSync(session.Context())
+		}
+	}
+	return wtt
 }
 
 // Write sends data over the WebTransport stream with length-prefixed framing.
-// Format: [2-byte big-endian length][payload]
+// Format: [2-byte big-endian length][payload], followed by [4-byte CRC32]
+// when checksums are enabled. Wide frames have a 4-byte length instead.
 func (wtt *wtTransport) Write(p []byte) (n int, err error) {
 	wtt.mu.Lock()
 	defer wtt.mu.Unlock()
 
-	if len(p) > 65535 {
-		return 0, errors.New("message too large for WebTransport frame (max 65535 bytes)")
+	if wtt.wideFrames {
+		if uint64(len(p)) >= wtWideChecksumFlag {
+			return 0, errors.New("message too large for WebTransport frame (2 GiB or more)")
+		}
+	} else {
+		if len(p) > 65535 {
+			return 0, errors.New("message too large for WebTransport frame (max 65535 bytes)")
+		}
+		if wtt.checksum && len(p) >= wtChecksumFlag {
+			return 0, errors.New("message too large for checksummed WebTransport frame (max 32767 bytes)")
+		}
+	}
+	if len(p) == 0 {
+		// zero-length frames are reserved for stream migration
+		return 0, nil
+	}
+
+	if wtt.useDatagram(len(p)) {
+		err := wtt.writeDatagram(p)
+		if err == nil {
+			return len(p), nil
+		}
+		log.Printf("WebTransport datagrams unavailable, falling back to the stream: %v", err)
+		wtt.sendDatagrams = false
 	}
 
-	// Write length prefix (2 bytes, big-endian)
-	header := make([]byte, 2)
-	binary.BigEndian.PutUint16(header, uint16(len(p)))
+	headerLen := wtt.headerLen()
+	if wtt.maxStreamBytes > 0 && wtt.streamBytes > 0 && wtt.streamBytes+headerLen+len(p) > wtt.maxStreamBytes {
+		if err := wtt.migrate(); err != nil {
+			log.Printf("WebTransport stream migration failed, staying on current stream: %v", err)
+		}
+	}
 
-	if _, err := wtt.stream.Write(header); err != nil {
-		return 0, errors.Wrap(err, "failed to write frame header")
+	// Build the whole frame so that it reaches the stream in a single write
+	// and cannot be split up by the stream's buffering.
+	frameLen := headerLen + len(p)
+	if wtt.checksum {
+		frameLen += 4
+	}
+	frameBuf := getBuffer(frameLen)
+	defer putBuffer(frameBuf)
+	frame := (*frameBuf)[:headerLen]
+	switch {
+	case wtt.wideFrames && wtt.checksum:
+		binary.BigEndian.PutUint32(frame, uint32(len(p))|wtWideChecksumFlag)
+	case wtt.wideFrames:
+		binary.BigEndian.PutUint32(frame, uint32(len(p)))
+	case wtt.checksum:
+		binary.BigEndian.PutUint16(frame, uint16(len(p))|wtChecksumFlag)
+	default:
+		binary.BigEndian.PutUint16(frame, uint16(len(p)))
+	}
+	frame = append(frame, p...)
+	if wtt.checksum {
+		frame = binary.BigEndian.AppendUint32(frame, crc32.ChecksumIEEE(p))
 	}
 
-	// Write payload
-	written, err := wtt.stream.Write(p)
+	written, err := wtt.stream.Write(frame)
+	wtt.streamBytes += written
+	// Report the payload bytes written, without header and checksum
+	written = min(max(written-headerLen, 0), len(p))
 	if err != nil {
-		return written, errors.Wrap(err, "failed to write frame payload")
+		return written, errors.Wrap(err, "failed to write frame")
 	}
-
 	return written, nil
 }
 
-// Read reads a length-prefixed frame from the WebTransport stream.
+// headerLen returns the length of the frame headers.
+func (wtt *wtTransport) headerLen() int {
+	if wtt.wideFrames {
+		return 4
+	}
+	return 2
+}
+
+// migrate opens a new stream, announces it on the current one and switches
+// writes over to it. Must be called with mu held.
+func (wtt *wtTransport) migrate() error {
+	if wtt.openStream == nil {
+		return errors.New("stream opener is not available")
+	}
+	next, err := wtt.openStream()
+	if err != nil {
+		return errors.Wrap(err, "failed to open stream")
+	}
+
+	if _, err := wtt.stream.Write(make([]byte, wtt.headerLen())); err != nil {
+		next.Close()
+		return errors.Wrap(err, "failed to write migration frame")
+	}
+	// Close only ends our sending side; the client keeps writing until it switches.
+	wtt.stream.Close()
+
+	wtt.readMu.Lock()
+	wtt.readers = append(wtt.readers, next)
+	wtt.readMu.Unlock()
+
+	wtt.stream = next
+	wtt.streamBytes = 0
+	return nil
+}
+
+// Read reads a message from either a length-prefixed frame of the
+// WebTransport stream or, when enabled, a datagram.
 func (wtt *wtTransport) Read(p []byte) (n int, err error) {
-	// Read length prefix (2 bytes)
-	header := make([]byte, 2)
-	if _, err := io.ReadFull(wtt.stream, header); err != nil {
+	if wtt.datagrams == nil {
+		return wtt.readFrame(p)
+	}
+
+	msg, err := wtt.readMessage(len(p))
+	if err != nil {
 		return 0, err
 	}
+	if msg.buf != nil {
+		defer putBuffer(msg.buf)
+	}
+	if len(msg.data) > len(p) {
+		return 0, errors.Errorf("message size %d exceeds buffer size %d", len(msg.data), len(p))
+	}
+	return copy(p, msg.data), msg.err
+}
+
+// readFrame reads a length-prefixed frame from the WebTransport stream.
+func (wtt *wtTransport) readFrame(p []byte) (n int, err error) {
+	for {
+		reader := wtt.currentReader()
 
-	length := int(binary.BigEndian.Uint16(header))
-	if length > len(p) {
-		return 0, errors.Errorf("message size %d exceeds buffer size %d", length, len(p))
+		// Read length prefix (2 bytes, or 4 for wide frames)
+		header := wtt.readBuf[:wtt.headerLen()]
+		if _, err := io.ReadFull(reader, header); err != nil {
+			if err == io.EOF && wtt.advanceReader(reader) {
+				continue
+			}
+			return 0, err
+		}
+
+		var length int
+		var checksummed bool
+		if wtt.wideFrames {
+			prefix := binary.BigEndian.Uint32(header)
+			checksummed = wtt.checksum && prefix&wtWideChecksumFlag != 0
+			if checksummed {
+				prefix &^= wtWideChecksumFlag
+			}
+			length = int(prefix)
+		} else {
+			length = int(binary.BigEndian.Uint16(header))
+			checksummed = wtt.checksum && length&wtChecksumFlag != 0
+			if checksummed {
+				length &^= wtChecksumFlag
+			}
+		}
+		if length > len(p) {
+			return 0, errors.Errorf("message size %d exceeds buffer size %d", length, len(p))
+		}
+
+		// Read payload
+		n, err := io.ReadFull(reader, p[:length])
+		if err != nil || !checksummed {
+			return n, err
+		}
+
+		trailer := wtt.readBuf[:4]
+		if _, err := io.ReadFull(reader, trailer); err != nil {
+			return 0, errors.Wrap(err, "failed to read frame checksum")
+		}
+		if binary.BigEndian.Uint32(trailer) != crc32.ChecksumIEEE(p[:n]) {
+			if wtt.session != nil {
+				wtt.session.CloseWithError(1, errWTChecksum.Error())
+			}
+			return 0, errWTChecksum
+		}
+		return n, nil
 	}
+}
+
+func (wtt *wtTransport) currentReader() io.ReadWriteCloser {
+	wtt.readMu.Lock()
+	defer wtt.readMu.Unlock()
+	return wtt.readers[0]
+}
 
-	// Read payload
-	return io.ReadFull(wtt.stream, p[:length])
+// advanceReader drops a finished stream from the read queue, reporting
+// whether a newer stream is available to read from.
+func (wtt *wtTransport) advanceReader(finished io.ReadWriteCloser) bool {
+	wtt.readMu.Lock()
+	defer wtt.readMu.Unlock()
+	if len(wtt.readers) < 2 || wtt.readers[0] != finished {
+		return false
+	}
+	wtt.readers = wtt.readers[1:]
+	return true
 }
 
 // Close closes the WebTransport stream and session.
 func (wtt *wtTransport) Close() error {
+	if wtt.stopDatagrams != nil {
+		wtt.stopDatagrams()
+	}
 	var err error
+	wtt.mu.Lock()
 	if wtt.stream != nil {
 		err = wtt.stream.Close()
 	}
+	wtt.mu.Unlock()
 	if wtt.session != nil {
 		wtt.session.CloseWithError(0, "connection closed")
 	}
@@ -89,5 +310,15 @@ func (wtt *wtTransport) RemoteAddr() string {
 	return "unknown"
 }
 
+// RTT returns QUIC's smoothed RTT estimate for the session.
+func (wtt *wtTransport) RTT() (time.Duration, bool) {
+	if wtt.smoothedRTT == nil {
+		return 0, false
+	}
+	rtt := wtt.smoothedRTT()
+	return rtt, rtt > 0
+}
+
 // Ensure wtTransport implements Transport interface
 var _ Transport = (*wtTransport)(nil)
+var _ RTTReporter = (*wtTransport)(nil)
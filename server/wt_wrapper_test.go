@@ -0,0 +1,403 @@
+package server
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// mockStream records writes and serves reads from a fixed buffer.
+type mockStream struct {
+	in     *bytes.Reader
+	out    bytes.Buffer
+	writes [][]byte
+	closed bool
+}
+
+func newMockStream(frames ...string) *mockStream {
+	var in bytes.Buffer
+	for _, frame := range frames {
+		binary.Write(&in, binary.BigEndian, uint16(len(frame)))
+		in.WriteString(frame)
+	}
+	return &mockStream{in: bytes.NewReader(in.Bytes())}
+}
+
+func (s *mockStream) Read(p []byte) (int, error) { return s.in.Read(p) }
+func (s *mockStream) Write(p []byte) (int, error) {
+	s.writes = append(s.writes, append([]byte(nil), p...))
+	return s.out.Write(p)
+}
+func (s *mockStream) Close() error { s.closed = true; return nil }
+
+// frames decodes the length-prefixed frames written to the stream.
+func (s *mockStream) frames(t *testing.T) []string {
+	t.Helper()
+	data := s.out.Bytes()
+	frames := []string{}
+	for len(data) > 0 {
+		if len(data) < 2 {
+			t.Fatalf("truncated frame header: %v", data)
+		}
+		length := int(binary.BigEndian.Uint16(data))
+		frames = append(frames, string(data[2:2+length]))
+		data = data[2+length:]
+	}
+	return frames
+}
+
+func newMockWTTransport(first *mockStream, maxBytes int, next ...*mockStream) (*wtTransport, *int) {
+	opened := 0
+	wtt := &wtTransport{
+		stream:         first,
+		readers:        []io.ReadWriteCloser{first},
+		maxStreamBytes: maxBytes,
+		openStream: func() (io.ReadWriteCloser, error) {
+			if opened >= len(next) {
+				return nil, errors.New("no more streams")
+			}
+			opened++
+			return next[opened-1], nil
+		},
+	}
+	return wtt, &opened
+}
+
+func TestWTTransportMigratesStreamAfterMaxBytes(t *testing.T) {
+	first, second := newMockStream(), newMockStream()
+	wtt, opened := newMockWTTransport(first, 12, second)
+
+	for _, msg := range []string{"1abc", "1def", "1ghi"} {
+		if _, err := wtt.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write(%q) error: %v", msg, err)
+		}
+	}
+
+	if *opened != 1 {
+		t.Fatalf("opened %d streams, want 1", *opened)
+	}
+	if got := first.frames(t); len(got) != 3 || got[0] != "1abc" || got[1] != "1def" || got[2] != "" {
+		t.Errorf("first stream frames = %q, want [1abc 1def \"\"]", got)
+	}
+	if !first.closed {
+		t.Error("first stream should be closed after migration")
+	}
+	if got := second.frames(t); len(got) != 1 || got[0] != "1ghi" {
+		t.Errorf("second stream frames = %q, want [1ghi]", got)
+	}
+}
+
+func TestWTTransportNoMigrationWhenDisabled(t *testing.T) {
+	first := newMockStream()
+	wtt, opened := newMockWTTransport(first, 0, newMockStream())
+
+	for i := 0; i < 100; i++ {
+		if _, err := wtt.Write([]byte("1data")); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if *opened != 0 {
+		t.Errorf("opened %d streams, want 0", *opened)
+	}
+}
+
+func TestWTTransportMigrationFailureKeepsStream(t *testing.T) {
+	first := newMockStream()
+	wtt, _ := newMockWTTransport(first, 5)
+
+	for _, msg := range []string{"1abc", "1def"} {
+		if _, err := wtt.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write(%q) error: %v", msg, err)
+		}
+	}
+	if got := first.frames(t); len(got) != 2 || got[1] != "1def" {
+		t.Errorf("first stream frames = %q, want [1abc 1def]", got)
+	}
+	if first.closed {
+		t.Error("first stream should stay open when migration fails")
+	}
+}
+
+func TestWTTransportReadFollowsMigration(t *testing.T) {
+	first := newMockStream("1abc")
+	second := newMockStream("1def")
+	wtt, _ := newMockWTTransport(first, 4, second)
+
+	wtt.Write([]byte("1out"))
+	wtt.Write([]byte("1out"))
+
+	buf := make([]byte, 16)
+	for _, want := range []string{"1abc", "1def"} {
+		n, err := wtt.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error: %v", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("Read() = %q, want %q", got, want)
+		}
+	}
+	if _, err := wtt.Read(buf); err != io.EOF {
+		t.Errorf("Read() after last stream error = %v, want io.EOF", err)
+	}
+}
+
+func TestWTTransportRTT(t *testing.T) {
+	wtt, _ := newMockWTTransport(newMockStream(), 0)
+	if _, ok := wtt.RTT(); ok {
+		t.Error("RTT() should not be available without QUIC stats")
+	}
+
+	wtt.smoothedRTT = func() time.Duration { return 25 * time.Millisecond }
+	if rtt, ok := wtt.RTT(); !ok || rtt != 25*time.Millisecond {
+		t.Errorf("RTT() = %s, %v, want 25ms", rtt, ok)
+	}
+}
+
+func TestWTTransportChecksumRoundTrip(t *testing.T) {
+	writer, _ := newMockWTTransport(newMockStream(), 0)
+	writer.checksum = true
+	for _, msg := range []string{"1hello", "2world"} {
+		if _, err := writer.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write(%q) error: %v", msg, err)
+		}
+	}
+
+	stream := &mockStream{in: bytes.NewReader(writer.stream.(*mockStream).out.Bytes())}
+	reader, _ := newMockWTTransport(stream, 0)
+	reader.checksum = true
+	buf := make([]byte, 64)
+	for _, want := range []string{"1hello", "2world"} {
+		n, err := reader.Read(buf)
+		if err != nil {
+			t.Fatalf("Read() error: %v", err)
+		}
+		if got := string(buf[:n]); got != want {
+			t.Errorf("Read() = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestWTTransportChecksumMismatch(t *testing.T) {
+	writer, _ := newMockWTTransport(newMockStream(), 0)
+	writer.checksum = true
+	if _, err := writer.Write([]byte("1hello")); err != nil {
+		t.Fatalf("Write() error: %v", err)
+	}
+
+	data := writer.stream.(*mockStream).out.Bytes()
+	if header := binary.BigEndian.Uint16(data); header&wtChecksumFlag == 0 {
+		t.Fatalf("frame header %#x does not carry the checksum flag", header)
+	}
+	data[3] ^= 0xff
+
+	reader, _ := newMockWTTransport(&mockStream{in: bytes.NewReader(data)}, 0)
+	reader.checksum = true
+	if _, err := reader.Read(make([]byte, 64)); err != errWTChecksum {
+		t.Errorf("Read() error = %v, want %v", err, errWTChecksum)
+	}
+}
+
+func TestWTTransportChecksumFrameLimit(t *testing.T) {
+	wtt, _ := newMockWTTransport(newMockStream(), 0)
+	wtt.checksum = true
+	if _, err := wtt.Write(make([]byte, wtChecksumFlag)); err == nil {
+		t.Error("Write() should reject frames that overlap the checksum flag")
+	}
+	if _, err := wtt.Write(make([]byte, wtChecksumFlag-1)); err != nil {
+		t.Errorf("Write() of largest checksummed frame error: %v", err)
+	}
+}
+
+func TestWTTransportWideFramesRoundTrip(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		for _, size := range []int{70000, 200000} {
+			msg := bytes.Repeat([]byte("0123456789"), size/10)
+			writer, _ := newMockWTTransport(newMockStream(), 0)
+			writer.wideFrames = true
+			writer.checksum = checksum
+			n, err := writer.Write(msg)
+			if err != nil || n != size {
+				t.Fatalf("checksum %t: Write() of %d bytes = %d, %v", checksum, size, n, err)
+			}
+
+			data := writer.stream.(*mockStream).out.Bytes()
+			if length := binary.BigEndian.Uint32(data) &^ wtWideChecksumFlag; length != uint32(size) {
+				t.Errorf("checksum %t: frame length = %d, want %d", checksum, length, size)
+			}
+
+			reader, _ := newMockWTTransport(&mockStream{in: bytes.NewReader(data)}, 0)
+			reader.wideFrames = true
+			reader.checksum = checksum
+			buf := make([]byte, size)
+			n, err = reader.Read(buf)
+			if err != nil {
+				t.Fatalf("checksum %t: Read() of %d bytes error: %v", checksum, size, err)
+			}
+			if !bytes.Equal(buf[:n], msg) {
+				t.Errorf("checksum %t: Read() returned %d bytes, want the %d bytes written", checksum, n, size)
+			}
+		}
+	}
+}
+
+func TestWTTransportNarrowFramesLimit(t *testing.T) {
+	wtt, _ := newMockWTTransport(newMockStream(), 0)
+	if _, err := wtt.Write(make([]byte, 70000)); err == nil {
+		t.Error("Write() should reject 70000 bytes without wide frames")
+	}
+}
+
+func TestWTTransportWideFramesMigration(t *testing.T) {
+	first, second := newMockStream(), newMockStream()
+	wtt, opened := newMockWTTransport(first, 12, second)
+	wtt.wideFrames = true
+
+	for _, msg := range []string{"1hello", "1world"} {
+		if _, err := wtt.Write([]byte(msg)); err != nil {
+			t.Fatalf("Write(%q) error: %v", msg, err)
+		}
+	}
+	if *opened != 1 {
+		t.Fatalf("opened %d streams, want 1", *opened)
+	}
+	want := []byte{0, 0, 0, 6, '1', 'h', 'e', 'l', 'l', 'o', 0, 0, 0, 0}
+	if got := first.out.Bytes(); !bytes.Equal(got, want) {
+		t.Errorf("first stream = %v, want a wide frame and a wide migration frame %v", got, want)
+	}
+}
+
+func TestWTTransportWritesWholeFrames(t *testing.T) {
+	for _, checksum := range []bool{false, true} {
+		stream := newMockStream()
+		wtt, _ := newMockWTTransport(stream, 0)
+		wtt.checksum = checksum
+
+		for _, msg := range []string{"1hello", "1world"} {
+			n, err := wtt.Write([]byte(msg))
+			if n != len(msg) || err != nil {
+				t.Fatalf("Write(%q) = %d, %v, want %d, nil", msg, n, err, len(msg))
+			}
+		}
+
+		if len(stream.writes) != 2 {
+			t.Fatalf("checksum %t: stream got %d writes, want one per frame", checksum, len(stream.writes))
+		}
+		for i, msg := range []string{"1hello", "1world"} {
+			write := stream.writes[i]
+			length := int(binary.BigEndian.Uint16(write) &^ wtChecksumFlag)
+			if length != len(msg) || string(write[2:2+length]) != msg {
+				t.Errorf("checksum %t: write %d = %q, want the header and payload of %q", checksum, i, write, msg)
+			}
+			wantLen := 2 + len(msg)
+			if checksum {
+				wantLen += 4
+			}
+			if len(write) != wantLen {
+				t.Errorf("checksum %t: write %d has %d bytes, want %d", checksum, i, len(write), wantLen)
+			}
+		}
+	}
+}
+
+func TestWTTransportConcurrentWritesKeepFrames(t *testing.T) {
+	stream := newMockStream()
+	wtt, _ := newMockWTTransport(stream, 0)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				wtt.Write([]byte(fmt.Sprintf("1writer %d message %d", i, j)))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	frames := stream.frames(t)
+	if len(frames) != 8*50 {
+		t.Fatalf("got %d frames, want %d", len(frames), 8*50)
+	}
+	for _, frame := range frames {
+		if !strings.HasPrefix(frame, "1writer ") {
+			t.Fatalf("corrupted frame %q", frame)
+		}
+	}
+}
+
+// shortStream accepts the first n bytes of each write, then fails.
+type shortStream struct {
+	mockStream
+	n int
+}
+
+func (s *shortStream) Write(p []byte) (int, error) {
+	if len(p) <= s.n {
+		return s.mockStream.Write(p)
+	}
+	s.mockStream.Write(p[:s.n])
+	return s.n, io.ErrShortWrite
+}
+
+func TestWTTransportPartialFrameWrite(t *testing.T) {
+	stream := &shortStream{n: 5}
+	wtt := &wtTransport{stream: stream}
+
+	n, err := wtt.Write([]byte("1hello"))
+	if n != 3 || err == nil {
+		t.Errorf("Write() = %d, %v, want the 3 payload bytes written and an error", n, err)
+	}
+	if wtt.streamBytes != 5 {
+		t.Errorf("streamBytes = %d, want 5", wtt.streamBytes)
+	}
+}
+
+// discardStream accepts writes and serves reads from a repeated frame
+type discardStream struct {
+	frame []byte
+	pos   int
+}
+
+func (s *discardStream) Write(p []byte) (int, error) { return len(p), nil }
+func (s *discardStream) Close() error                { return nil }
+func (s *discardStream) Read(p []byte) (int, error) {
+	n := copy(p, s.frame[s.pos:])
+	s.pos = (s.pos + n) % len(s.frame)
+	return n, nil
+}
+
+func BenchmarkWTTransportWrite(b *testing.B) {
+	wtt, _ := newMockWTTransport(nil, 0)
+	wtt.stream = &discardStream{}
+	data := []byte("benchmark test data")
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		wtt.Write(data)
+	}
+}
+
+func BenchmarkWTTransportRead(b *testing.B) {
+	frame := binary.BigEndian.AppendUint16(nil, uint16(len("1benchmark test input")))
+	stream := &discardStream{frame: append(frame, "1benchmark test input"...)}
+	wtt, _ := newMockWTTransport(nil, 0)
+	wtt.readers[0] = stream
+	buf := make([]byte, 1024)
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := wtt.Read(buf); err != nil {
+			b.Fatalf("Read() error: %v", err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package webtty
+
+import (
+	"bytes"
+	"time"
+)
+
+// coalesceOutput holds slave output back for up to coalesceWindow to send
+// it in fewer messages. Output ending up larger than a message, or
+// containing one of the flush patterns, is sent right away.
+func (wt *WebTTY) coalesceOutput(data []byte) error {
+	wt.coalesceMutex.Lock()
+	defer wt.coalesceMutex.Unlock()
+
+	if wt.coalesceErr != nil {
+		return wt.coalesceErr
+	}
+
+	chunkSize := int((wt.bufferSize-1)/4) * 3
+	if len(wt.coalesced)+len(data) > chunkSize {
+		if err := wt.flushCoalescedLocked(); err != nil {
+			return err
+		}
+	}
+
+	start := len(wt.coalesced)
+	wt.coalesced = append(wt.coalesced, data...)
+	if wt.hasFlushPattern(start) {
+		return wt.flushCoalescedLocked()
+	}
+
+	if wt.coalesceTimer == nil {
+		wt.coalesceTimer = time.AfterFunc(wt.coalesceWindow, func() {
+			wt.coalesceMutex.Lock()
+			defer wt.coalesceMutex.Unlock()
+			// Reported by the next output, if the master is still there
+			wt.coalesceErr = wt.flushCoalescedLocked()
+		})
+	}
+	return nil
+}
+
+// hasFlushPattern reports whether a flush pattern ends in the coalesced
+// output past start, including patterns split across slave reads.
+func (wt *WebTTY) hasFlushPattern(start int) bool {
+	for _, pattern := range wt.flushPatterns {
+		from := max(start-len(pattern)+1, 0)
+		if bytes.Contains(wt.coalesced[from:], pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// flushCoalesced sends any output held back by coalesceOutput.
+func (wt *WebTTY) flushCoalesced() error {
+	wt.coalesceMutex.Lock()
+	defer wt.coalesceMutex.Unlock()
+	return wt.flushCoalescedLocked()
+}
+
+func (wt *WebTTY) flushCoalescedLocked() error {
+	if wt.coalesceTimer != nil {
+		wt.coalesceTimer.Stop()
+		wt.coalesceTimer = nil
+	}
+	if len(wt.coalesced) == 0 {
+		return nil
+	}
+
+	data := wt.coalesced
+	wt.coalesced = nil
+	return wt.SendOutput(data)
+}
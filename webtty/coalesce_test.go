@@ -0,0 +1,91 @@
+package webtty
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// sentOutput decodes the Output messages the master received so far.
+func sentOutput(t *testing.T, wt *WebTTY, master *recordingMaster) []string {
+	wt.coalesceMutex.Lock()
+	defer wt.coalesceMutex.Unlock()
+
+	outputs := []string{}
+	for _, message := range master.messages {
+		if message[0] != Output {
+			t.Fatalf("Unexpected message type `%c`", message[0])
+		}
+		data, err := base64.StdEncoding.DecodeString(message[1:])
+		if err != nil {
+			t.Fatalf("Failed to decode output: %v", err)
+		}
+		outputs = append(outputs, string(data))
+	}
+	return outputs
+}
+
+func TestOutputCoalescingFlushPattern(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithOutputCoalescing(time.Hour, []byte("\a"), []byte("\x1b]133;A")))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	for _, data := range []string{"build", "ing..."} {
+		wt.handleSlaveReadEvent([]byte(data))
+	}
+	if got := sentOutput(t, wt, master); len(got) != 0 {
+		t.Fatalf("Sent %q, want output batched", got)
+	}
+
+	wt.handleSlaveReadEvent([]byte("done\a"))
+	if got := sentOutput(t, wt, master); len(got) != 1 || got[0] != "building...done\a" {
+		t.Fatalf("Sent %q, want the batch flushed on the bell", got)
+	}
+
+	// A prompt marker split across reads
+	wt.handleSlaveReadEvent([]byte("\r\n\x1b]13"))
+	wt.handleSlaveReadEvent([]byte("3;A\x07$ "))
+	if got := sentOutput(t, wt, master); len(got) != 2 || got[1] != "\r\n\x1b]133;A\x07$ " {
+		t.Fatalf("Sent %q, want the batch flushed on the prompt marker", got)
+	}
+}
+
+func TestOutputCoalescingWindow(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithOutputCoalescing(20*time.Millisecond, []byte("\a")))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	wt.handleSlaveReadEvent([]byte("a"))
+	wt.handleSlaveReadEvent([]byte("b"))
+	if got := sentOutput(t, wt, master); len(got) != 0 {
+		t.Fatalf("Sent %q, want output batched", got)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if got := sentOutput(t, wt, master); len(got) != 1 || got[0] != "ab" {
+		t.Fatalf("Sent %q, want one batch after the window", got)
+	}
+}
+
+func TestOutputCoalescingMessageSize(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithOutputCoalescing(time.Hour))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	wt.bufferSize = 13 // 9 bytes of output per message
+
+	for _, data := range []string{"abcd", "efgh", "ijkl"} {
+		wt.handleSlaveReadEvent([]byte(data))
+	}
+	wt.flushCoalesced()
+
+	got := sentOutput(t, wt, master)
+	if len(got) != 2 || got[0] != "abcdefgh" || got[1] != "ijkl" {
+		t.Errorf("Sent %q, want batches no larger than a message", got)
+	}
+}
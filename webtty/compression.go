@@ -0,0 +1,46 @@
+package webtty
+
+import (
+	"bytes"
+	"compress/flate"
+	"sync"
+)
+
+// OutputDictionary is the preset DEFLATE dictionary of CompressedOutput
+// messages, made of escape sequences common in terminal output. The most
+// frequent ones come last, where back references are shortest.
+var OutputDictionary = []byte("" +
+	"\x1b[?1049h\x1b[?1049l\x1b[?2004h\x1b[?2004l\x1b[?1h\x1b=\x1b[?1l\x1b>\x1b]0;\x07\x1b(B" +
+	"\x1b[38;2;\x1b[48;2;\x1b[38;5;\x1b[48;5;\x1b[39m\x1b[49m\x1b[39;49m" +
+	"\x1b[90m\x1b[91m\x1b[92m\x1b[93m\x1b[94m\x1b[95m\x1b[96m\x1b[97m" +
+	"\x1b[40m\x1b[41m\x1b[42m\x1b[43m\x1b[44m\x1b[45m\x1b[46m\x1b[47m" +
+	"\x1b[30m\x1b[31m\x1b[32m\x1b[33m\x1b[34m\x1b[35m\x1b[36m\x1b[37m" +
+	"\x1b[1;31m\x1b[1;32m\x1b[1;34m\x1b[01;34m\x1b[01;32m\x1b[7m\x1b[4m\x1b[22m\x1b[2m\x1b[1m" +
+	"\x1b[?25l\x1b[?25h\x1b[2J\x1b[H\x1b[J\x1b[2K\x1b[K\x1b[C\x1b[A\x1b[m\x1b[0m\r\n")
+
+// outputCompressor compresses output with OutputDictionary, reusing one
+// flate writer.
+type outputCompressor struct {
+	mu     sync.Mutex
+	buf    bytes.Buffer
+	writer *flate.Writer
+}
+
+func newOutputCompressor() *outputCompressor {
+	// Lower levels skip the dictionary for short writes, and output
+	// arrives in short writes
+	writer, _ := flate.NewWriterDict(nil, flate.BestCompression, OutputDictionary)
+	return &outputCompressor{writer: writer}
+}
+
+// compress returns data as a complete raw DEFLATE stream.
+func (oc *outputCompressor) compress(data []byte) []byte {
+	oc.mu.Lock()
+	defer oc.mu.Unlock()
+
+	oc.buf.Reset()
+	oc.writer.Reset(&oc.buf)
+	oc.writer.Write(data)
+	oc.writer.Close()
+	return append([]byte(nil), oc.buf.Bytes()...)
+}
@@ -0,0 +1,87 @@
+package webtty
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"io"
+	"testing"
+)
+
+// ansiOutput is a short burst of colored prompt output, typical of what a
+// shell writes at a time.
+var ansiOutput = []byte("\x1b[0m\x1b[01;34mbin\x1b[0m  \x1b[01;32mrun.sh\x1b[0m  docs\r\n" +
+	"\x1b[1;32muser@host\x1b[0m:\x1b[1;34m~\x1b[0m$ \x1b[K\x1b[?25h")
+
+func TestOutputDictionaryShrinksOutput(t *testing.T) {
+	var plain bytes.Buffer
+	writer, _ := flate.NewWriter(&plain, flate.BestCompression)
+	writer.Write(ansiOutput)
+	writer.Close()
+
+	withDict := newOutputCompressor().compress(ansiOutput)
+
+	if len(withDict) >= plain.Len() {
+		t.Errorf("Compressed size with dictionary = %d, without = %d, want smaller", len(withDict), plain.Len())
+	}
+	if len(withDict) >= len(ansiOutput) {
+		t.Errorf("Compressed size with dictionary = %d, want less than %d", len(withDict), len(ansiOutput))
+	}
+}
+
+func TestOutputCompressorReuse(t *testing.T) {
+	compressor := newOutputCompressor()
+	for _, data := range [][]byte{ansiOutput, []byte("hello\r\n"), ansiOutput} {
+		reader := flate.NewReaderDict(bytes.NewReader(compressor.compress(data)), OutputDictionary)
+		got, err := io.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("Failed to inflate: %v", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("Inflated = %q, want %q", got, data)
+		}
+	}
+}
+
+// The browser inflates without dictionary support, prefixing the
+// dictionary as a stored block.
+func TestOutputDictionaryAsStoredBlock(t *testing.T) {
+	n := len(OutputDictionary)
+	input := []byte{0x00, byte(n), byte(n >> 8), ^byte(n), ^byte(n >> 8)}
+	input = append(input, OutputDictionary...)
+	input = append(input, newOutputCompressor().compress(ansiOutput)...)
+
+	got, err := io.ReadAll(flate.NewReader(bytes.NewReader(input)))
+	if err != nil {
+		t.Fatalf("Failed to inflate: %v", err)
+	}
+	if !bytes.Equal(got[n:], ansiOutput) {
+		t.Errorf("Inflated = %q, want %q", got[n:], ansiOutput)
+	}
+}
+
+func TestSendOutputCompressed(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithCompressedOutput())
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	if err := wt.SendOutput(ansiOutput); err != nil {
+		t.Fatalf("SendOutput() error: %v", err)
+	}
+	// Too short to get any smaller
+	if err := wt.SendOutput([]byte("a")); err != nil {
+		t.Fatalf("SendOutput() error: %v", err)
+	}
+
+	if len(master.messages) != 2 {
+		t.Fatalf("Sent %d messages, want 2", len(master.messages))
+	}
+	if master.messages[0][0] != CompressedOutput {
+		t.Errorf("First message type = %q, want %q", master.messages[0][0], CompressedOutput)
+	}
+	if master.messages[1] != string(Output)+base64.StdEncoding.EncodeToString([]byte("a")) {
+		t.Errorf("Second message = %q, want uncompressed output", master.messages[1])
+	}
+}
@@ -11,3 +11,17 @@ var (
 	// ErrSlaveClosed is returned when the slave connection is closed.
 	ErrMasterClosed = errors.New("master closed")
 )
+
+// MasterWriteError is returned when writing to the master fails,
+// typically because the client stopped reading.
+type MasterWriteError struct {
+	Err error
+}
+
+func (e *MasterWriteError) Error() string {
+	return "failed to write to master: " + e.Err.Error()
+}
+
+func (e *MasterWriteError) Unwrap() error {
+	return e.Err
+}
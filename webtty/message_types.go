@@ -43,6 +43,11 @@ const (
 	TmuxSessionInfo = 'A'
 	// Tmux error
 	TmuxError = 'B'
+
+	// The slave is attached and output follows (JSON payload)
+	ConnectionReady = 'C'
+	// Output compressed with raw DEFLATE and OutputDictionary (base64)
+	CompressedOutput = 'D'
 )
 
 // Tmux input message types (client -> server)
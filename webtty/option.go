@@ -2,6 +2,7 @@ package webtty
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -33,6 +34,24 @@ func WithFixedRows(rows int) Option {
 	}
 }
 
+// WithResizePresets snaps sizes requested by the master to the nearest
+// of the given presets.
+func WithResizePresets(presets ...TerminalSize) Option {
+	return func(wt *WebTTY) error {
+		wt.resizePresets = presets
+		return nil
+	}
+}
+
+// WithMaxResizeArea caps the area (columns * rows) of sizes requested by
+// the master, scaling both dimensions down proportionally.
+func WithMaxResizeArea(area int) Option {
+	return func(wt *WebTTY) error {
+		wt.maxResizeArea = area
+		return nil
+	}
+}
+
 // WithWindowTitle sets the default window title of the session
 func WithWindowTitle(windowTitle []byte) Option {
 	return func(wt *WebTTY) error {
@@ -49,6 +68,70 @@ func WithReconnect(timeInSeconds int) Option {
 	}
 }
 
+// WithTrimPartialSequences holds back escape sequences split across reads
+// from the slave until they are complete, and drops an incomplete trailing
+// sequence when the slave closes.
+func WithTrimPartialSequences() Option {
+	return func(wt *WebTTY) error {
+		wt.trimPartial = true
+		return nil
+	}
+}
+
+// WithClearScreen clears the master's screen before any output is sent.
+func WithClearScreen() Option {
+	return func(wt *WebTTY) error {
+		wt.clearScreen = true
+		return nil
+	}
+}
+
+// WithClientIP includes the client's address, as resolved by the server,
+// in the ready message.
+func WithClientIP(ip string) Option {
+	return func(wt *WebTTY) error {
+		wt.clientIP = ip
+		return nil
+	}
+}
+
+// WithCompressedOutput sends output as CompressedOutput messages whenever
+// compressing it with OutputDictionary makes it smaller.
+func WithCompressedOutput() Option {
+	return func(wt *WebTTY) error {
+		wt.compressor = newOutputCompressor()
+		return nil
+	}
+}
+
+// WithOutputCoalescing batches slave output for up to window before sending
+// it to the master. Output containing any of flushPatterns, such as a bell
+// or a shell prompt, is sent right away with what was batched before it.
+func WithOutputCoalescing(window time.Duration, flushPatterns ...[]byte) Option {
+	return func(wt *WebTTY) error {
+		wt.coalesceWindow = window
+		wt.flushPatterns = flushPatterns
+		return nil
+	}
+}
+
+// WithReplay sends output from a previous session to the master right
+// after the initialization messages.
+func WithReplay(output []byte) Option {
+	return func(wt *WebTTY) error {
+		wt.replay = output
+		return nil
+	}
+}
+
+// WithTitleInterval limits window title updates to at most one per interval.
+func WithTitleInterval(interval time.Duration) Option {
+	return func(wt *WebTTY) error {
+		wt.titleInterval = interval
+		return nil
+	}
+}
+
 // WithMasterPreferences sets an optional configuration of master.
 func WithMasterPreferences(preferences interface{}) Option {
 	return func(wt *WebTTY) error {
@@ -0,0 +1,89 @@
+package webtty
+
+import (
+	"encoding/json"
+	"math"
+
+	"github.com/pkg/errors"
+)
+
+// maxTerminalDimension is the largest number of columns or rows a pty
+// can be given.
+const maxTerminalDimension = math.MaxUint16
+
+// TerminalSize is a terminal dimension in columns and rows.
+type TerminalSize struct {
+	Columns int
+	Rows    int
+}
+
+type argResizeTerminal struct {
+	Columns float64
+	Rows    float64
+}
+
+// parseResize decodes the payload of a ResizeTerminal message. Both
+// dimensions must be whole numbers between 1 and maxTerminalDimension.
+func parseResize(payload []byte) (columns int, rows int, err error) {
+	if len(payload) == 0 {
+		return 0, 0, errors.New("empty payload")
+	}
+
+	var args argResizeTerminal
+	if err := json.Unmarshal(payload, &args); err != nil {
+		return 0, 0, errors.Wrapf(err, "malformed payload")
+	}
+
+	if columns, err = terminalDimension("columns", args.Columns); err != nil {
+		return 0, 0, err
+	}
+	if rows, err = terminalDimension("rows", args.Rows); err != nil {
+		return 0, 0, err
+	}
+	return columns, rows, nil
+}
+
+func terminalDimension(name string, value float64) (int, error) {
+	if value != math.Trunc(value) || value < 1 || value > maxTerminalDimension {
+		return 0, errors.Errorf("invalid %s: %v", name, value)
+	}
+	return int(value), nil
+}
+
+// constrainSize applies the configured resize presets and maximum area
+// to a size requested by the master.
+func (wt *WebTTY) constrainSize(columns int, rows int) (int, int) {
+	if len(wt.resizePresets) > 0 {
+		columns, rows = nearestPreset(wt.resizePresets, columns, rows)
+	}
+
+	if wt.maxResizeArea > 0 && columns*rows > wt.maxResizeArea {
+		scale := math.Sqrt(float64(wt.maxResizeArea) / float64(columns*rows))
+		columns = int(float64(columns) * scale)
+		rows = int(float64(rows) * scale)
+		if columns < 1 {
+			columns = 1
+		}
+		if rows < 1 {
+			rows = 1
+		}
+	}
+
+	return columns, rows
+}
+
+// nearestPreset returns the preset closest to the given size.
+func nearestPreset(presets []TerminalSize, columns int, rows int) (int, int) {
+	best := presets[0]
+	bestDistance := -1
+	for _, preset := range presets {
+		dc := preset.Columns - columns
+		dr := preset.Rows - rows
+		distance := dc*dc + dr*dr
+		if bestDistance < 0 || distance < bestDistance {
+			best = preset
+			bestDistance = distance
+		}
+	}
+	return best.Columns, best.Rows
+}
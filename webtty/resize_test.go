@@ -0,0 +1,125 @@
+package webtty
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestConstrainSize(t *testing.T) {
+	presets := []TerminalSize{{80, 24}, {120, 40}}
+
+	tests := []struct {
+		name                  string
+		presets               []TerminalSize
+		maxArea               int
+		columns, rows         int
+		wantColumns, wantRows int
+	}{
+		{"unconstrained", nil, 0, 97, 31, 97, 31},
+		{"snap to small preset", presets, 0, 85, 26, 80, 24},
+		{"snap to large preset", presets, 0, 113, 37, 120, 40},
+		{"exact preset", presets, 0, 120, 40, 120, 40},
+		{"within area", nil, 10000, 100, 50, 100, 50},
+		{"over area", nil, 2000, 200, 100, 63, 31},
+		{"preset over area", presets, 2000, 120, 40, 77, 25},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wt := &WebTTY{resizePresets: tt.presets, maxResizeArea: tt.maxArea}
+			columns, rows := wt.constrainSize(tt.columns, tt.rows)
+			if columns != tt.wantColumns || rows != tt.wantRows {
+				t.Errorf("constrainSize(%d, %d) = %dx%d, want %dx%d",
+					tt.columns, tt.rows, columns, rows, tt.wantColumns, tt.wantRows)
+			}
+			if tt.maxArea > 0 && columns*rows > tt.maxArea {
+				t.Errorf("constrainSize(%d, %d) area %d exceeds %d", tt.columns, tt.rows, columns*rows, tt.maxArea)
+			}
+		})
+	}
+}
+
+func TestResizeTerminalSnapsToPreset(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster, mSlave, _, cancel := prepareSUT(t, &wg, WithResizePresets(TerminalSize{80, 24}, TerminalSize{120, 40}))
+	defer cancel()
+
+	// Absorb initialization messages
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	mSlave.wg.Add(1)
+	mMaster.masterToGottyWriter.Write([]byte(`3{"Columns": 83, "Rows": 27}`))
+	mSlave.wg.Wait()
+
+	if mSlave.columns != 80 || mSlave.rows != 24 {
+		t.Fatalf("Size not snapped to preset. Expected 80x24, got %dx%d", mSlave.columns, mSlave.rows)
+	}
+
+	cancel()
+	wg.Wait()
+}
+
+func TestParseResize(t *testing.T) {
+	tests := []struct {
+		payload string
+		valid   bool
+	}{
+		{`{"Columns": 80, "Rows": 24}`, true},
+		{`{"Columns": 80, "Rows": 24, "Extra": true}`, true},
+		{``, false},
+		{`{"Columns": 80`, false},
+		{`{"Columns": "80", "Rows": "24"}`, false},
+		{`{"Columns": 80}`, false},
+		{`{"Columns": 80.5, "Rows": 24}`, false},
+		{`{"Columns": -80, "Rows": 24}`, false},
+		{`{"Columns": 80, "Rows": 1e20}`, false},
+		{`[80, 24]`, false},
+	}
+
+	for _, tt := range tests {
+		columns, rows, err := parseResize([]byte(tt.payload))
+		if tt.valid && (err != nil || columns != 80 || rows != 24) {
+			t.Errorf("parseResize(%q) = %d, %d, %v, want 80, 24", tt.payload, columns, rows, err)
+		}
+		if !tt.valid && err == nil {
+			t.Errorf("parseResize(%q) = %d, %d, want an error", tt.payload, columns, rows)
+		}
+	}
+}
+
+func TestMalformedResizeIgnored(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster, mSlave, _, cancel := prepareSUT(t, &wg)
+	defer cancel()
+
+	// Absorb initialization messages
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	// The mock slave panics on a resize it does not expect
+	for _, message := range []string{`3`, `3{"Columns": "abc", "Rows": 24}`, `3{"Columns": 0, "Rows": 0}`, `3not json`} {
+		mMaster.masterToGottyWriter.Write([]byte(message))
+	}
+
+	// The connection survives
+	mMaster.masterToGottyWriter.Write([]byte{Ping})
+	checkNextMsgType(t, mMaster.gottyToMasterReader, Pong)
+
+	mSlave.wg.Add(1)
+	mMaster.masterToGottyWriter.Write([]byte(`3{"Columns": 100, "Rows": 30}`))
+	mSlave.wg.Wait()
+
+	if mSlave.columns != 100 || mSlave.rows != 30 {
+		t.Fatalf("Expected 100x30 after malformed resizes, got %dx%d", mSlave.columns, mSlave.rows)
+	}
+
+	cancel()
+	wg.Wait()
+}
@@ -0,0 +1,83 @@
+package webtty
+
+// maxPendingSequence is the longest incomplete escape sequence held back
+// from the master. Longer sequences are sent as-is to avoid stalling output.
+const maxPendingSequence = 256
+
+// incompleteSequenceStart returns the index where an unterminated escape
+// sequence at the end of data begins, or len(data) if data ends on a
+// sequence boundary.
+func incompleteSequenceStart(data []byte) int {
+	for i := 0; i < len(data); i++ {
+		if data[i] != 0x1b {
+			continue
+		}
+		end := sequenceEnd(data, i)
+		if end < 0 {
+			return i
+		}
+		i = end - 1
+	}
+	return len(data)
+}
+
+// sequenceEnd returns the index just after the escape sequence starting at
+// data[start], or -1 if the sequence is not terminated within data.
+func sequenceEnd(data []byte, start int) int {
+	if start+1 >= len(data) {
+		return -1
+	}
+
+	switch data[start+1] {
+	case '[': // CSI: parameters and intermediates, then a final byte
+		for i := start + 2; i < len(data); i++ {
+			if data[i] >= 0x40 && data[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return -1
+
+	case ']', 'P', 'X', '^', '_': // OSC, DCS, SOS, PM, APC: terminated by ST (or BEL for OSC)
+		for i := start + 2; i < len(data); i++ {
+			if data[i] == 0x07 && data[start+1] == ']' {
+				return i + 1
+			}
+			if data[i] == 0x1b {
+				if i+1 >= len(data) {
+					return -1
+				}
+				if data[i+1] == '\\' {
+					return i + 2
+				}
+			}
+		}
+		return -1
+
+	default: // intermediates followed by a final byte, e.g. ESC ( B
+		i := start + 1
+		for i < len(data) && data[i] >= 0x20 && data[i] <= 0x2f {
+			i++
+		}
+		if i >= len(data) {
+			return -1
+		}
+		return i + 1
+	}
+}
+
+// splitPendingOutput prepends previously held back output to data and
+// splits off a trailing incomplete escape sequence to be held back until
+// the next read.
+func (wt *WebTTY) splitPendingOutput(data []byte) []byte {
+	if len(wt.pendingOutput) > 0 {
+		data = append(wt.pendingOutput, data...)
+	}
+
+	cut := incompleteSequenceStart(data)
+	if len(data)-cut > maxPendingSequence {
+		cut = len(data)
+	}
+
+	wt.pendingOutput = append([]byte(nil), data[cut:]...)
+	return data[:cut]
+}
@@ -0,0 +1,113 @@
+package webtty
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"testing"
+)
+
+func TestIncompleteSequenceStart(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want int
+	}{
+		{"plain text", "hello", 5},
+		{"complete CSI", "a\x1b[31mb", 7},
+		{"lone escape", "hello\x1b", 5},
+		{"partial CSI", "hello\x1b[3", 5},
+		{"complete OSC with BEL", "\x1b]0;title\x07", 10},
+		{"complete OSC with ST", "\x1b]0;title\x1b\\", 11},
+		{"partial OSC", "ab\x1b]0;tit", 2},
+		{"partial OSC terminator", "ab\x1b]0;title\x1b", 2},
+		{"charset designation", "\x1b(B", 3},
+		{"partial charset designation", "x\x1b(", 1},
+		{"partial after complete", "\x1b[0m\x1b[1", 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := incompleteSequenceStart([]byte(tt.data))
+			if got != tt.want {
+				t.Errorf("incompleteSequenceStart(%q) = %d, want %d", tt.data, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitPendingOutput(t *testing.T) {
+	wt := &WebTTY{trimPartial: true}
+
+	out := wt.splitPendingOutput([]byte("red: \x1b[3"))
+	if string(out) != "red: " {
+		t.Errorf("first chunk = %q, want %q", out, "red: ")
+	}
+
+	out = wt.splitPendingOutput([]byte("1mtext"))
+	if string(out) != "\x1b[31mtext" {
+		t.Errorf("second chunk = %q, want %q", out, "\x1b[31mtext")
+	}
+	if len(wt.pendingOutput) != 0 {
+		t.Errorf("pendingOutput = %q, want empty", wt.pendingOutput)
+	}
+}
+
+func TestSplitPendingOutputLimit(t *testing.T) {
+	wt := &WebTTY{trimPartial: true}
+
+	data := append([]byte("\x1b]0;"), bytes.Repeat([]byte("x"), maxPendingSequence)...)
+	out := wt.splitPendingOutput(data)
+	if !bytes.Equal(out, data) {
+		t.Errorf("overlong sequence should be sent as-is, got %d bytes", len(out))
+	}
+}
+
+func TestTrimPartialSequenceOnDisconnect(t *testing.T) {
+	mMaster := newMockMaster()
+	mSlave := newMockSlave()
+
+	dt, err := New(mMaster, mSlave, WithTrimPartialSequences())
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- dt.Run(context.Background())
+	}()
+
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	mSlave.slaveToGottyWriter.Write([]byte("hello\x1b[3"))
+
+	msgType, payload := nextMsg(t, mMaster.gottyToMasterReader)
+	if msgType != Output {
+		t.Fatalf("Unexpected message type `%c`", msgType)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimRight(payload, "\x00")))
+	if err != nil {
+		t.Fatalf("Unexpected error from Decode(): %s", err)
+	}
+	if string(decoded) != "hello" {
+		t.Fatalf("Unexpected output `%q`, partial sequence should be held back", decoded)
+	}
+
+	// Disconnect with the partial sequence still pending
+	mSlave.slaveToGottyWriter.Close()
+	if err := <-done; err != ErrSlaveClosed {
+		t.Fatalf("Run() = %v, want %v", err, ErrSlaveClosed)
+	}
+	if len(dt.pendingOutput) != 0 {
+		t.Errorf("pending partial sequence should be dropped, got %q", dt.pendingOutput)
+	}
+
+	// Nothing else must have been delivered to the master
+	mMaster.gottyToMasterWriter.Close()
+	buf := make([]byte, 1024)
+	if n, err := mMaster.gottyToMasterReader.Read(buf); err == nil {
+		t.Errorf("Unexpected message after disconnect: %q", buf[:n])
+	}
+}
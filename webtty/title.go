@@ -0,0 +1,34 @@
+package webtty
+
+import (
+	"bytes"
+
+	"github.com/pkg/errors"
+)
+
+// UpdateWindowTitle sends title to the master when it differs from the
+// current one and the title interval has passed since the last title was sent.
+// It reports whether the title was sent; skipped updates are not queued,
+// so callers polling for changes simply retry on their next poll.
+func (wt *WebTTY) UpdateWindowTitle(title []byte) (bool, error) {
+	wt.titleMutex.Lock()
+	defer wt.titleMutex.Unlock()
+
+	if bytes.Equal(title, wt.windowTitle) {
+		return false, nil
+	}
+
+	now := wt.now()
+	if wt.titleInterval > 0 && !wt.titleSentAt.IsZero() && now.Sub(wt.titleSentAt) < wt.titleInterval {
+		return false, nil
+	}
+
+	err := wt.masterWrite(append([]byte{SetWindowTitle}, title...))
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to send window title")
+	}
+	wt.windowTitle = append([]byte(nil), title...)
+	wt.titleSentAt = now
+
+	return true, nil
+}
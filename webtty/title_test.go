@@ -0,0 +1,66 @@
+package webtty
+
+import (
+	"testing"
+	"time"
+)
+
+// recordingMaster records every message written to it.
+type recordingMaster struct {
+	messages []string
+}
+
+func (m *recordingMaster) Read(p []byte) (int, error) { select {} }
+
+func (m *recordingMaster) Write(p []byte) (int, error) {
+	m.messages = append(m.messages, string(p))
+	return len(p), nil
+}
+
+func TestUpdateWindowTitleRateLimited(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithWindowTitle([]byte("a")), WithTitleInterval(time.Second))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	clock := time.Unix(0, 0)
+	wt.now = func() time.Time { return clock }
+
+	// Flapping titles, one update every 300ms
+	updates := []string{"a", "b", "a", "b", "c", "c", "c", "a"}
+	for _, title := range updates {
+		clock = clock.Add(300 * time.Millisecond)
+		if _, err := wt.UpdateWindowTitle([]byte(title)); err != nil {
+			t.Fatalf("UpdateWindowTitle(%q) error: %v", title, err)
+		}
+	}
+
+	// "b" is sent at 600ms, "c" at 1.8s; the changes at 900ms, 1.5s
+	// and 2.4s come too soon after the previous title and are dropped
+	want := []string{"3b", "3c"}
+	if len(master.messages) != len(want) {
+		t.Fatalf("Sent titles = %q, want %q", master.messages, want)
+	}
+	for i := range want {
+		if master.messages[i] != want[i] {
+			t.Errorf("Sent titles = %q, want %q", master.messages, want)
+		}
+	}
+}
+
+func TestUpdateWindowTitleOnlyOnChange(t *testing.T) {
+	master := &recordingMaster{}
+	wt, err := New(master, newMockSlave(), WithWindowTitle([]byte("a")))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	for _, title := range []string{"a", "a", "b", "b", "a"} {
+		wt.UpdateWindowTitle([]byte(title))
+	}
+
+	if len(master.messages) != 2 || master.messages[0] != "3b" || master.messages[1] != "3a" {
+		t.Errorf("Sent titles = %q, want [3b 3a]", master.messages)
+	}
+}
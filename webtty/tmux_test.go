@@ -0,0 +1,76 @@
+package webtty
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+
+	"webtmux/pkg/tmux"
+)
+
+// fakeTmuxController serves a fixed layout; other operations are unused.
+type fakeTmuxController struct {
+	TmuxController
+	layout *tmux.Layout
+}
+
+func (c *fakeTmuxController) GetLayout() *tmux.Layout { return c.layout }
+func (c *fakeTmuxController) RefreshLayout() error    { return nil }
+
+func TestInitializationSendsTmuxLayout(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	layout := &tmux.Layout{
+		SessionID:   "$1",
+		SessionName: "main",
+		Windows: []tmux.Window{{
+			ID:     "@1",
+			Active: true,
+			Panes: []tmux.Pane{
+				{ID: "%1", Active: true, Width: 80, Height: 23},
+				{ID: "%2", Width: 39, Height: 23, Left: 81},
+			},
+		}},
+		ActiveWinID:  "@1",
+		ActivePaneID: "%1",
+	}
+
+	mMaster := newMockMaster()
+	dt, err := New(mMaster, newMockSlave())
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+	dt.SetTmuxController(&fakeTmuxController{layout: layout})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dt.Run(ctx)
+	}()
+
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	buf := make([]byte, 1024)
+	n, err := mMaster.gottyToMasterReader.Read(buf)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if buf[0] != TmuxLayoutUpdate {
+		t.Fatalf("Unexpected message type `%c`", buf[0])
+	}
+
+	var got tmux.Layout
+	if err := json.Unmarshal(buf[1:n], &got); err != nil {
+		t.Fatalf("failed to decode layout: %v", err)
+	}
+	if !reflect.DeepEqual(&got, layout) {
+		t.Errorf("layout = %+v, want %+v", got, *layout)
+	}
+}
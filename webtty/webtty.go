@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -19,21 +20,54 @@ type WebTTY struct {
 	// PTY Slave
 	slave Slave
 
-	windowTitle []byte
+	windowTitle   []byte
+	titleInterval time.Duration
+	titleSentAt   time.Time
+	titleMutex    sync.Mutex
+	now           func() time.Time
+
 	permitWrite bool
 	columns     int
 	rows        int
 	reconnect   int // in seconds
 	masterPrefs []byte
+	replay      []byte
+	clearScreen bool
+	clientIP    string
 	decoder     Decoder
 
+	resizePresets []TerminalSize
+	maxResizeArea int
+
 	bufferSize int
 	writeMutex sync.Mutex
+	compressor *outputCompressor
+
+	// Batch slave output for coalesceWindow unless it has a flush pattern
+	coalesceWindow time.Duration
+	flushPatterns  [][]byte
+	coalesceMutex  sync.Mutex
+	coalesced      []byte
+	coalesceTimer  *time.Timer
+	coalesceErr    error
+
+	// Hold back incomplete escape sequences at the end of slave output
+	trimPartial   bool
+	pendingOutput []byte
 
 	// Tmux controller for tmux-specific operations
 	tmuxCtrl TmuxController
 }
 
+// clearScreenSequence moves the cursor home and erases the display.
+var clearScreenSequence = []byte("\x1b[H\x1b[2J")
+
+// readyMessage is the payload of ConnectionReady.
+type readyMessage struct {
+	Type     string `json:"type"`
+	ClientIP string `json:"clientIP,omitempty"`
+}
+
 // New creates a new instance of WebTTY.
 // masterConn is a connection to the PTY master,
 // typically it's a websocket connection to a client.
@@ -49,6 +83,7 @@ func New(masterConn Master, slave Slave, options ...Option) (*WebTTY, error) {
 
 		bufferSize: 1024,
 		decoder:    &NullCodec{},
+		now:        time.Now,
 	}
 
 	for _, option := range options {
@@ -83,6 +118,12 @@ func (wt *WebTTY) Run(ctx context.Context) error {
 
 				n, err := wt.slave.Read(buffer[:maxChunkSize])
 				if err != nil {
+					// Drop any incomplete trailing sequence
+					wt.pendingOutput = nil
+					// but deliver the last words of the slave
+					if err := wt.flushCoalesced(); err != nil {
+						return err
+					}
 					return ErrSlaveClosed
 				}
 
@@ -121,7 +162,10 @@ func (wt *WebTTY) Run(ctx context.Context) error {
 }
 
 func (wt *WebTTY) sendInitializeMessage() error {
+	wt.titleMutex.Lock()
 	err := wt.masterWrite(append([]byte{SetWindowTitle}, wt.windowTitle...))
+	wt.titleSentAt = wt.now()
+	wt.titleMutex.Unlock()
 	if err != nil {
 		return errors.Wrapf(err, "failed to send window title")
 	}
@@ -147,6 +191,36 @@ func (wt *WebTTY) sendInitializeMessage() error {
 		}
 	}
 
+	// Fixed sizes are never requested by the master, so apply them now
+	if wt.columns != 0 && wt.rows != 0 {
+		wt.slave.ResizeTerminal(wt.columns, wt.rows)
+	}
+
+	ready, err := json.Marshal(readyMessage{Type: "ready", ClientIP: wt.clientIP})
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal ready message")
+	}
+	err = wt.masterWrite(append([]byte{ConnectionReady}, ready...))
+	if err != nil {
+		return errors.Wrapf(err, "failed to send ready message")
+	}
+
+	if wt.clearScreen {
+		if err := wt.SendOutput(clearScreenSequence); err != nil {
+			return errors.Wrapf(err, "failed to clear screen")
+		}
+	}
+
+	// Replay in chunks no larger than regular slave output
+	chunkSize := int((wt.bufferSize-1)/4) * 3
+	for replay := wt.replay; len(replay) > 0; {
+		n := min(len(replay), chunkSize)
+		if err := wt.SendOutput(replay[:n]); err != nil {
+			return errors.Wrapf(err, "failed to replay output")
+		}
+		replay = replay[n:]
+	}
+
 	// Send initial tmux layout if available
 	if wt.tmuxCtrl != nil {
 		wt.tmuxCtrl.RefreshLayout()
@@ -159,8 +233,32 @@ func (wt *WebTTY) sendInitializeMessage() error {
 }
 
 func (wt *WebTTY) handleSlaveReadEvent(data []byte) error {
+	if wt.trimPartial {
+		data = wt.splitPendingOutput(data)
+		if len(data) == 0 {
+			return nil
+		}
+	}
+
+	if wt.coalesceWindow > 0 {
+		return wt.coalesceOutput(data)
+	}
+	return wt.SendOutput(data)
+}
+
+// SendOutput sends data to the master as terminal output.
+// It can still be used after Run returned as long as the master is open.
+func (wt *WebTTY) SendOutput(data []byte) error {
+	msgType := byte(Output)
+	if wt.compressor != nil {
+		// Short output may not get any smaller
+		if compressed := wt.compressor.compress(data); len(compressed) < len(data) {
+			msgType, data = CompressedOutput, compressed
+		}
+	}
+
 	safeMessage := base64.StdEncoding.EncodeToString(data)
-	err := wt.masterWrite(append([]byte{Output}, []byte(safeMessage)...))
+	err := wt.masterWrite(append([]byte{msgType}, []byte(safeMessage)...))
 	if err != nil {
 		return errors.Wrapf(err, "failed to send message to master")
 	}
@@ -174,7 +272,7 @@ func (wt *WebTTY) masterWrite(data []byte) error {
 
 	_, err := wt.masterConn.Write(data)
 	if err != nil {
-		return errors.Wrapf(err, "failed to write to master")
+		return &MasterWriteError{Err: err}
 	}
 
 	return nil
@@ -234,25 +332,20 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 			break
 		}
 
-		if len(data) <= 1 {
-			return errors.New("received malformed remote command for terminal resize: empty payload")
-		}
-
-		var args argResizeTerminal
-		err := json.Unmarshal(data[1:], &args)
+		columns, rows, err := parseResize(data[1:])
 		if err != nil {
-			return errors.Wrapf(err, "received malformed data for terminal resize")
+			// A bad resize is not worth dropping the connection over
+			log.Printf("Ignoring malformed terminal resize: %v", err)
+			break
 		}
-		rows := wt.rows
-		if rows == 0 {
-			rows = int(args.Rows)
+		if wt.rows != 0 {
+			rows = wt.rows
 		}
-
-		columns := wt.columns
-		if columns == 0 {
-			columns = int(args.Columns)
+		if wt.columns != 0 {
+			columns = wt.columns
 		}
 
+		columns, rows = wt.constrainSize(columns, rows)
 		wt.slave.ResizeTerminal(columns, rows)
 
 	default:
@@ -265,8 +358,3 @@ func (wt *WebTTY) handleMasterReadEvent(data []byte) error {
 
 	return nil
 }
-
-type argResizeTerminal struct {
-	Columns float64
-	Rows    float64
-}
@@ -19,6 +19,7 @@ func TestInitialization(t *testing.T) {
 	// Check that the initialization happens as expected
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 }
 
 func TestInitializationWithPreferences(t *testing.T) {
@@ -32,6 +33,7 @@ func TestInitializationWithPreferences(t *testing.T) {
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetPreferences)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 }
 
 func TestInitializationWithReconnect(t *testing.T) {
@@ -45,6 +47,102 @@ func TestInitializationWithReconnect(t *testing.T) {
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetReconnect)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+}
+
+func TestInitializationWithReplay(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	replay := bytes.Repeat([]byte("0123456789"), 100)
+	mMaster, _, _, cancel := prepareSUT(t, &wg, WithReplay(replay))
+	defer cancel()
+
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	// The replay is split into chunks that fit the default buffer size
+	var replayed []byte
+	for len(replayed) < len(replay) {
+		msgType, payload := nextMsg(t, mMaster.gottyToMasterReader)
+		if msgType != Output {
+			t.Fatalf("Unexpected message type `%c`", msgType)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimRight(payload, "\x00")))
+		if err != nil {
+			t.Fatalf("Unexpected error from Decode(): %s", err)
+		}
+		replayed = append(replayed, decoded...)
+	}
+	if !bytes.Equal(replayed, replay) {
+		t.Errorf("Replayed output does not match, got %d bytes", len(replayed))
+	}
+}
+
+func TestInitializationWithClearScreen(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster, _, _, cancel := prepareSUT(t, &wg, WithClearScreen(), WithReplay([]byte("$ ")))
+	defer cancel()
+
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
+
+	// The screen is cleared before the replay is sent
+	for _, want := range []string{"\x1b[H\x1b[2J", "$ "} {
+		msgType, payload := nextMsg(t, mMaster.gottyToMasterReader)
+		if msgType != Output {
+			t.Fatalf("Unexpected message type `%c`", msgType)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(bytes.TrimRight(payload, "\x00")))
+		if err != nil {
+			t.Fatalf("Unexpected error from Decode(): %s", err)
+		}
+		if string(decoded) != want {
+			t.Errorf("Output = %q, want %q", decoded, want)
+		}
+	}
+}
+
+func TestInitializationReadyWithFixedSize(t *testing.T) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	mMaster := newMockMaster()
+	mSlave := newMockSlave()
+	mSlave.wg.Add(1)
+	dt, err := New(mMaster, mSlave, WithFixedColumns(100), WithFixedRows(30))
+	if err != nil {
+		t.Fatalf("Unexpected error from New(): %s", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dt.Run(ctx)
+	}()
+
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+
+	msgType, payload := nextMsg(t, mMaster.gottyToMasterReader)
+	if msgType != ConnectionReady {
+		t.Fatalf("Unexpected message type `%c`", msgType)
+	}
+	if got := string(bytes.TrimRight(payload, "\x00")); got != `{"type":"ready"}` {
+		t.Errorf("Ready payload = %s, want %s", got, `{"type":"ready"}`)
+	}
+
+	// The fixed size is applied before the master is told it is ready
+	mSlave.wg.Wait()
+	if mSlave.columns != 100 || mSlave.rows != 30 {
+		t.Errorf("Slave size = %dx%d, want 100x30", mSlave.columns, mSlave.rows)
+	}
 }
 
 func TestWriteFromSlaveCommand(t *testing.T) {
@@ -57,6 +155,7 @@ func TestWriteFromSlaveCommand(t *testing.T) {
 	// Check that the initialization happens as expected
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 
 	// Simulate the slave (the process being run by GoTTY)
 	// echoing "foobar"
@@ -97,6 +196,7 @@ func TestWriteFromFrontend(t *testing.T) {
 	// Absorb initialization messages
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 
 	// simulate input from frontend...
 	message := []byte("1hello\n") // line buffered canonical mode
@@ -123,6 +223,7 @@ func TestPing(t *testing.T) {
 	// Absorb initialization messages
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 
 	// ping
 	message := []byte("2\n") // line buffered canonical mode
@@ -157,6 +258,7 @@ func TestResizeTerminal(t *testing.T) {
 	// Absorb initialization messages
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetWindowTitle)
 	checkNextMsgType(t, mMaster.gottyToMasterReader, SetBufferSize)
+	checkNextMsgType(t, mMaster.gottyToMasterReader, ConnectionReady)
 
 	message := []byte(`3{"Columns": 1234, "Rows": 2345}` + "\n") // line buffered canonical mode
 